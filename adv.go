@@ -1,8 +1,12 @@
 package gatt
 
 import (
+	"encoding/binary"
 	"errors"
-	"log"
+	"fmt"
+	"strings"
+
+	"github.com/paypal/gatt/btaddr"
 )
 
 // MaxEIRPacketLength is the maximum allowed AdvertisingPacket
@@ -45,6 +49,7 @@ const (
 	typeServiceData128    = 0x21 // Service Data - 128-bit UUID
 	typeLESecConfirm      = 0x22 // LE Secure Connections Confirmation Value
 	typeLESecRandom       = 0x23 // LE Secure Connections Random Value
+	typeURI               = 0x24 // URI
 	typeManufacturerData  = 0xFF // Manufacturer Specific Data
 )
 
@@ -57,85 +62,414 @@ const (
 	flagBothHost            = 0x10 // Simultaneous LE and BR/EDR to Same Device Capable (Host).
 )
 
-// FIXME: check the unmarshalling of this data structure.
+// ServiceData is the data associated with a service.
 type ServiceData struct {
 	UUID UUID
 	Data []byte
 }
 
+// AdvField is an advertising data field, i.e. an AD structure, of a type not
+// parsed into the fields of Advertisement.
+type AdvField struct {
+	Type byte
+	Data []byte
+}
+
 // This is borrowed from core bluetooth.
 // Embedded/Linux folks might be interested in more details.
 type Advertisement struct {
 	LocalName        string
-	ManufacturerData []byte
+	ManufacturerData []byte // including the company identifier, least significant octet first
 	ServiceData      []ServiceData
 	Services         []UUID
 	OverflowService  []UUID
 	TxPowerLevel     int
 	Connectable      bool
 	SolicitedService []UUID
+
+	// The fields below are only filled on Linux.
+
+	Flags            byte       // 0x01: LE Limited, 0x02: LE General Discoverable Mode, 0x04: BR/EDR Not Supported, ...
+	Appearance       uint16     // external appearance of the device, from the GAP Appearance values
+	URIs             []string   // URIs of the schemes "http:" and "https:", or of no scheme code
+	ClassOfDevice    uint32     // class of device, 24 bits
+	SimplePairingC   []byte     // Simple Pairing Hash C-192
+	SimplePairingR   []byte     // Simple Pairing Randomizer R-192
+	SecurityTK       []byte     // Security Manager TK Value
+	SecurityOOBFlags byte       // Security Manager Out of Band Flags
+	ConnIntervalMin  uint16     // slave connection interval range, in units of 1.25 ms; 0xFFFF: no specific value
+	ConnIntervalMax  uint16     //
+	TargetAddrs      []BDAddr   // public and random target addresses
+	AdvInterval      uint16     // advertising interval, in units of 0.625 ms
+	LEDeviceAddr     BDAddr     // LE Bluetooth Device Address
+	LERole           byte       // LE Role; 0x00: only peripheral, 0x01: only central, 0x02, 0x03: both, preferably peripheral, central
+	LESecConfirm     []byte     // LE Secure Connections Confirmation Value
+	LESecRandom      []byte     // LE Secure Connections Random Value
+	OtherFields      []AdvField // fields of the other types, and URIs of the other scheme codes
+
+	// AdvFields and RspFields are all the fields of the advertising data and
+	// of the scan response data, in order, as parsed by Unmarshal and
+	// UnmarshalScanResponse. They keep what the fields above can't: the packet
+	// of each field, the fields of zero value, the incomplete UUID lists, the
+	// shortened local name, and the fields repeated, e.g. the manufacturer
+	// data of several companies. They are marshalled by MarshalFields; they
+	// aren't updated with the fields above.
+	AdvFields []AdvField
+	RspFields []AdvField
+}
+
+// Unmarshal parses the advertising data b into a, and appends its fields to
+// AdvFields. The lists are appended to; the other fields are overwritten by the ones
+// of b, except that a shortened local name doesn't replace a local name. The
+// fields of the types not parsed, including the URIs of the other scheme
+// codes, are appended to OtherFields.
+// A malformed field is skipped, and the first error found is returned, after
+// the rest of b is parsed, if possible.
+func (a *Advertisement) Unmarshal(b []byte) error {
+	return a.unmarshal(b, &a.AdvFields)
+}
+
+// UnmarshalScanResponse is like Unmarshal, for the scan response data b, whose
+// fields are appended to RspFields.
+func (a *Advertisement) UnmarshalScanResponse(b []byte) error {
+	return a.unmarshal(b, &a.RspFields)
 }
 
-// This is only used in Linux port.
-func (a *Advertisement) unmarshall(b []byte) error {
+// unmarshal parses the data b into a, and appends its fields to ff.
+func (a *Advertisement) unmarshal(b []byte, ff *[]AdvField) error {
+	var err error
+	for len(b) > 0 {
+		l := int(b[0])
+		if l == 0 {
+			break // the rest of the data is insignificant
+		}
+		if len(b) < 1+l {
+			return errors.New("invalid advertising data")
+		}
+		t, d := b[1], b[2:1+l]
+		b = b[1+l:]
+		*ff = append(*ff, AdvField{t, append([]byte{}, d...)})
+		if a.unmarshalField(t, d) != nil && err == nil {
+			err = fmt.Errorf("invalid advertising data field 0x%02X: [ % X ]", t, d)
+		}
+	}
+	return err
+}
+
+// errInvalidField is returned by unmarshalField for a field of an invalid
+// length.
+var errInvalidField = errors.New("invalid field")
 
+// unmarshalField parses the field of type t and of data d into a.
+func (a *Advertisement) unmarshalField(t byte, d []byte) error {
 	// Utility function for creating a list of uuids.
-	uuidList := func(u []UUID, d []byte, w int) []UUID {
-		for len(d) > 0 {
-			u = append(u, UUID{d[:w]})
-			d = d[w:]
+	uuidList := func(u []UUID, w int) ([]UUID, error) {
+		if len(d)%w != 0 {
+			return u, errInvalidField
+		}
+		for d := d; len(d) > 0; d = d[w:] {
+			u = append(u, UUID{append([]byte{}, d[:w]...)})
+		}
+		return u, nil
+	}
+	serviceData := func(w int) error {
+		if len(d) < w {
+			return errInvalidField
 		}
-		return u
+		a.ServiceData = append(a.ServiceData, ServiceData{
+			UUID: UUID{append([]byte{}, d[:w]...)},
+			Data: append([]byte{}, d[w:]...),
+		})
+		return nil
+	}
+	// fixed returns the copy of d, if it is n octets long.
+	fixed := func(n int) ([]byte, error) {
+		if len(d) != n {
+			return nil, errInvalidField
+		}
+		return append([]byte{}, d...), nil
 	}
 
-	for len(b) > 0 {
-		if len(b) < 2 {
-			return errors.New("invalid advertise data")
-		}
-		l, t := b[0], b[1]
-		if len(b) < int(1+l) {
-			return errors.New("invalid advertise data")
-		}
-		d := b[2 : 1+l]
-		switch t {
-		case typeFlags:
-			// TODO: should we do anything about the discoverability here?
-		case typeSomeUUID16:
-			a.Services = uuidList(a.Services, d, 2)
-		case typeAllUUID16:
-			a.Services = uuidList(a.Services, d, 2)
-		case typeSomeUUID32:
-			a.Services = uuidList(a.Services, d, 4)
-		case typeAllUUID32:
-			a.Services = uuidList(a.Services, d, 4)
-		case typeSomeUUID128:
-			a.Services = uuidList(a.Services, d, 16)
-		case typeAllUUID128:
-			a.Services = uuidList(a.Services, d, 16)
-		case typeShortName:
-			a.LocalName = string(d)
-		case typeCompleteName:
+	var err error
+	switch t {
+	case typeFlags:
+		if len(d) < 1 {
+			return errInvalidField
+		}
+		a.Flags = d[0]
+	case typeSomeUUID16, typeAllUUID16:
+		a.Services, err = uuidList(a.Services, 2)
+	case typeSomeUUID32, typeAllUUID32:
+		a.Services, err = uuidList(a.Services, 4)
+	case typeSomeUUID128, typeAllUUID128:
+		a.Services, err = uuidList(a.Services, 16)
+	case typeShortName:
+		if a.LocalName == "" {
 			a.LocalName = string(d)
-		case typeTxPower:
-			a.TxPowerLevel = int(d[0])
-		case typeServiceSol16:
-			a.SolicitedService = uuidList(a.SolicitedService, d, 2)
-		case typeServiceSol128:
-			a.SolicitedService = uuidList(a.SolicitedService, d, 16)
-		case typeServiceSol32:
-			a.SolicitedService = uuidList(a.SolicitedService, d, 4)
-		case typeManufacturerData:
-			a.ManufacturerData = make([]byte, len(d))
-			copy(a.ManufacturerData, d)
-		// case typeServiceData16,
-		// case typeServiceData32,
-		// case typeServiceData128:
+		}
+	case typeCompleteName:
+		a.LocalName = string(d)
+	case typeTxPower:
+		if len(d) != 1 {
+			return errInvalidField
+		}
+		a.TxPowerLevel = int(int8(d[0]))
+	case typeClassOfDevice:
+		if len(d) != 3 {
+			return errInvalidField
+		}
+		a.ClassOfDevice = uint32(d[0]) | uint32(d[1])<<8 | uint32(d[2])<<16
+	case typeSimplePairingC192:
+		a.SimplePairingC, err = fixed(16)
+	case typeSimplePairingR192:
+		a.SimplePairingR, err = fixed(16)
+	case typeSecManagerTK:
+		a.SecurityTK, err = fixed(16)
+	case typeSecManagerOOB:
+		if len(d) != 1 {
+			return errInvalidField
+		}
+		a.SecurityOOBFlags = d[0]
+	case typeSlaveConnInt:
+		if len(d) != 4 {
+			return errInvalidField
+		}
+		a.ConnIntervalMin = binary.LittleEndian.Uint16(d)
+		a.ConnIntervalMax = binary.LittleEndian.Uint16(d[2:])
+	case typeServiceSol16:
+		a.SolicitedService, err = uuidList(a.SolicitedService, 2)
+	case typeServiceSol32:
+		a.SolicitedService, err = uuidList(a.SolicitedService, 4)
+	case typeServiceSol128:
+		a.SolicitedService, err = uuidList(a.SolicitedService, 16)
+	case typeServiceData16:
+		err = serviceData(2)
+	case typeServiceData32:
+		err = serviceData(4)
+	case typeServiceData128:
+		err = serviceData(16)
+	case typePubTargetAddr, typeRandTargetAddr:
+		if len(d)%6 != 0 {
+			return errInvalidField
+		}
+		typ := uint8(0x00) // public
+		if t == typeRandTargetAddr {
+			typ = 0x01
+		}
+		for d := d; len(d) > 0; d = d[6:] {
+			a.TargetAddrs = append(a.TargetAddrs, btaddr.New(addrOctets(d), typ))
+		}
+	case typeAppearance:
+		if len(d) != 2 {
+			return errInvalidField
+		}
+		a.Appearance = binary.LittleEndian.Uint16(d)
+	case typeAdvInterval:
+		if len(d) != 2 {
+			return errInvalidField
+		}
+		a.AdvInterval = binary.LittleEndian.Uint16(d)
+	case typeLEDeviceAddr:
+		if len(d) != 7 {
+			return errInvalidField
+		}
+		a.LEDeviceAddr = btaddr.New(addrOctets(d), d[6]&0x01)
+	case typeLERole:
+		if len(d) != 1 {
+			return errInvalidField
+		}
+		a.LERole = d[0]
+	case typeLESecConfirm:
+		a.LESecConfirm, err = fixed(16)
+	case typeLESecRandom:
+		a.LESecRandom, err = fixed(16)
+	case typeURI:
+		if len(d) < 1 {
+			return errInvalidField
+		}
+		if s, ok := uriSchemes[d[0]]; ok {
+			a.URIs = append(a.URIs, s+string(d[1:]))
+			break
+		}
+		a.OtherFields = append(a.OtherFields, AdvField{t, append([]byte{}, d...)})
+	case typeManufacturerData:
+		if len(d) < 2 {
+			return errInvalidField
+		}
+		a.ManufacturerData = append([]byte{}, d...)
+	default:
+		a.OtherFields = append(a.OtherFields, AdvField{t, append([]byte{}, d...)})
+	}
+	return err
+}
+
+// uriSchemes are the URI schemes of the scheme name string codes parsed;
+// 0x01 stands for no scheme, which is part of the URI then.
+var uriSchemes = map[byte]string{
+	0x01: "",
+	0x16: "http:",
+	0x17: "https:",
+}
+
+// addrOctets returns the address of the first 6 octets of b, which are least
+// significant octet first, in display order.
+func addrOctets(b []byte) [6]byte {
+	return [6]byte{b[5], b[4], b[3], b[2], b[1], b[0]}
+}
+
+// Marshal returns the advertising data and the scan response data of a. The
+// fields are appended to the advertising data as long as they fit, and then
+// to the scan response data; the flags are only appended to the advertising
+// data. The fields of zero value, and Connectable, OverflowService,
+// AdvFields and RspFields, are not marshalled. ErrEIRPacketTooLong is
+// returned if a field fits in neither.
+func (a *Advertisement) Marshal() (adv, rsp *AdvPacket, err error) {
+	adv, rsp = &AdvPacket{}, &AdvPacket{}
+	if a.Flags != 0 {
+		adv.AppendFlags(a.Flags)
+	}
+	for _, f := range a.fields() {
+		switch {
+		case len(adv.b)+2+len(f.Data) <= MaxEIRPacketLength:
+			adv.AppendField(f.Type, f.Data)
+		case len(rsp.b)+2+len(f.Data) <= MaxEIRPacketLength:
+			rsp.AppendField(f.Type, f.Data)
 		default:
-			log.Printf("DATA: [ % X ]", d)
+			return nil, nil, ErrEIRPacketTooLong
 		}
-		b = b[1+l:]
 	}
-	return nil
+	return adv, rsp, nil
+}
+
+// MarshalFields returns the advertising data and the scan response data of
+// the fields AdvFields and RspFields of a, as they are, so that a received
+// advertisement is sent again unchanged. ErrEIRPacketTooLong is returned if
+// the fields don't fit.
+func (a *Advertisement) MarshalFields() (adv, rsp *AdvPacket, err error) {
+	adv, rsp = &AdvPacket{}, &AdvPacket{}
+	for _, p := range []struct {
+		p  *AdvPacket
+		ff []AdvField
+	}{{adv, a.AdvFields}, {rsp, a.RspFields}} {
+		for _, f := range p.ff {
+			if len(p.p.b)+2+len(f.Data) > MaxEIRPacketLength {
+				return nil, nil, ErrEIRPacketTooLong
+			}
+			p.p.AppendField(f.Type, f.Data)
+		}
+	}
+	return adv, rsp, nil
+}
+
+// fields returns the fields of a, but for the flags, in the order they are
+// marshalled.
+func (a *Advertisement) fields() []AdvField {
+	var ff []AdvField
+	add := func(t byte, d []byte) { ff = append(ff, AdvField{t, d}) }
+	u16 := func(v uint16) []byte { return []byte{uint8(v), uint8(v >> 8)} }
+	// uuidLists adds the lists of the UUIDs uu of 2, 4 and 16 octets, of the
+	// types tt.
+	uuidLists := func(uu []UUID, tt [3]byte) {
+		for i, w := range []int{2, 4, 16} {
+			var d []byte
+			for _, u := range uu {
+				if u.Len() == w {
+					d = append(d, u.b...)
+				}
+			}
+			if d != nil {
+				add(tt[i], d)
+			}
+		}
+	}
+	// addrs adds the addresses aa, least significant octet first.
+	addrs := func(t byte, aa []BDAddr) {
+		var d []byte
+		for _, ba := range aa {
+			d = append(d, reverse(ba.Octets[:])...)
+		}
+		if d != nil {
+			add(t, d)
+		}
+	}
+
+	uuidLists(a.Services, [3]byte{typeAllUUID16, typeAllUUID32, typeAllUUID128})
+	if a.LocalName != "" {
+		add(typeCompleteName, []byte(a.LocalName))
+	}
+	if a.TxPowerLevel != 0 {
+		add(typeTxPower, []byte{uint8(int8(a.TxPowerLevel))})
+	}
+	if a.ClassOfDevice != 0 {
+		add(typeClassOfDevice, []byte{uint8(a.ClassOfDevice), uint8(a.ClassOfDevice >> 8), uint8(a.ClassOfDevice >> 16)})
+	}
+	if a.SimplePairingC != nil {
+		add(typeSimplePairingC192, a.SimplePairingC)
+	}
+	if a.SimplePairingR != nil {
+		add(typeSimplePairingR192, a.SimplePairingR)
+	}
+	if a.SecurityTK != nil {
+		add(typeSecManagerTK, a.SecurityTK)
+	}
+	if a.SecurityOOBFlags != 0 {
+		add(typeSecManagerOOB, []byte{a.SecurityOOBFlags})
+	}
+	if a.ConnIntervalMin != 0 || a.ConnIntervalMax != 0 {
+		add(typeSlaveConnInt, append(u16(a.ConnIntervalMin), u16(a.ConnIntervalMax)...))
+	}
+	uuidLists(a.SolicitedService, [3]byte{typeServiceSol16, typeServiceSol32, typeServiceSol128})
+	for _, sd := range a.ServiceData {
+		t := byte(typeServiceData16)
+		switch sd.UUID.Len() {
+		case 4:
+			t = typeServiceData32
+		case 16:
+			t = typeServiceData128
+		}
+		add(t, append(append([]byte{}, sd.UUID.b...), sd.Data...))
+	}
+	var pub, rand []BDAddr
+	for _, ta := range a.TargetAddrs {
+		if ta.IsRandom() {
+			rand = append(rand, ta)
+		} else {
+			pub = append(pub, ta)
+		}
+	}
+	addrs(typePubTargetAddr, pub)
+	addrs(typeRandTargetAddr, rand)
+	if a.Appearance != 0 {
+		add(typeAppearance, u16(a.Appearance))
+	}
+	if a.AdvInterval != 0 {
+		add(typeAdvInterval, u16(a.AdvInterval))
+	}
+	if !a.LEDeviceAddr.IsZero() {
+		add(typeLEDeviceAddr, append(reverse(a.LEDeviceAddr.Octets[:]), a.LEDeviceAddr.HCIType()))
+	}
+	if a.LERole != 0 {
+		add(typeLERole, []byte{a.LERole})
+	}
+	if a.LESecConfirm != nil {
+		add(typeLESecConfirm, a.LESecConfirm)
+	}
+	if a.LESecRandom != nil {
+		add(typeLESecRandom, a.LESecRandom)
+	}
+	for _, u := range a.URIs {
+		code := byte(0x01)
+		for c, s := range uriSchemes {
+			if s != "" && strings.HasPrefix(u, s) {
+				code, u = c, u[len(s):]
+			}
+		}
+		add(typeURI, append([]byte{code}, u...))
+	}
+	if a.ManufacturerData != nil {
+		add(typeManufacturerData, a.ManufacturerData)
+	}
+	return append(ff, a.OtherFields...)
 }
 
 // AdvPacket is an utility to help crafting advertisment or scan response data.
@@ -1,6 +1,12 @@
 package gatt
 
-import "testing"
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/paypal/gatt/btaddr"
+)
 
 // TODO:
 func TestAppendField(t *testing.T) {}
@@ -108,3 +114,187 @@ func TestAppendUUIDFit(t *testing.T) {
 	// 	}
 	// }
 }
+
+func TestAdvertisementUnmarshal(t *testing.T) {
+	adv := []byte{
+		0x02, typeFlags, 0x06,
+		0x03, typeAllUUID16, 0x0F, 0x18,
+		0x02, typeTxPower, 0xF4,
+		0x04, typeServiceData16, 0x0F, 0x18, 0x64,
+		0x03, typeAppearance, 0xC1, 0x03,
+		0x05, typeManufacturerData, 0x4C, 0x00, 0x01, 0x02,
+	}
+	rsp := []byte{
+		0x05, typeCompleteName, 'g', 'a', 't', 't',
+		0x0A, typeURI, 0x17, '/', '/', 'e', 'x', '.', 'c', 'o', 'm',
+		0x03, 0x99, 0xAA, 0xBB,
+		0x00, 0x00, // insignificant
+	}
+	want := &Advertisement{
+		LocalName:        "gatt",
+		ManufacturerData: []byte{0x4C, 0x00, 0x01, 0x02},
+		ServiceData:      []ServiceData{{UUID16(0x180F), []byte{0x64}}},
+		Services:         []UUID{UUID16(0x180F)},
+		TxPowerLevel:     -12,
+		Flags:            0x06,
+		Appearance:       0x03C1,
+		URIs:             []string{"https://ex.com"},
+		OtherFields:      []AdvField{{0x99, []byte{0xAA, 0xBB}}},
+		AdvFields: []AdvField{
+			{typeFlags, []byte{0x06}},
+			{typeAllUUID16, []byte{0x0F, 0x18}},
+			{typeTxPower, []byte{0xF4}},
+			{typeServiceData16, []byte{0x0F, 0x18, 0x64}},
+			{typeAppearance, []byte{0xC1, 0x03}},
+			{typeManufacturerData, []byte{0x4C, 0x00, 0x01, 0x02}},
+		},
+		RspFields: []AdvField{
+			{typeCompleteName, []byte("gatt")},
+			{typeURI, []byte("\x17//ex.com")},
+			{0x99, []byte{0xAA, 0xBB}},
+		},
+	}
+	a := &Advertisement{}
+	if err := a.Unmarshal(adv); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.UnmarshalScanResponse(rsp); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, want) {
+		t.Errorf("got %+v, want %+v", a, want)
+	}
+
+	// A shortened name doesn't replace the complete name.
+	if a.Unmarshal([]byte{0x03, typeShortName, 'g', 'a'}); a.LocalName != "gatt" {
+		t.Errorf("got name %q, want %q", a.LocalName, "gatt")
+	}
+}
+
+func TestAdvertisementUnmarshalMalformed(t *testing.T) {
+	a := &Advertisement{}
+	err := a.Unmarshal([]byte{
+		0x02, typeTxPower, 0xF4,
+		0x04, typeAllUUID16, 0x0F, 0x18, 0x0A, // odd length
+		0x02, typeCompleteName, 'x',
+	})
+	if err == nil {
+		t.Error("malformed UUID list accepted")
+	}
+	if a.TxPowerLevel != -12 || a.LocalName != "x" || a.Services != nil {
+		t.Errorf("got %+v, want the valid fields only", a)
+	}
+	if err := a.Unmarshal([]byte{0x05, typeCompleteName, 'a'}); err == nil {
+		t.Error("truncated field accepted")
+	}
+}
+
+func TestAdvertisementMarshal(t *testing.T) {
+	for _, a := range []*Advertisement{
+		{
+			LocalName:        "gatt",
+			ManufacturerData: []byte{0x4C, 0x00, 0x01, 0x02},
+			ServiceData:      []ServiceData{{UUID16(0x180F), []byte{0x64}}},
+			Services:         []UUID{UUID16(0x180F), UUID16(0x180A)},
+			TxPowerLevel:     -12,
+			Flags:            0x06,
+			Appearance:       0x03C1,
+			URIs:             []string{"https://ex.com", "mailto:a@b"},
+			OtherFields:      []AdvField{{0x99, []byte{0xAA, 0xBB}}},
+		},
+		{
+			SolicitedService: []UUID{MustParseUUID("ABABABABABABABABABABABABABABABAB")},
+			ClassOfDevice:    0x5A020C,
+			ConnIntervalMin:  0x0006,
+			ConnIntervalMax:  0xFFFF,
+			TargetAddrs: []BDAddr{
+				btaddr.New([6]byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}, 0x00),
+				btaddr.New([6]byte{0xC0, 0x01, 0x02, 0x03, 0x04, 0x05}, 0x01),
+			},
+			LEDeviceAddr: btaddr.New([6]byte{0xC0, 0x01, 0x02, 0x03, 0x04, 0x05}, 0x01),
+			LERole:       0x02,
+		},
+	} {
+		adv, rsp, err := a.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := &Advertisement{}
+		if err := got.Unmarshal(adv.b); err != nil {
+			t.Fatal(err)
+		}
+		if err := got.UnmarshalScanResponse(rsp.b); err != nil {
+			t.Fatal(err)
+		}
+		got.AdvFields, got.RspFields = nil, nil
+		if !reflect.DeepEqual(got, a) {
+			t.Errorf("got %+v, want %+v", got, a)
+		}
+		if a.Flags != 0 && (adv.Len() < 3 || adv.b[1] != typeFlags) {
+			t.Errorf("got advertising data [ % X ], want the flags first", adv.b)
+		}
+	}
+
+	a := &Advertisement{LocalName: "a name too long for the advertising data"}
+	if _, _, err := a.Marshal(); err != ErrEIRPacketTooLong {
+		t.Errorf("got %v, want %v", err, ErrEIRPacketTooLong)
+	}
+}
+
+func TestAdvertisementRoundTrip(t *testing.T) {
+	adv := []byte{
+		0x02, typeFlags, 0x06,
+		0x03, typeSomeUUID16, 0x0F, 0x18, // incomplete list
+		0x02, typeTxPower, 0x00, // 0 dBm
+		0x03, typeAppearance, 0x00, 0x00, // unknown appearance
+		0x02, typeLERole, 0x00, // only peripheral
+		0x04, typeManufacturerData, 0x4C, 0x00, 0x01,
+		0x04, typeManufacturerData, 0x06, 0x00, 0x02,
+	}
+	rsp := []byte{
+		0x03, typeShortName, 'g', 'a',
+		0x03, typeAllUUID16, 0x0A, 0x18,
+	}
+	a := &Advertisement{}
+	if err := a.Unmarshal(adv); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.UnmarshalScanResponse(rsp); err != nil {
+		t.Fatal(err)
+	}
+	gotAdv, gotRsp, err := a.MarshalFields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(gotAdv.b, adv) {
+		t.Errorf("got advertising data [ % X ], want [ % X ]", gotAdv.b, adv)
+	}
+	if !bytes.Equal(gotRsp.b, rsp) {
+		t.Errorf("got scan response data [ % X ], want [ % X ]", gotRsp.b, rsp)
+	}
+}
+
+func TestAdvertisementMarshalEdited(t *testing.T) {
+	a := &Advertisement{}
+	if err := a.Unmarshal([]byte{
+		0x02, typeFlags, 0x06,
+		0x05, typeCompleteName, 'g', 'a', 't', 't',
+		0x04, typeManufacturerData, 0x4C, 0x00, 0x01,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	a.LocalName = "edited"
+	a.ManufacturerData = []byte{0x4C, 0x00, 0x02}
+	adv, rsp, err := a.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0x02, typeFlags, 0x06,
+		0x07, typeCompleteName, 'e', 'd', 'i', 't', 'e', 'd',
+		0x04, typeManufacturerData, 0x4C, 0x00, 0x02,
+	}
+	if !bytes.Equal(adv.b, want) || rsp.Len() != 0 {
+		t.Errorf("got [ % X ] and [ % X ], want [ % X ]", adv.b, rsp.b, want)
+	}
+}
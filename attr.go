@@ -1,15 +1,22 @@
 package gatt
 
-import "log"
+import (
+	"encoding/binary"
+	"log"
+
+	"github.com/paypal/gatt/crypto"
+)
 
 // attr is a BLE attribute. It is not exported;
 // managing attributes is an implementation detail.
 type attr struct {
-	h      uint16   // attribute handle
-	typ    UUID     // attribute type in UUID
-	props  Property // attripute property
-	secure Property // attribute secure (implementation specific usage)
-	value  []byte   // attribute value
+	h     uint16     // attribute handle
+	typ   UUID       // attribute type in UUID
+	props Property   // attripute property
+	rperm Permission // read permission
+	wperm Permission // write permission
+	authz Authorizer // authorizer of the requests requiring authorization
+	value []byte     // attribute value
 
 	pvt interface{} // point to the corresponsing Serveice/Characteristic/Descriptor
 }
@@ -69,12 +76,33 @@ func (r *attrRange) Subrange(start, end uint16) []attr {
 	return r.aa[startidx:endidx]
 }
 
+// hash returns the Database Hash of the attributes, which changes with the
+// services, characteristics and descriptors. See Core spec Vol 3, Part G, 7.3.1.
+func (r *attrRange) hash() []byte {
+	var m []byte
+	for _, a := range r.aa {
+		switch {
+		case a.typ.Equal(attrPrimaryServiceUUID), a.typ.Equal(attrSecondaryServiceUUID),
+			a.typ.Equal(attrIncludeUUID), a.typ.Equal(attrCharacteristicUUID),
+			a.typ.Equal(attrCharacteristicExtPropsUUID):
+			m = binary.LittleEndian.AppendUint16(m, a.h)
+			m = append(append(m, a.typ.b...), a.value...)
+		case a.typ.Equal(attrCharacteristicUserDescUUID), a.typ.Equal(attrClientCharacteristicConfigUUID),
+			a.typ.Equal(attrServerCharacteristicConfigUUID), a.typ.Equal(attrCharacteristicFormatUUID),
+			a.typ.Equal(attrCharacteristicAggFormatUUID):
+			m = binary.LittleEndian.AppendUint16(m, a.h)
+			m = append(m, a.typ.b...)
+		}
+	}
+	return crypto.AESCMAC(make([]byte, 16), m)
+}
+
 func dumpAttributes(aa []attr) {
 	log.Printf("Generating attribute table:")
-	log.Printf("handle\ttype\tprops\tsecure\tpvt\tvalue")
+	log.Printf("handle\ttype\tprops\tpvt\tvalue")
 	for _, a := range aa {
-		log.Printf("0x%04X\t0x%s\t0x%02X\t0x%02x\t%T\t[ % X ]",
-			a.h, a.typ, int(a.props), int(a.secure), a.pvt, a.value)
+		log.Printf("0x%04X\t0x%s\t0x%02X\t%T\t[ % X ]",
+			a.h, a.typ, int(a.props), a.pvt, a.value)
 	}
 }
 
@@ -134,6 +162,9 @@ func generateCharAttributes(c *Characteristic, h uint16) (uint16, []attr) {
 		typ:   c.uuid,
 		value: c.value,
 		props: c.props,
+		rperm: c.rperm,
+		wperm: c.wperm,
+		authz: c.authz,
 		pvt:   c,
 	}
 	h += 2
@@ -154,7 +185,17 @@ func generateDescAttributes(d *Descriptor, h uint16) attr {
 		typ:   d.uuid,
 		value: d.value,
 		props: d.props,
+		rperm: d.rperm,
+		wperm: d.wperm,
+		authz: d.authz,
 		pvt:   d,
 	}
+	if d.char != nil && d == d.char.cccd {
+		// Subscribing requires the security of reading the characteristic.
+		// The configuration itself is never subject to authorization.
+		p := d.char.rperm
+		p.Authorization = false
+		a.rperm, a.wperm, a.authz = p, p, nil
+	}
 	return a
 }
@@ -66,3 +66,23 @@ func TestHandleRangeSubrange(t *testing.T) {
 		}
 	}
 }
+
+func TestDatabaseHash(t *testing.T) {
+	services := func(chars ...UUID) []*Service {
+		s := NewService(UUID16(0x180F))
+		for _, u := range chars {
+			s.AddCharacteristic(u).SetValue([]byte{0x64})
+		}
+		return []*Service{NewService(attrGAPUUID), s}
+	}
+	h := generateAttributes(services(UUID16(0x2A19)), 1).hash()
+	if len(h) != 16 {
+		t.Fatalf("got a hash of %d bytes", len(h))
+	}
+	if got := generateAttributes(services(UUID16(0x2A19)), 1).hash(); !reflect.DeepEqual(got, h) {
+		t.Errorf("hash of the same services: got [ % X ] want [ % X ]", got, h)
+	}
+	if got := generateAttributes(services(UUID16(0x2A19), UUID16(0x2A1A)), 1).hash(); reflect.DeepEqual(got, h) {
+		t.Errorf("hash did not change with the services")
+	}
+}
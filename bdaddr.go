@@ -0,0 +1,25 @@
+package gatt
+
+import "github.com/paypal/gatt/btaddr"
+
+// A BDAddr is a Bluetooth device address, most significant octet first, and
+// its type. Its String method returns the address in upper case, e.g.
+// "A1:A2:A3:A4:A5:A6", as the ID of the Linux peripherals and centrals.
+type BDAddr = btaddr.BDAddr
+
+// AddrType is the type of a device address.
+type AddrType = btaddr.Type
+
+const (
+	AddrPublic               = btaddr.Public               // public address, assigned by the IEEE
+	AddrRandomStatic         = btaddr.RandomStatic         // random static address
+	AddrResolvablePrivate    = btaddr.ResolvablePrivate    // resolvable private address, generated from an IRK
+	AddrNonResolvablePrivate = btaddr.NonResolvablePrivate // non-resolvable private address
+)
+
+// ParseBDAddr parses the address s, e.g. "a1:a2:a3:a4:a5:a6", of the HCI
+// address type addrType, 0x00: public, 0x01: random. The type of a random
+// address is classified by its two most significant bits.
+func ParseBDAddr(s string, addrType uint8) (BDAddr, error) {
+	return btaddr.Parse(s, addrType)
+}
@@ -0,0 +1,134 @@
+package gatt
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/paypal/gatt/linux"
+)
+
+// psmEATT is the SPSM of the Enhanced ATT bearers.
+const psmEATT = 0x0027
+
+// A bearer is an ATT bearer of a connection; the fixed L2CAP channel of ATT,
+// or one of the Enhanced ATT channels. Each bearer has its own MTU, and
+// carries one transaction at a time.
+type bearer struct {
+	l2c      io.ReadWriteCloser
+	mtu      uint16
+	enhanced bool
+
+	// The following fields are only used by the client.
+	reqc  chan *message // requests to send on this bearer only
+	rspc  chan []byte
+	quitc chan struct{}
+}
+
+func newBearer(l2c io.ReadWriteCloser, mtu uint16, enhanced bool) *bearer {
+	return &bearer{
+		l2c:      l2c,
+		mtu:      mtu,
+		enhanced: enhanced,
+		reqc:     make(chan *message),
+		rspc:     make(chan []byte),
+		quitc:    make(chan struct{}),
+	}
+}
+
+// newEnhancedBearer returns a bearer on an Enhanced ATT channel, whose MTU is
+// the smaller of the L2CAP MTUs of both devices. The MTU can't be exchanged
+// on Enhanced ATT bearers.
+func newEnhancedBearer(ch *linux.Channel) *bearer {
+	mtu := ch.MTU()
+	if ch.LocalMTU() < mtu {
+		mtu = ch.LocalMTU()
+	}
+	return newBearer(ch, uint16(mtu), true)
+}
+
+// rxBuf returns a buffer for the PDUs received on the bearer.
+func (br *bearer) rxBuf() []byte {
+	// L2CAP implementations shall support a minimum MTU size of 48 bytes.
+	// The default value is 672 bytes
+	if br.mtu > 672 {
+		return make([]byte, br.mtu)
+	}
+	return make([]byte, 672)
+}
+
+// acceptEATT hands the Enhanced ATT channels opened by remote centrals to
+// their connections, until the listener is closed. The channels are only
+// opened on encrypted links, and are only served if the services of the device
+// declare the support of Enhanced ATT bearers in the Server Supported Features.
+func (d *device) acceptEATT(l *linux.Listener) {
+	for {
+		ch, err := l.Accept()
+		if err != nil {
+			return
+		}
+		c, ok := d.peer(ch.PlatData()).(*central)
+		if !ok || serverFeatures(c.attrs)&ServerFeatEATT == 0 {
+			// Only the connections on which the device is the server serve
+			// Enhanced ATT bearers.
+			ch.Close()
+			continue
+		}
+		go c.serve(newEnhancedBearer(ch))
+	}
+}
+
+// serverFeatures returns the Server Supported Features of the attributes.
+func serverFeatures(r *attrRange) byte {
+	if r == nil {
+		return 0
+	}
+	for _, a := range r.aa {
+		if a.typ.Equal(attrServerSupportedFeaturesUUID) && len(a.value) > 0 {
+			return a.value[0]
+		}
+	}
+	return 0
+}
+
+// readByType reads the first attribute of the type u of the remote server,
+// and returns its handle and its value.
+func (p *peripheral) readByType(u UUID) (uint16, []byte, error) {
+	b := make([]byte, 5+u.Len())
+	b[0] = attOpReadByTypeReq
+	binary.LittleEndian.PutUint16(b[1:3], 0x0001)
+	binary.LittleEndian.PutUint16(b[3:5], 0xFFFF)
+	copy(b[5:], u.b)
+	b = p.sendReq(attOpReadByTypeReq, b)
+	if len(b) == 5 && b[0] == attOpError {
+		return 0, nil, attEcode(b[4])
+	}
+	if len(b) < 4 || b[0] != attOpReadByTypeRsp || b[1] < 2 || len(b) < 2+int(b[1]) {
+		return 0, nil, ErrInvalidLength
+	}
+	return binary.LittleEndian.Uint16(b[2:4]), b[4 : 2+int(b[1])], nil
+}
+
+func (p *peripheral) EnableEATT(n int) error {
+	if s, err := p.d.hci.Security(p.pd.Handle); err != nil || !s.Encrypted {
+		return errors.New("the link must be encrypted to enable EATT")
+	}
+	if _, v, err := p.readByType(attrServerSupportedFeaturesUUID); err != nil || len(v) == 0 || v[0]&ServerFeatEATT == 0 {
+		return errors.New("peripheral doesn't support EATT")
+	}
+	// Declare the features of the client, if the server keeps them.
+	if h, v, err := p.readByType(attrClientSupportedFeaturesUUID); err == nil && len(v) > 0 {
+		f := v[0] | ClientFeatEATT | ClientFeatMultiNotify
+		if err := p.WriteCharacteristic(&Characteristic{vh: h}, []byte{f}, false); err != nil {
+			return err
+		}
+	}
+	chs, err := p.d.hci.OpenChannels(p.pd.Handle, psmEATT, n)
+	if err != nil {
+		return err
+	}
+	for _, ch := range chs {
+		go p.serve(newEnhancedBearer(ch))
+	}
+	return nil
+}
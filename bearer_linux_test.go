@@ -0,0 +1,229 @@
+package gatt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"testing"
+	"time"
+)
+
+func newTestHandler() *testHandler {
+	return &testHandler{readc: make(chan []byte), writec: make(chan []byte, 1)}
+}
+
+func recv(t *testing.T, h *testHandler) string {
+	select {
+	case b := <-h.writec:
+		return hex.EncodeToString(b)
+	case <-time.After(time.Second):
+		t.Fatalf("nothing sent")
+	}
+	return ""
+}
+
+func TestEnhancedBearerServing(t *testing.T) {
+	release := make(chan struct{})
+	svc := &Service{uuid: MustParseUUID("09fc95c0-c111-11e3-9904-0002a5d5c51b")}
+	svc.AddCharacteristic(MustParseUUID("11fac9e0-c111-11e3-9246-0002a5d5c51b")).HandleReadFunc(
+		func(resp ResponseWriter, req *ReadRequest) {
+			<-release
+			io.WriteString(resp, "slow")
+		})
+	svc.AddCharacteristic(MustParseUUID("16fe0d80-c111-11e3-b8c8-0002a5d5c51b")).HandleReadFunc(
+		func(resp ResponseWriter, req *ReadRequest) {
+			resp.Write(bytes.Repeat([]byte{0xAA}, req.Cap))
+		})
+	a := generateAttributes([]*Service{svc}, uint16(1))
+
+	att, eatt := newTestHandler(), newTestHandler()
+	c := newCentral(a, BDAddr{}, att)
+	go c.loop()
+	go c.serve(newBearer(eatt, 64, true))
+
+	// A slow read on the ATT bearer doesn't hold up the Enhanced ATT bearer.
+	att.readc <- []byte{attOpReadReq, 0x03, 0x00}
+	eatt.readc <- []byte{attOpReadReq, 0x05, 0x00}
+	if got, want := recv(t, eatt), "0b"+hex.EncodeToString(bytes.Repeat([]byte{0xAA}, 63)); got != want {
+		t.Errorf("read on the enhanced bearer: got %s want %s", got, want)
+	}
+
+	// The MTU can't be exchanged on Enhanced ATT bearers.
+	eatt.readc <- []byte{attOpMtuReq, 0x87, 0x00}
+	if got, want := recv(t, eatt), "0102000006"; got != want {
+		t.Errorf("exchange mtu on the enhanced bearer: got %s want %s", got, want)
+	}
+
+	close(release)
+	if got, want := recv(t, att), "0b736c6f77"; got != want {
+		t.Errorf("read on the att bearer: got %s want %s", got, want)
+	}
+	if c.MTU() != 23 {
+		t.Errorf("MTU: got %d want 23", c.MTU())
+	}
+}
+
+func TestNotifyMultiple(t *testing.T) {
+	svc := &Service{uuid: MustParseUUID("09fc95c0-c111-11e3-9904-0002a5d5c51b")}
+	var chars []*Characteristic
+	for _, u := range []string{"1c927b50-c116-11e3-8a33-0800200c9a66", "1c927b50-c116-11e3-8a33-0800200c9a67"} {
+		char := svc.AddCharacteristic(MustParseUUID(u))
+		char.HandleNotifyFunc(func(r Request, n Notifier) {})
+		chars = append(chars, char)
+	}
+	feat := svc.AddClientFeatures()
+	a := generateAttributes([]*Service{svc}, uint16(1))
+
+	h := newTestHandler()
+	c := newCentral(a, BDAddr{}, h)
+	ns := []Notification{{chars[0], []byte("ab")}, {chars[1], []byte("c")}}
+	if err := c.NotifyMultiple(ns); err == nil {
+		t.Errorf("NotifyMultiple to an unsubscribed central should fail")
+	}
+
+	// Subscribe to both characteristics.
+	for _, char := range chars {
+		req := []byte{attOpWriteReq, 0, 0, 0x01, 0x00}
+		req[1], req[2] = byte(char.vh+1), byte((char.vh+1)>>8)
+		if rsp := c.handleReq(c.att, req); !bytes.Equal(rsp, []byte{attOpWriteRsp}) {
+			t.Fatalf("subscribe: got [ % X ]", rsp)
+		}
+	}
+	if err := c.NotifyMultiple(ns[:1]); err == nil {
+		t.Errorf("NotifyMultiple of a single value should fail")
+	}
+	if err := c.NotifyMultiple(ns); err == nil {
+		t.Errorf("NotifyMultiple to a central not supporting them should fail")
+	}
+
+	// Enable the Multiple Handle Value Notifications.
+	req := []byte{attOpWriteReq, byte(feat.vh), byte(feat.vh >> 8), ClientFeatMultiNotify}
+	if rsp := c.handleReq(c.att, req); !bytes.Equal(rsp, []byte{attOpWriteRsp}) {
+		t.Fatalf("write client features: got [ % X ]", rsp)
+	}
+	if err := c.NotifyMultiple(ns); err != nil {
+		t.Fatalf("NotifyMultiple: %s", err)
+	}
+	want := []byte{attOpMultiHandleNotify, byte(chars[0].vh), 0x00, 0x02, 0x00, 'a', 'b', byte(chars[1].vh), 0x00, 0x01, 0x00, 'c'}
+	if got := recv(t, h); got != hex.EncodeToString(want) {
+		t.Errorf("got %s want %x", got, want)
+	}
+
+	// The notifications must fit in the MTU of the ATT bearer.
+	ns[0].Value = make([]byte, 20)
+	if err := c.NotifyMultiple(ns); err == nil {
+		t.Errorf("NotifyMultiple exceeding the mtu should fail")
+	}
+}
+
+func TestRequestsSpreadAcrossBearers(t *testing.T) {
+	att, eatt := newTestHandler(), newTestHandler()
+	p := &peripheral{
+		att:   newBearer(att, 23, false),
+		reqc:  make(chan *message),
+		quitc: make(chan struct{}),
+		sub:   newSubscriber(),
+	}
+	go p.loop()
+	go p.serve(newBearer(eatt, 64, true))
+
+	type result struct {
+		b   []byte
+		err error
+	}
+	read := func(vh uint16) chan result {
+		rc := make(chan result, 1)
+		go func() {
+			b, err := p.ReadCharacteristic(&Characteristic{vh: vh})
+			rc <- result{b, err}
+		}()
+		return rc
+	}
+
+	// The first request takes one of the bearers, and the second request
+	// takes the other one, while the first is outstanding.
+	var busy, idle *testHandler
+	rc1 := read(0x0003)
+	select {
+	case <-att.writec:
+		busy, idle = att, eatt
+	case <-eatt.writec:
+		busy, idle = eatt, att
+	case <-time.After(time.Second):
+		t.Fatalf("first request not sent")
+	}
+	rc2 := read(0x0005)
+	if got := recv(t, idle); got != "0a0500" {
+		t.Fatalf("second request: got %s want 0a0500", got)
+	}
+	idle.readc <- []byte{attOpReadRsp, 0x02}
+	if r := <-rc2; !bytes.Equal(r.b, []byte{0x02}) {
+		t.Errorf("second read: got [ % X ]", r.b)
+	}
+	busy.readc <- []byte{attOpReadRsp, 0x01}
+	if r := <-rc1; !bytes.Equal(r.b, []byte{0x01}) {
+		t.Errorf("first read: got [ % X ]", r.b)
+	}
+
+	// Multiple Handle Value Notifications are delivered to each subscriber.
+	notified := make(chan string, 2)
+	for _, h := range []uint16{0x0003, 0x0006} {
+		p.sub.subscribe(h, func(b []byte, err error) { notified <- string(b) })
+	}
+	eatt.readc <- []byte{attOpMultiHandleNotify, 0x03, 0x00, 0x02, 0x00, 'a', 'b', 0x06, 0x00, 0x01, 0x00, 'c'}
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case s := <-notified:
+			got[s] = true
+		case <-time.After(time.Second):
+			t.Fatalf("not notified")
+		}
+	}
+	if !got["ab"] || !got["c"] {
+		t.Errorf("notified: got %v", got)
+	}
+}
+
+func TestClientFeatures(t *testing.T) {
+	svc := NewService(attrGATTUUID)
+	svc.AddServerFeatures(ServerFeatEATT)
+	feat := svc.AddClientFeatures()
+	a := generateAttributes([]*Service{svc}, uint16(1))
+	if serverFeatures(a) != ServerFeatEATT {
+		t.Errorf("server features: got 0x%02X", serverFeatures(a))
+	}
+
+	c := newCentral(a, BDAddr{}, newTestHandler())
+	h := []byte{byte(feat.vh), byte(feat.vh >> 8)}
+	for _, tt := range []struct {
+		req, want []byte
+	}{
+		{append([]byte{attOpReadReq}, h...), []byte{attOpReadRsp, 0x00}},
+		{append([]byte{attOpWriteReq}, append(h, ClientFeatEATT|ClientFeatMultiNotify|0x80)...), []byte{attOpWriteRsp}},
+		{append([]byte{attOpReadReq}, h...), []byte{attOpReadRsp, ClientFeatEATT | ClientFeatMultiNotify}},
+
+		// The features enabled can't be disabled.
+		{append([]byte{attOpWriteReq}, append(h, ClientFeatEATT)...), attErrorRsp(attOpWriteReq, feat.vh, attEcodeValueNotAllowed)},
+		{append([]byte{attOpWriteReq}, h...), attErrorRsp(attOpWriteReq, feat.vh, attEcodeInvalAttrValueLen)},
+	} {
+		if got := c.handleReq(c.att, tt.req); !bytes.Equal(got, tt.want) {
+			t.Errorf("[ % X ]: got [ % X ] want [ % X ]", tt.req, got, tt.want)
+		}
+	}
+
+	// A Write Command, if permitted, gets no response, even if refused.
+	feat.props |= CharWriteNR
+	c = newCentral(generateAttributes([]*Service{svc}, uint16(1)), BDAddr{}, newTestHandler())
+	for _, req := range [][]byte{
+		append([]byte{attOpWriteCmd}, append(h, ClientFeatEATT)...),
+		append([]byte{attOpWriteCmd}, h...),
+	} {
+		if got := c.handleReq(c.att, req); got != nil {
+			t.Errorf("[ % X ]: got [ % X ] want no response", req, got)
+		}
+	}
+	if f := c.clientFeatures(); f != ClientFeatEATT {
+		t.Errorf("features after Write Command: got 0x%02X", f)
+	}
+}
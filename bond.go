@@ -0,0 +1,291 @@
+package gatt
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/paypal/gatt/btaddr"
+)
+
+// BondKeys are the keys distributed by a device in a pairing. The keys not
+// distributed are nil. The keys are in the byte order they are sent in,
+// least significant octet first.
+type BondKeys struct {
+	LTK  []byte `json:"ltk,omitempty"`
+	EDIV uint16 `json:"ediv,omitempty"`
+	Rand uint64 `json:"rand,omitempty"`
+	IRK  []byte `json:"irk,omitempty"`
+	CSRK []byte `json:"csrk,omitempty"`
+
+	// SignCounter is the sign counter of the next data signed with the CSRK;
+	// the signatures with lower counters are replays.
+	SignCounter uint32 `json:"signCounter,omitempty"`
+}
+
+// A Bond is a bond with a remote device; the keys of the pairing with it, and
+// the state kept for it across its connections.
+type Bond struct {
+	// Addr is the identity address of the remote device, if it distributed
+	// its IRK, or else the address it paired with. The bonds are stored by
+	// it.
+	Addr BDAddr `json:"-"`
+
+	Local  BondKeys `json:"local"`  // keys distributed by the local device
+	Remote BondKeys `json:"remote"` // keys distributed by the remote device
+
+	KeySize           int  `json:"keySize"`           // encryption key size
+	Authenticated     bool `json:"authenticated"`     // MITM protection; other than Just Works
+	SecureConnections bool `json:"secureConnections"` // LE Secure Connections pairing
+
+	// CCC are the Client Characteristic Configurations written by the
+	// remote device, by handle, which are restored when it reconnects.
+	CCC map[uint16]uint16 `json:"ccc,omitempty"`
+
+	// ClientFeatures are the Client Supported Features written by the remote
+	// device, which are restored when it reconnects.
+	ClientFeatures byte `json:"clientFeatures,omitempty"`
+
+	// ServiceChanged reports that the services of the local device changed
+	// since the remote device last connected, which is pending to be
+	// indicated to it once it subscribes to Service Changed. The other CCC
+	// are discarded then.
+	ServiceChanged bool `json:"serviceChanged,omitempty"`
+
+	// DatabaseHash is the Database Hash of the services of the local device
+	// when the remote device last connected.
+	DatabaseHash []byte `json:"databaseHash,omitempty"`
+}
+
+// bondAddr is the JSON encoding of the address of a bond, e.g.
+// "A1:A2:A3:A4:A5:A6", and its HCI address type; 0x00: public, 0x01: random.
+type bondAddr struct {
+	Addr     string `json:"addr"`
+	AddrType uint8  `json:"addrType"`
+}
+
+// MarshalJSON encodes the bond, with its address as a bondAddr.
+func (b Bond) MarshalJSON() ([]byte, error) {
+	type bond Bond // without the methods of Bond
+	return json.Marshal(struct {
+		bondAddr
+		*bond
+	}{bondAddr{b.Addr.String(), b.Addr.HCIType()}, (*bond)(&b)})
+}
+
+// UnmarshalJSON decodes the bond, with its address as a bondAddr.
+func (b *Bond) UnmarshalJSON(data []byte) error {
+	type bond Bond
+	v := struct {
+		bondAddr
+		*bond
+	}{bond: (*bond)(b)}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	a, err := btaddr.Parse(v.bondAddr.Addr, v.AddrType)
+	if err != nil {
+		return err
+	}
+	b.Addr = a
+	return nil
+}
+
+// clone returns a copy of the bond, which can be modified independently.
+func (b *Bond) clone() *Bond {
+	c := *b
+	if b.CCC != nil {
+		c.CCC = make(map[uint16]uint16, len(b.CCC))
+		for h, v := range b.CCC {
+			c.CCC[h] = v
+		}
+	}
+	return &c
+}
+
+// A BondStore keeps the bonds with the remote devices, by address, its type
+// included, as returned by the IdentityAddress method of the peers. The bonds
+// are copied in and out of the store. Its methods may be called concurrently.
+type BondStore interface {
+	// Load returns the bond with the remote device of the address, or nil
+	// if there is none.
+	Load(a BDAddr) (*Bond, error)
+
+	// Save adds the bond, or replaces the one with the same address.
+	Save(b *Bond) error
+
+	// Delete removes the bond with the remote device of the address, if any.
+	// The RemoveBond method of the Device deletes the bond from the
+	// resolving list of the controller as well.
+	Delete(a BDAddr) error
+
+	// Bonds returns all the bonds, ordered by address.
+	Bonds() ([]*Bond, error)
+}
+
+// bondMap is a BondStore in memory, which keeps the bonds until the process
+// exits.
+type bondMap struct {
+	mu    *sync.Mutex
+	bonds map[BDAddr]*Bond
+}
+
+func newBondMap() *bondMap {
+	return &bondMap{mu: &sync.Mutex{}, bonds: map[BDAddr]*Bond{}}
+}
+
+func (m *bondMap) Load(a BDAddr) (*Bond, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if b, ok := m.bonds[a]; ok {
+		return b.clone(), nil
+	}
+	return nil, nil
+}
+
+func (m *bondMap) Save(b *Bond) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bonds[b.Addr] = b.clone()
+	return nil
+}
+
+func (m *bondMap) Delete(a BDAddr) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.bonds, a)
+	return nil
+}
+
+func (m *bondMap) Bonds() ([]*Bond, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.list(), nil
+}
+
+// list returns copies of the bonds, ordered by address. The caller holds mu.
+func (m *bondMap) list() []*Bond {
+	bb := make([]*Bond, 0, len(m.bonds))
+	for _, b := range m.bonds {
+		bb = append(bb, b.clone())
+	}
+	sort.Slice(bb, func(i, j int) bool {
+		a, b := bb[i].Addr, bb[j].Addr
+		if a.Octets != b.Octets {
+			return bytes.Compare(a.Octets[:], b.Octets[:]) < 0
+		}
+		return a.Type < b.Type
+	})
+	return bb
+}
+
+// A FileBondStore is a BondStore which keeps the bonds in a JSON file, so
+// that they survive the restarts of the process. The file, which holds the
+// keys, is only accessible to its owner, and is replaced atomically on each
+// change.
+type FileBondStore struct {
+	path string
+	m    *bondMap
+}
+
+// NewFileBondStore returns a FileBondStore keeping the bonds in the file at
+// path, which is created, along with its directory, if it doesn't exist.
+func NewFileBondStore(path string) (*FileBondStore, error) {
+	s := &FileBondStore{path: path, m: newBondMap()}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, err
+		}
+		return s, s.write()
+	}
+	if err != nil {
+		return nil, err
+	}
+	var bb []*Bond
+	if err := json.Unmarshal(b, &bb); err != nil {
+		return nil, err
+	}
+	for _, b := range bb {
+		s.m.bonds[b.Addr] = b
+	}
+	// Tighten the permissions of a file written by other means.
+	if err := os.Chmod(path, 0600); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileBondStore) Load(a BDAddr) (*Bond, error) { return s.m.Load(a) }
+func (s *FileBondStore) Bonds() ([]*Bond, error)      { return s.m.Bonds() }
+
+func (s *FileBondStore) Save(b *Bond) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	old, ok := s.m.bonds[b.Addr]
+	s.m.bonds[b.Addr] = b.clone()
+	if err := s.write(); err != nil {
+		// Keep the store as it is on disk.
+		if ok {
+			s.m.bonds[b.Addr] = old
+		} else {
+			delete(s.m.bonds, b.Addr)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *FileBondStore) Delete(a BDAddr) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	old, ok := s.m.bonds[a]
+	if !ok {
+		return nil
+	}
+	delete(s.m.bonds, a)
+	if err := s.write(); err != nil {
+		s.m.bonds[a] = old
+		return err
+	}
+	return nil
+}
+
+// write replaces the file with the bonds; it writes them to a temporary file
+// in the same directory, and renames it over the file, so that the file is
+// never partially written. The caller holds the lock of the bonds, if needed.
+func (s *FileBondStore) write() error {
+	b, err := json.MarshalIndent(s.m.list(), "", "\t")
+	if err != nil {
+		return err
+	}
+	// The temporary file is created only accessible to its owner.
+	f, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // fails once renamed
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), s.path); err != nil {
+		return err
+	}
+	// Persist the rename.
+	if d, err := os.Open(filepath.Dir(s.path)); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
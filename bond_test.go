@@ -0,0 +1,99 @@
+package gatt
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFileBondStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gatt", "bonds.json")
+	s, err := NewFileBondStore(path)
+	if err != nil {
+		t.Fatalf("NewFileBondStore: %s", err)
+	}
+	b1 := &Bond{
+		Addr:    BDAddr{Octets: [6]byte{0xB1, 0xB2, 0xB3, 0xB4, 0xB5, 0xB6}, Type: AddrRandomStatic},
+		Local:   BondKeys{LTK: []byte{1, 2, 3}, EDIV: 0x1234, Rand: 0x0102030405060708},
+		Remote:  BondKeys{IRK: []byte{4, 5, 6}, CSRK: []byte{7, 8, 9}},
+		KeySize: 16,
+		CCC:     map[uint16]uint16{0x000E: gattCCCNotifyFlag},
+	}
+	b2 := &Bond{Addr: BDAddr{Octets: [6]byte{0xA1, 0xA2, 0xA3, 0xA4, 0xA5, 0xA6}}, ServiceChanged: true, DatabaseHash: []byte{0xAA}}
+	for _, b := range []*Bond{b1, b2} {
+		if err := s.Save(b); err != nil {
+			t.Fatalf("Save: %s", err)
+		}
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat: %s", err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("got file mode %v, want 0600", fi.Mode().Perm())
+	}
+
+	// The bonds survive the store.
+	s, err = NewFileBondStore(path)
+	if err != nil {
+		t.Fatalf("NewFileBondStore: %s", err)
+	}
+	bb, err := s.Bonds()
+	if err != nil || !reflect.DeepEqual(bb, []*Bond{b2, b1}) {
+		t.Errorf("Bonds: got %+v, %v", bb, err)
+	}
+	if b, err := s.Load(b1.Addr); err != nil || !reflect.DeepEqual(b, b1) {
+		t.Errorf("Load: got %+v, %v, want %+v", b, err, b1)
+	}
+	// The bonds are found by the IDs of the remote devices.
+	if a, _ := ParseBDAddr(b1.Addr.String(), 0x01); a != b1.Addr {
+		t.Errorf("got address %v, want %v", a, b1.Addr)
+	} else if b, err := s.Load(a); err != nil || !reflect.DeepEqual(b, b1) {
+		t.Errorf("Load by ID: got %+v, %v, want %+v", b, err, b1)
+	}
+	for _, a := range []BDAddr{
+		{Octets: [6]byte{0xC1, 0xC2, 0xC3, 0xC4, 0xC5, 0xC6}},
+		{Octets: b1.Addr.Octets}, // public, rather than random
+	} {
+		if b, err := s.Load(a); b != nil || err != nil {
+			t.Errorf("Load of an unknown address %v: got %+v, %v", a, b, err)
+		}
+	}
+
+	if err := s.Delete(b2.Addr); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+	s, _ = NewFileBondStore(path)
+	if bb, _ := s.Bonds(); len(bb) != 1 || bb[0].Addr != b1.Addr {
+		t.Errorf("Bonds after Delete: got %+v", bb)
+	}
+
+	// Only the file is left in the directory.
+	if ff, _ := os.ReadDir(filepath.Dir(path)); len(ff) != 1 {
+		t.Errorf("got %d files in the directory, want 1", len(ff))
+	}
+}
+
+func TestFileBondStoreFormat(t *testing.T) {
+	// The addresses are stored as strings, along with their HCI types.
+	path := filepath.Join(t.TempDir(), "bonds.json")
+	data := `[{"addr": "c0:01:02:03:04:05", "addrType": 1, "keySize": 16}]`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewFileBondStore(path)
+	if err != nil {
+		t.Fatalf("NewFileBondStore: %s", err)
+	}
+	a := BDAddr{Octets: [6]byte{0xC0, 0x01, 0x02, 0x03, 0x04, 0x05}, Type: AddrRandomStatic}
+	if b, err := s.Load(a); err != nil || b == nil || b.KeySize != 16 {
+		t.Fatalf("Load: got %+v, %v", b, err)
+	}
+	s.Save(&Bond{Addr: a})
+	b, _ := os.ReadFile(path)
+	if !bytes.Contains(b, []byte(`"addr": "C0:01:02:03:04:05",`)) || !bytes.Contains(b, []byte(`"addrType": 1,`)) {
+		t.Errorf("got file %s", b)
+	}
+}
@@ -0,0 +1,109 @@
+// Package btaddr implements the Bluetooth device addresses, of the Core spec
+// Vol 6, Part B, 1.3; shared by the gatt package and its Linux implementation.
+//
+// The octets of an address are in display order, most significant octet
+// first, unlike the HCI packets, which carry them least significant octet
+// first.
+package btaddr
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Type is the type of a device address.
+type Type uint8
+
+const (
+	Public               Type = iota // public address, assigned by the IEEE
+	RandomStatic                     // random static address, which changes at most on power cycles
+	ResolvablePrivate                // resolvable private address, generated from an IRK
+	NonResolvablePrivate             // non-resolvable private address
+)
+
+func (t Type) String() string {
+	switch t {
+	case Public:
+		return "public"
+	case RandomStatic:
+		return "random static"
+	case ResolvablePrivate:
+		return "resolvable private"
+	case NonResolvablePrivate:
+		return "non-resolvable private"
+	}
+	return fmt.Sprintf("Type(%d)", uint8(t))
+}
+
+// A BDAddr is a Bluetooth device address. Its zero value is the public
+// address 00:00:00:00:00:00.
+type BDAddr struct {
+	Octets [6]byte // most significant octet first
+	Type   Type
+}
+
+// New returns the address of the octets a, most significant octet first, and
+// of the HCI address type typ; 0x00: public, 0x01: random, 0x02 and 0x03:
+// public and random identity addresses resolved by the controller. The type
+// of a random address is classified by its two most significant bits.
+func New(a [6]byte, typ uint8) BDAddr {
+	if typ&0x01 == 0x00 {
+		return BDAddr{a, Public}
+	}
+	switch a[0] >> 6 {
+	case 0x00:
+		return BDAddr{a, NonResolvablePrivate}
+	case 0x01:
+		return BDAddr{a, ResolvablePrivate}
+	}
+	return BDAddr{a, RandomStatic} // 0x02 is reserved
+}
+
+// Parse parses the address s, e.g. "A1:A2:A3:A4:A5:A6", of the HCI address
+// type typ, as New.
+func Parse(s string, typ uint8) (BDAddr, error) {
+	ha, err := net.ParseMAC(s)
+	if err != nil {
+		return BDAddr{}, err
+	}
+	if len(ha) != 6 {
+		return BDAddr{}, fmt.Errorf("invalid address %s", s)
+	}
+	var a [6]byte
+	copy(a[:], ha)
+	return New(a, typ), nil
+}
+
+// String returns the address in upper case, e.g. "A1:A2:A3:A4:A5:A6".
+func (a BDAddr) String() string {
+	return strings.ToUpper(net.HardwareAddr(a.Octets[:]).String())
+}
+
+// HCIType returns the HCI address type of the address; 0x00: public, 0x01:
+// random.
+func (a BDAddr) HCIType() uint8 {
+	if a.Type == Public {
+		return 0x00
+	}
+	return 0x01
+}
+
+// IsZero reports whether the address is all zeros.
+func (a BDAddr) IsZero() bool { return a.Octets == [6]byte{} }
+
+// IsRandom reports whether the address is a random address.
+func (a BDAddr) IsRandom() bool { return a.Type != Public }
+
+// IsIdentity reports whether the address is an identity address, public or
+// random static, which doesn't change over time.
+func (a BDAddr) IsIdentity() bool { return a.Type == Public || a.Type == RandomStatic }
+
+// IsPrivate reports whether the address is a private address, resolvable or
+// not, which changes periodically.
+func (a BDAddr) IsPrivate() bool {
+	return a.Type == ResolvablePrivate || a.Type == NonResolvablePrivate
+}
+
+// IsResolvable reports whether the address is a resolvable private address.
+func (a BDAddr) IsResolvable() bool { return a.Type == ResolvablePrivate }
@@ -0,0 +1,57 @@
+package btaddr
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		s    string
+		typ  uint8
+		want Type
+	}{
+		{"00:11:22:33:44:55", 0x00, Public},
+		{"c0:11:22:33:44:55", 0x00, Public},
+		{"C0:11:22:33:44:55", 0x01, RandomStatic},
+		{"70:81:94:0D:FB:AA", 0x01, ResolvablePrivate},
+		{"30:81:94:0D:FB:AA", 0x01, NonResolvablePrivate},
+		{"70:81:94:0D:FB:AA", 0x03, ResolvablePrivate},
+	}
+	for _, tt := range tests {
+		a, err := Parse(tt.s, tt.typ)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.s, err)
+			continue
+		}
+		if a.Type != tt.want {
+			t.Errorf("Parse(%q, %d): got %s, want %s", tt.s, tt.typ, a.Type, tt.want)
+		}
+		if a.HCIType() != tt.typ&0x01 {
+			t.Errorf("Parse(%q, %d): got HCI type %d", tt.s, tt.typ, a.HCIType())
+		}
+	}
+
+	a, _ := Parse("a1:a2:a3:a4:a5:a6", 0x00)
+	if a.Octets != [6]byte{0xA1, 0xA2, 0xA3, 0xA4, 0xA5, 0xA6} || a.String() != "A1:A2:A3:A4:A5:A6" {
+		t.Errorf("got %X, %s", a.Octets, a)
+	}
+	if _, err := Parse("a1:a2:a3:a4:a5:a6:a7:a8", 0x00); err == nil {
+		t.Error("Parse accepted an EUI-64")
+	}
+}
+
+func TestClassification(t *testing.T) {
+	for _, tt := range []struct {
+		a                                     BDAddr
+		random, identity, private, resolvable bool
+	}{
+		{BDAddr{Type: Public}, false, true, false, false},
+		{BDAddr{Type: RandomStatic}, true, true, false, false},
+		{BDAddr{Type: ResolvablePrivate}, true, false, true, true},
+		{BDAddr{Type: NonResolvablePrivate}, true, false, true, false},
+	} {
+		a := tt.a
+		if a.IsRandom() != tt.random || a.IsIdentity() != tt.identity || a.IsPrivate() != tt.private || a.IsResolvable() != tt.resolvable {
+			t.Errorf("%s: got random %t, identity %t, private %t, resolvable %t", a.Type,
+				a.IsRandom(), a.IsIdentity(), a.IsPrivate(), a.IsResolvable())
+		}
+	}
+}
@@ -12,6 +12,52 @@ type Central interface {
 	ID() string   // ID returns platform specific ID of the remote central device.
 	Close() error // Close disconnects the connection.
 	MTU() int     // MTU returns the current connection mtu.
+
+	// Address returns the current address of the remote central, and its type.
+	// On Linux, ID is derived from the identity address of a central bonded when it connected, instead.
+	Address() BDAddr
+
+	// IdentityAddress returns the identity address of the remote central, and its type, if it has bonded.
+	// Its resolvable private addresses are resolved with the IRK it distributed.
+	IdentityAddress() (a BDAddr, ok bool)
+
+	// SetDataLength suggests the link layer to send data PDUs of up to n payload octets, from MinDataLength to MaxDataLength.
+	// The data length in effect is reported by the CentralLinkUpdated handler.
+	SetDataLength(n int) error
+
+	// SetPHY requests the link layer to use the specified PHYs to transmit and to receive.
+	// The PHYs in effect are reported by the CentralLinkUpdated handler.
+	SetPHY(tx, rx PHY) error
+
+	// UpdateParameters requests new connection parameters.
+	// As the central of the connection, the link layer updates them; as the peripheral, it requests the remote central,
+	// and fails if the remote central rejects them. The parameters in effect are reported by the CentralLinkUpdated handler.
+	UpdateParameters(p ConnParams) error
+
+	// Link returns the link layer state of the connection.
+	Link() Link
+
+	// ConnInfo returns the connection handle, the role and the peer address type of the connection, and its current
+	// parameters. Only the role is reported on OS X.
+	ConnInfo() ConnInfo
+
+	// OpenChannel opens a L2CAP channel to the LE_PSM of the remote device.
+	OpenChannel(psm int) (L2CAPChannel, error)
+
+	// NotifyMultiple sends the values of two or more characteristics in a single Multiple Handle Value Notification.
+	// The central must have subscribed to the notifications of each of the characteristics, and must have enabled
+	// Multiple Handle Value Notifications in the Client Supported Features characteristic; see AddClientFeatures.
+	NotifyMultiple(ns []Notification) error
+
+	// Pair requests the remote central to pair, and waits for the pairing to complete.
+	// The pairings, requested by either device, are also reported by the CentralPaired handler.
+	Pair() error
+}
+
+// A Notification is a value of a characteristic, to notify to a central.
+type Notification struct {
+	Char  *Characteristic
+	Value []byte
 }
 
 type ResponseWriter interface {
@@ -98,6 +144,24 @@ func (f NotifyHandlerFunc) ServeNotify(r Request, n Notifier) {
 	f(r, n)
 }
 
+// An Authorizer authorizes the read and write requests to the attributes
+// which require authorization. The requests not authorized are rejected with
+// an Insufficient Authorization error.
+type Authorizer interface {
+	Authorize(r Request, write bool) bool
+}
+
+// AuthorizerFunc is an adapter to allow the use of
+// ordinary functions as Authorizers. If f is a function
+// with the appropriate signature, AuthorizerFunc(f) is an
+// Authorizer that calls f.
+type AuthorizerFunc func(r Request, write bool) bool
+
+// Authorize returns f(r, write).
+func (f AuthorizerFunc) Authorize(r Request, write bool) bool {
+	return f(r, write)
+}
+
 // A Notifier provides a means for a GATT server to send
 // notifications about value changes to a connected device.
 // Notifiers are provided by NotifyHandlers.
@@ -28,6 +28,19 @@ func (c *central) ID() string   { return c.uuid.String() }
 func (c *central) Close() error { return nil }
 func (c *central) MTU() int     { return c.mtu }
 
+func (c *central) SetDataLength(n int) error             { return notImplemented }
+func (c *central) SetPHY(tx, rx PHY) error               { return notImplemented }
+func (c *central) UpdateParameters(ConnParams) error     { return notImplemented }
+func (c *central) OpenChannel(int) (L2CAPChannel, error) { return nil, notImplemented }
+func (c *central) NotifyMultiple([]Notification) error   { return notImplemented }
+func (c *central) Pair() error                           { return notImplemented }
+func (c *central) Link() Link                            { return Link{} }
+
+func (c *central) Address() BDAddr                 { return BDAddr{} }
+func (c *central) IdentityAddress() (BDAddr, bool) { return BDAddr{}, false }
+
+func (c *central) ConnInfo() ConnInfo { return ConnInfo{Role: RolePeripheral} }
+
 func (c *central) sendNotification(a *attr, b []byte) (int, error) {
 	data := make([]byte, len(b))
 	copy(data, b) // have to make a copy, why?
@@ -2,43 +2,44 @@ package gatt
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
-	"net"
 	"sync"
-)
-
-type security int
 
-const (
-	securityLow = iota
-	securityMed
-	securityHigh
+	"github.com/paypal/gatt/linux"
 )
 
 type central struct {
 	attrs       *attrRange
-	mtu         uint16
-	addr        net.HardwareAddr
-	security    security
-	l2conn      io.ReadWriteCloser
+	id          BDAddr  // identity address, if bonded when connected, or else the address
+	att         *bearer // the ATT bearer; Enhanced ATT bearers are only known to their loops
 	notifiers   map[uint16]*notifier
 	notifiersmu *sync.Mutex
+
+	hci   *linux.HCI
+	pd    *linux.PlatData // platform specific data of the connection
+	bonds BondStore
+	cnfc  chan struct{} // the Handle Value Confirmations
+
+	mu       sync.Mutex
+	features byte // Client Supported Features
+	resumed  bool // the bond has been resumed
 }
 
-func newCentral(a *attrRange, addr net.HardwareAddr, l2conn io.ReadWriteCloser) *central {
+func newCentral(a *attrRange, id BDAddr, l2conn io.ReadWriteCloser) *central {
 	return &central{
 		attrs:       a,
-		mtu:         23,
-		addr:        addr,
-		security:    securityLow,
-		l2conn:      l2conn,
+		id:          id,
+		att:         newBearer(l2conn, 23, false),
 		notifiers:   make(map[uint16]*notifier),
 		notifiersmu: &sync.Mutex{},
+		cnfc:        make(chan struct{}, 1),
 	}
 }
 
 func (c *central) ID() string {
-	return c.addr.String()
+	return c.id.String()
 }
 
 func (c *central) Close() error {
@@ -47,52 +48,70 @@ func (c *central) Close() error {
 	for _, n := range c.notifiers {
 		n.stop()
 	}
-	return c.l2conn.Close()
+	return c.att.l2c.Close()
 }
 
 func (c *central) MTU() int {
-	return int(c.mtu)
+	return int(c.att.mtu)
 }
 
+func (c *central) SetDataLength(n int) error                 { return setDataLength(c.hci, c.pd, n) }
+func (c *central) SetPHY(tx, rx PHY) error                   { return setPHY(c.hci, c.pd, tx, rx) }
+func (c *central) UpdateParameters(p ConnParams) error       { return updateParameters(c.hci, c.pd, p) }
+func (c *central) OpenChannel(psm int) (L2CAPChannel, error) { return openChannel(c.hci, c.pd, psm) }
+func (c *central) Link() Link                                { return link(c.hci, c.pd) }
+func (c *central) ConnInfo() ConnInfo                        { return connInfo(c.hci, c.pd, RolePeripheral) }
+
 func (c *central) loop() {
+	c.serve(c.att)
+	c.Close()
+}
+
+// serve handles the requests received on the bearer, one at a time, until
+// the bearer is closed. The bearers of a central are served concurrently.
+func (c *central) serve(br *bearer) {
 	for {
-		// L2CAP implementations shall support a minimum MTU size of 48 bytes.
-		// The default value is 672 bytes
-		b := make([]byte, 672)
-		n, err := c.l2conn.Read(b)
+		b := br.rxBuf()
+		n, err := br.l2c.Read(b)
 		if n == 0 || err != nil {
-			c.Close()
 			break
 		}
-		if rsp := c.handleReq(b[:n]); rsp != nil {
-			c.l2conn.Write(rsp)
+		if rsp := c.handleReq(br, b[:n]); rsp != nil {
+			br.l2c.Write(rsp)
 		}
 	}
 }
 
-// handleReq dispatches a raw request from the central shim
+// handleReq dispatches a raw request received on the bearer br
 // to an appropriate handler, based on its type.
 // It panics if len(b) == 0.
-func (c *central) handleReq(b []byte) []byte {
+func (c *central) handleReq(br *bearer, b []byte) []byte {
 	var resp []byte
 	switch reqType, req := b[0], b[1:]; reqType {
 	case attOpMtuReq:
-		resp = c.handleMTU(req)
+		resp = c.handleMTU(br, req)
 	case attOpFindInfoReq:
-		resp = c.handleFindInfo(req)
+		resp = c.handleFindInfo(br, req)
 	case attOpFindByTypeValueReq:
-		resp = c.handleFindByTypeValue(req)
+		resp = c.handleFindByTypeValue(br, req)
 	case attOpReadByTypeReq:
-		resp = c.handleReadByType(req)
+		resp = c.handleReadByType(br, req)
 	case attOpReadReq:
-		resp = c.handleRead(req)
+		resp = c.handleRead(br, req)
 	case attOpReadBlobReq:
-		resp = c.handleReadBlob(req)
+		resp = c.handleReadBlob(br, req)
 	case attOpReadByGroupReq:
-		resp = c.handleReadByGroup(req)
+		resp = c.handleReadByGroup(br, req)
 	case attOpWriteReq, attOpWriteCmd:
-		resp = c.handleWrite(reqType, req)
-	case attOpReadMultiReq, attOpPrepWriteReq, attOpExecWriteReq, attOpSignedWriteCmd:
+		resp = c.handleWrite(br, reqType, req)
+	case attOpSignedWriteCmd:
+		resp = c.handleSignedWrite(br, req)
+	case attOpHandleCnf:
+		select {
+		case c.cnfc <- struct{}{}:
+		default:
+		}
+	case attOpReadMultiReq, attOpPrepWriteReq, attOpExecWriteReq:
 		fallthrough
 	default:
 		resp = attErrorRsp(reqType, 0x0000, attEcodeReqNotSupp)
@@ -100,23 +119,27 @@ func (c *central) handleReq(b []byte) []byte {
 	return resp
 }
 
-func (c *central) handleMTU(b []byte) []byte {
-	c.mtu = binary.LittleEndian.Uint16(b[:2])
-	if c.mtu < 23 {
-		c.mtu = 23
+func (c *central) handleMTU(br *bearer, b []byte) []byte {
+	if br.enhanced {
+		// The MTU of Enhanced ATT bearers is the MTU of their L2CAP channels.
+		return attErrorRsp(attOpMtuReq, 0x0000, attEcodeReqNotSupp)
+	}
+	br.mtu = binary.LittleEndian.Uint16(b[:2])
+	if br.mtu < 23 {
+		br.mtu = 23
 	}
-	if c.mtu >= 256 {
-		c.mtu = 256
+	if br.mtu >= 256 {
+		br.mtu = 256
 	}
-	return []byte{attOpMtuRsp, uint8(c.mtu), uint8(c.mtu >> 8)}
+	return []byte{attOpMtuRsp, uint8(br.mtu), uint8(br.mtu >> 8)}
 }
 
 // REQ: FindInfoReq(0x04), StartHandle, EndHandle
 // RSP: FindInfoRsp(0x05), UUIDFormat, Handle, UUID, Handle, UUID, ...
-func (c *central) handleFindInfo(b []byte) []byte {
+func (c *central) handleFindInfo(br *bearer, b []byte) []byte {
 	start, end := readHandleRange(b[:4])
 
-	w := newL2capWriter(c.mtu)
+	w := newL2capWriter(br.mtu)
 	w.WriteByteFit(attOpFindInfoRsp)
 
 	uuidLen := -1
@@ -148,7 +171,7 @@ func (c *central) handleFindInfo(b []byte) []byte {
 
 // REQ: FindByTypeValueReq(0x06), StartHandle, EndHandle, Type(UUID), Value
 // RSP: FindByTypeValueRsp(0x07), AttrHandle, GroupEndHandle, AttrHandle, GroupEndHandle, ...
-func (c *central) handleFindByTypeValue(b []byte) []byte {
+func (c *central) handleFindByTypeValue(br *bearer, b []byte) []byte {
 	start, end := readHandleRange(b[:4])
 	t := UUID{b[4:6]}
 	u := UUID{b[6:]}
@@ -159,7 +182,7 @@ func (c *central) handleFindByTypeValue(b []byte) []byte {
 		return attErrorRsp(attOpFindByTypeValueReq, start, attEcodeAttrNotFound)
 	}
 
-	w := newL2capWriter(c.mtu)
+	w := newL2capWriter(br.mtu)
 	w.WriteByteFit(attOpFindByTypeValueRsp)
 
 	var wrote bool
@@ -188,26 +211,29 @@ func (c *central) handleFindByTypeValue(b []byte) []byte {
 
 // REQ: ReadByType(0x08), StartHandle, EndHandle, Type(UUID)
 // RSP: ReadByType(0x09), LenOfEachDataField, DataField, DataField, ...
-func (c *central) handleReadByType(b []byte) []byte {
+func (c *central) handleReadByType(br *bearer, b []byte) []byte {
 	start, end := readHandleRange(b[:4])
 	t := UUID{b[4:]}
 
-	w := newL2capWriter(c.mtu)
+	w := newL2capWriter(br.mtu)
 	w.WriteByteFit(attOpReadByTypeRsp)
 	uuidLen := -1
 	for _, a := range c.attrs.Subrange(start, end) {
 		if !a.typ.Equal(t) {
 			continue
 		}
-		if (a.secure&CharRead) != 0 && c.security > securityLow {
-			return attErrorRsp(attOpReadByTypeReq, start, attEcodeAuthentication)
+		if ecode := c.access(&a, false, c.security()); ecode != attEcodeSuccess {
+			if uuidLen == -1 {
+				return attErrorRsp(attOpReadByTypeReq, a.h, ecode)
+			}
+			break
 		}
-		v := a.value
+		v := c.value(&a)
 		if v == nil {
-			rsp := newResponseWriter(int(c.mtu - 1))
+			rsp := newResponseWriter(int(br.mtu - 1))
 			req := &ReadRequest{
 				Request: Request{Central: c},
-				Cap:     int(c.mtu - 1),
+				Cap:     int(br.mtu - 1),
 				Offset:  0,
 			}
 			if c, ok := a.pvt.(*Characteristic); ok {
@@ -239,7 +265,7 @@ func (c *central) handleReadByType(b []byte) []byte {
 
 // REQ: ReadReq(0x0A), Handle
 // RSP: ReadRsp(0x0B), Value
-func (c *central) handleRead(b []byte) []byte {
+func (c *central) handleRead(br *bearer, b []byte) []byte {
 	h := binary.LittleEndian.Uint16(b)
 	a, ok := c.attrs.At(h)
 	if !ok {
@@ -248,17 +274,17 @@ func (c *central) handleRead(b []byte) []byte {
 	if a.props&CharRead == 0 {
 		return attErrorRsp(attOpReadReq, h, attEcodeReadNotPerm)
 	}
-	if a.secure&CharRead != 0 && c.security > securityLow {
-		return attErrorRsp(attOpReadReq, h, attEcodeAuthentication)
+	if ecode := c.access(&a, false, c.security()); ecode != attEcodeSuccess {
+		return attErrorRsp(attOpReadReq, h, ecode)
 	}
-	v := a.value
+	v := c.value(&a)
 	if v == nil {
 		req := &ReadRequest{
 			Request: Request{Central: c},
-			Cap:     int(c.mtu - 1),
+			Cap:     int(br.mtu - 1),
 			Offset:  0,
 		}
-		rsp := newResponseWriter(int(c.mtu - 1))
+		rsp := newResponseWriter(int(br.mtu - 1))
 		if c, ok := a.pvt.(*Characteristic); ok {
 			c.rhandler.ServeRead(rsp, req)
 		} else if d, ok := a.pvt.(*Descriptor); ok {
@@ -267,7 +293,7 @@ func (c *central) handleRead(b []byte) []byte {
 		v = rsp.bytes()
 	}
 
-	w := newL2capWriter(c.mtu)
+	w := newL2capWriter(br.mtu)
 	w.WriteByteFit(attOpReadRsp)
 	w.Chunk()
 	w.WriteFit(v)
@@ -276,7 +302,7 @@ func (c *central) handleRead(b []byte) []byte {
 }
 
 // FIXME: check this, untested, might be broken
-func (c *central) handleReadBlob(b []byte) []byte {
+func (c *central) handleReadBlob(br *bearer, b []byte) []byte {
 	h := binary.LittleEndian.Uint16(b)
 	offset := binary.LittleEndian.Uint16(b[2:])
 	a, ok := c.attrs.At(h)
@@ -286,17 +312,17 @@ func (c *central) handleReadBlob(b []byte) []byte {
 	if a.props&CharRead == 0 {
 		return attErrorRsp(attOpReadBlobReq, h, attEcodeReadNotPerm)
 	}
-	if a.secure&CharRead != 0 && c.security > securityLow {
-		return attErrorRsp(attOpReadBlobReq, h, attEcodeAuthentication)
+	if ecode := c.access(&a, false, c.security()); ecode != attEcodeSuccess {
+		return attErrorRsp(attOpReadBlobReq, h, ecode)
 	}
-	v := a.value
+	v := c.value(&a)
 	if v == nil {
 		req := &ReadRequest{
 			Request: Request{Central: c},
-			Cap:     int(c.mtu - 1),
+			Cap:     int(br.mtu - 1),
 			Offset:  int(offset),
 		}
-		rsp := newResponseWriter(int(c.mtu - 1))
+		rsp := newResponseWriter(int(br.mtu - 1))
 		if c, ok := a.pvt.(*Characteristic); ok {
 			c.rhandler.ServeRead(rsp, req)
 		} else if d, ok := a.pvt.(*Descriptor); ok {
@@ -305,7 +331,7 @@ func (c *central) handleReadBlob(b []byte) []byte {
 		v = rsp.bytes()
 		offset = 0 // the server has already adjusted for the offset
 	}
-	w := newL2capWriter(c.mtu)
+	w := newL2capWriter(br.mtu)
 	w.WriteByteFit(attOpReadBlobRsp)
 	w.Chunk()
 	w.WriteFit(v)
@@ -316,7 +342,7 @@ func (c *central) handleReadBlob(b []byte) []byte {
 	return w.Bytes()
 }
 
-func (c *central) handleReadByGroup(b []byte) []byte {
+func (c *central) handleReadByGroup(br *bearer, b []byte) []byte {
 	start, end := readHandleRange(b)
 	t := UUID{b[4:]}
 
@@ -326,7 +352,7 @@ func (c *central) handleReadByGroup(b []byte) []byte {
 		return attErrorRsp(attOpReadByGroupReq, start, attEcodeUnsuppGrpType)
 	}
 
-	w := newL2capWriter(c.mtu)
+	w := newL2capWriter(br.mtu)
 	w.WriteByteFit(attOpReadByGroupRsp)
 	uuidLen := -1
 	for _, a := range c.attrs.Subrange(start, end) {
@@ -355,7 +381,7 @@ func (c *central) handleReadByGroup(b []byte) []byte {
 	return w.Bytes()
 }
 
-func (c *central) handleWrite(reqType byte, b []byte) []byte {
+func (c *central) handleWrite(br *bearer, reqType byte, b []byte) []byte {
 	h := binary.LittleEndian.Uint16(b[:2])
 	value := b[2:]
 
@@ -372,19 +398,32 @@ func (c *central) handleWrite(reqType byte, b []byte) []byte {
 	if a.props&charFlag == 0 {
 		return attErrorRsp(reqType, h, attEcodeWriteNotPerm)
 	}
-	if a.secure&charFlag == 0 && c.security > securityLow {
-		return attErrorRsp(reqType, h, attEcodeAuthentication)
+	if ecode := c.access(&a, true, c.security()); ecode != attEcodeSuccess {
+		if noRsp {
+			return nil
+		}
+		return attErrorRsp(reqType, h, ecode)
 	}
 
 	// Props of Service and Characteristic declration are read only.
 	// So we only need deal with writable descriptors here.
 	// (Characteristic's value is implemented with descriptor)
+	if a.typ.Equal(attrClientSupportedFeaturesUUID) {
+		ecode := c.setFeatures(value)
+		switch {
+		case noRsp:
+			return nil
+		case ecode != attEcodeSuccess:
+			return attErrorRsp(reqType, h, ecode)
+		}
+		return []byte{attOpWriteRsp}
+	}
 	if !a.typ.Equal(attrClientCharacteristicConfigUUID) {
 		// Regular write, not CCC
 		r := Request{Central: c}
 		if c, ok := a.pvt.(*Characteristic); ok {
 			c.whandler.ServeWrite(r, value)
-		} else if d, ok := a.pvt.(*Characteristic); ok {
+		} else if d, ok := a.pvt.(*Descriptor); ok {
 			d.whandler.ServeWrite(r, value)
 		}
 		if noRsp {
@@ -399,9 +438,10 @@ func (c *central) handleWrite(reqType byte, b []byte) []byte {
 		return attErrorRsp(reqType, h, attEcodeInvalAttrValueLen)
 	}
 	ccc := binary.LittleEndian.Uint16(value)
+	c.setCCC(h, ccc)
 	// char := a.pvt.(*Descriptor).char
 	if ccc&(gattCCCNotifyFlag|gattCCCIndicateFlag) != 0 {
-		c.startNotify(&a, int(c.mtu-3))
+		c.startNotify(&a, int(c.att.mtu-3))
 	} else {
 		c.stopNotify(&a)
 	}
@@ -412,11 +452,86 @@ func (c *central) handleWrite(reqType byte, b []byte) []byte {
 }
 
 func (c *central) sendNotification(a *attr, data []byte) (int, error) {
-	w := newL2capWriter(c.mtu)
+	w := newL2capWriter(c.att.mtu)
 	w.WriteByteFit(attOpHandleNotify)
 	w.WriteUint16Fit(a.pvt.(*Descriptor).char.vh)
 	w.WriteFit(data)
-	return c.l2conn.Write(w.Bytes())
+	return c.att.l2c.Write(w.Bytes())
+}
+
+func (c *central) NotifyMultiple(ns []Notification) error {
+	if len(ns) < 2 {
+		return errors.New("at least two notifications are required")
+	}
+	if c.clientFeatures()&ClientFeatMultiNotify == 0 {
+		return errors.New("central doesn't support multiple handle value notifications")
+	}
+	w := newL2capWriter(c.att.mtu)
+	w.WriteByteFit(attOpMultiHandleNotify)
+	for _, n := range ns {
+		if !c.subscribed(n.Char) {
+			return fmt.Errorf("central not subscribed to characteristic %s", n.Char.uuid)
+		}
+		w.Chunk()
+		w.WriteUint16Fit(n.Char.vh)
+		w.WriteUint16Fit(uint16(len(n.Value)))
+		w.WriteFit(n.Value)
+		if ok := w.Commit(); !ok {
+			return errors.New("notifications exceed the mtu")
+		}
+	}
+	_, err := c.att.l2c.Write(w.Bytes())
+	return err
+}
+
+// value returns the value of the attribute, or nil if it is served by a
+// handler. The value of the Client Supported Features is that of the central.
+func (c *central) value(a *attr) []byte {
+	if a.typ.Equal(attrClientSupportedFeaturesUUID) {
+		return []byte{c.clientFeatures()}
+	}
+	return a.value
+}
+
+// clientFeatures returns the Client Supported Features of the central.
+func (c *central) clientFeatures() byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.features
+}
+
+// setFeatures sets the Client Supported Features written by the central, and
+// keeps them in its bond, if it has bonded. The features enabled can't be
+// disabled.
+func (c *central) setFeatures(v []byte) attEcode {
+	if len(v) == 0 {
+		return attEcodeInvalAttrValueLen
+	}
+	f := v[0] & clientFeatAll
+	c.mu.Lock()
+	if c.features&^f != 0 {
+		c.mu.Unlock()
+		return attEcodeValueNotAllowed
+	}
+	c.features = f
+	c.mu.Unlock()
+	if b := c.bond(); b != nil {
+		b.ClientFeatures = f
+		c.saveBond(b)
+	}
+	return attEcodeSuccess
+}
+
+// subscribed reports if the central subscribed to the notifications of the characteristic.
+func (c *central) subscribed(char *Characteristic) bool {
+	c.notifiersmu.Lock()
+	defer c.notifiersmu.Unlock()
+	for _, n := range c.notifiers {
+		if n.a.pvt.(*Descriptor).char == char && !n.Done() {
+			return true
+		}
+	}
+	return false
 }
 
 func readHandleRange(b []byte) (start, end uint16) {
@@ -4,7 +4,6 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
-	"net"
 	"testing"
 	"time"
 )
@@ -88,7 +87,7 @@ func TestServing(t *testing.T) {
 	gattSvc := NewService(attrGATTUUID)
 
 	a := generateAttributes([]*Service{gapSvc, gattSvc, svc}, uint16(1)) // ble a start at 1
-	go newCentral(a, net.HardwareAddr{}, h).loop()
+	go newCentral(a, BDAddr{}, h).loop()
 
 	// 0x0001	0x2800	0x02	0x00	*gatt.Service	[ 00 18 ]
 	// 0x0002	0x2803	0x02	0x00	*gatt.Characteristic	[ 02 03 00 00 2A ]
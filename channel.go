@@ -0,0 +1,37 @@
+package gatt
+
+import (
+	"fmt"
+	"io"
+)
+
+// An L2CAPChannel is a LE Credit Based connection-oriented channel, which
+// carries a stream of data between the device and a remote device.
+// Writes block while the remote device has no credit for more data.
+type L2CAPChannel interface {
+	io.ReadWriteCloser
+
+	// PSM returns the LE Protocol/Service Multiplexer of the channel.
+	PSM() int
+}
+
+// An L2CAPListener accepts the L2CAP channels opened by remote devices to a PSM.
+type L2CAPListener interface {
+	// Accept waits for and returns the next channel opened by a remote device.
+	Accept() (L2CAPChannel, error)
+
+	// Close stops listening. The channels already opened are not affected.
+	Close() error
+
+	// PSM returns the LE Protocol/Service Multiplexer the listener listens to.
+	PSM() int
+}
+
+// LE_PSMs are assigned by the Bluetooth SIG from 0x0001 to 0x007F, and
+// dynamically from 0x0080 to 0x00FF.
+func checkPSM(psm int) error {
+	if psm < 0x0001 || psm > 0x00FF {
+		return fmt.Errorf("invalid LE_PSM 0x%04X", psm)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package gatt
+
+import "github.com/paypal/gatt/linux"
+
+type l2capListener struct{ *linux.Listener }
+
+func (l l2capListener) Accept() (L2CAPChannel, error) {
+	ch, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+func (d *device) Listen(psm int) (L2CAPListener, error) {
+	if err := checkPSM(psm); err != nil {
+		return nil, err
+	}
+	l, err := d.hci.Listen(uint16(psm))
+	if err != nil {
+		return nil, err
+	}
+	return l2capListener{l}, nil
+}
+
+func openChannel(h *linux.HCI, pd *linux.PlatData, psm int) (L2CAPChannel, error) {
+	if err := checkPSM(psm); err != nil {
+		return nil, err
+	}
+	ch, err := h.OpenChannel(pd.Handle, uint16(psm))
+	if err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
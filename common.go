@@ -64,6 +64,22 @@ func (p Property) String() (result string) {
 	return
 }
 
+// A Permission is the security a request of a central requires to read or to
+// write an attribute. The zero Permission requires none. Requiring
+// authentication, Secure Connections, or a key size implies encryption.
+type Permission struct {
+	Encryption        bool // the link is encrypted
+	Authentication    bool // the key is from a pairing with MITM protection
+	SecureConnections bool // the key is from a LE Secure Connections pairing
+	MinKeySize        int  // the key is at least MinKeySize octets, if non-zero
+	Authorization     bool // the Authorizer of the attribute authorizes the request
+}
+
+// encrypted reports whether the permission requires an encrypted link.
+func (p Permission) encrypted() bool {
+	return p.Encryption || p.Authentication || p.SecureConnections || p.MinKeySize > 0
+}
+
 // A Service is a BLE service.
 type Service struct {
 	uuid  UUID
@@ -121,12 +137,11 @@ func (s *Service) Characteristics() []*Characteristic { return s.chars }
 
 // A Characteristic is a BLE characteristic.
 type Characteristic struct {
-	uuid   UUID
-	props  Property // enabled properties
-	secure Property // security enabled properties
-	svc    *Service
-	cccd   *Descriptor
-	descs  []*Descriptor
+	uuid  UUID
+	props Property // enabled properties
+	svc   *Service
+	cccd  *Descriptor
+	descs []*Descriptor
 
 	value []byte
 
@@ -135,6 +150,10 @@ type Characteristic struct {
 	whandler WriteHandler
 	nhandler NotifyHandler
 
+	rperm Permission // read permission, which notifications also require
+	wperm Permission // write permission
+	authz Authorizer
+
 	h    uint16
 	vh   uint16
 	endh uint16
@@ -229,7 +248,6 @@ func (c *Characteristic) SetValue(b []byte) {
 		panic("charactristic has been configured with a read handler")
 	}
 	c.props |= CharRead
-	// c.secure |= CharRead
 	c.value = make([]byte, len(b))
 	copy(c.value, b)
 }
@@ -243,7 +261,6 @@ func (c *Characteristic) HandleRead(h ReadHandler) {
 		panic("charactristic has been configured with a static value")
 	}
 	c.props |= CharRead
-	// c.secure |= CharRead
 	c.rhandler = h
 }
 
@@ -259,7 +276,6 @@ func (c *Characteristic) HandleReadFunc(f func(rsp ResponseWriter, req *ReadRequ
 // HandleWrite must be called before the containing service is added to a server.
 func (c *Characteristic) HandleWrite(h WriteHandler) {
 	c.props |= CharWrite | CharWriteNR
-	// c.secure |= CharWrite | CharWriteNR
 	c.whandler = h
 }
 
@@ -268,6 +284,21 @@ func (c *Characteristic) HandleWriteFunc(f func(r Request, data []byte) (status
 	c.HandleWrite(WriteHandlerFunc(f))
 }
 
+// HandleSignedWrite makes the characteristic support signed write requests,
+// from the bonded centrals which distributed a signing key, and routes the
+// verified requests to h, along with the write requests, if supported.
+// HandleSignedWrite must be called before the containing service is added to
+// a server.
+func (c *Characteristic) HandleSignedWrite(h WriteHandler) {
+	c.props |= CharSignedWrite
+	c.whandler = h
+}
+
+// HandleSignedWriteFunc calls HandleSignedWrite(WriteHandlerFunc(f)).
+func (c *Characteristic) HandleSignedWriteFunc(f func(r Request, data []byte) (status byte)) {
+	c.HandleSignedWrite(WriteHandlerFunc(f))
+}
+
 // HandleNotify makes the characteristic support notify requests, and routes
 // notification requests to h. HandleNotify must be called before the
 // containing service is added to a server.
@@ -279,17 +310,11 @@ func (c *Characteristic) HandleNotify(h NotifyHandler) {
 	c.props |= p
 	c.nhandler = h
 
-	// add ccc (client characteristic configuration) descriptor
-	secure := Property(0)
-	// If the characteristic requested secure notifications,
-	// then set ccc security to r/w.
-	if c.secure&p != 0 {
-		secure = CharRead | CharWrite
-	}
+	// add ccc (client characteristic configuration) descriptor; its
+	// permissions are those of the characteristic, once it is added to a server.
 	cd := &Descriptor{
-		uuid:   attrClientCharacteristicConfigUUID,
-		props:  CharRead | CharWrite | CharWriteNR,
-		secure: secure,
+		uuid:  attrClientCharacteristicConfigUUID,
+		props: CharRead | CharWrite | CharWriteNR,
 		// FIXME: currently, we always return 0, which is inaccurate.
 		// Each connection should have it's own copy of this value.
 		value: []byte{0x00, 0x00},
@@ -304,22 +329,46 @@ func (c *Characteristic) HandleNotifyFunc(f func(r Request, n Notifier)) {
 	c.HandleNotify(NotifyHandlerFunc(f))
 }
 
+// SetReadPermission sets the security required to read the characteristic,
+// and to subscribe to its notifications and indications.
+// SetReadPermission must be called before the containing service is added to
+// a server.
+func (c *Characteristic) SetReadPermission(p Permission) { c.rperm = p }
+
+// SetWritePermission sets the security required to write the characteristic.
+// SetWritePermission must be called before the containing service is added
+// to a server.
+func (c *Characteristic) SetWritePermission(p Permission) { c.wperm = p }
+
+// SetAuthorizer sets the Authorizer of the requests to the characteristic,
+// which its permissions require to be authorized. Without an Authorizer,
+// such requests are rejected.
+func (c *Characteristic) SetAuthorizer(a Authorizer) { c.authz = a }
+
+// SetAuthorizerFunc calls SetAuthorizer(AuthorizerFunc(f)).
+func (c *Characteristic) SetAuthorizerFunc(f func(r Request, write bool) bool) {
+	c.SetAuthorizer(AuthorizerFunc(f))
+}
+
 // TODO
 // func (c *Characteristic) SubscribedCentrals() []Central{
 // }
 
 // Descriptor is a BLE descriptor
 type Descriptor struct {
-	uuid   UUID
-	char   *Characteristic
-	props  Property // enabled properties
-	secure Property // security enabled properties
+	uuid  UUID
+	char  *Characteristic
+	props Property // enabled properties
 
 	h     uint16
 	value []byte
 
 	rhandler ReadHandler
 	whandler WriteHandler
+
+	rperm Permission // read permission
+	wperm Permission // write permission
+	authz Authorizer
 }
 
 // Handle returns the Handle of the descriptor.
@@ -362,7 +411,6 @@ func (d *Descriptor) SetValue(b []byte) {
 		panic("descriptor has been configured with a read handler")
 	}
 	d.props |= CharRead
-	// d.secure |= CharRead
 	d.value = make([]byte, len(b))
 	copy(d.value, b)
 }
@@ -375,7 +423,6 @@ func (d *Descriptor) HandleRead(h ReadHandler) {
 		panic("descriptor has been configured with a static value")
 	}
 	d.props |= CharRead
-	// d.secure |= CharRead
 	d.rhandler = h
 }
 
@@ -389,7 +436,6 @@ func (d *Descriptor) HandleReadFunc(f func(rsp ResponseWriter, req *ReadRequest)
 // HandleWrite must be called before the containing service is added to a server.
 func (d *Descriptor) HandleWrite(h WriteHandler) {
 	d.props |= CharWrite | CharWriteNR
-	// d.secure |= CharWrite | CharWriteNR
 	d.whandler = h
 }
 
@@ -397,3 +443,23 @@ func (d *Descriptor) HandleWrite(h WriteHandler) {
 func (d *Descriptor) HandleWriteFunc(f func(r Request, data []byte) (status byte)) {
 	d.HandleWrite(WriteHandlerFunc(f))
 }
+
+// SetReadPermission sets the security required to read the descriptor.
+// SetReadPermission must be called before the containing service is added to
+// a server.
+func (d *Descriptor) SetReadPermission(p Permission) { d.rperm = p }
+
+// SetWritePermission sets the security required to write the descriptor.
+// SetWritePermission must be called before the containing service is added
+// to a server.
+func (d *Descriptor) SetWritePermission(p Permission) { d.wperm = p }
+
+// SetAuthorizer sets the Authorizer of the requests to the descriptor, which
+// its permissions require to be authorized. Without an Authorizer, such
+// requests are rejected.
+func (d *Descriptor) SetAuthorizer(a Authorizer) { d.authz = a }
+
+// SetAuthorizerFunc calls SetAuthorizer(AuthorizerFunc(f)).
+func (d *Descriptor) SetAuthorizerFunc(f func(r Request, write bool) bool) {
+	d.SetAuthorizer(AuthorizerFunc(f))
+}
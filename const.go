@@ -1,5 +1,7 @@
 package gatt
 
+import "time"
+
 // This file includes constants from the BLE spec.
 
 var (
@@ -11,8 +13,12 @@ var (
 	attrIncludeUUID          = UUID16(0x2802)
 	attrCharacteristicUUID   = UUID16(0x2803)
 
+	attrCharacteristicExtPropsUUID     = UUID16(0x2900)
+	attrCharacteristicUserDescUUID     = UUID16(0x2901)
 	attrClientCharacteristicConfigUUID = UUID16(0x2902)
 	attrServerCharacteristicConfigUUID = UUID16(0x2903)
+	attrCharacteristicFormatUUID       = UUID16(0x2904)
+	attrCharacteristicAggFormatUUID    = UUID16(0x2905)
 
 	attrDeviceNameUUID        = UUID16(0x2A00)
 	attrAppearanceUUID        = UUID16(0x2A01)
@@ -20,6 +26,9 @@ var (
 	attrReconnectionAddrUUID  = UUID16(0x2A03)
 	attrPeferredParamsUUID    = UUID16(0x2A04)
 	attrServiceChangedUUID    = UUID16(0x2A05)
+
+	attrClientSupportedFeaturesUUID = UUID16(0x2B29)
+	attrServerSupportedFeaturesUUID = UUID16(0x2B3A)
 )
 
 const (
@@ -55,9 +64,13 @@ const (
 	attOpHandleNotify       = 0x1b
 	attOpHandleInd          = 0x1d
 	attOpHandleCnf          = 0x1e
+	attOpMultiHandleNotify  = 0x23
 	attOpSignedWriteCmd     = 0xd2
 )
 
+// attTimeout is the ATT transaction timeout.
+const attTimeout = 30 * time.Second
+
 type attEcode byte
 
 const (
@@ -79,13 +92,15 @@ const (
 	attEcodeInsuffEnc         attEcode = 0x0f // The attribute requires encryption before it can be read or written.
 	attEcodeUnsuppGrpType     attEcode = 0x10 // The attribute type is not a supported grouping attribute as defined by a higher layer specification.
 	attEcodeInsuffResources   attEcode = 0x11 // Insufficient Resources to complete the request.
+	attEcodeValueNotAllowed   attEcode = 0x13 // The attribute parameter value was not allowed.
 )
 
 func (a attEcode) Error() string {
+	if s, ok := attEcodeName[a]; ok {
+		return s
+	}
 	switch i := int(a); {
-	case i < 0x11:
-		return attEcodeName[a]
-	case i >= 0x12 && i <= 0x7F: // Reserved for future use
+	case i <= 0x7F: // Reserved for future use
 		return "reserved error code"
 	case i >= 0x80 && i <= 0x9F: // Application Error, defined by higher level
 		return "reserved error code"
@@ -117,6 +132,7 @@ var attEcodeName = map[attEcode]string{
 	attEcodeInsuffEnc:         "insufficient encryption",
 	attEcodeUnsuppGrpType:     "unsupported group type",
 	attEcodeInsuffResources:   "insufficient resources",
+	attEcodeValueNotAllowed:   "value not allowed",
 }
 
 func attErrorRsp(op byte, h uint16, s attEcode) []byte {
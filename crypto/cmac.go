@@ -0,0 +1,108 @@
+package crypto
+
+import "crypto/aes"
+
+// AESCMAC is AES-CMAC, as specified in RFC 4493, of the message m with the
+// key k. Unlike the other functions, its key, message and result are
+// big-endian, as in the RFC.
+func AESCMAC(k, m []byte) []byte {
+	c, err := aes.NewCipher(k)
+	if err != nil {
+		panic(err) // k is always 16 bytes
+	}
+	// Generate the subkeys k1 and k2.
+	subkey := func(l []byte) []byte {
+		r := make([]byte, 16)
+		for i := 0; i < 15; i++ {
+			r[i] = l[i]<<1 | l[i+1]>>7
+		}
+		r[15] = l[15] << 1
+		if l[0]&0x80 != 0 {
+			r[15] ^= 0x87
+		}
+		return r
+	}
+	l := make([]byte, 16)
+	c.Encrypt(l, l)
+	k1 := subkey(l)
+	k2 := subkey(k1)
+
+	n := (len(m) + 15) / 16
+	last := make([]byte, 16)
+	if n > 0 && len(m)%16 == 0 {
+		copy(last, xor(m[(n-1)*16:], k1))
+	} else {
+		if n == 0 {
+			n = 1
+		}
+		copy(last, m[(n-1)*16:])
+		last[len(m)-(n-1)*16] = 0x80
+		last = xor(last, k2)
+	}
+	x := make([]byte, 16)
+	for i := 0; i < n-1; i++ {
+		c.Encrypt(x, xor(x, m[i*16:i*16+16]))
+	}
+	c.Encrypt(x, xor(x, last))
+	return x
+}
+
+// cat returns the big-endian concatenation of the little-endian values vv,
+// the first being the most significant.
+func cat(vv ...[]byte) []byte {
+	var b []byte
+	for _, v := range vv {
+		b = append(b, swap(v)...)
+	}
+	return b
+}
+
+// F4 is the confirm value generation function f4 of LE Secure Connections,
+// of the public key x-coordinates u and v, the random value x, and z.
+func F4(u, v, x []byte, z uint8) []byte {
+	return swap(AESCMAC(swap(x), cat(u, v, []byte{z})))
+}
+
+// f5salt is the SALT of f5.
+var f5salt = []byte{0x6C, 0x88, 0x83, 0x91, 0xAA, 0xF5, 0xA5, 0x38, 0x60, 0x37, 0x0B, 0xDB, 0x5A, 0x60, 0x83, 0xBE}
+
+// F5 is the key generation function f5 of LE Secure Connections, which
+// generates the MacKey and the LTK from the DHKey w, the random values n1 and
+// n2, and the 56-bit addresses a1 and a2, each the address followed by its
+// type.
+func F5(w, n1, n2, a1, a2 []byte) (mackey, ltk []byte) {
+	t := AESCMAC(f5salt, swap(w))
+	m := append([]byte{0, 0x62, 0x74, 0x6C, 0x65}, cat(n1, n2, a1, a2)...) // Counter || keyID "btle" || ...
+	m = append(m, 0x01, 0x00)                                              // Length, 256
+	mackey = swap(AESCMAC(t, m))
+	m[0] = 1
+	ltk = swap(AESCMAC(t, m))
+	return mackey, ltk
+}
+
+// F6 is the check value generation function f6 of LE Secure Connections, of
+// the MacKey w, the random values n1, n2 and r, the IO capabilities iocap,
+// and the addresses a1 and a2.
+func F6(w, n1, n2, r, iocap, a1, a2 []byte) []byte {
+	return swap(AESCMAC(swap(w), cat(n1, n2, r, iocap, a1, a2)))
+}
+
+// G2 is the numeric comparison value generation function g2 of LE Secure
+// Connections, of the public key x-coordinates u and v, and the random values
+// x and y. The value displayed is the result modulo 1000000.
+func G2(u, v, x, y []byte) uint32 {
+	b := AESCMAC(swap(x), cat(u, v, y))
+	return uint32(b[12])<<24 | uint32(b[13])<<16 | uint32(b[14])<<8 | uint32(b[15])
+}
+
+// H6 is the link key conversion function h6, of the key w and the 32-bit
+// keyID.
+func H6(w, keyID []byte) []byte {
+	return swap(AESCMAC(swap(w), swap(keyID)))
+}
+
+// H7 is the link key conversion function h7, of the 128-bit salt and the
+// key w.
+func H7(salt, w []byte) []byte {
+	return swap(AESCMAC(swap(salt), swap(w)))
+}
@@ -0,0 +1,75 @@
+// Package crypto implements the security functions of the Bluetooth Core
+// spec, Vol 3, Part H, 2.2 and 2.4.5; used by pairing, address resolution
+// and signed writes.
+//
+// The keys and values are little-endian, least significant octet first, as
+// they are sent over the air, while the spec, and AES, treat them as
+// big-endian numbers. AESCMAC is the exception.
+package crypto
+
+import "crypto/aes"
+
+// swap returns the octets of b in reverse order.
+func swap(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i, v := range b {
+		r[len(b)-1-i] = v
+	}
+	return r
+}
+
+func xor(a, b []byte) []byte {
+	r := make([]byte, len(a))
+	for i := range a {
+		r[i] = a[i] ^ b[i]
+	}
+	return r
+}
+
+// E is the security function e, AES-128 encrypting the 128-bit plaintext p
+// with the 128-bit key k.
+func E(k, p []byte) []byte {
+	c, err := aes.NewCipher(swap(k))
+	if err != nil {
+		panic(err) // k is always 16 bytes
+	}
+	b := make([]byte, 16)
+	c.Encrypt(b, swap(p))
+	return swap(b)
+}
+
+// C1 is the confirm value generation function c1 of LE legacy pairing, of the
+// temporary key k, the random value r, the Pairing Request and Response
+// commands preq and pres, and the types and addresses of the initiating and
+// responding devices.
+func C1(k, r, preq, pres []byte, iat uint8, ia []byte, rat uint8, ra []byte) []byte {
+	p1 := make([]byte, 16) // pres || preq || rat' || iat'
+	p1[0], p1[1] = iat, rat
+	copy(p1[2:9], preq)
+	copy(p1[9:16], pres)
+
+	p2 := make([]byte, 16) // padding || ia || ra
+	copy(p2[0:6], ra)
+	copy(p2[6:12], ia)
+
+	return E(k, xor(E(k, xor(r, p1)), p2))
+}
+
+// S1 is the key generation function s1 of LE legacy pairing, which generates
+// the STK from the temporary key k, and the random values r1 and r2 of the
+// responding and the initiating devices.
+func S1(k, r1, r2 []byte) []byte {
+	r := make([]byte, 16) // r1' || r2'
+	copy(r[0:8], r2[0:8])
+	copy(r[8:16], r1[0:8])
+	return E(k, r)
+}
+
+// Ah is the random address hash function ah, of the IRK k and the 24-bit
+// random value r, which returns the 24-bit hash of a resolvable private
+// address.
+func Ah(k, r []byte) []byte {
+	p := make([]byte, 16) // padding || r
+	copy(p, r[:3])
+	return E(k, p)[:3]
+}
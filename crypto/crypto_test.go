@@ -0,0 +1,146 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// be returns the little-endian bytes of the big-endian hex string s, as
+// the values are written in the spec.
+func be(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return swap(b)
+}
+
+func TestE(t *testing.T) {
+	// FIPS 197, C.1.
+	k := be("000102030405060708090a0b0c0d0e0f")
+	got := E(k, be("00112233445566778899aabbccddeeff"))
+	if want := be("69c4e0d86a7b0430d8cdb78070b4c55a"); !bytes.Equal(got, want) {
+		t.Errorf("e: got [ % X ] want [ % X ]", got, want)
+	}
+}
+
+func TestC1(t *testing.T) {
+	// Core spec Vol 3, Part H, 2.2.3.
+	k := make([]byte, 16)
+	r := be("5783D52156AD6F0E6388274EC6702EE0")
+	preq := be("07071000000101")
+	pres := be("05000800000302")
+	got := C1(k, r, preq, pres, 0x01, be("A1A2A3A4A5A6"), 0x00, be("B1B2B3B4B5B6"))
+	if want := be("1E1E3FEF878988EAD2A74DC5BEF13B86"); !bytes.Equal(got, want) {
+		t.Errorf("c1: got [ % X ] want [ % X ]", got, want)
+	}
+}
+
+func TestS1(t *testing.T) {
+	// Core spec Vol 3, Part H, 2.2.4.
+	k := make([]byte, 16)
+	got := S1(k, be("000F0E0D0C0B0A091122334455667788"), be("010203040506070899AABBCCDDEEFF00"))
+	if want := be("9A1FE1F0E8B0F49B5B4216AE796DA062"); !bytes.Equal(got, want) {
+		t.Errorf("s1: got [ % X ] want [ % X ]", got, want)
+	}
+}
+
+func TestAh(t *testing.T) {
+	// Core spec Vol 3, Part H, D.7.
+	got := Ah(be("ec0234a357c8ad05341010a60a397d9b"), be("708194"))
+	if want := be("0dfbaa"); !bytes.Equal(got, want) {
+		t.Errorf("ah: got [ % X ] want [ % X ]", got, want)
+	}
+}
+
+func TestAESCMAC(t *testing.T) {
+	// RFC 4493, 4.
+	k, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+	m, _ := hex.DecodeString("6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710")
+	for _, tt := range []struct {
+		n    int
+		want string
+	}{
+		{0, "bb1d6929e95937287fa37d129b756746"},
+		{16, "070a16b46b4d4144f79bdd9dd04a287c"},
+		{40, "dfa66747de9ae63030ca32611497c827"},
+		{64, "51f0bebf7e3b9d92fc49741779363cfe"},
+	} {
+		if got := hex.EncodeToString(AESCMAC(k, m[:tt.n])); got != tt.want {
+			t.Errorf("AES-CMAC of %d bytes: got %s want %s", tt.n, got, tt.want)
+		}
+	}
+}
+
+// The sample data of the LE Secure Connections functions. See Core spec
+// Vol 3, Part H, D.2 - D.5.
+var (
+	samplePKax = be("20b003d2f297be2c5e2c83a7e9f9a5b9eff49111acf4fddbcc0301480e359de6")
+	samplePKbx = be("55188b3d32f6bb9a900afcfbeed4e72a59cb9ac2f19d7cfb6b4fdd49f47fc5fd")
+	sampleNa   = be("d5cb8454d177733effffb2ec712baeab")
+	sampleNb   = be("a6e8e7cc25a75f6e216583f7ff3dc4cf")
+	sampleA1   = be("0056123737bfce")
+	sampleA2   = be("00a713702dcfc1")
+)
+
+func TestF4(t *testing.T) {
+	got := F4(samplePKax, samplePKbx, sampleNa, 0)
+	if want := be("f2c916f107a9bd1cf1eda1bea974872d"); !bytes.Equal(got, want) {
+		t.Errorf("f4: got [ % X ] want [ % X ]", got, want)
+	}
+}
+
+func TestF5(t *testing.T) {
+	w := be("ec0234a357c8ad05341010a60a397d9b99796b13b4f866f1868d34f373bfa698")
+	mackey, ltk := F5(w, sampleNa, sampleNb, sampleA1, sampleA2)
+	if want := be("2965f176a1084a02fd3f6a20ce636e20"); !bytes.Equal(mackey, want) {
+		t.Errorf("f5 MacKey: got [ % X ] want [ % X ]", mackey, want)
+	}
+	if want := be("6986791169d7cd23980522b594750a38"); !bytes.Equal(ltk, want) {
+		t.Errorf("f5 LTK: got [ % X ] want [ % X ]", ltk, want)
+	}
+}
+
+func TestF6(t *testing.T) {
+	w := be("2965f176a1084a02fd3f6a20ce636e20")
+	r := be("12a3343bb453bb5408da42d20c2d0fc8")
+	got := F6(w, sampleNa, sampleNb, r, be("010102"), sampleA1, sampleA2)
+	if want := be("e3c473989cd0e8c5d26c0b09da958f61"); !bytes.Equal(got, want) {
+		t.Errorf("f6: got [ % X ] want [ % X ]", got, want)
+	}
+}
+
+func TestG2(t *testing.T) {
+	if got := G2(samplePKax, samplePKbx, sampleNa, sampleNb); got != 0x2f9ed5ba {
+		t.Errorf("g2: got 0x%08x want 0x2f9ed5ba", got)
+	}
+}
+
+func TestH6(t *testing.T) {
+	// Core spec Vol 3, Part H, D.8.
+	got := H6(be("ec0234a357c8ad05341010a60a397d9b"), be("6c656272"))
+	if want := be("2d9ae102e76dc91ce8d3a9e280b16399"); !bytes.Equal(got, want) {
+		t.Errorf("h6: got [ % X ] want [ % X ]", got, want)
+	}
+}
+
+func TestH7(t *testing.T) {
+	// Core spec Vol 3, Part H, D.9.
+	got := H7(be("000000000000000000000000746D7031"), be("ec0234a357c8ad05341010a60a397d9b"))
+	if want := be("fb173597c6a3c0ecd2998c2a75a57011"); !bytes.Equal(got, want) {
+		t.Errorf("h7: got [ % X ] want [ % X ]", got, want)
+	}
+}
+
+func TestSign(t *testing.T) {
+	// The 16 byte message of RFC 4493, 4, as the data and the SignCounter
+	// of a signed write; their concatenation is the message of the MAC.
+	k := be("2b7e151628aed2a6abf7158809cf4f3c")
+	m := be("6bc1bee22e409f96e93d7e117393172a")
+	got := Sign(k, m[:12], 0x6bc1bee2)
+	want := append(be("6bc1bee2"), be("070a16b46b4d4144")...)
+	if !bytes.Equal(got, want) {
+		t.Errorf("signature: got [ % X ] want [ % X ]", got, want)
+	}
+}
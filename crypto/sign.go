@@ -0,0 +1,15 @@
+package crypto
+
+import "encoding/binary"
+
+// Sign returns the Authentication Signature of the data m, such as an ATT
+// Signed Write Command without its signature, signed with the CSRK k and the
+// SignCounter n; the counter followed by the 64 most significant bits of the
+// MAC. See Core spec Vol 3, Part H, 2.4.5.
+func Sign(k, m []byte, n uint32) []byte {
+	sig := make([]byte, 12)
+	binary.LittleEndian.PutUint32(sig, n)
+	mac := swap(AESCMAC(swap(k), swap(append(append([]byte{}, m...), sig[:4]...))))
+	copy(sig[4:], mac[8:])
+	return sig
+}
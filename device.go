@@ -1,6 +1,9 @@
 package gatt
 
-import "errors"
+import (
+	"context"
+	"errors"
+)
 
 var notImplemented = errors.New("not implemented")
 
@@ -73,11 +76,30 @@ type Device interface {
 	StopScanning()
 
 	// Connect connects to a remote peripheral.
-	Connect(p Peripheral)
-
-	// CancelConnection disconnects a remote peripheral.
+	// The connection is requested with the first of the params specified, or DefaultConnParams if none;
+	// they are ignored on OS X, where the system chooses them.
+	// A failure to request the connection, or to establish it, is reported by the PeripheralConnected handler.
+	// On Linux, the connections are initiated one at a time; the others are queued meanwhile.
+	Connect(p Peripheral, params ...ConnParams)
+
+	// ConnectAddress connects to the remote peripheral of the address, e.g. as parsed by ParseBDAddr,
+	// without scanning it first, and waits for the connection to be established.
+	// If ctx is done first, the connection is canceled, and ctx.Err() is returned.
+	// The connection, or the failure to establish it, is also reported by the PeripheralConnected handler.
+	// It is not implemented on OS X.
+	ConnectAddress(ctx context.Context, a BDAddr, params ...ConnParams) (Peripheral, error)
+
+	// CancelConnection cancels a pending connection to a remote peripheral, or disconnects it.
 	CancelConnection(p Peripheral)
 
+	// Listen listens for the L2CAP channels opened by remote devices to the LE_PSM.
+	Listen(psm int) (L2CAPListener, error)
+
+	// RemoveBond deletes the bond with the remote device of the identity address a from the BondStore,
+	// and from the resolving list of the controller, if the address resolution is enabled.
+	// It is not implemented on OS X.
+	RemoveBond(a BDAddr) error
+
 	// Handle registers the specified handlers.
 	Handle(h ...Handler)
 
@@ -94,7 +116,7 @@ type deviceHandler struct {
 	centralConnected func(c Central)
 
 	// disconnect is called when a remote central device disconnects to the device.
-	centralDisconnected func(c Central)
+	centralDisconnected func(c Central, err error)
 
 	// peripheralDiscovered is called when a remote peripheral device is found during scan procedure.
 	peripheralDiscovered func(p Peripheral, a *Advertisement, rssi int)
@@ -104,6 +126,27 @@ type deviceHandler struct {
 
 	// peripheralConnected is called when a remote peripheral is disconneted.
 	peripheralDisconnected func(p Peripheral, err error)
+
+	// centralLinkUpdated is called when the link layer of a remote central connection changes.
+	centralLinkUpdated func(c Central, l Link, err error)
+
+	// peripheralLinkUpdated is called when the link layer of a remote peripheral connection changes.
+	peripheralLinkUpdated func(p Peripheral, l Link, err error)
+
+	// centralConnParamsRequested is called when a remote central requests new connection parameters.
+	centralConnParamsRequested func(c Central, p ConnParams) bool
+
+	// peripheralConnParamsRequested is called when a remote peripheral requests new connection parameters.
+	peripheralConnParamsRequested func(p Peripheral, cp ConnParams) bool
+
+	// centralPaired is called when a pairing with a remote central completes, or fails.
+	centralPaired func(c Central, err error)
+
+	// peripheralPaired is called when a pairing with a remote peripheral completes, or fails.
+	peripheralPaired func(p Peripheral, err error)
+
+	// agent takes part in the pairings.
+	agent Agent
 }
 
 // A Handler is a self-referential function, which registers the options specified.
@@ -123,7 +166,8 @@ func CentralConnected(f func(Central)) Handler {
 }
 
 // CentralDisconnected returns a Handler, which sets the specified function to be called when a device disconnects from the server.
-func CentralDisconnected(f func(Central)) Handler {
+// On Linux, err is the DisconnectReason reported by the controller.
+func CentralDisconnected(f func(Central, error)) Handler {
 	return func(d Device) { d.(*device).centralDisconnected = f }
 }
 
@@ -133,15 +177,53 @@ func PeripheralDiscovered(f func(Peripheral, *Advertisement, int)) Handler {
 }
 
 // PeripheralConnected returns a Handler, which sets the specified function to be called when a remote peripheral device connects.
+// On Linux, err is a DisconnectReason if the controller reports the failure to establish the connection.
 func PeripheralConnected(f func(Peripheral, error)) Handler {
 	return func(d Device) { d.(*device).peripheralConnected = f }
 }
 
 // PeripheralDisconnected returns a Handler, which sets the specified function to be called when a remote peripheral device disconnects.
+// On Linux, err is the DisconnectReason reported by the controller.
 func PeripheralDisconnected(f func(Peripheral, error)) Handler {
 	return func(d Device) { d.(*device).peripheralDisconnected = f }
 }
 
+// CentralLinkUpdated returns a Handler, which sets the specified function to be called when the data length, the PHY or the connection parameters of a connection to a remote central change.
+// A failure to update the PHY or the connection parameters is reported with the unchanged link and a non-nil error.
+func CentralLinkUpdated(f func(Central, Link, error)) Handler {
+	return func(d Device) { d.(*device).centralLinkUpdated = f }
+}
+
+// PeripheralLinkUpdated returns a Handler, which sets the specified function to be called when the data length, the PHY or the connection parameters of a connection to a remote peripheral change.
+// A failure to update the PHY or the connection parameters is reported with the unchanged link and a non-nil error.
+func PeripheralLinkUpdated(f func(Peripheral, Link, error)) Handler {
+	return func(d Device) { d.(*device).peripheralLinkUpdated = f }
+}
+
+// CentralConnParamsRequested returns a Handler, which sets the specified function to be called when a remote central requests new connection parameters.
+// The function reports whether the parameters are accepted. Parameters out of the ranges of the spec are always rejected;
+// the others are accepted if no function is set.
+func CentralConnParamsRequested(f func(Central, ConnParams) bool) Handler {
+	return func(d Device) { d.(*device).centralConnParamsRequested = f }
+}
+
+// PeripheralConnParamsRequested returns a Handler, which sets the specified function to be called when a remote peripheral requests new connection parameters.
+// The function reports whether the parameters are accepted. Parameters out of the ranges of the spec are always rejected;
+// the others are accepted if no function is set.
+func PeripheralConnParamsRequested(f func(Peripheral, ConnParams) bool) Handler {
+	return func(d Device) { d.(*device).peripheralConnParamsRequested = f }
+}
+
+// CentralPaired returns a Handler, which sets the specified function to be called when a pairing with a remote central completes, or fails.
+func CentralPaired(f func(Central, error)) Handler {
+	return func(d Device) { d.(*device).centralPaired = f }
+}
+
+// PeripheralPaired returns a Handler, which sets the specified function to be called when a pairing with a remote peripheral completes, or fails.
+func PeripheralPaired(f func(Peripheral, error)) Handler {
+	return func(d Device) { d.(*device).peripheralPaired = f }
+}
+
 // An Option is a self-referential function, which sets the option specified.
 // Most Options are platform-specific, which gives more fine-grained control over the device at a cost of losing portibility.
 // See http://commandcenter.blogspot.com.au/2014/01/self-referential-functions-and-design.html for more discussion.
@@ -2,6 +2,7 @@ package gatt
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -168,7 +169,7 @@ func (d *device) AddService(s *Service) error {
 		perm := 0
 		if c.props&CharRead != 0 {
 			props |= 0x02
-			if CharRead&c.secure != 0 {
+			if c.rperm.encrypted() {
 				perm |= 0x04
 			} else {
 				perm |= 0x01
@@ -176,7 +177,7 @@ func (d *device) AddService(s *Service) error {
 		}
 		if c.props&CharWriteNR != 0 {
 			props |= 0x04
-			if c.secure&CharWriteNR != 0 {
+			if c.wperm.encrypted() {
 				perm |= 0x08
 			} else {
 				perm |= 0x02
@@ -184,21 +185,21 @@ func (d *device) AddService(s *Service) error {
 		}
 		if c.props&CharWrite != 0 {
 			props |= 0x08
-			if c.secure&CharWrite != 0 {
+			if c.wperm.encrypted() {
 				perm |= 0x08
 			} else {
 				perm |= 0x02
 			}
 		}
 		if c.props&CharNotify != 0 {
-			if c.secure&CharNotify != 0 {
+			if c.rperm.encrypted() {
 				props |= 0x100
 			} else {
 				props |= 0x10
 			}
 		}
 		if c.props&CharIndicate != 0 {
-			if c.secure&CharIndicate != 0 {
+			if c.rperm.encrypted() {
 				props |= 0x200
 			} else {
 				props |= 0x20
@@ -261,7 +262,7 @@ func (d *device) StopScanning() {
 	d.sendCmd(30, nil)
 }
 
-func (d *device) Connect(p Peripheral) {
+func (d *device) Connect(p Peripheral, params ...ConnParams) {
 	pp := p.(*peripheral)
 	d.plist[pp.id.String()] = pp
 	d.sendCmd(31,
@@ -359,10 +360,21 @@ func (d *device) respondToRequest(id int, args xpc.Dict) {
 	}
 }
 
+func (d *device) ConnectAddress(ctx context.Context, a BDAddr, params ...ConnParams) (Peripheral, error) {
+	return nil, notImplemented
+}
+
 func (d *device) CancelConnection(p Peripheral) {
 	d.sendCmd(32, xpc.Dict{"kCBMsgArgDeviceUUID": p.(*peripheral).id})
 }
 
+func (d *device) Listen(psm int) (L2CAPListener, error) { return nil, notImplemented }
+
+func (d *device) RemoveBond(a BDAddr) error { return notImplemented }
+
+// setAgent sets the pairing agent, which the system takes the place of.
+func (d *device) setAgent(a Agent) { d.agent = a }
+
 // process device events and asynchronous errors
 // (implements XpcEventHandler)
 func (d *device) HandleXpcEvent(event xpc.Dict, err error) {
@@ -1,8 +1,10 @@
 package gatt
 
 import (
+	"context"
 	"encoding/binary"
-	"net"
+	"log"
+	"sync"
 
 	"github.com/paypal/gatt/linux"
 	"github.com/paypal/gatt/linux/cmd"
@@ -18,14 +20,28 @@ type device struct {
 	svcs  []*Service
 	attrs *attrRange
 
-	devID   int
-	chkLE   bool
-	maxConn int
+	devID     int
+	chkLE     bool
+	maxConn   int
+	maxPeriph int // connections to remote peripherals at a time, if non-zero
 
 	advData   *cmd.LESetAdvertisingData
 	scanResp  *cmd.LESetScanResponseData
 	advParam  *cmd.LESetAdvertisingParameters
+	advSent   cmd.LESetAdvertisingParameters // advertising parameters last sent
 	scanParam *cmd.LESetScanParameters
+
+	defDataLen *cmd.LEWriteSuggestedDefaultDataLength
+	defPHY     *cmd.LESetDefaultPHY
+	privacy    *linux.Privacy
+	resolving  bool // the controller resolves the private addresses of the bonds
+	scanFilter bool // the scanning is restricted to the filter accept list
+
+	peers   map[*linux.PlatData]interface{}     // remote centrals and peripherals, by connection
+	conns   map[*linux.PlatData]chan connResult // connections awaited by ConnectAddress
+	peersmu *sync.Mutex
+
+	bonds BondStore
 }
 
 func NewDevice(opts ...Option) (Device, error) {
@@ -51,6 +67,12 @@ func NewDevice(opts ...Option) (Device, error) {
 			OwnAddressType:       0x00,   // [0x00]: public, 0x01: random
 			ScanningFilterPolicy: 0x00,   // [0x00]: accept all, 0x01: ignore non-white-listed.
 		},
+
+		peers:   map[*linux.PlatData]interface{}{},
+		conns:   map[*linux.PlatData]chan connResult{},
+		peersmu: &sync.Mutex{},
+
+		bonds: newBondMap(),
 	}
 
 	d.Option(opts...)
@@ -60,43 +82,105 @@ func NewDevice(opts ...Option) (Device, error) {
 	}
 
 	d.hci = h
+	h.SetMaxMasterConnections(d.maxPeriph)
+	if err := d.updateDefaults(); err != nil {
+		h.Close()
+		return nil, err
+	}
+
+	// Distribute a CSRK in the pairings, so that the local device can sign
+	// its writes. The bonds keep the CSRKs they were paired with.
+	h.SetLocalKeys(nil, true)
+
+	if d.privacy != nil {
+		if err := h.SetPrivacy(*d.privacy); err != nil {
+			h.Close()
+			return nil, err
+		}
+		d.advParam.OwnAddressType = h.OwnAddressType()
+	}
+	if d.scanFilter {
+		if err := h.SetScanFilter(true); err != nil {
+			h.Close()
+			return nil, err
+		}
+	}
+	if d.resolving {
+		if err := d.syncResolvingList(); err != nil {
+			h.Close()
+			return nil, err
+		}
+	}
 	return d, nil
 }
 
 func (d *device) Init(f func(Device, State)) error {
 	d.hci.AcceptMasterHandler = func(pd *linux.PlatData) {
-		a := pd.Address
-		c := newCentral(d.attrs, net.HardwareAddr([]byte{a[5], a[4], a[3], a[2], a[1], a[0]}), pd.Conn)
+		a, _ := identity(d.bonds, pd)
+		c := newCentral(d.attrs, a, pd.Conn)
+		c.hci = d.hci
+		c.pd = pd
+		c.bonds = d.bonds
+		remove := d.addPeer(pd, c)
+		// The link is encrypted with the LTK of the bond before the
+		// connection is accepted, if the central connected again.
+		if c.security().Bonded {
+			c.resume()
+		}
 		if d.centralConnected != nil {
 			d.centralConnected(c)
 		}
 		c.loop()
+		remove()
 		if d.centralDisconnected != nil {
-			d.centralDisconnected(c)
+			d.centralDisconnected(c, disconnectReason(pd))
 		}
 	}
 	d.hci.AcceptSlaveHandler = func(pd *linux.PlatData) {
 		p := &peripheral{
 			d:     d,
 			pd:    pd,
-			l2c:   pd.Conn,
-			reqc:  make(chan message),
+			att:   newBearer(pd.Conn, 23, false),
+			reqc:  make(chan *message),
 			quitc: make(chan struct{}),
 			sub:   newSubscriber(),
 		}
-		if d.peripheralConnected != nil {
-			go d.peripheralConnected(p, nil)
-		}
+		p.id, _ = identity(d.bonds, pd)
+		remove := d.addPeer(pd, p)
+		d.connected(pd, p, nil)
 		p.loop()
+		remove()
 		if d.peripheralDisconnected != nil {
-			d.peripheralDisconnected(p, nil)
+			d.peripheralDisconnected(p, disconnectReason(pd))
 		}
 	}
+	if l, err := d.hci.ListenEncrypted(psmEATT); err == nil {
+		go d.acceptEATT(l)
+	}
+	d.hci.LinkUpdatedHandler = d.handleLinkUpdated
+	d.hci.ConnParamsRequestHandler = d.handleConnParamsRequest
+	d.hci.PairedHandler = d.handlePaired
+	d.hci.EncryptedHandler = d.handleEncrypted
+	d.hci.PairingRequestHandler = d.handlePairingRequest
+	d.hci.PasskeyDisplayHandler = d.handlePasskeyDisplay
+	d.hci.PasskeyRequestHandler = d.handlePasskeyRequest
+	d.hci.ConfirmPasskeyHandler = d.handleConfirmPasskey
+	d.hci.BondHandler = d.handleBond
+	d.hci.ConnectFailedHandler = func(pd *linux.PlatData, err error) {
+		p := &peripheral{d: d, pd: pd}
+		p.id, _ = identity(d.bonds, pd)
+		d.connected(pd, p, connectError(err))
+	}
 	d.hci.AdvertisementHandler = func(pd *linux.PlatData) {
-		a := &Advertisement{}
-		a.unmarshall(pd.Data)
-		a.Connectable = pd.Connectable
+		a := &Advertisement{Connectable: pd.Connectable}
+		if err := a.Unmarshal(pd.Data); err != nil {
+			log.Printf("malformed advertisement of %s: %s", pd.Address, err)
+		}
+		if err := a.UnmarshalScanResponse(pd.ScanResponse); err != nil {
+			log.Printf("malformed scan response of %s: %s", pd.Address, err)
+		}
 		p := &peripheral{pd: pd, d: d}
+		p.id, _ = identity(d.bonds, pd)
 		if d.peripheralDiscovered != nil {
 			pd.Name = a.LocalName
 			d.peripheralDiscovered(p, a, int(pd.RSSI))
@@ -202,8 +286,67 @@ func (d *device) StopScanning() {
 	d.hci.SetScanEnable(false, true)
 }
 
-func (d *device) Connect(p Peripheral) {
-	d.hci.Connect(p.(*peripheral).pd)
+func (d *device) Connect(p Peripheral, params ...ConnParams) {
+	cp := DefaultConnParams
+	if len(params) > 0 {
+		cp = params[0]
+	}
+	if err := d.hci.Connect(p.(*peripheral).pd, cp.lnx()); err != nil && d.peripheralConnected != nil {
+		go d.peripheralConnected(p, err)
+	}
+}
+
+// connResult is the outcome of a connection awaited by ConnectAddress.
+type connResult struct {
+	p   Peripheral
+	err error
+}
+
+func (d *device) ConnectAddress(ctx context.Context, a BDAddr, params ...ConnParams) (Peripheral, error) {
+	cp := DefaultConnParams
+	if len(params) > 0 {
+		cp = params[0]
+	}
+	pd := &linux.PlatData{Address: a}
+	rc := make(chan connResult, 1)
+	d.peersmu.Lock()
+	d.conns[pd] = rc
+	d.peersmu.Unlock()
+	defer func() {
+		d.peersmu.Lock()
+		delete(d.conns, pd)
+		d.peersmu.Unlock()
+	}()
+	if err := d.hci.Connect(pd, cp.lnx()); err != nil {
+		return nil, err
+	}
+	select {
+	case r := <-rc:
+		return r.p, r.err
+	case <-ctx.Done():
+	}
+	// Once canceled, the connection fails, unless it was established
+	// meanwhile; it is closed then. Either way it is reported, even if the
+	// cancellation raced its completion.
+	d.hci.CancelConnection(pd)
+	if r := <-rc; r.err == nil {
+		d.hci.CancelConnection(pd)
+	}
+	return nil, ctx.Err()
+}
+
+// connected reports the connection to the peripheral p, or the failure to
+// establish it, to ConnectAddress and to the PeripheralConnected handler.
+func (d *device) connected(pd *linux.PlatData, p *peripheral, err error) {
+	d.peersmu.Lock()
+	rc := d.conns[pd]
+	d.peersmu.Unlock()
+	if rc != nil {
+		rc <- connResult{p, err}
+	}
+	if d.peripheralConnected != nil {
+		go d.peripheralConnected(p, err)
+	}
 }
 
 func (d *device) CancelConnection(p Peripheral) {
@@ -220,6 +363,7 @@ func (d *device) update() error {
 		if err := d.hci.SendCmdWithAdvOff(d.advParam); err != nil {
 			return err
 		}
+		d.advSent = *d.advParam
 		d.advParam = nil
 	}
 	if d.scanResp != nil {
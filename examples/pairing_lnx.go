@@ -0,0 +1,135 @@
+// +build
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/paypal/gatt"
+	"github.com/paypal/gatt/examples/option"
+	"github.com/paypal/gatt/examples/service"
+)
+
+// pairing_lnx implements a GATT server, which pairs with the remote centrals
+// through a pairing agent on the command line, and keeps the bonds in a file.
+
+var (
+	name  = flag.String("name", "Gopher", "Device Name")
+	io    = flag.String("io", "keyboarddisplay", "IO capabilities: display, yesno, keyboard, none, keyboarddisplay")
+	mitm  = flag.Bool("mitm", true, "Require MITM protection")
+	sc    = flag.Bool("sc", true, "Use LE Secure Connections, if supported by the remote device")
+	bonds = flag.String("bonds", "bonds.json", "File of the bonds")
+)
+
+var ioCaps = map[string]gatt.IOCapability{
+	"display":         gatt.IODisplayOnly,
+	"yesno":           gatt.IODisplayYesNo,
+	"keyboard":        gatt.IOKeyboardOnly,
+	"none":            gatt.IONoInputNoOutput,
+	"keyboarddisplay": gatt.IOKeyboardDisplay,
+}
+
+// cliAgent is a pairing agent, which interacts with the user on the
+// command line, one pairing at a time.
+type cliAgent struct {
+	io   gatt.IOCapability
+	auth gatt.AuthReq
+
+	mu *sync.Mutex
+	in *bufio.Reader
+}
+
+func (a *cliAgent) IOCapability() gatt.IOCapability { return a.io }
+func (a *cliAgent) AuthReq() gatt.AuthReq           { return a.auth }
+
+// ask prints the prompt, and returns the line entered by the user.
+func (a *cliAgent) ask(prompt string) string {
+	fmt.Print(prompt)
+	s, _ := a.in.ReadString('\n')
+	return strings.TrimSpace(s)
+}
+
+func (a *cliAgent) AuthorizePairing(id string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.ask(fmt.Sprintf("Pair with %s? [y/n] ", id)) == "y"
+}
+
+func (a *cliAgent) DisplayPasskey(id string, passkey uint32) {
+	fmt.Printf("Enter the passkey %06d on %s\n", passkey, id)
+}
+
+func (a *cliAgent) RequestPasskey(id string) (uint32, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	n, err := strconv.ParseUint(a.ask(fmt.Sprintf("Passkey displayed by %s: ", id)), 10, 32)
+	return uint32(n), err
+}
+
+func (a *cliAgent) ConfirmPasskey(id string, passkey uint32) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.ask(fmt.Sprintf("Does %s display the passkey %06d? [y/n] ", id, passkey)) == "y"
+}
+
+func main() {
+	flag.Parse()
+	ioc, ok := ioCaps[*io]
+	if !ok {
+		log.Fatalf("Unknown IO capabilities: %s", *io)
+	}
+	auth := gatt.AuthBonding
+	if *mitm {
+		auth |= gatt.AuthMITM
+	}
+	if *sc {
+		auth |= gatt.AuthSC
+	}
+
+	s, err := gatt.NewFileBondStore(*bonds)
+	if err != nil {
+		log.Fatalf("Failed to open the bonds, err: %s", err)
+	}
+	d, err := gatt.NewDevice(append(option.DefaultServerOptions, gatt.LnxSetBondStore(s))...)
+	if err != nil {
+		log.Fatalf("Failed to open device, err: %s", err)
+	}
+
+	// Register the pairing agent, and optional handlers.
+	d.Handle(
+		gatt.PairingAgent(&cliAgent{io: ioc, auth: auth, mu: &sync.Mutex{}, in: bufio.NewReader(os.Stdin)}),
+		gatt.CentralConnected(func(c gatt.Central) { fmt.Println("Connect: ", c.ID()) }),
+		gatt.CentralDisconnected(func(c gatt.Central, err error) { fmt.Println("Disconnect: ", c.ID()) }),
+		gatt.CentralPaired(func(c gatt.Central, err error) {
+			if err != nil {
+				fmt.Printf("Pairing with %s failed, err: %s\n", c.ID(), err)
+				return
+			}
+			fmt.Println("Paired: ", c.ID())
+		}),
+	)
+
+	// A mandatory handler for monitoring device state.
+	onStateChanged := func(d gatt.Device, s gatt.State) {
+		fmt.Printf("State: %s\n", s)
+		switch s {
+		case gatt.StatePoweredOn:
+			d.AddService(service.NewGapService(*name))
+			d.AddService(service.NewGattService())
+			s1 := service.NewCountService()
+			d.AddService(s1)
+			d.AdvertiseNameAndServices(*name, []gatt.UUID{s1.UUID()})
+		default:
+		}
+	}
+
+	d.Init(onStateChanged)
+	select {}
+}
@@ -67,7 +67,7 @@ func main() {
 	// Register optional handlers.
 	d.Handle(
 		gatt.CentralConnected(func(c gatt.Central) { log.Println("Connect: ", c.ID()) }),
-		gatt.CentralDisconnected(func(c gatt.Central) { log.Println("Disconnect: ", c.ID()) }),
+		gatt.CentralDisconnected(func(c gatt.Central, err error) { log.Println("Disconnect: ", c.ID()) }),
 	)
 
 	// A mandatory handler for monitoring device state.
@@ -1,10 +1,6 @@
 package service
 
-import (
-	"log"
-
-	"github.com/paypal/gatt"
-)
+import "github.com/paypal/gatt"
 
 var (
 	attrGATTUUID           = gatt.UUID16(0x1801)
@@ -15,11 +11,11 @@ var (
 // For Linux/Embedded, however, this is something we want to fully control.
 func NewGattService() *gatt.Service {
 	s := gatt.NewService(attrGATTUUID)
+	// The changes of the services are indicated to the bonded centrals by
+	// the package, when they connect again.
 	s.AddCharacteristic(attrServiceChangedUUID).HandleNotifyFunc(
-		func(r gatt.Request, n gatt.Notifier) {
-			go func() {
-				log.Printf("TODO: indicate client when the services are changed")
-			}()
-		})
+		func(r gatt.Request, n gatt.Notifier) {})
+	s.AddServerFeatures(gatt.ServerFeatEATT)
+	s.AddClientFeatures()
 	return s
 }
@@ -0,0 +1,36 @@
+package gatt
+
+// Features of the Server Supported Features characteristic.
+const (
+	ServerFeatEATT = 0x01 // Enhanced ATT bearers supported
+)
+
+// Features of the Client Supported Features characteristic.
+const (
+	ClientFeatRobustCaching = 0x01 // Robust Caching
+	ClientFeatEATT          = 0x02 // Enhanced ATT bearers
+	ClientFeatMultiNotify   = 0x04 // Multiple Handle Value Notifications
+
+	clientFeatAll = ClientFeatRobustCaching | ClientFeatEATT | ClientFeatMultiNotify
+)
+
+// AddServerFeatures adds the Server Supported Features characteristic, of the
+// features f, to the GATT service. The Enhanced ATT bearers opened by the
+// centrals are only accepted if f includes ServerFeatEATT.
+// AddServerFeatures has no effect on OS X.
+func (s *Service) AddServerFeatures(f byte) *Characteristic {
+	c := s.AddCharacteristic(attrServerSupportedFeaturesUUID)
+	c.SetValue([]byte{f})
+	return c
+}
+
+// AddClientFeatures adds the Client Supported Features characteristic to the
+// GATT service. Its value is written by each central, and is kept by the
+// server for the connection, and across the connections of a bonded central.
+// A central can't disable the features it enabled.
+// AddClientFeatures has no effect on OS X.
+func (s *Service) AddClientFeatures() *Characteristic {
+	c := s.AddCharacteristic(attrClientSupportedFeaturesUUID)
+	c.props |= CharRead | CharWrite
+	return c
+}
@@ -0,0 +1,151 @@
+package gatt
+
+import (
+	"fmt"
+	"time"
+)
+
+// PHY is a physical layer of the LE radio.
+type PHY int
+
+const (
+	PHY1M    PHY = 0x01 // LE 1M, the default PHY
+	PHY2M    PHY = 0x02 // LE 2M, twice the symbol rate of LE 1M
+	PHYCoded PHY = 0x03 // LE Coded, for longer range
+)
+
+func (p PHY) String() string {
+	switch p {
+	case PHY1M:
+		return "LE 1M"
+	case PHY2M:
+		return "LE 2M"
+	case PHYCoded:
+		return "LE Coded"
+	}
+	return fmt.Sprintf("PHY(%d)", int(p))
+}
+
+// mask returns the PHY in the bitmask format of the HCI commands.
+func (p PHY) mask() (uint8, error) {
+	if p < PHY1M || p > PHYCoded {
+		return 0, fmt.Errorf("invalid PHY %d", int(p))
+	}
+	return 1 << uint(p-1), nil
+}
+
+// Data lengths of the LL data PDUs. See Core spec Vol 6, Part B, 4.5.10.
+const (
+	MinDataLength = 27
+	MaxDataLength = 251
+)
+
+func checkDataLength(n int) error {
+	if n < MinDataLength || n > MaxDataLength {
+		return fmt.Errorf("invalid data length %d, must be in [%d, %d]", n, MinDataLength, MaxDataLength)
+	}
+	return nil
+}
+
+// Link describes the link layer of a connection.
+type Link struct {
+	MaxTxOctets int           // maximum payload octets of the LL data PDUs sent
+	MaxTxTime   time.Duration // maximum time to send a LL data PDU
+	MaxRxOctets int           // maximum payload octets of the LL data PDUs received
+	MaxRxTime   time.Duration // maximum time to receive a LL data PDU
+	TxPHY       PHY           // PHY used by the transmitter
+	RxPHY       PHY           // PHY used by the receiver
+	Interval    time.Duration // connection interval
+	Latency     int           // connection events the peripheral can skip
+	Timeout     time.Duration // supervision timeout
+}
+
+// Role is the role of the local device in a connection.
+type Role int
+
+const (
+	RoleCentral    Role = iota // the local device is the central, the master of the connection
+	RolePeripheral             // the local device is the peripheral, the slave of the connection
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleCentral:
+		return "central"
+	case RolePeripheral:
+		return "peripheral"
+	}
+	return fmt.Sprintf("Role(%d)", int(r))
+}
+
+// ConnInfo describes a connection.
+type ConnInfo struct {
+	Handle      uint16        // connection handle of the controller
+	Role        Role          // role of the local device
+	PeerAddress BDAddr        // address of the remote device in the connection
+	Interval    time.Duration // connection interval
+	Latency     int           // connection events the peripheral can skip
+	Timeout     time.Duration // supervision timeout
+}
+
+// A DisconnectReason is the reason a connection terminated, or failed to be
+// established, as reported by the controller. See Core spec Vol 1, Part F,
+// Controller Error Codes.
+type DisconnectReason uint8
+
+const (
+	ReasonUnknownConnectionID      DisconnectReason = 0x02 // Unknown Connection Identifier; the connection was canceled
+	ReasonAuthenticationFailure    DisconnectReason = 0x05 // Authentication Failure
+	ReasonConnectionTimeout        DisconnectReason = 0x08 // Connection Timeout; the supervision timeout expired
+	ReasonRemoteUserTerminated     DisconnectReason = 0x13 // Remote User Terminated Connection
+	ReasonRemoteLowResources       DisconnectReason = 0x14 // Remote Device Terminated Connection due to Low Resources
+	ReasonRemotePowerOff           DisconnectReason = 0x15 // Remote Device Terminated Connection due to Power Off
+	ReasonLocalHostTerminated      DisconnectReason = 0x16 // Connection Terminated By Local Host
+	ReasonUnsupportedRemoteFeature DisconnectReason = 0x1A // Unsupported Remote Feature
+	ReasonLLResponseTimeout        DisconnectReason = 0x22 // LL Response Timeout
+	ReasonInstantPassed            DisconnectReason = 0x28 // Instant Passed
+	ReasonUnacceptableConnParams   DisconnectReason = 0x3B // Unacceptable Connection Parameters
+	ReasonMICFailure               DisconnectReason = 0x3D // Connection Terminated due to MIC Failure
+	ReasonConnectionFailed         DisconnectReason = 0x3E // Connection Failed to be Established
+)
+
+var disconnectReasons = map[DisconnectReason]string{
+	ReasonUnknownConnectionID:      "unknown connection identifier",
+	ReasonAuthenticationFailure:    "authentication failure",
+	ReasonConnectionTimeout:        "connection timeout",
+	ReasonRemoteUserTerminated:     "remote user terminated connection",
+	ReasonRemoteLowResources:       "remote device terminated connection due to low resources",
+	ReasonRemotePowerOff:           "remote device terminated connection due to power off",
+	ReasonLocalHostTerminated:      "connection terminated by local host",
+	ReasonUnsupportedRemoteFeature: "unsupported remote feature",
+	ReasonLLResponseTimeout:        "LL response timeout",
+	ReasonInstantPassed:            "instant passed",
+	ReasonUnacceptableConnParams:   "unacceptable connection parameters",
+	ReasonMICFailure:               "connection terminated due to MIC failure",
+	ReasonConnectionFailed:         "connection failed to be established",
+}
+
+func (r DisconnectReason) Error() string {
+	if s, ok := disconnectReasons[r]; ok {
+		return s
+	}
+	return fmt.Sprintf("disconnected, reason 0x%02X", uint8(r))
+}
+
+// ConnParams are the connection parameters requested for a connection.
+// The intervals are rounded down to multiples of 1.25 ms, and the timeout
+// to multiples of 10 ms.
+type ConnParams struct {
+	MinInterval time.Duration // minimum connection interval, from 7.5 ms to 4 s
+	MaxInterval time.Duration // maximum connection interval, from 7.5 ms to 4 s
+	Latency     int           // connection events the peripheral can skip, up to 499
+	Timeout     time.Duration // supervision timeout, from 100 ms to 32 s, longer than (1 + Latency) * MaxInterval * 2
+}
+
+// DefaultConnParams are the connection parameters used by Connect, unless specified.
+var DefaultConnParams = ConnParams{
+	MinInterval: 7500 * time.Microsecond,
+	MaxInterval: 7500 * time.Microsecond,
+	Latency:     0,
+	Timeout:     2 * time.Second,
+}
@@ -0,0 +1,184 @@
+package gatt
+
+import (
+	"time"
+
+	"github.com/paypal/gatt/linux"
+	"github.com/paypal/gatt/linux/cmd"
+)
+
+func linkOf(l linux.LinkState) Link {
+	return Link{
+		MaxTxOctets: int(l.MaxTxOctets),
+		MaxTxTime:   time.Duration(l.MaxTxTime) * time.Microsecond,
+		MaxRxOctets: int(l.MaxRxOctets),
+		MaxRxTime:   time.Duration(l.MaxRxTime) * time.Microsecond,
+		TxPHY:       PHY(l.TxPHY),
+		RxPHY:       PHY(l.RxPHY),
+		Interval:    time.Duration(l.Interval) * 1250 * time.Microsecond,
+		Latency:     int(l.Latency),
+		Timeout:     time.Duration(l.Timeout) * 10 * time.Millisecond,
+	}
+}
+
+func connParamsOf(p linux.ConnParams) ConnParams {
+	return ConnParams{
+		MinInterval: time.Duration(p.IntervalMin) * 1250 * time.Microsecond,
+		MaxInterval: time.Duration(p.IntervalMax) * 1250 * time.Microsecond,
+		Latency:     int(p.Latency),
+		Timeout:     time.Duration(p.Timeout) * 10 * time.Millisecond,
+	}
+}
+
+// lnx returns the connection parameters in the units of HCI. The values
+// out of range are left for HCI to reject.
+func (p ConnParams) lnx() linux.ConnParams {
+	u16 := func(n int64) uint16 {
+		if n < 0 || n > 0xFFFF {
+			return 0xFFFF
+		}
+		return uint16(n)
+	}
+	return linux.ConnParams{
+		IntervalMin: u16(int64(p.MinInterval / (1250 * time.Microsecond))),
+		IntervalMax: u16(int64(p.MaxInterval / (1250 * time.Microsecond))),
+		Latency:     u16(int64(p.Latency)),
+		Timeout:     u16(int64(p.Timeout / (10 * time.Millisecond))),
+	}
+}
+
+func updateParameters(h *linux.HCI, pd *linux.PlatData, p ConnParams) error {
+	return h.UpdateParameters(pd.Handle, p.lnx())
+}
+
+func setDataLength(h *linux.HCI, pd *linux.PlatData, n int) error {
+	if err := checkDataLength(n); err != nil {
+		return err
+	}
+	return h.SetDataLength(pd.Handle, uint16(n))
+}
+
+func setPHY(h *linux.HCI, pd *linux.PlatData, tx, rx PHY) error {
+	txm, err := tx.mask()
+	if err != nil {
+		return err
+	}
+	rxm, err := rx.mask()
+	if err != nil {
+		return err
+	}
+	return h.SetPHY(pd.Handle, txm, rxm)
+}
+
+func link(h *linux.HCI, pd *linux.PlatData) Link {
+	l, err := h.Link(pd.Handle)
+	if err != nil {
+		return Link{}
+	}
+	return linkOf(l)
+}
+
+func connInfo(h *linux.HCI, pd *linux.PlatData, r Role) ConnInfo {
+	l := link(h, pd)
+	return ConnInfo{
+		Handle:      pd.Handle,
+		Role:        r,
+		PeerAddress: pd.Address,
+		Interval:    l.Interval,
+		Latency:     l.Latency,
+		Timeout:     l.Timeout,
+	}
+}
+
+// disconnectReason returns the reason the connection of pd terminated, if it
+// did.
+func disconnectReason(pd *linux.PlatData) error {
+	if pd.Reason == 0x00 {
+		return nil
+	}
+	return DisconnectReason(pd.Reason)
+}
+
+// connectError returns the error of a connection failed to be established,
+// as a DisconnectReason if reported by the controller.
+func connectError(err error) error {
+	if e, ok := err.(cmd.Error); ok {
+		return DisconnectReason(e)
+	}
+	return err
+}
+
+// addPeer registers the remote central or peripheral of the connection pd,
+// until the returned function is called.
+func (d *device) addPeer(pd *linux.PlatData, peer interface{}) (remove func()) {
+	d.peersmu.Lock()
+	d.peers[pd] = peer
+	d.peersmu.Unlock()
+	return func() {
+		d.peersmu.Lock()
+		delete(d.peers, pd)
+		d.peersmu.Unlock()
+	}
+}
+
+func (d *device) peer(pd *linux.PlatData) interface{} {
+	d.peersmu.Lock()
+	defer d.peersmu.Unlock()
+	return d.peers[pd]
+}
+
+// handleLinkUpdated reports the link layer updates of the connections to
+// the LinkUpdated handlers of their roles.
+func (d *device) handleLinkUpdated(pd *linux.PlatData, l linux.LinkState, err error) {
+	switch p := d.peer(pd).(type) {
+	case *central:
+		if d.centralLinkUpdated != nil {
+			d.centralLinkUpdated(p, linkOf(l), err)
+		}
+	case *peripheral:
+		if d.peripheralLinkUpdated != nil {
+			d.peripheralLinkUpdated(p, linkOf(l), err)
+		}
+	}
+}
+
+// handleConnParamsRequest asks the ConnParamsRequested handlers of the
+// roles of the connections whether the requested parameters are accepted.
+func (d *device) handleConnParamsRequest(pd *linux.PlatData, cp linux.ConnParams) bool {
+	switch p := d.peer(pd).(type) {
+	case *central:
+		if d.centralConnParamsRequested != nil {
+			return d.centralConnParamsRequested(p, connParamsOf(cp))
+		}
+	case *peripheral:
+		if d.peripheralConnParamsRequested != nil {
+			return d.peripheralConnParamsRequested(p, connParamsOf(cp))
+		}
+	}
+	return true
+}
+
+// updateDefaults flushes the pending default link layer settings to the device.
+func (d *device) updateDefaults() error {
+	if d.defDataLen != nil {
+		if err := d.sendAndDecode(d.defDataLen); err != nil {
+			return err
+		}
+		d.defDataLen = nil
+	}
+	if d.defPHY != nil {
+		if err := d.sendAndDecode(d.defPHY); err != nil {
+			return err
+		}
+		d.defPHY = nil
+	}
+	return nil
+}
+
+func (d *device) sendAndDecode(c cmd.CmdParam) error {
+	b, err := d.hci.SendRawCommand(c)
+	if err != nil {
+		return err
+	}
+	return cmd.UnmarshalRP(b, cmd.NewRP(c))
+}
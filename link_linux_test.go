@@ -0,0 +1,24 @@
+package gatt
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/paypal/gatt/linux/cmd"
+)
+
+func TestConnectError(t *testing.T) {
+	other := errors.New("other")
+	for _, tt := range []struct {
+		err, want error
+	}{
+		{cmd.ErrConnectionFailedToEstablish, ReasonConnectionFailed},
+		{cmd.ErrUnknownConnectionID, ReasonUnknownConnectionID},
+		{cmd.ErrRemoteUserTerminated, ReasonRemoteUserTerminated},
+		{other, other},
+	} {
+		if got := connectError(tt.err); got != tt.want {
+			t.Errorf("connectError(%v): got %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
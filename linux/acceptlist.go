@@ -0,0 +1,43 @@
+package linux
+
+import "github.com/paypal/gatt/linux/cmd"
+
+// AddToAcceptList adds the device of the address a, of type typ, to the
+// filter accept list of the controller, formerly known as the white list.
+// The advertising, the scanning and the connections initiated by AutoConnect
+// may be restricted to the devices of the list.
+func (h *HCI) AddToAcceptList(typ uint8, a [6]byte) error {
+	return h.sendList(cmd.LEAddDeviceToWhiteList{AddressType: typ, Address: a})
+}
+
+// RemoveFromAcceptList removes the device of the address a, of type typ,
+// from the filter accept list of the controller.
+func (h *HCI) RemoveFromAcceptList(typ uint8, a [6]byte) error {
+	return h.sendList(cmd.LERemoveDeviceFromWhiteList{AddressType: typ, Address: a})
+}
+
+// ClearAcceptList removes all the devices from the filter accept list of the
+// controller.
+func (h *HCI) ClearAcceptList() error {
+	return h.sendList(cmd.LEClearWhiteList{})
+}
+
+// AcceptListSize returns the number of devices the filter accept list of the
+// controller holds at most.
+func (h *HCI) AcceptListSize() (int, error) {
+	rp := &cmd.LEReadWhiteListSizeRP{}
+	if err := h.c.SendAndDecode(cmd.LEReadWhiteListSize{}, rp); err != nil {
+		return 0, err
+	}
+	return int(rp.WhiteListSize), nil
+}
+
+// SetScanFilter restricts the scanning to the advertisements of the devices
+// of the filter accept list, if en is true.
+func (h *HCI) SetScanFilter(en bool) error {
+	h.addrmu.Lock()
+	h.scanParams.ScanningFilterPolicy = btoi(en)
+	sp := h.scanParams
+	h.addrmu.Unlock()
+	return h.sendPaused(sp)
+}
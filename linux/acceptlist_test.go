@@ -0,0 +1,84 @@
+package linux
+
+import (
+	"testing"
+	"time"
+
+	"github.com/paypal/gatt/linux/cmd"
+)
+
+// nextCmd waits for the next command of the opcode sent to the controller.
+func nextCmd(t *testing.T, cmdc chan []byte, op int) []byte {
+	for {
+		select {
+		case p := <-cmdc:
+			if int(p[1])|int(p[2])<<8 == op {
+				return p
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no command 0x%04X", op)
+		}
+	}
+}
+
+func TestAutoConnect(t *testing.T) {
+	f := newFakeController()
+	h, _ := newTestHCI(t, f)
+	defer f.Close()
+	cmdc := make(chan []byte, 16)
+	f.mu.Lock()
+	f.cmdc = cmdc
+	f.mu.Unlock()
+	pdc := make(chan *PlatData, 1)
+	h.AcceptSlaveHandler = func(pd *PlatData) { pdc <- pd }
+
+	a := [6]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06}
+	if err := h.AddToAcceptList(0x01, a); err != nil {
+		t.Fatal(err)
+	}
+	if p := nextCmd(t, cmdc, 0x2011); p[4] != 0x01 || p[5] != 0x06 || p[10] != 0x01 {
+		t.Errorf("got LE Add Device To White List [ % X ]", p[4:])
+	}
+	if err := h.SetScanFilter(true); err != nil {
+		t.Fatal(err)
+	}
+	if p := nextCmd(t, cmdc, 0x200B); p[4+6] != 0x01 {
+		t.Errorf("got scanning filter policy 0x%02X, want the accept list", p[4+6])
+	}
+	if err := h.AutoConnect(ConnParams{0x0018, 0x0028, 4, 0x01F4}); err != nil {
+		t.Fatal(err)
+	}
+	if p := nextCmd(t, cmdc, 0x200D); p[4+4] != 0x01 {
+		t.Errorf("got initiator filter policy 0x%02X, want the accept list", p[4+4])
+	}
+	f.mu.Lock()
+	f.cmdc = nil
+	f.mu.Unlock()
+
+	// The device connected was never scanned.
+	f.connectPeer(0x40, 0x00, a)
+	select {
+	case pd := <-pdc:
+		if pd.Address.Octets != a {
+			t.Errorf("got peer %s, want %X", pd.Address, a)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no connection")
+	}
+}
+
+func TestAcceptListError(t *testing.T) {
+	f := newFakeController()
+	h, _ := newTestHCI(t, f)
+	defer f.Close()
+
+	// The list is full; the Memory Capacity Exceeded status is reported.
+	f.setRP(0x2011, 0x07)
+	if err := h.AddToAcceptList(0x00, [6]byte{0x01}); err != cmd.Error(0x07) {
+		t.Errorf("AddToAcceptList: got %v, want %v", err, cmd.Error(0x07))
+	}
+	f.setRP(0x200B, 0x0C)
+	if err := h.SetScanFilter(true); err != cmd.Error(0x0C) {
+		t.Errorf("SetScanFilter: got %v, want %v", err, cmd.Error(0x0C))
+	}
+}
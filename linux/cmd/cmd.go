@@ -2,10 +2,12 @@ package cmd
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"time"
 
 	"github.com/paypal/gatt/linux/evt"
 	"github.com/paypal/gatt/linux/util"
@@ -17,12 +19,34 @@ type CmdParam interface {
 	Len() int
 }
 
+// DefaultTimeout is the time a command waits for its Command Complete or
+// Command Status event, once written to the controller, before it fails with
+// a TimeoutError.
+const DefaultTimeout = 2 * time.Second
+
+// ErrClosed is returned for commands that are pending, or issued, after Close.
+var ErrClosed = errors.New("HCI command channel closed")
+
+// A TimeoutError is returned when the controller does not respond to a command in time.
+type TimeoutError struct{ Opcode int }
+
+func (e TimeoutError) Error() string {
+	return fmt.Sprintf("HCI command: '0x%04x' timed out", e.Opcode)
+}
+
+// Timeout reports whether the error is a timeout. It always returns true.
+func (e TimeoutError) Timeout() bool { return true }
+
 func NewCmd(d io.Writer) *Cmd {
 	c := &Cmd{
 		dev:     d,
-		sent:    []*cmdPkt{},
+		timeout: DefaultTimeout,
+		credits: 1, // The controller accepts one command after reset.
+		sendc:   make(chan *cmdPkt),
+		expirec: make(chan *cmdPkt),
 		compc:   make(chan evt.CommandCompleteEP),
 		statusc: make(chan evt.CommandStatusEP),
+		quitc:   make(chan struct{}),
 	}
 	go c.processCmdEvents()
 	return c
@@ -31,7 +55,9 @@ func NewCmd(d io.Writer) *Cmd {
 type cmdPkt struct {
 	op   int
 	cp   CmdParam
-	done chan []byte
+	done chan []byte // receives the return parameters, or is closed with err set.
+	err  error
+	t    *time.Timer // expires the command, once written to the controller
 }
 
 func (c cmdPkt) Marshal() []byte {
@@ -44,21 +70,60 @@ func (c cmdPkt) Marshal() []byte {
 	return b
 }
 
+func (p *cmdPkt) fail(err error) {
+	p.err = err
+	close(p.done)
+}
+
 type Cmd struct {
 	dev     io.Writer
-	sent    []*cmdPkt
+	timeout time.Duration
+
+	// The following fields are owned by processCmdEvents.
+	queue   []*cmdPkt    // commands waiting for a command credit
+	sent    []*cmdPkt    // commands sent to the controller, in order
+	expired []expiredCmd // commands timed out, whose events may still arrive
+	credits int          // Num_HCI_Command_Packets last reported by the controller
+
+	sendc   chan *cmdPkt
+	expirec chan *cmdPkt
 	compc   chan evt.CommandCompleteEP
 	statusc chan evt.CommandStatusEP
+	quitc   chan struct{}
+}
+
+// expiredCmd is a command timed out after it was written to the controller.
+type expiredCmd struct {
+	op uint16
+	at time.Time
 }
 
 func (c Cmd) trace(fmt string, v ...interface{}) {}
 
+// SetTimeout sets the time a command waits for its completion.
+// It must be called before any command is sent.
+func (c *Cmd) SetTimeout(d time.Duration) { c.timeout = d }
+
+// Close fails all pending commands with ErrClosed.
+// Commands issued after Close fail immediately.
+func (c *Cmd) Close() error {
+	select {
+	case <-c.quitc:
+	default:
+		close(c.quitc)
+	}
+	return nil
+}
+
 func (c *Cmd) HandleComplete(b []byte) error {
 	var e evt.CommandCompleteEP
 	if err := e.Unmarshal(b); err != nil {
 		return err
 	}
-	c.compc <- e
+	select {
+	case c.compc <- e:
+	case <-c.quitc:
+	}
 	return nil
 }
 
@@ -67,22 +132,29 @@ func (c *Cmd) HandleStatus(b []byte) error {
 	if err := e.Unmarshal(b); err != nil {
 		return err
 	}
-	c.statusc <- e
+	select {
+	case c.statusc <- e:
+	case <-c.quitc:
+	}
 	return nil
 }
 
+// Send queues the command, and waits for its return parameters.
+// Commands are written to the controller only when it has a free command
+// credit, and time out if not completed in time once written. For commands
+// completed with a Command Status event, the returned slice contains only the
+// status.
 func (c *Cmd) Send(cp CmdParam) ([]byte, error) {
-	op := cp.Opcode()
-	p := &cmdPkt{op: op, cp: cp, done: make(chan []byte)}
-	raw := p.Marshal()
-
-	c.sent = append(c.sent, p)
-	if n, err := c.dev.Write(raw); err != nil {
-		return nil, err
-	} else if n != len(raw) {
-		return nil, errors.New("Failed to send whole Cmd pkt to HCI socket")
+	p := &cmdPkt{op: cp.Opcode(), cp: cp, done: make(chan []byte, 1)}
+	select {
+	case c.sendc <- p:
+	case <-c.quitc:
+		return nil, ErrClosed
+	}
+	if rsp, ok := <-p.done; ok {
+		return rsp, nil
 	}
-	return <-p.done, nil
+	return nil, p.err
 }
 
 func (c *Cmd) SendAndCheckResp(cp CmdParam, exp []byte) error {
@@ -95,42 +167,159 @@ func (c *Cmd) SendAndCheckResp(cp CmdParam, exp []byte) error {
 		return nil
 	}
 	// Check the if status is one of the expected value
-	if !bytes.Contains(exp, rsp[0:1]) {
-		return fmt.Errorf("HCI command: '0x%04x' return 0x%02X, expect: [%X] ", cp.Opcode(), rsp[0], exp)
+	if len(rsp) == 0 || !bytes.Contains(exp, rsp[0:1]) {
+		if len(rsp) != 0 && Error(rsp[0]) != Success {
+			return Error(rsp[0])
+		}
+		return fmt.Errorf("HCI command: '0x%04x' return % X, expect: [%X] ", cp.Opcode(), rsp, exp)
 	}
 	return nil
 }
 
+// SendAndDecode sends the command cp, and decodes its return parameters into rp.
+// If the controller reports a status other than Success, it is returned as an Error.
+// rp can be nil if only the status is of interest.
+func (c *Cmd) SendAndDecode(cp CmdParam, rp interface{}) error {
+	b, err := c.Send(cp)
+	if err != nil {
+		return err
+	}
+	return UnmarshalRP(b, rp)
+}
+
+// UnmarshalRP decodes the return parameters b into rp, which must be a pointer
+// to the return parameter struct of the command, such as *LEReadBufferSizeRP.
+// If the status in b is not Success, it is returned as an Error, and rp is
+// left untouched. rp can be nil if only the status is of interest.
+func UnmarshalRP(b []byte, rp interface{}) error {
+	if len(b) == 0 {
+		return errors.New("HCI command: missing return parameters")
+	}
+	if s := Error(b[0]); s != Success {
+		return s
+	}
+	if rp == nil {
+		return nil
+	}
+	return binary.Read(bytes.NewReader(b), binary.LittleEndian, rp)
+}
+
+// NewRP returns a pointer to a new return parameter struct for the command cp,
+// or nil if the return parameters of cp are unknown, e.g. for vendor commands.
+func NewRP(cp CmdParam) interface{} {
+	if f, ok := rps[cp.Opcode()]; ok {
+		return f()
+	}
+	return nil
+}
+
+// flush writes queued commands to the controller as long as it has credits.
+func (c *Cmd) flush() {
+	for c.credits > 0 && len(c.queue) > 0 {
+		p := c.queue[0]
+		c.queue = c.queue[1:]
+		raw := p.Marshal()
+		if n, err := c.dev.Write(raw); err != nil {
+			p.fail(err)
+			continue
+		} else if n != len(raw) {
+			p.fail(errors.New("Failed to send whole Cmd pkt to HCI socket"))
+			continue
+		}
+		c.credits--
+		c.sent = append(c.sent, p)
+		p.t = time.AfterFunc(c.timeout, func() {
+			select {
+			case c.expirec <- p:
+			case <-c.quitc:
+			}
+		})
+	}
+}
+
+// complete removes the oldest sent command with the opcode op.
+// Controllers complete commands of the same opcode in the order they were
+// issued, which allows several of them to be outstanding at a time. The late
+// event of a command timed out is dropped, and nil returned with dropped set:
+// it comes before the events of the commands of the same opcode sent after.
+func (c *Cmd) complete(op uint16) (p *cmdPkt, dropped bool) {
+	// Forget the commands whose events have likely been lost by now.
+	for len(c.expired) > 0 && time.Since(c.expired[0].at) > c.timeout {
+		c.expired = c.expired[1:]
+	}
+	for i, e := range c.expired {
+		if e.op == op {
+			c.expired = append(c.expired[:i], c.expired[i+1:]...)
+			return nil, true
+		}
+	}
+	for i, p := range c.sent {
+		if uint16(p.op) == op {
+			c.sent = append(c.sent[:i], c.sent[i+1:]...)
+			p.t.Stop()
+			return p, false
+		}
+	}
+	return nil, false
+}
+
+func remove(pp []*cmdPkt, p *cmdPkt) ([]*cmdPkt, bool) {
+	for i, q := range pp {
+		if q == p {
+			return append(pp[:i], pp[i+1:]...), true
+		}
+	}
+	return pp, false
+}
+
 func (c *Cmd) processCmdEvents() {
 	for {
 		select {
-		case status := <-c.statusc:
-			found := false
-			for i, p := range c.sent {
-				if uint16(p.op) == status.CommandOpcode {
-					found = true
-					c.sent = append(c.sent[:i], c.sent[i+1:]...)
-					close(p.done)
-					break
+		case p := <-c.sendc:
+			c.queue = append(c.queue, p)
+		case p := <-c.expirec:
+			var found bool
+			if c.sent, found = remove(c.sent, p); found {
+				p.fail(TimeoutError{Opcode: p.op})
+				c.expired = append(c.expired, expiredCmd{uint16(p.op), time.Now()})
+				// The controller has dropped the event, and the credit with it.
+				// Allow one more command, so the queue doesn't stall forever.
+				if c.credits == 0 {
+					c.credits = 1
 				}
 			}
-			if !found {
+		case status := <-c.statusc:
+			c.credits = int(status.NumHCICommandPackets)
+			if status.CommandOpcode == 0x0000 {
+				break // No-op, just updating the credits.
+			}
+			if p, dropped := c.complete(status.CommandOpcode); p != nil {
+				p.done <- []byte{status.Status}
+			} else if !dropped {
 				log.Printf("Can't find the cmdPkt for this CommandStatusEP: %v", status)
 			}
 		case comp := <-c.compc:
-			found := false
-			for i, p := range c.sent {
-				if uint16(p.op) == comp.CommandOPCode {
-					found = true
-					c.sent = append(c.sent[:i], c.sent[i+1:]...)
-					p.done <- comp.ReturnParameters
-					break
-				}
+			c.credits = int(comp.NumHCICommandPackets)
+			if comp.CommandOPCode == 0x0000 {
+				break // No-op, just updating the credits.
 			}
-			if !found {
+			if p, dropped := c.complete(comp.CommandOPCode); p != nil {
+				p.done <- comp.ReturnParameters
+			} else if !dropped {
 				log.Printf("Can't find the cmdPkt for this CommandCompleteEP: %v", comp)
 			}
+		case <-c.quitc:
+			for _, p := range c.sent {
+				p.t.Stop()
+				p.fail(ErrClosed)
+			}
+			for _, p := range c.queue {
+				p.fail(ErrClosed)
+			}
+			c.sent, c.queue = nil, nil
+			return
 		}
+		c.flush()
 	}
 }
 
@@ -140,9 +329,9 @@ const (
 	hostCtl     = 0x03
 	infoParam   = 0x04
 	statusParam = 0x05
-	testingCmd  = 0X3E
+	testingCmd  = 0x3E
 	leCtl       = 0x08
-	vendorCmd   = 0X3F
+	vendorCmd   = 0x3F
 )
 
 const (
@@ -338,6 +527,19 @@ const (
 	opLETestEnd                           = leCtl<<10 | 0x001f // LE Test End
 	opLERemoteConnectionParameterReply    = leCtl<<10 | 0x0020 // LE Remote Connection Parameter Request Reply
 	opLERemoteConnectionParameterNegReply = leCtl<<10 | 0x0021 // LE Remote Connection Parameter Request Negative Reply
+	opLESetDataLength                     = leCtl<<10 | 0x0022 // LE Set Data Length
+	opLEReadSuggestedDefaultDataLength    = leCtl<<10 | 0x0023 // LE Read Suggested Default Data Length
+	opLEWriteSuggestedDefaultDataLength   = leCtl<<10 | 0x0024 // LE Write Suggested Default Data Length
+	opLEAddDeviceToResolvingList          = leCtl<<10 | 0x0027 // LE Add Device To Resolving List
+	opLERemoveDeviceFromResolvingList     = leCtl<<10 | 0x0028 // LE Remove Device From Resolving List
+	opLEClearResolvingList                = leCtl<<10 | 0x0029 // LE Clear Resolving List
+	opLEReadResolvingListSize             = leCtl<<10 | 0x002a // LE Read Resolving List Size
+	opLESetAddressResolutionEnable        = leCtl<<10 | 0x002d // LE Set Address Resolution Enable
+	opLESetRPATimeout                     = leCtl<<10 | 0x002e // LE Set Resolvable Private Address Timeout
+	opLEReadMaximumDataLength             = leCtl<<10 | 0x002f // LE Read Maximum Data Length
+	opLEReadPHY                           = leCtl<<10 | 0x0030 // LE Read PHY
+	opLESetDefaultPHY                     = leCtl<<10 | 0x0031 // LE Set Default PHY
+	opLESetPHY                            = leCtl<<10 | 0x0032 // LE Set PHY
 )
 
 var o = util.Order
@@ -357,8 +559,8 @@ func (c Disconnect) Marshal(b []byte) {
 	b[2] = c.Reason
 }
 
-// No Return Parameters, Check for Disconnection Complete Event
-type DisconnectRP struct{}
+// Status of the Command Status Event; check for Disconnection Complete Event.
+type DisconnectRP struct{ Status uint8 }
 
 // Link Policy Commands
 
@@ -414,7 +616,7 @@ func (c Flush) Opcode() int      { return opFlush }
 func (c Flush) Len() int         { return 2 }
 func (c Flush) Marshal(b []byte) { o.PutUint16(b, c.ConnectionHandle) }
 
-type flushRP struct{ status uint8 }
+type FlushRP struct{ Status uint8 }
 
 // Write Page Timeout (0x0018)
 type WritePageTimeout struct{ PageTimeout uint16 }
@@ -423,7 +625,7 @@ func (c WritePageTimeout) Opcode() int      { return opWritePageTimeout }
 func (c WritePageTimeout) Len() int         { return 2 }
 func (c WritePageTimeout) Marshal(b []byte) { o.PutUint16(b, c.PageTimeout) }
 
-type WritePageTimeoutRP struct{}
+type WritePageTimeoutRP struct{ Status uint8 }
 
 // Write Class of Device (0x0024)
 type WriteClassOfDevice struct{ ClassOfDevice [3]byte }
@@ -432,7 +634,7 @@ func (c WriteClassOfDevice) Opcode() int      { return opWriteClassOfDevice }
 func (c WriteClassOfDevice) Len() int         { return 3 }
 func (c WriteClassOfDevice) Marshal(b []byte) { copy(b, c.ClassOfDevice[:]) }
 
-type WriteClassOfDevRP struct{ status uint8 }
+type WriteClassOfDevRP struct{ Status uint8 }
 
 // Write Host Buffer Size (0x0033)
 type HostBufferSize struct {
@@ -489,7 +691,7 @@ func (c WriteSimplePairingMode) Opcode() int      { return opWriteSimplePairingM
 func (c WriteSimplePairingMode) Len() int         { return 1 }
 func (c WriteSimplePairingMode) Marshal(b []byte) { b[0] = c.SimplePairingMode }
 
-type WriteSimplePairingModeRP struct{}
+type WriteSimplePairingModeRP struct{ Status uint8 }
 
 // Set Event Mask Page 2 (0x0063)
 type SetEventMaskPage2 struct{ EventMaskPage2 uint64 }
@@ -512,6 +714,35 @@ func (c WriteLEHostSupported) Marshal(b []byte) { b[0], b[1] = c.LESupportedHost
 
 type WriteLeHostSupportedRP struct{ Status uint8 }
 
+// Informational Parameters
+
+// Read Buffer Size (0x0005)
+type ReadBufferSize struct{}
+
+func (c ReadBufferSize) Opcode() int      { return opReadBufferSize }
+func (c ReadBufferSize) Len() int         { return 0 }
+func (c ReadBufferSize) Marshal(b []byte) {}
+
+type ReadBufferSizeRP struct {
+	Status                           uint8
+	HCACLDataPacketLength            uint16
+	HCSynchronousDataPacketLength    uint8
+	HCTotalNumACLDataPackets         uint16
+	HCTotalNumSynchronousDataPackets uint16
+}
+
+// Read BD_ADDR (0x0009)
+type ReadBDADDR struct{}
+
+func (c ReadBDADDR) Opcode() int      { return opReadBDADDR }
+func (c ReadBDADDR) Len() int         { return 0 }
+func (c ReadBDADDR) Marshal(b []byte) {}
+
+type ReadBDADDRRP struct {
+	Status uint8
+	BDADDR [6]byte
+}
+
 // LE Controller Commands
 
 // LE Set Event Mask (0x0001)
@@ -527,7 +758,7 @@ type LESetEventMaskRP struct{ Status uint8 }
 type LEReadBufferSize struct{}
 
 func (c LEReadBufferSize) Opcode() int      { return opLEReadBufferSize }
-func (c LEReadBufferSize) Len() int         { return 1 }
+func (c LEReadBufferSize) Len() int         { return 0 }
 func (c LEReadBufferSize) Marshal(b []byte) {}
 
 type LEReadBufferSizeRP struct {
@@ -593,7 +824,7 @@ func (c LEReadAdvertisingChannelTxPower) Marshal(b []byte) {}
 
 type LEReadAdvertisingChannelTxPowerRP struct {
 	Status             uint8
-	TransmitPowerLevel uint8
+	TransmitPowerLevel int8
 }
 
 // LE Set Advertising Data (0x0008)
@@ -701,7 +932,8 @@ func (c LECreateConn) Marshal(b []byte) {
 	o.PutUint16(b[23:], c.MaximumCELength)
 }
 
-type LECreateConnRP struct{}
+// Status of the Command Status Event; check for LE Connection Complete Event.
+type LECreateConnRP struct{ Status uint8 }
 
 // LE Create Connection Cancel (0x000E)
 type LECreateConnCancel struct{}
@@ -786,7 +1018,8 @@ func (c LEConnUpdate) Marshal(b []byte) {
 	o.PutUint16(b[12:], c.MaximumCELength)
 }
 
-type LEConnUpdateRP struct{}
+// Status of the Command Status Event; check for LE Connection Update Complete Event.
+type LEConnUpdateRP struct{ Status uint8 }
 
 // LE Set Host Channel Classification (0x0014)
 type LESetHostChannelClassification struct{ ChannelMap [5]byte }
@@ -814,10 +1047,11 @@ type LEReadChannelMapRP struct {
 type LEReadRemoteUsedFeatures struct{ ConnectionHandle uint16 }
 
 func (c LEReadRemoteUsedFeatures) Opcode() int      { return opLEReadRemoteUsedFeatures }
-func (c LEReadRemoteUsedFeatures) Len() int         { return 8 }
+func (c LEReadRemoteUsedFeatures) Len() int         { return 2 }
 func (c LEReadRemoteUsedFeatures) Marshal(b []byte) { o.PutUint16(b, c.ConnectionHandle) }
 
-type LEReadRemoteUsedFeaturesRP struct{}
+// Status of the Command Status Event; check for LE Read Remote Used Features Complete Event.
+type LEReadRemoteUsedFeaturesRP struct{ Status uint8 }
 
 // LE Encrypt (0x0017)
 type LEEncrypt struct {
@@ -833,7 +1067,7 @@ func (c LEEncrypt) Marshal(b []byte) {
 }
 
 type LEEncryptRP struct {
-	Stauts        uint8
+	Status        uint8
 	EncryptedData [16]byte
 }
 
@@ -866,7 +1100,8 @@ func (c LEStartEncryption) Marshal(b []byte) {
 	copy(b[12:], c.LongTermKey[:])
 }
 
-type LEStartEncryptionRP struct{}
+// Status of the Command Status Event; check for Encryption Change Event.
+type LEStartEncryptionRP struct{ Status uint8 }
 
 // LE Long Term Key Reply (0x001A)
 type LELTKReply struct {
@@ -993,3 +1228,257 @@ type LERemoteConnectionParameterNegReplyRP struct {
 	Status           uint8
 	ConnectionHandle uint16
 }
+
+// LE Set Data Length (0x0022)
+type LESetDataLength struct {
+	ConnectionHandle uint16
+	TxOctets         uint16
+	TxTime           uint16
+}
+
+func (c LESetDataLength) Opcode() int { return opLESetDataLength }
+func (c LESetDataLength) Len() int    { return 6 }
+func (c LESetDataLength) Marshal(b []byte) {
+	o.PutUint16(b[0:], c.ConnectionHandle)
+	o.PutUint16(b[2:], c.TxOctets)
+	o.PutUint16(b[4:], c.TxTime)
+}
+
+type LESetDataLengthRP struct {
+	Status           uint8
+	ConnectionHandle uint16
+}
+
+// LE Read Suggested Default Data Length (0x0023)
+type LEReadSuggestedDefaultDataLength struct{}
+
+func (c LEReadSuggestedDefaultDataLength) Opcode() int {
+	return opLEReadSuggestedDefaultDataLength
+}
+func (c LEReadSuggestedDefaultDataLength) Len() int         { return 0 }
+func (c LEReadSuggestedDefaultDataLength) Marshal(b []byte) {}
+
+type LEReadSuggestedDefaultDataLengthRP struct {
+	Status               uint8
+	SuggestedMaxTxOctets uint16
+	SuggestedMaxTxTime   uint16
+}
+
+// LE Write Suggested Default Data Length (0x0024)
+type LEWriteSuggestedDefaultDataLength struct {
+	SuggestedMaxTxOctets uint16
+	SuggestedMaxTxTime   uint16
+}
+
+func (c LEWriteSuggestedDefaultDataLength) Opcode() int {
+	return opLEWriteSuggestedDefaultDataLength
+}
+func (c LEWriteSuggestedDefaultDataLength) Len() int { return 4 }
+func (c LEWriteSuggestedDefaultDataLength) Marshal(b []byte) {
+	o.PutUint16(b[0:], c.SuggestedMaxTxOctets)
+	o.PutUint16(b[2:], c.SuggestedMaxTxTime)
+}
+
+type LEWriteSuggestedDefaultDataLengthRP struct{ Status uint8 }
+
+// LE Add Device To Resolving List (0x0027)
+type LEAddDeviceToResolvingList struct {
+	PeerIdentityAddressType uint8
+	PeerIdentityAddress     [6]byte
+	PeerIRK                 [16]byte
+	LocalIRK                [16]byte
+}
+
+func (c LEAddDeviceToResolvingList) Opcode() int { return opLEAddDeviceToResolvingList }
+func (c LEAddDeviceToResolvingList) Len() int    { return 39 }
+func (c LEAddDeviceToResolvingList) Marshal(b []byte) {
+	b[0] = c.PeerIdentityAddressType
+	o.PutMAC(b[1:], c.PeerIdentityAddress)
+	copy(b[7:], c.PeerIRK[:])
+	copy(b[23:], c.LocalIRK[:])
+}
+
+type LEAddDeviceToResolvingListRP struct{ Status uint8 }
+
+// LE Remove Device From Resolving List (0x0028)
+type LERemoveDeviceFromResolvingList struct {
+	PeerIdentityAddressType uint8
+	PeerIdentityAddress     [6]byte
+}
+
+func (c LERemoveDeviceFromResolvingList) Opcode() int { return opLERemoveDeviceFromResolvingList }
+func (c LERemoveDeviceFromResolvingList) Len() int    { return 7 }
+func (c LERemoveDeviceFromResolvingList) Marshal(b []byte) {
+	b[0] = c.PeerIdentityAddressType
+	o.PutMAC(b[1:], c.PeerIdentityAddress)
+}
+
+type LERemoveDeviceFromResolvingListRP struct{ Status uint8 }
+
+// LE Clear Resolving List (0x0029)
+type LEClearResolvingList struct{}
+
+func (c LEClearResolvingList) Opcode() int      { return opLEClearResolvingList }
+func (c LEClearResolvingList) Len() int         { return 0 }
+func (c LEClearResolvingList) Marshal(b []byte) {}
+
+type LEClearResolvingListRP struct{ Status uint8 }
+
+// LE Read Resolving List Size (0x002A)
+type LEReadResolvingListSize struct{}
+
+func (c LEReadResolvingListSize) Opcode() int      { return opLEReadResolvingListSize }
+func (c LEReadResolvingListSize) Len() int         { return 0 }
+func (c LEReadResolvingListSize) Marshal(b []byte) {}
+
+type LEReadResolvingListSizeRP struct {
+	Status            uint8
+	ResolvingListSize uint8
+}
+
+// LE Set Address Resolution Enable (0x002D)
+type LESetAddressResolutionEnable struct{ AddressResolutionEnable uint8 }
+
+func (c LESetAddressResolutionEnable) Opcode() int      { return opLESetAddressResolutionEnable }
+func (c LESetAddressResolutionEnable) Len() int         { return 1 }
+func (c LESetAddressResolutionEnable) Marshal(b []byte) { b[0] = c.AddressResolutionEnable }
+
+type LESetAddressResolutionEnableRP struct{ Status uint8 }
+
+// LE Set Resolvable Private Address Timeout (0x002E)
+type LESetRPATimeout struct{ RPATimeout uint16 }
+
+func (c LESetRPATimeout) Opcode() int      { return opLESetRPATimeout }
+func (c LESetRPATimeout) Len() int         { return 2 }
+func (c LESetRPATimeout) Marshal(b []byte) { o.PutUint16(b, c.RPATimeout) }
+
+type LESetRPATimeoutRP struct{ Status uint8 }
+
+// LE Read Maximum Data Length (0x002F)
+type LEReadMaximumDataLength struct{}
+
+func (c LEReadMaximumDataLength) Opcode() int      { return opLEReadMaximumDataLength }
+func (c LEReadMaximumDataLength) Len() int         { return 0 }
+func (c LEReadMaximumDataLength) Marshal(b []byte) {}
+
+type LEReadMaximumDataLengthRP struct {
+	Status               uint8
+	SupportedMaxTxOctets uint16
+	SupportedMaxTxTime   uint16
+	SupportedMaxRxOctets uint16
+	SupportedMaxRxTime   uint16
+}
+
+// LE Read PHY (0x0030)
+type LEReadPHY struct{ ConnectionHandle uint16 }
+
+func (c LEReadPHY) Opcode() int      { return opLEReadPHY }
+func (c LEReadPHY) Len() int         { return 2 }
+func (c LEReadPHY) Marshal(b []byte) { o.PutUint16(b[0:], c.ConnectionHandle) }
+
+type LEReadPHYRP struct {
+	Status           uint8
+	ConnectionHandle uint16
+	TxPHY            uint8
+	RxPHY            uint8
+}
+
+// LE Set Default PHY (0x0031)
+type LESetDefaultPHY struct {
+	AllPHYs uint8
+	TxPHYs  uint8
+	RxPHYs  uint8
+}
+
+func (c LESetDefaultPHY) Opcode() int { return opLESetDefaultPHY }
+func (c LESetDefaultPHY) Len() int    { return 3 }
+func (c LESetDefaultPHY) Marshal(b []byte) {
+	b[0], b[1], b[2] = c.AllPHYs, c.TxPHYs, c.RxPHYs
+}
+
+type LESetDefaultPHYRP struct{ Status uint8 }
+
+// LE Set PHY (0x0032)
+type LESetPHY struct {
+	ConnectionHandle uint16
+	AllPHYs          uint8
+	TxPHYs           uint8
+	RxPHYs           uint8
+	PHYOptions       uint16
+}
+
+func (c LESetPHY) Opcode() int { return opLESetPHY }
+func (c LESetPHY) Len() int    { return 7 }
+func (c LESetPHY) Marshal(b []byte) {
+	o.PutUint16(b[0:], c.ConnectionHandle)
+	b[2], b[3], b[4] = c.AllPHYs, c.TxPHYs, c.RxPHYs
+	o.PutUint16(b[5:], c.PHYOptions)
+}
+
+// Status of the Command Status Event; check for LE PHY Update Complete Event.
+type LESetPHYRP struct{ Status uint8 }
+
+// rps maps the opcodes of the commands to their return parameters.
+var rps = map[int]func() interface{}{
+	opDisconnect:                          func() interface{} { return &DisconnectRP{} },
+	opWriteDefaultLinkPolicy:              func() interface{} { return &WriteDefaultLinkPolicyRP{} },
+	opSetEventMask:                        func() interface{} { return &SetEventMaskRP{} },
+	opReset:                               func() interface{} { return &ResetRP{} },
+	opFlush:                               func() interface{} { return &FlushRP{} },
+	opWritePageTimeout:                    func() interface{} { return &WritePageTimeoutRP{} },
+	opWriteClassOfDevice:                  func() interface{} { return &WriteClassOfDevRP{} },
+	opHostBufferSize:                      func() interface{} { return &HostBufferSizeRP{} },
+	opWriteInquiryScanType:                func() interface{} { return &WriteInquiryScanTypeRP{} },
+	opWriteInquiryMode:                    func() interface{} { return &WriteInquiryModeRP{} },
+	opWritePageScanType:                   func() interface{} { return &WritePageScanTypeRP{} },
+	opWriteSimplePairingMode:              func() interface{} { return &WriteSimplePairingModeRP{} },
+	opSetEventMaskPage2:                   func() interface{} { return &SetEventMaskPage2RP{} },
+	opWriteLEHostSupported:                func() interface{} { return &WriteLeHostSupportedRP{} },
+	opReadBufferSize:                      func() interface{} { return &ReadBufferSizeRP{} },
+	opReadBDADDR:                          func() interface{} { return &ReadBDADDRRP{} },
+	opLESetEventMask:                      func() interface{} { return &LESetEventMaskRP{} },
+	opLEReadBufferSize:                    func() interface{} { return &LEReadBufferSizeRP{} },
+	opLEReadLocalSupportedFeatures:        func() interface{} { return &LEReadLocalSupportedFeaturesRP{} },
+	opLESetRandomAddress:                  func() interface{} { return &LESetRandomAddressRP{} },
+	opLESetAdvertisingParameters:          func() interface{} { return &LESetAdvertisingParametersRP{} },
+	opLEReadAdvertisingChannelTxPower:     func() interface{} { return &LEReadAdvertisingChannelTxPowerRP{} },
+	opLESetAdvertisingData:                func() interface{} { return &LESetAdvertisingDataRP{} },
+	opLESetScanResponseData:               func() interface{} { return &LESetScanResponseDataRP{} },
+	opLESetAdvertiseEnable:                func() interface{} { return &LESetAdvertiseEnableRP{} },
+	opLESetScanParameters:                 func() interface{} { return &LESetScanParametersRP{} },
+	opLESetScanEnable:                     func() interface{} { return &LESetScanEnableRP{} },
+	opLECreateConn:                        func() interface{} { return &LECreateConnRP{} },
+	opLECreateConnCancel:                  func() interface{} { return &LECreateConnCancelRP{} },
+	opLEReadWhiteListSize:                 func() interface{} { return &LEReadWhiteListSizeRP{} },
+	opLEClearWhiteList:                    func() interface{} { return &LEClearWhiteListRP{} },
+	opLEAddDeviceToWhiteList:              func() interface{} { return &LEAddDeviceToWhiteListRP{} },
+	opLERemoveDeviceFromWhiteList:         func() interface{} { return &LERemoveDeviceFromWhiteListRP{} },
+	opLEConnUpdate:                        func() interface{} { return &LEConnUpdateRP{} },
+	opLESetHostChannelClassification:      func() interface{} { return &LESetHostChannelClassificationRP{} },
+	opLEReadChannelMap:                    func() interface{} { return &LEReadChannelMapRP{} },
+	opLEReadRemoteUsedFeatures:            func() interface{} { return &LEReadRemoteUsedFeaturesRP{} },
+	opLEEncrypt:                           func() interface{} { return &LEEncryptRP{} },
+	opLERand:                              func() interface{} { return &LERandRP{} },
+	opLEStartEncryption:                   func() interface{} { return &LEStartEncryptionRP{} },
+	opLELTKReply:                          func() interface{} { return &LELTKReplyRP{} },
+	opLELTKNegReply:                       func() interface{} { return &LELTKNegReplyRP{} },
+	opLEReadSupportedStates:               func() interface{} { return &LEReadSupportedStatesRP{} },
+	opLEReceiverTest:                      func() interface{} { return &LEReceiverTestRP{} },
+	opLETransmitterTest:                   func() interface{} { return &LETransmitterTestRP{} },
+	opLETestEnd:                           func() interface{} { return &LETestEndRP{} },
+	opLERemoteConnectionParameterReply:    func() interface{} { return &LERemoteConnectionParameterReplyRP{} },
+	opLERemoteConnectionParameterNegReply: func() interface{} { return &LERemoteConnectionParameterNegReplyRP{} },
+	opLESetDataLength:                     func() interface{} { return &LESetDataLengthRP{} },
+	opLEReadSuggestedDefaultDataLength:    func() interface{} { return &LEReadSuggestedDefaultDataLengthRP{} },
+	opLEWriteSuggestedDefaultDataLength:   func() interface{} { return &LEWriteSuggestedDefaultDataLengthRP{} },
+	opLEAddDeviceToResolvingList:          func() interface{} { return &LEAddDeviceToResolvingListRP{} },
+	opLERemoveDeviceFromResolvingList:     func() interface{} { return &LERemoveDeviceFromResolvingListRP{} },
+	opLEClearResolvingList:                func() interface{} { return &LEClearResolvingListRP{} },
+	opLEReadResolvingListSize:             func() interface{} { return &LEReadResolvingListSizeRP{} },
+	opLESetAddressResolutionEnable:        func() interface{} { return &LESetAddressResolutionEnableRP{} },
+	opLESetRPATimeout:                     func() interface{} { return &LESetRPATimeoutRP{} },
+	opLEReadMaximumDataLength:             func() interface{} { return &LEReadMaximumDataLengthRP{} },
+	opLEReadPHY:                           func() interface{} { return &LEReadPHYRP{} },
+	opLESetDefaultPHY:                     func() interface{} { return &LESetDefaultPHYRP{} },
+	opLESetPHY:                            func() interface{} { return &LESetPHYRP{} },
+}
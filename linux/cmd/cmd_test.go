@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeDev records the command packets written by Cmd.
+type fakeDev struct{ wrotec chan []byte }
+
+func (d *fakeDev) Write(b []byte) (int, error) {
+	d.wrotec <- b
+	return len(b), nil
+}
+
+func complete(c *Cmd, n uint8, op int, rp ...byte) {
+	c.HandleComplete(append([]byte{n, byte(op), byte(op >> 8)}, rp...))
+}
+
+func TestSendCredits(t *testing.T) {
+	d := &fakeDev{wrotec: make(chan []byte, 10)}
+	c := NewCmd(d)
+	defer c.Close()
+
+	type result struct {
+		rp  []byte
+		err error
+	}
+	resc := make(chan result, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			rp, err := c.Send(LEReadBufferSize{})
+			resc <- result{rp, err}
+		}()
+	}
+
+	// Only one command credit is available until the controller reports more.
+	<-d.wrotec
+	select {
+	case <-d.wrotec:
+		t.Fatalf("second command sent without a credit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	complete(c, 1, opLEReadBufferSize, 0x00, 0x1b, 0x00, 0x01)
+	<-d.wrotec
+	complete(c, 1, opLEReadBufferSize, 0x00, 0x1b, 0x00, 0x02)
+
+	got := map[byte]bool{}
+	for i := 0; i < 2; i++ {
+		r := <-resc
+		if r.err != nil {
+			t.Fatalf("Send: got error %v", r.err)
+		}
+		got[r.rp[3]] = true
+	}
+	if !got[1] || !got[2] {
+		t.Errorf("each command should get its own completion, got %v", got)
+	}
+}
+
+func TestSendTimeout(t *testing.T) {
+	d := &fakeDev{wrotec: make(chan []byte, 10)}
+	c := NewCmd(d)
+	c.SetTimeout(20 * time.Millisecond)
+	defer c.Close()
+
+	_, err := c.Send(Reset{})
+	if _, ok := err.(TimeoutError); !ok {
+		t.Fatalf("Send: got %v, want TimeoutError", err)
+	}
+	<-d.wrotec
+
+	// The credit lost with the dropped event is restored.
+	go c.Send(Reset{})
+	select {
+	case <-d.wrotec:
+	case <-time.After(time.Second):
+		t.Fatalf("command queue stalled after a timeout")
+	}
+}
+
+func TestSendLateComplete(t *testing.T) {
+	d := &fakeDev{wrotec: make(chan []byte, 10)}
+	c := NewCmd(d)
+	c.SetTimeout(20 * time.Millisecond)
+	defer c.Close()
+
+	if _, err := c.Send(LEReadBufferSize{}); err == nil {
+		t.Fatalf("Send: got no error, want TimeoutError")
+	}
+	<-d.wrotec
+
+	rpc := make(chan []byte, 1)
+	go func() {
+		rp, _ := c.Send(LEReadBufferSize{})
+		rpc <- rp
+	}()
+	<-d.wrotec
+	// The completion of the command timed out doesn't complete the next one.
+	complete(c, 1, opLEReadBufferSize, 0x00, 0x1b, 0x00, 0x01)
+	complete(c, 1, opLEReadBufferSize, 0x00, 0x1b, 0x00, 0x02)
+	if rp := <-rpc; len(rp) != 4 || rp[3] != 0x02 {
+		t.Errorf("Send: got [ % X ], want its own completion", rp)
+	}
+}
+
+func TestSendQueuedTimeout(t *testing.T) {
+	d := &fakeDev{wrotec: make(chan []byte, 10)}
+	c := NewCmd(d)
+	c.SetTimeout(100 * time.Millisecond)
+	defer c.Close()
+
+	errc := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := c.Send(Reset{})
+			errc <- err
+		}()
+	}
+	// The second command waits for the credit longer than the timeout, but
+	// it times out only once written.
+	for i := 0; i < 2; i++ {
+		<-d.wrotec
+		time.Sleep(60 * time.Millisecond)
+		complete(c, 1, opReset, 0x00)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errc; err != nil {
+			t.Errorf("Send: got %v", err)
+		}
+	}
+}
+
+func TestClose(t *testing.T) {
+	d := &fakeDev{wrotec: make(chan []byte, 10)}
+	c := NewCmd(d)
+
+	errc := make(chan error)
+	go func() {
+		_, err := c.Send(Reset{})
+		errc <- err
+	}()
+	<-d.wrotec
+	c.Close()
+	if err := <-errc; err != ErrClosed {
+		t.Errorf("pending Send: got %v, want ErrClosed", err)
+	}
+	if _, err := c.Send(Reset{}); err != ErrClosed {
+		t.Errorf("Send after Close: got %v, want ErrClosed", err)
+	}
+}
+
+func TestUnmarshalRP(t *testing.T) {
+	rp := &LEReadBufferSizeRP{}
+	if err := UnmarshalRP([]byte{0x00, 0xFB, 0x00, 0x08}, rp); err != nil {
+		t.Fatalf("UnmarshalRP: got error %v", err)
+	}
+	if rp.HCLEACLDataPacketLength != 251 || rp.HCTotalNumLEACLDataPackets != 8 {
+		t.Errorf("UnmarshalRP: got %+v", rp)
+	}
+
+	err := UnmarshalRP([]byte{0x0C}, NewRP(LESetAdvertiseEnable{}))
+	if err != ErrCommandDisallowed {
+		t.Errorf("UnmarshalRP: got %v, want %v", err, ErrCommandDisallowed)
+	}
+	if err.Error() != "command disallowed" {
+		t.Errorf("Error: got %q", err.Error())
+	}
+
+	if err := UnmarshalRP([]byte{0x00}, NewRP(LECreateConn{})); err != nil {
+		t.Errorf("UnmarshalRP command status: got error %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package cmd
+
+// Error is an HCI status code, as returned by commands in their return
+// parameters and reported by events in their Status and Reason fields.
+// See Core spec Vol 2, Part D, Error Codes.
+type Error uint8
+
+const (
+	Success                              Error = 0x00 // Success
+	ErrUnknownCommand                    Error = 0x01 // Unknown HCI Command
+	ErrUnknownConnectionID               Error = 0x02 // Unknown Connection Identifier
+	ErrHardwareFailure                   Error = 0x03 // Hardware Failure
+	ErrPageTimeout                       Error = 0x04 // Page Timeout
+	ErrAuthenticationFailure             Error = 0x05 // Authentication Failure
+	ErrPINOrKeyMissing                   Error = 0x06 // PIN or Key Missing
+	ErrMemoryCapacityExceeded            Error = 0x07 // Memory Capacity Exceeded
+	ErrConnectionTimeout                 Error = 0x08 // Connection Timeout
+	ErrConnectionLimitExceeded           Error = 0x09 // Connection Limit Exceeded
+	ErrSyncConnectionLimitExceeded       Error = 0x0A // Synchronous Connection Limit To A Device Exceeded
+	ErrConnectionAlreadyExists           Error = 0x0B // Connection Already Exists
+	ErrCommandDisallowed                 Error = 0x0C // Command Disallowed
+	ErrRejectedLimitedResources          Error = 0x0D // Connection Rejected due to Limited Resources
+	ErrRejectedSecurityReasons           Error = 0x0E // Connection Rejected Due To Security Reasons
+	ErrRejectedUnacceptableBDADDR        Error = 0x0F // Connection Rejected due to Unacceptable BD_ADDR
+	ErrConnectionAcceptTimeout           Error = 0x10 // Connection Accept Timeout Exceeded
+	ErrUnsupportedFeature                Error = 0x11 // Unsupported Feature or Parameter Value
+	ErrInvalidParameters                 Error = 0x12 // Invalid HCI Command Parameters
+	ErrRemoteUserTerminated              Error = 0x13 // Remote User Terminated Connection
+	ErrRemoteLowResources                Error = 0x14 // Remote Device Terminated Connection due to Low Resources
+	ErrRemotePowerOff                    Error = 0x15 // Remote Device Terminated Connection due to Power Off
+	ErrLocalHostTerminated               Error = 0x16 // Connection Terminated By Local Host
+	ErrRepeatedAttempts                  Error = 0x17 // Repeated Attempts
+	ErrPairingNotAllowed                 Error = 0x18 // Pairing Not Allowed
+	ErrUnknownLMPPDU                     Error = 0x19 // Unknown LMP PDU
+	ErrUnsupportedRemoteFeature          Error = 0x1A // Unsupported Remote Feature / Unsupported LMP Feature
+	ErrSCOOffsetRejected                 Error = 0x1B // SCO Offset Rejected
+	ErrSCOIntervalRejected               Error = 0x1C // SCO Interval Rejected
+	ErrSCOAirModeRejected                Error = 0x1D // SCO Air Mode Rejected
+	ErrInvalidLLParameters               Error = 0x1E // Invalid LMP Parameters / Invalid LL Parameters
+	ErrUnspecified                       Error = 0x1F // Unspecified Error
+	ErrUnsupportedLLParameterValue       Error = 0x20 // Unsupported LMP Parameter Value / Unsupported LL Parameter Value
+	ErrRoleChangeNotAllowed              Error = 0x21 // Role Change Not Allowed
+	ErrLLResponseTimeout                 Error = 0x22 // LMP Response Timeout / LL Response Timeout
+	ErrLLProcedureCollision              Error = 0x23 // LMP Error Transaction Collision / LL Procedure Collision
+	ErrLMPPDUNotAllowed                  Error = 0x24 // LMP PDU Not Allowed
+	ErrEncryptionModeNotAcceptable       Error = 0x25 // Encryption Mode Not Acceptable
+	ErrLinkKeyCannotBeChanged            Error = 0x26 // Link Key cannot be Changed
+	ErrRequestedQoSNotSupported          Error = 0x27 // Requested QoS Not Supported
+	ErrInstantPassed                     Error = 0x28 // Instant Passed
+	ErrPairingWithUnitKeyNotSupported    Error = 0x29 // Pairing With Unit Key Not Supported
+	ErrDifferentTransactionCollision     Error = 0x2A // Different Transaction Collision
+	ErrQoSUnacceptableParameter          Error = 0x2C // QoS Unacceptable Parameter
+	ErrQoSRejected                       Error = 0x2D // QoS Rejected
+	ErrChannelClassificationNotSupported Error = 0x2E // Channel Classification Not Supported
+	ErrInsufficientSecurity              Error = 0x2F // Insufficient Security
+	ErrParameterOutOfRange               Error = 0x30 // Parameter Out Of Mandatory Range
+	ErrRoleSwitchPending                 Error = 0x32 // Role Switch Pending
+	ErrReservedSlotViolation             Error = 0x34 // Reserved Slot Violation
+	ErrRoleSwitchFailed                  Error = 0x35 // Role Switch Failed
+	ErrEIRTooLarge                       Error = 0x36 // Extended Inquiry Response Too Large
+	ErrSSPNotSupportedByHost             Error = 0x37 // Secure Simple Pairing Not Supported By Host
+	ErrHostBusyPairing                   Error = 0x38 // Host Busy - Pairing
+	ErrNoSuitableChannel                 Error = 0x39 // Connection Rejected due to No Suitable Channel Found
+	ErrControllerBusy                    Error = 0x3A // Controller Busy
+	ErrUnacceptableConnParameters        Error = 0x3B // Unacceptable Connection Parameters
+	ErrAdvertisingTimeout                Error = 0x3C // Advertising Timeout
+	ErrMICFailure                        Error = 0x3D // Connection Terminated due to MIC Failure
+	ErrConnectionFailedToEstablish       Error = 0x3E // Connection Failed to be Established / Synchronization Timeout
+	ErrMACConnectionFailed               Error = 0x3F // MAC Connection Failed
+	ErrCoarseClockAdjustmentRejected     Error = 0x40 // Coarse Clock Adjustment Rejected but Will Try to Adjust Using Clock Dragging
+	ErrType0SubmapNotDefined             Error = 0x41 // Type0 Submap Not Defined
+	ErrUnknownAdvertisingID              Error = 0x42 // Unknown Advertising Identifier
+	ErrLimitReached                      Error = 0x43 // Limit Reached
+	ErrOperationCancelledByHost          Error = 0x44 // Operation Cancelled by Host
+	ErrPacketTooLong                     Error = 0x45 // Packet Too Long
+)
+
+func (e Error) Error() string {
+	if s, ok := errorName[e]; ok {
+		return s
+	}
+	return "reserved error code"
+}
+
+var errorName = map[Error]string{
+	Success:                              "success",
+	ErrUnknownCommand:                    "unknown HCI command",
+	ErrUnknownConnectionID:               "unknown connection identifier",
+	ErrHardwareFailure:                   "hardware failure",
+	ErrPageTimeout:                       "page timeout",
+	ErrAuthenticationFailure:             "authentication failure",
+	ErrPINOrKeyMissing:                   "PIN or key missing",
+	ErrMemoryCapacityExceeded:            "memory capacity exceeded",
+	ErrConnectionTimeout:                 "connection timeout",
+	ErrConnectionLimitExceeded:           "connection limit exceeded",
+	ErrSyncConnectionLimitExceeded:       "synchronous connection limit to a device exceeded",
+	ErrConnectionAlreadyExists:           "connection already exists",
+	ErrCommandDisallowed:                 "command disallowed",
+	ErrRejectedLimitedResources:          "connection rejected due to limited resources",
+	ErrRejectedSecurityReasons:           "connection rejected due to security reasons",
+	ErrRejectedUnacceptableBDADDR:        "connection rejected due to unacceptable BD_ADDR",
+	ErrConnectionAcceptTimeout:           "connection accept timeout exceeded",
+	ErrUnsupportedFeature:                "unsupported feature or parameter value",
+	ErrInvalidParameters:                 "invalid HCI command parameters",
+	ErrRemoteUserTerminated:              "remote user terminated connection",
+	ErrRemoteLowResources:                "remote device terminated connection due to low resources",
+	ErrRemotePowerOff:                    "remote device terminated connection due to power off",
+	ErrLocalHostTerminated:               "connection terminated by local host",
+	ErrRepeatedAttempts:                  "repeated attempts",
+	ErrPairingNotAllowed:                 "pairing not allowed",
+	ErrUnknownLMPPDU:                     "unknown LMP PDU",
+	ErrUnsupportedRemoteFeature:          "unsupported remote feature",
+	ErrSCOOffsetRejected:                 "SCO offset rejected",
+	ErrSCOIntervalRejected:               "SCO interval rejected",
+	ErrSCOAirModeRejected:                "SCO air mode rejected",
+	ErrInvalidLLParameters:               "invalid LMP/LL parameters",
+	ErrUnspecified:                       "unspecified error",
+	ErrUnsupportedLLParameterValue:       "unsupported LMP/LL parameter value",
+	ErrRoleChangeNotAllowed:              "role change not allowed",
+	ErrLLResponseTimeout:                 "LMP/LL response timeout",
+	ErrLLProcedureCollision:              "LMP error transaction collision / LL procedure collision",
+	ErrLMPPDUNotAllowed:                  "LMP PDU not allowed",
+	ErrEncryptionModeNotAcceptable:       "encryption mode not acceptable",
+	ErrLinkKeyCannotBeChanged:            "link key cannot be changed",
+	ErrRequestedQoSNotSupported:          "requested QoS not supported",
+	ErrInstantPassed:                     "instant passed",
+	ErrPairingWithUnitKeyNotSupported:    "pairing with unit key not supported",
+	ErrDifferentTransactionCollision:     "different transaction collision",
+	ErrQoSUnacceptableParameter:          "QoS unacceptable parameter",
+	ErrQoSRejected:                       "QoS rejected",
+	ErrChannelClassificationNotSupported: "channel classification not supported",
+	ErrInsufficientSecurity:              "insufficient security",
+	ErrParameterOutOfRange:               "parameter out of mandatory range",
+	ErrRoleSwitchPending:                 "role switch pending",
+	ErrReservedSlotViolation:             "reserved slot violation",
+	ErrRoleSwitchFailed:                  "role switch failed",
+	ErrEIRTooLarge:                       "extended inquiry response too large",
+	ErrSSPNotSupportedByHost:             "secure simple pairing not supported by host",
+	ErrHostBusyPairing:                   "host busy - pairing",
+	ErrNoSuitableChannel:                 "connection rejected due to no suitable channel found",
+	ErrControllerBusy:                    "controller busy",
+	ErrUnacceptableConnParameters:        "unacceptable connection parameters",
+	ErrAdvertisingTimeout:                "advertising timeout",
+	ErrMICFailure:                        "connection terminated due to MIC failure",
+	ErrConnectionFailedToEstablish:       "connection failed to be established",
+	ErrMACConnectionFailed:               "MAC connection failed",
+	ErrCoarseClockAdjustmentRejected:     "coarse clock adjustment rejected",
+	ErrType0SubmapNotDefined:             "type0 submap not defined",
+	ErrUnknownAdvertisingID:              "unknown advertising identifier",
+	ErrLimitReached:                      "limit reached",
+	ErrOperationCancelledByHost:          "operation cancelled by host",
+	ErrPacketTooLong:                     "packet too long",
+}
@@ -0,0 +1,569 @@
+package linux
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+)
+
+// Dynamically allocated CIDs of LE. See Core spec Vol 3, Part A, 2.1.
+const (
+	cidDynamicMin = 0x0040
+	cidDynamicMax = 0x007F
+)
+
+// Parameters of the connection-oriented channels of the local device.
+const (
+	cocMTU     = 1024 // maximum SDU size received
+	cocMPS     = 247  // maximum K-frame payload received, which fits a LL data PDU of 251 octets
+	cocCredits = 10   // K-frames the remote device can send without waiting for more credits
+)
+
+// ChannelError is the result of a refused LE Credit Based Connection Request.
+// See Core spec Vol 3, Part A, 4.23.
+type ChannelError uint16
+
+const (
+	ErrPSMNotSupported          ChannelError = 0x0002 // LE_PSM not supported
+	ErrNoResources              ChannelError = 0x0004 // No resources available
+	ErrInsufficientAuthn        ChannelError = 0x0005 // Insufficient Authentication
+	ErrInsufficientAuthz        ChannelError = 0x0006 // Insufficient Authorization
+	ErrInsufficientKeySize      ChannelError = 0x0007 // Insufficient Encryption Key Size
+	ErrInsufficientEncryption   ChannelError = 0x0008 // Insufficient Encryption
+	ErrInvalidSourceCID         ChannelError = 0x0009 // Invalid Source CID
+	ErrSourceCIDAlreadyAssigned ChannelError = 0x000A // Source CID already allocated
+	ErrUnacceptableParameters   ChannelError = 0x000B // Unacceptable parameters
+	ErrInvalidParameters        ChannelError = 0x000C // Invalid parameters
+)
+
+var channelErrorName = map[ChannelError]string{
+	ErrPSMNotSupported:          "LE_PSM not supported",
+	ErrNoResources:              "no resources available",
+	ErrInsufficientAuthn:        "insufficient authentication",
+	ErrInsufficientAuthz:        "insufficient authorization",
+	ErrInsufficientKeySize:      "insufficient encryption key size",
+	ErrInsufficientEncryption:   "insufficient encryption",
+	ErrInvalidSourceCID:         "invalid source CID",
+	ErrSourceCIDAlreadyAssigned: "source CID already allocated",
+	ErrUnacceptableParameters:   "unacceptable parameters",
+	ErrInvalidParameters:        "invalid parameters",
+}
+
+func (e ChannelError) Error() string {
+	if s, ok := channelErrorName[e]; ok {
+		return "l2conn: " + s
+	}
+	return fmt.Sprintf("l2conn: channel refused, result 0x%04X", uint16(e))
+}
+
+var errChannelClosed = errors.New("l2conn: channel closed")
+
+// Channel is a LE Credit Based, or an Enhanced Credit Based, connection-oriented
+// channel. The data written
+// is sent in SDUs of up to the MTU of the remote device, and the writes block
+// while the remote device has no credit for more K-frames.
+type Channel struct {
+	c    *conn
+	psm  uint16
+	scid uint16 // local CID
+
+	enhanced bool // opened in Enhanced Credit Based Flow Control Mode
+
+	wmu *sync.Mutex // serializes the SDUs written
+
+	mu        *sync.Mutex // protects the following fields
+	cond      *sync.Cond
+	dcid      uint16  // remote CID
+	mtu       uint16  // maximum SDU size the remote device receives
+	mps       uint16  // maximum K-frame payload the remote device receives
+	credits   int     // K-frames the remote device can receive
+	rxCredits int     // K-frames the remote device can send
+	sdu       []byte  // SDU being reassembled
+	sduLen    int     // length of the SDU being reassembled
+	frames    int     // K-frames of the SDU being reassembled
+	rxq       []rxSDU // SDUs received, not read yet
+	rbuf      []byte  // rest of the SDU being read
+	closed    bool
+}
+
+type rxSDU struct {
+	b      []byte
+	frames int
+}
+
+// newChannel allocates a local CID for a new channel on the connection.
+func (c *conn) newChannel(psm uint16, enhanced bool) (*Channel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for cid := uint16(cidDynamicMin); cid <= cidDynamicMax; cid++ {
+		if _, ok := c.chans[cid]; ok {
+			continue
+		}
+		mu := &sync.Mutex{}
+		ch := &Channel{
+			c:         c,
+			psm:       psm,
+			scid:      cid,
+			enhanced:  enhanced,
+			wmu:       &sync.Mutex{},
+			mu:        mu,
+			cond:      sync.NewCond(mu),
+			rxCredits: cocCredits,
+		}
+		c.chans[cid] = ch
+		return ch, nil
+	}
+	return nil, ErrNoResources
+}
+
+func (c *conn) channel(cid uint16) *Channel {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.chans[cid]
+}
+
+// remoteChannel returns the channel of the remote CID.
+func (c *conn) remoteChannel(dcid uint16) *Channel {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, ch := range c.chans {
+		ch.mu.Lock()
+		ok := ch.dcid == dcid
+		ch.mu.Unlock()
+		if ok {
+			return ch
+		}
+	}
+	return nil
+}
+
+func (c *conn) removeChannel(cid uint16) {
+	c.mu.Lock()
+	delete(c.chans, cid)
+	c.mu.Unlock()
+}
+
+// closeChannels closes the channels of a disconnected connection.
+func (c *conn) closeChannels() {
+	c.mu.Lock()
+	chans := c.chans
+	c.chans = map[uint16]*Channel{}
+	c.mu.Unlock()
+	for _, ch := range chans {
+		ch.shutdown()
+	}
+}
+
+// OpenChannel opens a connection-oriented channel to the PSM of the remote
+// device of the connection hh.
+func (h *HCI) OpenChannel(hh uint16, psm uint16) (*Channel, error) {
+	if psm == 0 || psm > 0x00FF {
+		return nil, fmt.Errorf("l2conn: invalid LE_PSM 0x%04X", psm)
+	}
+	c, err := h.conn(hh)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := c.newChannel(psm, false)
+	if err != nil {
+		return nil, err
+	}
+	rsp, err := c.request(sigLECreditConnRequest, le16s(psm, ch.scid, cocMTU, cocMPS, cocCredits))
+	if err != nil {
+		c.removeChannel(ch.scid)
+		return nil, err
+	}
+	if rsp.code == sigCommandReject {
+		c.removeChannel(ch.scid)
+		return nil, errors.New("l2conn: connection-oriented channels not supported by the remote device")
+	}
+	if len(rsp.data) < 10 {
+		c.removeChannel(ch.scid)
+		return nil, errors.New("l2conn: malformed LE credit based connection response")
+	}
+	v := unle16s(rsp.data, 5)
+	dcid, mtu, mps, credits, result := v[0], v[1], v[2], v[3], v[4]
+	if result != 0x0000 {
+		c.removeChannel(ch.scid)
+		return nil, ChannelError(result)
+	}
+	if dcid < cidDynamicMin || dcid > cidDynamicMax || mtu < 23 || mps < 23 || mps > 65533 {
+		ch.Close()
+		return nil, errors.New("l2conn: invalid LE credit based connection response")
+	}
+	ch.mu.Lock()
+	ch.dcid, ch.mtu, ch.mps, ch.credits = dcid, mtu, mps, int(credits)
+	ch.mu.Unlock()
+	return ch, nil
+}
+
+// PSM returns the LE_PSM of the channel.
+func (ch *Channel) PSM() int { return int(ch.psm) }
+
+// MTU returns the maximum SDU size the remote device receives.
+func (ch *Channel) MTU() int {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return int(ch.mtu)
+}
+
+// LocalMTU returns the maximum SDU size the local device receives.
+func (ch *Channel) LocalMTU() int { return cocMTU }
+
+// PlatData returns the platform data of the connection of the channel.
+func (ch *Channel) PlatData() *PlatData { return ch.c.platData() }
+
+// Read reads the data of the SDUs received, and returns the credits of the
+// K-frames read to the remote device.
+func (ch *Channel) Read(b []byte) (int, error) {
+	ch.mu.Lock()
+	credits := 0
+	for len(ch.rbuf) == 0 {
+		if len(ch.rxq) != 0 {
+			s := ch.rxq[0]
+			ch.rxq = ch.rxq[1:]
+			ch.rbuf = s.b
+			credits += s.frames
+			continue
+		}
+		if ch.closed {
+			ch.mu.Unlock()
+			return 0, io.EOF
+		}
+		ch.cond.Wait()
+	}
+	n := copy(b, ch.rbuf)
+	ch.rbuf = ch.rbuf[n:]
+	ch.rxCredits += credits
+	closed := ch.closed
+	ch.mu.Unlock()
+
+	if credits > 0 && !closed {
+		ch.c.mu.Lock()
+		id := ch.c.nextSigID()
+		ch.c.mu.Unlock()
+		ch.c.sendSignal(&signal{code: sigLEFlowControlCredit, id: id, data: le16s(ch.scid, uint16(credits))})
+	}
+	return n, nil
+}
+
+// Write sends the data in SDUs of up to the MTU of the remote device.
+func (ch *Channel) Write(b []byte) (int, error) {
+	ch.wmu.Lock()
+	defer ch.wmu.Unlock()
+	n := 0
+	for len(b) > 0 {
+		sdu := b
+		if mtu := ch.MTU(); len(sdu) > mtu {
+			sdu = sdu[:mtu]
+		}
+		if err := ch.writeSDU(sdu); err != nil {
+			return n, err
+		}
+		n += len(sdu)
+		b = b[len(sdu):]
+	}
+	return n, nil
+}
+
+// writeSDU segments the SDU in K-frames of up to the MPS of the remote
+// device, each of which takes a credit.
+func (ch *Channel) writeSDU(sdu []byte) error {
+	p := append([]byte{uint8(len(sdu)), uint8(len(sdu) >> 8)}, sdu...)
+	for len(p) > 0 {
+		ch.mu.Lock()
+		for ch.credits == 0 && !ch.closed {
+			ch.cond.Wait()
+		}
+		if ch.closed {
+			ch.mu.Unlock()
+			return errChannelClosed
+		}
+		ch.credits--
+		dcid := ch.dcid
+		k := p
+		if len(k) > int(ch.mps) {
+			k = k[:ch.mps]
+		}
+		ch.mu.Unlock()
+		if _, err := ch.c.write(int(dcid), k); err != nil {
+			return err
+		}
+		p = p[len(k):]
+	}
+	return nil
+}
+
+// Close disconnects the channel.
+func (ch *Channel) Close() error {
+	if !ch.shutdown() {
+		return nil
+	}
+	ch.c.removeChannel(ch.scid)
+	ch.mu.Lock()
+	dcid := ch.dcid
+	ch.mu.Unlock()
+	rsp, err := ch.c.request(sigDisconnectRequest, le16s(dcid, ch.scid))
+	if err != nil {
+		return err
+	}
+	if rsp.code == sigCommandReject {
+		return errors.New("l2conn: channel disconnection rejected by the remote device")
+	}
+	return nil
+}
+
+// shutdown wakes up the blocked readers and writers of the channel, and
+// reports if the channel was open.
+func (ch *Channel) shutdown() bool {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.closed {
+		return false
+	}
+	ch.closed = true
+	ch.cond.Broadcast()
+	return true
+}
+
+// receive reassembles the K-frames received into SDUs. It is called by
+// mainLoop, and never blocks.
+func (ch *Channel) receive(b []byte) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.closed {
+		return nil
+	}
+	if ch.rxCredits == 0 {
+		return errors.New("l2conn: K-frame received without credit")
+	}
+	if len(b) > cocMPS {
+		return errors.New("l2conn: K-frame larger than the MPS")
+	}
+	ch.rxCredits--
+	ch.frames++
+	if ch.sdu == nil {
+		if len(b) < 2 {
+			return errors.New("l2conn: K-frame without SDU length")
+		}
+		ch.sduLen = int(b[0]) | int(b[1])<<8
+		if ch.sduLen > cocMTU {
+			return errors.New("l2conn: SDU larger than the MTU")
+		}
+		ch.sdu = make([]byte, 0, ch.sduLen)
+		b = b[2:]
+	}
+	ch.sdu = append(ch.sdu, b...)
+	if len(ch.sdu) > ch.sduLen {
+		return errors.New("l2conn: SDU longer than its length")
+	}
+	if len(ch.sdu) == ch.sduLen {
+		ch.rxq = append(ch.rxq, rxSDU{ch.sdu, ch.frames})
+		ch.sdu, ch.frames = nil, 0
+		ch.cond.Broadcast()
+	}
+	return nil
+}
+
+// handleKFrame delivers a K-frame to its channel, and disconnects the
+// channel if the remote device violates its parameters.
+func (c *conn) handleKFrame(cid uint16, b []byte) {
+	ch := c.channel(cid)
+	if ch == nil {
+		log.Printf("l2conn: got data for unknown channel 0x%04X", cid)
+		return
+	}
+	if err := ch.receive(b); err != nil {
+		log.Printf("%s, disconnecting channel 0x%04X", err, cid)
+		go ch.Close()
+	}
+}
+
+// Listener accepts the connection-oriented channels opened by remote devices.
+type Listener struct {
+	h       *HCI
+	psm     uint16
+	encrypt bool // refuse the channels on unencrypted links
+	chc     chan *Channel
+	closed  chan struct{}
+	once    sync.Once
+}
+
+// Listen listens for connection-oriented channels to the PSM.
+func (h *HCI) Listen(psm uint16) (*Listener, error) {
+	return h.listen(psm, false)
+}
+
+// ListenEncrypted listens for connection-oriented channels to the PSM, as
+// Listen, but refuses the channels opened on unencrypted links, with
+// ErrInsufficientEncryption.
+func (h *HCI) ListenEncrypted(psm uint16) (*Listener, error) {
+	return h.listen(psm, true)
+}
+
+func (h *HCI) listen(psm uint16, encrypt bool) (*Listener, error) {
+	if psm == 0 || psm > 0x00FF {
+		return nil, fmt.Errorf("l2conn: invalid LE_PSM 0x%04X", psm)
+	}
+	h.listenersmu.Lock()
+	defer h.listenersmu.Unlock()
+	if _, ok := h.listeners[psm]; ok {
+		return nil, fmt.Errorf("l2conn: LE_PSM 0x%04X already in use", psm)
+	}
+	l := &Listener{
+		h:       h,
+		psm:     psm,
+		encrypt: encrypt,
+		chc:     make(chan *Channel, 8),
+		closed:  make(chan struct{}),
+	}
+	h.listeners[psm] = l
+	return l, nil
+}
+
+func (h *HCI) listener(psm uint16) *Listener {
+	h.listenersmu.Lock()
+	defer h.listenersmu.Unlock()
+	return h.listeners[psm]
+}
+
+// Accept waits for and returns the next channel opened by a remote device.
+func (l *Listener) Accept() (*Channel, error) {
+	select {
+	case ch := <-l.chc:
+		return ch, nil
+	case <-l.closed:
+		return nil, errors.New("l2conn: listener closed")
+	}
+}
+
+// Close stops listening. The channels already opened are not affected.
+func (l *Listener) Close() error {
+	l.once.Do(func() {
+		l.h.listenersmu.Lock()
+		delete(l.h.listeners, l.psm)
+		l.h.listenersmu.Unlock()
+		close(l.closed)
+	})
+	return nil
+}
+
+// PSM returns the LE_PSM the listener listens to.
+func (l *Listener) PSM() int { return int(l.psm) }
+
+// offer hands the channel to Accept, and reports false if the listener is
+// closed, or has too many channels not accepted yet.
+func (l *Listener) offer(ch *Channel) bool {
+	select {
+	case <-l.closed:
+		return false
+	default:
+	}
+	select {
+	case l.chc <- ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *conn) handleLECreditConnRequest(s *signal) *signal {
+	if len(s.data) != 10 {
+		return reject(s, rejectNotUnderstood)
+	}
+	v := unle16s(s.data, 5)
+	psm, dcid, mtu, mps, credits := v[0], v[1], v[2], v[3], v[4]
+	refuse := func(e ChannelError) *signal {
+		return &signal{code: sigLECreditConnResponse, id: s.id, data: le16s(0, 0, 0, 0, uint16(e))}
+	}
+	l := c.hci.listener(psm)
+	switch {
+	case l == nil:
+		return refuse(ErrPSMNotSupported)
+	case l.encrypt && !c.smp.security().Encrypted:
+		return refuse(ErrInsufficientEncryption)
+	case dcid < cidDynamicMin || dcid > cidDynamicMax:
+		return refuse(ErrInvalidSourceCID)
+	case c.remoteChannel(dcid) != nil:
+		return refuse(ErrSourceCIDAlreadyAssigned)
+	case mtu < 23 || mps < 23 || mps > 65533:
+		return refuse(ErrUnacceptableParameters)
+	}
+	ch, err := c.newChannel(psm, false)
+	if err != nil {
+		return refuse(ErrNoResources)
+	}
+	ch.mu.Lock()
+	ch.dcid, ch.mtu, ch.mps, ch.credits = dcid, mtu, mps, int(credits)
+	ch.mu.Unlock()
+
+	// Respond before the channel is accepted, so the remote device doesn't
+	// get data on a channel it doesn't know yet.
+	c.sendSignal(&signal{code: sigLECreditConnResponse, id: s.id, data: le16s(ch.scid, cocMTU, cocMPS, cocCredits, 0)})
+	if !l.offer(ch) {
+		ch.Close()
+	}
+	return nil
+}
+
+func (c *conn) handleLEFlowControlCredit(s *signal) *signal {
+	if len(s.data) != 4 {
+		return reject(s, rejectNotUnderstood)
+	}
+	v := unle16s(s.data, 2)
+	ch := c.remoteChannel(v[0])
+	if ch == nil {
+		return nil // The channel may have just been closed.
+	}
+	ch.mu.Lock()
+	ch.credits += int(v[1])
+	overflow := ch.credits > 0xFFFF
+	ch.cond.Broadcast()
+	ch.mu.Unlock()
+	if overflow {
+		log.Printf("l2conn: credits overflow, disconnecting channel 0x%04X", ch.scid)
+		ch.Close()
+	}
+	return nil
+}
+
+func (c *conn) handleDisconnectRequest(s *signal) *signal {
+	if len(s.data) != 4 {
+		return reject(s, rejectNotUnderstood)
+	}
+	v := unle16s(s.data, 2)
+	scid, dcid := v[0], v[1] // the destination of the remote device is the local CID.
+	ch := c.channel(scid)
+	if ch == nil || ch.remoteCID() != dcid {
+		return reject(s, rejectInvalidCID, s.data...)
+	}
+	c.removeChannel(scid)
+	ch.shutdown()
+	return &signal{code: sigDisconnectResponse, id: s.id, data: s.data}
+}
+
+func (ch *Channel) remoteCID() uint16 {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.dcid
+}
+
+// le16s marshals the values in little endian.
+func le16s(v ...uint16) []byte {
+	b := make([]byte, 2*len(v))
+	for i, x := range v {
+		b[2*i], b[2*i+1] = uint8(x), uint8(x>>8)
+	}
+	return b
+}
+
+// unle16s unmarshals n little endian values from b.
+func unle16s(b []byte, n int) []uint16 {
+	v := make([]uint16, n)
+	for i := range v {
+		v[i] = uint16(b[2*i]) | uint16(b[2*i+1])<<8
+	}
+	return v
+}
@@ -0,0 +1,306 @@
+package linux
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+// kframe returns the next K-frame sent by the host on the channel cid,
+// after completing its packet.
+func kframe(t *testing.T, f *fakeController, hh uint16, cid uint16) []byte {
+	select {
+	case p := <-f.aclc:
+		f.completed(hh, 1)
+		if got := uint16(p[7]) | uint16(p[8])<<8; got != cid {
+			t.Fatalf("got [ % X ], want a K-frame on channel 0x%04X", p, cid)
+		}
+		return p[9:]
+	case <-time.After(time.Second):
+		t.Fatalf("no K-frame sent")
+	}
+	return nil
+}
+
+// openTestChannel opens a channel to the PSM 0x0080, which the remote device
+// accepts with the CID 0x0041, and the parameters specified.
+func openTestChannel(t *testing.T, h *HCI, f *fakeController, hh uint16, mtu, mps, credits uint16) *Channel {
+	type result struct {
+		ch  *Channel
+		err error
+	}
+	rc := make(chan result, 1)
+	go func() {
+		ch, err := h.OpenChannel(hh, 0x0080)
+		rc <- result{ch, err}
+	}()
+	req := signalRsp(t, f, hh)
+	if req[0] != sigLECreditConnRequest || !bytes.Equal(req[4:], le16s(0x0080, 0x0040, cocMTU, cocMPS, cocCredits)) {
+		t.Fatalf("got [ % X ], want LE Credit Based Connection Request", req)
+	}
+	rsp := append([]byte{sigLECreditConnResponse, req[1], 0x0A, 0x00}, le16s(0x0041, mtu, mps, credits, 0x0000)...)
+	f.acl(hh, cidLESignal, rsp...)
+	r := <-rc
+	if r.err != nil {
+		t.Fatalf("OpenChannel: %s", r.err)
+	}
+	return r.ch
+}
+
+func TestOpenChannelRefused(t *testing.T) {
+	f := newFakeController()
+	h, pdc := newTestHCI(t, f)
+	defer h.Close()
+	f.connect(0x40)
+	<-pdc
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := h.OpenChannel(0x40, 0x0080)
+		errc <- err
+	}()
+	req := signalRsp(t, f, 0x40)
+	rsp := append([]byte{sigLECreditConnResponse, req[1], 0x0A, 0x00}, le16s(0, 0, 0, 0, uint16(ErrPSMNotSupported))...)
+	f.acl(0x40, cidLESignal, rsp...)
+	if err := <-errc; err != ErrPSMNotSupported {
+		t.Errorf("OpenChannel: got %v, want %v", err, ErrPSMNotSupported)
+	}
+}
+
+func TestChannelWrite(t *testing.T) {
+	f := newFakeController()
+	h, pdc := newTestHCI(t, f)
+	defer h.Close()
+	f.connect(0x40)
+	<-pdc
+	ch := openTestChannel(t, h, f, 0x40, 30, 23, 2)
+
+	// A SDU of 30 octets, and its length, takes two K-frames.
+	data := bytes.Repeat([]byte{0xAA}, 40)
+	errc := make(chan error, 1)
+	go func() {
+		_, err := ch.Write(data)
+		errc <- err
+	}()
+	if got := kframe(t, f, 0x40, 0x41); !bytes.Equal(got, append([]byte{30, 0}, data[:21]...)) {
+		t.Errorf("first K-frame: got [ % X ]", got)
+	}
+	if got := kframe(t, f, 0x40, 0x41); !bytes.Equal(got, data[21:30]) {
+		t.Errorf("second K-frame: got [ % X ]", got)
+	}
+
+	// The next SDU waits for credits.
+	select {
+	case p := <-f.aclc:
+		t.Fatalf("got [ % X ] without credits", p)
+	case <-time.After(50 * time.Millisecond):
+	}
+	f.acl(0x40, cidLESignal, append([]byte{sigLEFlowControlCredit, 0x01, 0x04, 0x00}, le16s(0x0041, 1)...)...)
+	if got := kframe(t, f, 0x40, 0x41); !bytes.Equal(got, append([]byte{10, 0}, data[30:]...)) {
+		t.Errorf("third K-frame: got [ % X ]", got)
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("Write: %s", err)
+	}
+}
+
+func TestChannelRead(t *testing.T) {
+	f := newFakeController()
+	h, pdc := newTestHCI(t, f)
+	defer h.Close()
+	f.connect(0x40)
+	<-pdc
+	ch := openTestChannel(t, h, f, 0x40, 100, 100, 0)
+
+	f.acl(0x40, 0x40, 0x05, 0x00, 'h', 'e')
+	f.acl(0x40, 0x40, 'l', 'l', 'o')
+	b := make([]byte, 16)
+	n, err := ch.Read(b)
+	if err != nil || string(b[:n]) != "hello" {
+		t.Fatalf("Read: got %q, %v, want \"hello\"", b[:n], err)
+	}
+
+	// The credits of both K-frames are returned.
+	got := signalRsp(t, f, 0x40)
+	if got[0] != sigLEFlowControlCredit || !bytes.Equal(got[4:], le16s(0x0040, 2)) {
+		t.Errorf("got [ % X ], want LE Flow Control Credit", got)
+	}
+
+	// The remote device disconnects the channel.
+	f.acl(0x40, cidLESignal, append([]byte{sigDisconnectRequest, 0x09, 0x04, 0x00}, le16s(0x0040, 0x0041)...)...)
+	got = signalRsp(t, f, 0x40)
+	if got[0] != sigDisconnectResponse || got[1] != 0x09 || !bytes.Equal(got[4:], le16s(0x0040, 0x0041)) {
+		t.Errorf("got [ % X ], want Disconnection Response", got)
+	}
+	if _, err := ch.Read(b); err != io.EOF {
+		t.Errorf("Read on a disconnected channel: got %v, want EOF", err)
+	}
+}
+
+func TestListen(t *testing.T) {
+	f := newFakeController()
+	h, pdc := newTestHCI(t, f)
+	defer h.Close()
+	f.connect(0x40)
+	<-pdc
+
+	l, err := h.Listen(0x0080)
+	if err != nil {
+		t.Fatalf("Listen: %s", err)
+	}
+	defer l.Close()
+	if _, err := h.Listen(0x0080); err == nil {
+		t.Errorf("Listen to a PSM in use should fail")
+	}
+
+	// Requests to other PSMs are refused.
+	f.acl(0x40, cidLESignal, append([]byte{sigLECreditConnRequest, 0x01, 0x0A, 0x00}, le16s(0x0081, 0x0041, 100, 50, 3)...)...)
+	got := signalRsp(t, f, 0x40)
+	if got[0] != sigLECreditConnResponse || !bytes.Equal(got[4:], le16s(0, 0, 0, 0, uint16(ErrPSMNotSupported))) {
+		t.Errorf("got [ % X ], want LE_PSM not supported", got)
+	}
+
+	f.acl(0x40, cidLESignal, append([]byte{sigLECreditConnRequest, 0x02, 0x0A, 0x00}, le16s(0x0080, 0x0041, 100, 50, 3)...)...)
+	got = signalRsp(t, f, 0x40)
+	if got[0] != sigLECreditConnResponse || got[1] != 0x02 || !bytes.Equal(got[4:], le16s(0x0040, cocMTU, cocMPS, cocCredits, 0)) {
+		t.Errorf("got [ % X ], want LE Credit Based Connection Response", got)
+	}
+	ch, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %s", err)
+	}
+	if ch.PSM() != 0x0080 || ch.MTU() != 100 {
+		t.Errorf("channel: got PSM 0x%04X, MTU %d", ch.PSM(), ch.MTU())
+	}
+
+	// The same remote CID can't be used twice.
+	f.acl(0x40, cidLESignal, append([]byte{sigLECreditConnRequest, 0x03, 0x0A, 0x00}, le16s(0x0080, 0x0041, 100, 50, 3)...)...)
+	got = signalRsp(t, f, 0x40)
+	if !bytes.Equal(got[4:], le16s(0, 0, 0, 0, uint16(ErrSourceCIDAlreadyAssigned))) {
+		t.Errorf("got [ % X ], want source CID already allocated", got)
+	}
+
+	// Channels are closed with the connection.
+	f.disconnect(0x40)
+	if _, err := ch.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Read on a disconnected connection: got %v, want EOF", err)
+	}
+}
+
+func TestOpenChannels(t *testing.T) {
+	f := newFakeController()
+	h, pdc := newTestHCI(t, f)
+	defer h.Close()
+	f.connect(0x40)
+	<-pdc
+
+	type result struct {
+		chs []*Channel
+		err error
+	}
+	rc := make(chan result, 1)
+	go func() {
+		chs, err := h.OpenChannels(0x40, 0x0027, 3)
+		rc <- result{chs, err}
+	}()
+	req := signalRsp(t, f, 0x40)
+	if req[0] != sigCreditConnRequest || !bytes.Equal(req[4:], le16s(0x0027, cocMTU, cocMPS, cocCredits, 0x0040, 0x0041, 0x0042)) {
+		t.Fatalf("got [ % X ], want Credit Based Connection Request", req)
+	}
+
+	// The remote device refuses the second channel.
+	rsp := append([]byte{sigCreditConnResponse, req[1], 0x0E, 0x00}, le16s(100, 64, 1, uint16(ErrNoResources), 0x0050, 0x0000, 0x0051)...)
+	f.acl(0x40, cidLESignal, rsp...)
+	r := <-rc
+	if r.err != nil {
+		t.Fatalf("OpenChannels: %s", r.err)
+	}
+	if len(r.chs) != 2 || r.chs[0].remoteCID() != 0x0050 || r.chs[1].remoteCID() != 0x0051 {
+		t.Fatalf("OpenChannels: got %d channels", len(r.chs))
+	}
+	if r.chs[1].MTU() != 100 {
+		t.Errorf("MTU: got %d want 100", r.chs[1].MTU())
+	}
+
+	// The MTU of the channels can be increased, but not reduced. The channels
+	// are identified by the CIDs of the remote device.
+	f.acl(0x40, cidLESignal, append([]byte{sigCreditReconfRequest, 0x01, 0x08, 0x00}, le16s(90, 64, 0x0050, 0x0051)...)...)
+	if got := signalRsp(t, f, 0x40); got[0] != sigCreditReconfResponse || !bytes.Equal(got[4:], le16s(reconfMTUReduced)) {
+		t.Errorf("got [ % X ], want MTU reduction not allowed", got)
+	}
+	f.acl(0x40, cidLESignal, append([]byte{sigCreditReconfRequest, 0x02, 0x08, 0x00}, le16s(200, 64, 0x0050, 0x0040)...)...)
+	if got := signalRsp(t, f, 0x40); !bytes.Equal(got[4:], le16s(reconfInvalidCID)) {
+		t.Errorf("got [ % X ], want invalid CID", got)
+	}
+	f.acl(0x40, cidLESignal, append([]byte{sigCreditReconfRequest, 0x03, 0x08, 0x00}, le16s(200, 64, 0x0050, 0x0051)...)...)
+	if got := signalRsp(t, f, 0x40); !bytes.Equal(got[4:], le16s(reconfSuccess)) {
+		t.Errorf("got [ % X ], want success", got)
+	}
+	if r.chs[0].MTU() != 200 || r.chs[1].MTU() != 200 {
+		t.Errorf("MTU: got %d and %d, want 200", r.chs[0].MTU(), r.chs[1].MTU())
+	}
+}
+
+func TestListenEnhanced(t *testing.T) {
+	f := newFakeController()
+	h, pdc := newTestHCI(t, f)
+	defer h.Close()
+	f.connect(0x40)
+	<-pdc
+
+	l, err := h.Listen(0x0027)
+	if err != nil {
+		t.Fatalf("Listen: %s", err)
+	}
+	defer l.Close()
+
+	// Parameters below the minimum of the mode are refused.
+	f.acl(0x40, cidLESignal, append([]byte{sigCreditConnRequest, 0x01, 0x0A, 0x00}, le16s(0x0027, 23, 23, 1, 0x0050)...)...)
+	got := signalRsp(t, f, 0x40)
+	if got[0] != sigCreditConnResponse || !bytes.Equal(got[4:], le16s(0, 0, 0, uint16(ErrUnacceptableParameters), 0)) {
+		t.Errorf("got [ % X ], want unacceptable parameters", got)
+	}
+
+	f.acl(0x40, cidLESignal, append([]byte{sigCreditConnRequest, 0x02, 0x0E, 0x00}, le16s(0x0027, 100, 64, 1, 0x0050, 0x0010, 0x0051)...)...)
+	got = signalRsp(t, f, 0x40)
+	want := le16s(cocMTU, cocMPS, cocCredits, uint16(ErrInvalidSourceCID), 0x0040, 0x0000, 0x0041)
+	if got[0] != sigCreditConnResponse || got[1] != 0x02 || !bytes.Equal(got[4:], want) {
+		t.Errorf("got [ % X ], want [ % X ]", got[4:], want)
+	}
+	for _, dcid := range []uint16{0x0050, 0x0051} {
+		ch, err := l.Accept()
+		if err != nil {
+			t.Fatalf("Accept: %s", err)
+		}
+		if ch.remoteCID() != dcid || !ch.enhanced {
+			t.Errorf("channel: got remote CID 0x%04X, want 0x%04X", ch.remoteCID(), dcid)
+		}
+	}
+}
+
+func TestListenEncrypted(t *testing.T) {
+	f := newFakeController()
+	h, pdc := newTestHCI(t, f)
+	defer h.Close()
+	f.connect(0x40)
+	<-pdc
+
+	l, err := h.ListenEncrypted(0x0027)
+	if err != nil {
+		t.Fatalf("ListenEncrypted: %s", err)
+	}
+	defer l.Close()
+
+	// The link isn't encrypted.
+	f.acl(0x40, cidLESignal, append([]byte{sigCreditConnRequest, 0x01, 0x0A, 0x00}, le16s(0x0027, 100, 64, 1, 0x0050)...)...)
+	got := signalRsp(t, f, 0x40)
+	if got[0] != sigCreditConnResponse || !bytes.Equal(got[4:], le16s(0, 0, 0, uint16(ErrInsufficientEncryption), 0)) {
+		t.Errorf("got [ % X ], want insufficient encryption", got)
+	}
+	f.acl(0x40, cidLESignal, append([]byte{sigLECreditConnRequest, 0x02, 0x0A, 0x00}, le16s(0x0027, 0x0050, 100, 64, 1)...)...)
+	got = signalRsp(t, f, 0x40)
+	if got[0] != sigLECreditConnResponse || !bytes.Equal(got[4:], le16s(0, 0, 0, 0, uint16(ErrInsufficientEncryption))) {
+		t.Errorf("got [ % X ], want insufficient encryption", got)
+	}
+}
@@ -0,0 +1,171 @@
+package linux
+
+import (
+	"errors"
+
+	"github.com/paypal/gatt/linux/cmd"
+)
+
+// A connReq is a connection requested by Connect or AutoConnect.
+type connReq struct {
+	pd     *PlatData
+	p      ConnParams
+	filter uint8 // 0x00: peer address, 0x01: filter accept list
+}
+
+// Connect initiates a connection to the device of pd, which is reported by
+// AcceptSlaveHandler, or by ConnectFailedHandler if it fails. The controller
+// initiates one connection at a time; the others are queued until it
+// completes, and until the local device is the master of less than the
+// maximum set by SetMaxMasterConnections. Each connection initiated is
+// reported once, even if canceled, or if h is closed meanwhile.
+func (h *HCI) Connect(pd *PlatData, p ConnParams) error {
+	return h.connect(&connReq{pd, p, 0x00})
+}
+
+// AutoConnect initiates a connection to the first device of the filter accept
+// list which advertises connectably. The connection is reported by
+// AcceptSlaveHandler, as the connections initiated by Connect.
+func (h *HCI) AutoConnect(p ConnParams) error {
+	return h.connect(&connReq{&PlatData{}, p, 0x01})
+}
+
+// SetMaxMasterConnections limits the connections of which the local device is
+// the master to n at a time, if n is non-zero. The connections requested
+// beyond are queued until others disconnect.
+func (h *HCI) SetMaxMasterConnections(n int) {
+	h.addrmu.Lock()
+	h.maxMasters = n
+	h.addrmu.Unlock()
+	go h.nextConn()
+}
+
+var errHCIClosed = errors.New("hci: closed")
+
+func (h *HCI) connect(r *connReq) error {
+	if err := r.p.check(); err != nil {
+		return err
+	}
+	if h.connected(r.pd) {
+		return errors.New("hci: already connected")
+	}
+	h.addrmu.Lock()
+	defer h.addrmu.Unlock()
+	if h.connecting == r.pd || h.queued(r.pd) >= 0 {
+		return errors.New("hci: connection pending")
+	}
+	if h.initiating || !h.canMaster() {
+		h.connq = append(h.connq, r)
+		return nil
+	}
+	return h.createConn(r)
+}
+
+// createConn sends the LE Create Connection of r. The private address isn't
+// renewed while the connection is initiated. The caller holds addrmu.
+func (h *HCI) createConn(r *connReq) error {
+	err := h.c.SendAndDecode(
+		cmd.LECreateConn{
+			LEScanInterval:        0x0004,                 // N x 0.625ms
+			LEScanWindow:          0x0004,                 // N x 0.625ms
+			InitiatorFilterPolicy: r.filter,               // 0x00: peer address, 0x01: filter accept list
+			PeerAddressType:       r.pd.Address.HCIType(), // public or random
+			PeerAddress:           r.pd.Address.Octets,    //
+			OwnAddressType:        h.ownType,              // public or random
+			ConnIntervalMin:       r.p.IntervalMin,        // N x 1.25ms
+			ConnIntervalMax:       r.p.IntervalMax,        // N x 1.25ms
+			ConnLatency:           r.p.Latency,            //
+			SupervisionTimeout:    r.p.Timeout,            // N x 10ms
+			MinimumCELength:       0x0000,                 // N x 0.625ms
+			MaximumCELength:       0x0000,                 // N x 0.625ms
+		}, &cmd.LECreateConnRP{})
+	h.initiating = err == nil
+	if err == nil {
+		h.connecting = r.pd
+	}
+	return err
+}
+
+// nextConn initiates the next connection queued, if the pending one
+// completed, and the limit of masters allows. The connections which fail to
+// be initiated are reported to ConnectFailedHandler.
+func (h *HCI) nextConn() {
+	type failure struct {
+		pd  *PlatData
+		err error
+	}
+	var ff []failure
+	h.addrmu.Lock()
+	for !h.initiating && h.canMaster() && len(h.connq) > 0 {
+		r := h.connq[0]
+		h.connq = h.connq[1:]
+		if err := h.createConn(r); err != nil {
+			ff = append(ff, failure{r.pd, err})
+		}
+	}
+	h.addrmu.Unlock()
+	for _, f := range ff {
+		if h.ConnectFailedHandler != nil {
+			h.ConnectFailedHandler(f.pd, f.err)
+		}
+	}
+}
+
+// canMaster reports whether the local device may be the master of another
+// connection. The caller holds addrmu.
+func (h *HCI) canMaster() bool {
+	return h.maxMasters == 0 || h.masters < h.maxMasters
+}
+
+// queued returns the index of the connection to the device of pd in the
+// queue, or -1. The caller holds addrmu.
+func (h *HCI) queued(pd *PlatData) int {
+	for i, r := range h.connq {
+		if r.pd == pd {
+			return i
+		}
+	}
+	return -1
+}
+
+// connected reports whether pd has a connection which isn't disconnected.
+func (h *HCI) connected(pd *PlatData) bool {
+	h.plistmu.Lock()
+	c, _ := pd.Conn.(*conn)
+	h.plistmu.Unlock()
+	if c == nil {
+		return false
+	}
+	h.connsmu.Lock()
+	defer h.connsmu.Unlock()
+	return h.conns[c.attr] == c
+}
+
+// CancelConnection cancels the connection to the device of pd, if it is
+// pending or queued, which then fails with cmd.ErrUnknownConnectionID; or
+// else closes the connection. A connection which completes meanwhile is
+// reported by AcceptSlaveHandler nonetheless, to be closed then.
+func (h *HCI) CancelConnection(pd *PlatData) error {
+	h.addrmu.Lock()
+	if h.connecting == pd {
+		err := h.c.SendAndCheckResp(cmd.LECreateConnCancel{}, []byte{0x00})
+		h.addrmu.Unlock()
+		return err
+	}
+	if i := h.queued(pd); i >= 0 {
+		h.connq = append(h.connq[:i], h.connq[i+1:]...)
+		h.addrmu.Unlock()
+		if h.ConnectFailedHandler != nil {
+			go h.ConnectFailedHandler(pd, cmd.ErrUnknownConnectionID)
+		}
+		return nil
+	}
+	h.addrmu.Unlock()
+	h.plistmu.Lock()
+	c := pd.Conn
+	h.plistmu.Unlock()
+	if c == nil {
+		return errors.New("hci: not connected")
+	}
+	return c.Close()
+}
@@ -0,0 +1,93 @@
+package linux
+
+import (
+	"testing"
+	"time"
+
+	"github.com/paypal/gatt/btaddr"
+	"github.com/paypal/gatt/linux/cmd"
+)
+
+// createConnAddr waits for the next LE Create Connection sent to the
+// controller, and returns its peer address, or fails if none is sent.
+func createConnAddr(t *testing.T, cmdc chan []byte) bdaddr {
+	p := nextCmd(t, cmdc, 0x200D)
+	return bdaddr{p[15], p[14], p[13], p[12], p[11], p[10]}
+}
+
+// noCreateConn fails if an LE Create Connection is sent to the controller.
+func noCreateConn(t *testing.T, cmdc chan []byte) {
+	for {
+		select {
+		case p := <-cmdc:
+			if int(p[1])|int(p[2])<<8 == 0x200D {
+				t.Fatal("unexpected LE Create Connection")
+			}
+		case <-time.After(100 * time.Millisecond):
+			return
+		}
+	}
+}
+
+func TestConnectQueue(t *testing.T) {
+	f := newFakeController()
+	h, _ := newTestHCI(t, f)
+	defer f.Close()
+	cmdc := make(chan []byte, 16)
+	f.mu.Lock()
+	f.cmdc = cmdc
+	f.mu.Unlock()
+	pdc := make(chan *PlatData, 2)
+	h.AcceptSlaveHandler = func(pd *PlatData) { pdc <- pd }
+	fc := make(chan error, 1)
+	h.ConnectFailedHandler = func(pd *PlatData, err error) { fc <- err }
+	h.SetMaxMasterConnections(1)
+
+	p := ConnParams{0x0018, 0x0028, 4, 0x01F4}
+	pd1 := &PlatData{Address: btaddr.BDAddr{Octets: [6]byte{1, 1, 1, 1, 1, 1}}}
+	pd2 := &PlatData{Address: btaddr.BDAddr{Octets: [6]byte{2, 2, 2, 2, 2, 2}}}
+	pd3 := &PlatData{Address: btaddr.BDAddr{Octets: [6]byte{3, 3, 3, 3, 3, 3}}}
+	for _, pd := range []*PlatData{pd1, pd2, pd3} {
+		if err := h.Connect(pd, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.Connect(pd2, p); err == nil {
+		t.Error("connection queued twice")
+	}
+	if a := createConnAddr(t, cmdc); a != pd1.Address.Octets {
+		t.Errorf("got LE Create Connection to %X, want %X", a, pd1.Address.Octets)
+	}
+	noCreateConn(t, cmdc)
+
+	// The queued connection is canceled.
+	if err := h.CancelConnection(pd3); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-fc:
+		if err != cmd.ErrUnknownConnectionID {
+			t.Errorf("got %v, want %v", err, cmd.ErrUnknownConnectionID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no failure reported")
+	}
+
+	// The next connection waits for the first one to disconnect.
+	f.connectPeer(0x40, 0x00, pd1.Address.Octets)
+	if pd := <-pdc; pd != pd1 {
+		t.Errorf("got connection of %p, want %p", pd, pd1)
+	}
+	if err := h.Connect(pd1, p); err == nil {
+		t.Error("connected twice")
+	}
+	noCreateConn(t, cmdc)
+	f.disconnect(0x40)
+	if a := createConnAddr(t, cmdc); a != pd2.Address.Octets {
+		t.Errorf("got LE Create Connection to %X, want %X", a, pd2.Address.Octets)
+	}
+	f.connectPeer(0x41, 0x00, pd2.Address.Octets)
+	if pd := <-pdc; pd != pd2 {
+		t.Errorf("got connection of %p, want %p", pd, pd2)
+	}
+}
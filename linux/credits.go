@@ -0,0 +1,94 @@
+package linux
+
+import "sync"
+
+// credits accounts for the ACL data packets buffered in the controller.
+// Each packet written to the controller takes a credit from a connection,
+// which is returned when the controller reports it as completed, or when
+// the connection is disconnected with packets still in flight.
+type credits struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	avail    int            // credits not taken by any connection
+	inflight map[uint16]int // credits taken by each live connection
+	closed   bool
+}
+
+func newCredits(n int) *credits {
+	c := &credits{avail: n, inflight: map[uint16]int{}}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// setTotal sets the number of packets the controller can buffer.
+// It is meant to be called before any connection is established.
+func (c *credits) setTotal(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, k := range c.inflight {
+		n -= k
+	}
+	c.avail = n
+	c.cond.Broadcast()
+}
+
+// open starts the accounting of the connection h.
+func (c *credits) open(h uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.inflight[h]; !ok {
+		c.inflight[h] = 0
+	}
+}
+
+// close reclaims all the credits taken by the connection h, and wakes up
+// its blocked writers.
+func (c *credits) close(h uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.avail += c.inflight[h]
+	delete(c.inflight, h)
+	c.cond.Broadcast()
+}
+
+// closeAll wakes up all the blocked writers, and fails the later ones.
+func (c *credits) closeAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	c.cond.Broadcast()
+}
+
+// acquire takes a credit for the connection h, blocking until one is available.
+// It reports false if the connection is, or becomes, disconnected.
+func (c *credits) acquire(h uint16) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		if _, ok := c.inflight[h]; !ok || c.closed {
+			return false
+		}
+		if c.avail > 0 {
+			c.avail--
+			c.inflight[h]++
+			return true
+		}
+		c.cond.Wait()
+	}
+}
+
+// release returns n credits of the connection h.
+func (c *credits) release(h uint16, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k, ok := c.inflight[h]
+	if !ok {
+		return // Already reclaimed at disconnection.
+	}
+	if n > k {
+		n = k
+	}
+	c.inflight[h] -= n
+	c.avail += n
+	c.cond.Broadcast()
+}
@@ -0,0 +1,192 @@
+package linux
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Limits of the Enhanced Credit Based Flow Control Mode.
+// See Core spec Vol 3, Part A, 4.25.
+const (
+	ecfcMinMTU   = 64 // minimum MTU and MPS of the channels
+	ecfcChannels = 5  // maximum channels opened by one request
+)
+
+// Results of the Credit Based Reconfigure Request. See Core spec Vol 3, Part A, 4.28.
+const (
+	reconfSuccess           = 0x0000
+	reconfMTUReduced        = 0x0001 // Reduction in size of MTU not allowed
+	reconfMPSReduced        = 0x0002 // Reduction in size of MPS not allowed for more than one channel
+	reconfInvalidCID        = 0x0003 // One or more Destination CIDs invalid
+	reconfUnacceptableParam = 0x0004 // Unacceptable parameters
+)
+
+// OpenChannels opens up to n channels, in Enhanced Credit Based Flow Control
+// Mode, to the PSM of the remote device of the connection hh. The remote device
+// may refuse some of the channels, in which case the channels it accepted are
+// returned without error.
+func (h *HCI) OpenChannels(hh uint16, psm uint16, n int) ([]*Channel, error) {
+	if psm == 0 || psm > 0x00FF {
+		return nil, fmt.Errorf("l2conn: invalid SPSM 0x%04X", psm)
+	}
+	if n < 1 || n > ecfcChannels {
+		return nil, fmt.Errorf("l2conn: can't open %d channels in one request", n)
+	}
+	c, err := h.conn(hh)
+	if err != nil {
+		return nil, err
+	}
+	var chs []*Channel
+	removeAll := func() {
+		for _, ch := range chs {
+			c.removeChannel(ch.scid)
+		}
+	}
+	v := []uint16{psm, cocMTU, cocMPS, cocCredits}
+	for i := 0; i < n; i++ {
+		ch, err := c.newChannel(psm, true)
+		if err != nil {
+			removeAll()
+			return nil, err
+		}
+		chs = append(chs, ch)
+		v = append(v, ch.scid)
+	}
+
+	rsp, err := c.request(sigCreditConnRequest, le16s(v...))
+	if err != nil {
+		removeAll()
+		return nil, err
+	}
+	if rsp.code == sigCommandReject {
+		removeAll()
+		return nil, errors.New("l2conn: enhanced credit based channels not supported by the remote device")
+	}
+	if len(rsp.data) < 8+2*n {
+		removeAll()
+		return nil, errors.New("l2conn: malformed credit based connection response")
+	}
+	v = unle16s(rsp.data, 4+n)
+	mtu, mps, credits, result, dcids := v[0], v[1], v[2], v[3], v[4:]
+
+	var opened []*Channel
+	for i, ch := range chs {
+		if dcids[i] == 0x0000 {
+			c.removeChannel(ch.scid)
+			continue
+		}
+		ch.mu.Lock()
+		ch.dcid, ch.mtu, ch.mps, ch.credits = dcids[i], mtu, mps, int(credits)
+		ch.mu.Unlock()
+		opened = append(opened, ch)
+	}
+	if len(opened) == 0 {
+		if result == 0x0000 {
+			return nil, errors.New("l2conn: malformed credit based connection response")
+		}
+		return nil, ChannelError(result)
+	}
+	if mtu < ecfcMinMTU || mps < ecfcMinMTU || mps > 65533 {
+		for _, ch := range opened {
+			ch.Close()
+		}
+		return nil, errors.New("l2conn: invalid credit based connection response")
+	}
+	return opened, nil
+}
+
+func (c *conn) handleCreditConnRequest(s *signal) *signal {
+	if len(s.data) < 10 || len(s.data)%2 != 0 || len(s.data) > 8+2*ecfcChannels {
+		return reject(s, rejectNotUnderstood)
+	}
+	n := (len(s.data) - 8) / 2
+	v := unle16s(s.data, 4+n)
+	psm, mtu, mps, credits, scids := v[0], v[1], v[2], v[3], v[4:]
+	refuse := func(e ChannelError) *signal {
+		return &signal{code: sigCreditConnResponse, id: s.id, data: le16s(append([]uint16{0, 0, 0, uint16(e)}, make([]uint16, n)...)...)}
+	}
+	l := c.hci.listener(psm)
+	switch {
+	case l == nil:
+		return refuse(ErrPSMNotSupported)
+	case l.encrypt && !c.smp.security().Encrypted:
+		return refuse(ErrInsufficientEncryption)
+	case mtu < ecfcMinMTU || mps < ecfcMinMTU || mps > 65533:
+		return refuse(ErrUnacceptableParameters)
+	}
+
+	// Each of the channels is accepted, or refused, on its own.
+	result := uint16(0x0000)
+	dcids := make([]uint16, n)
+	var chs []*Channel
+	for i, scid := range scids {
+		if scid < cidDynamicMin || scid > cidDynamicMax {
+			result = uint16(ErrInvalidSourceCID)
+			continue
+		}
+		if c.remoteChannel(scid) != nil {
+			result = uint16(ErrSourceCIDAlreadyAssigned)
+			continue
+		}
+		ch, err := c.newChannel(psm, true)
+		if err != nil {
+			result = uint16(ErrNoResources)
+			continue
+		}
+		ch.mu.Lock()
+		ch.dcid, ch.mtu, ch.mps, ch.credits = scid, mtu, mps, int(credits)
+		ch.mu.Unlock()
+		dcids[i] = ch.scid
+		chs = append(chs, ch)
+	}
+
+	c.sendSignal(&signal{code: sigCreditConnResponse, id: s.id, data: le16s(append([]uint16{cocMTU, cocMPS, cocCredits, result}, dcids...)...)})
+	for _, ch := range chs {
+		if !l.offer(ch) {
+			ch.Close()
+		}
+	}
+	return nil
+}
+
+// handleCreditReconfRequest updates the MTU and MPS the remote device
+// receives on its Enhanced Credit Based channels.
+func (c *conn) handleCreditReconfRequest(s *signal) *signal {
+	if len(s.data) < 6 || len(s.data)%2 != 0 {
+		return reject(s, rejectNotUnderstood)
+	}
+	n := (len(s.data) - 4) / 2
+	v := unle16s(s.data, 2+n)
+	mtu, mps, cids := v[0], v[1], v[2:]
+	respond := func(result uint16) *signal {
+		return &signal{code: sigCreditReconfResponse, id: s.id, data: le16s(result)}
+	}
+	if mtu < ecfcMinMTU || mps < ecfcMinMTU || mps > 65533 {
+		return respond(reconfUnacceptableParam)
+	}
+	var chs []*Channel
+	for _, cid := range cids {
+		ch := c.remoteChannel(cid) // the CIDs of the remote device
+		if ch == nil || !ch.enhanced {
+			return respond(reconfInvalidCID)
+		}
+		chs = append(chs, ch)
+	}
+	for _, ch := range chs {
+		ch.mu.Lock()
+		mtuReduced, mpsReduced := mtu < ch.mtu, mps < ch.mps
+		ch.mu.Unlock()
+		switch {
+		case mtuReduced:
+			return respond(reconfMTUReduced)
+		case mpsReduced && len(chs) > 1:
+			return respond(reconfMPSReduced)
+		}
+	}
+	for _, ch := range chs {
+		ch.mu.Lock()
+		ch.mtu, ch.mps = mtu, mps
+		ch.mu.Unlock()
+	}
+	return respond(reconfSuccess)
+}
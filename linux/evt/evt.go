@@ -134,6 +134,9 @@ const (
 	LEReadRemoteUsedFeaturesComplete               = 0x04 // LE Read Remote Used Features Complete
 	LELTKRequest                                   = 0x05 // LE LTK Request
 	LERemoteConnectionParameterRequest             = 0x06 // LE Remote Connection Parameter Request
+	LEDataLengthChange                             = 0x07 // LE Data Length Change
+	LEEnhancedConnectionComplete                   = 0x0A // LE Enhanced Connection Complete
+	LEPHYUpdateComplete                            = 0x0C // LE PHY Update Complete
 )
 
 type EventHeader struct {
@@ -198,6 +201,25 @@ func (e *DisconnectionCompleteEP) Unmarshal(b []byte) error {
 	return binary.Read(buf, binary.LittleEndian, &e.Reason)
 }
 
+type EncryptionChangeEP struct {
+	Status            uint8
+	ConnectionHandle  uint16
+	EncryptionEnabled uint8
+}
+
+func (e *EncryptionChangeEP) Unmarshal(b []byte) error {
+	return binary.Read(bytes.NewBuffer(b), binary.LittleEndian, e)
+}
+
+type EncryptionKeyRefreshCompleteEP struct {
+	Status           uint8
+	ConnectionHandle uint16
+}
+
+func (e *EncryptionKeyRefreshCompleteEP) Unmarshal(b []byte) error {
+	return binary.Read(bytes.NewBuffer(b), binary.LittleEndian, e)
+}
+
 type CommandCompleteEP struct {
 	NumHCICommandPackets uint8
 	CommandOPCode        uint16
@@ -268,6 +290,9 @@ type LEConnectionCompleteEP struct {
 }
 
 func (e *LEConnectionCompleteEP) Unmarshal(b []byte) error {
+	if len(b) < 19 {
+		return errors.New("malformed LE Connection Complete")
+	}
 	e.SubeventCode = o.Uint8(b[0:])
 	e.Status = o.Uint8(b[1:])
 	e.ConnectionHandle = o.Uint16(b[2:])
@@ -277,7 +302,41 @@ func (e *LEConnectionCompleteEP) Unmarshal(b []byte) error {
 	e.ConnInterval = o.Uint16(b[12:])
 	e.ConnLatency = o.Uint16(b[14:])
 	e.SupervisionTimeout = o.Uint16(b[16:])
-	e.MasterClockAccuracy = o.Uint8(b[17:])
+	e.MasterClockAccuracy = o.Uint8(b[18:])
+	return nil
+}
+
+type LEEnhancedConnectionCompleteEP struct {
+	SubeventCode                  uint8
+	Status                        uint8
+	ConnectionHandle              uint16
+	Role                          uint8
+	PeerAddressType               uint8
+	PeerAddress                   [6]byte
+	LocalResolvablePrivateAddress [6]byte
+	PeerResolvablePrivateAddress  [6]byte
+	ConnInterval                  uint16
+	ConnLatency                   uint16
+	SupervisionTimeout            uint16
+	MasterClockAccuracy           uint8
+}
+
+func (e *LEEnhancedConnectionCompleteEP) Unmarshal(b []byte) error {
+	if len(b) < 31 {
+		return errors.New("malformed LE Enhanced Connection Complete")
+	}
+	e.SubeventCode = o.Uint8(b[0:])
+	e.Status = o.Uint8(b[1:])
+	e.ConnectionHandle = o.Uint16(b[2:])
+	e.Role = o.Uint8(b[4:])
+	e.PeerAddressType = o.Uint8(b[5:])
+	e.PeerAddress = o.MAC(b[6:])
+	e.LocalResolvablePrivateAddress = o.MAC(b[12:])
+	e.PeerResolvablePrivateAddress = o.MAC(b[18:])
+	e.ConnInterval = o.Uint16(b[24:])
+	e.ConnLatency = o.Uint16(b[26:])
+	e.SupervisionTimeout = o.Uint16(b[28:])
+	e.MasterClockAccuracy = o.Uint8(b[30:])
 	return nil
 }
 
@@ -380,3 +439,28 @@ type LERemoteConnectionParameterRequestEP struct {
 func (e *LERemoteConnectionParameterRequestEP) Unmarshal(b []byte) error {
 	return binary.Read(bytes.NewBuffer(b), binary.LittleEndian, e)
 }
+
+type LEDataLengthChangeEP struct {
+	SubeventCode     uint8
+	ConnectionHandle uint16
+	MaxTxOctets      uint16
+	MaxTxTime        uint16
+	MaxRxOctets      uint16
+	MaxRxTime        uint16
+}
+
+func (e *LEDataLengthChangeEP) Unmarshal(b []byte) error {
+	return binary.Read(bytes.NewBuffer(b), binary.LittleEndian, e)
+}
+
+type LEPHYUpdateCompleteEP struct {
+	SubeventCode     uint8
+	Status           uint8
+	ConnectionHandle uint16
+	TxPHY            uint8
+	RxPHY            uint8
+}
+
+func (e *LEPHYUpdateCompleteEP) Unmarshal(b []byte) error {
+	return binary.Read(bytes.NewBuffer(b), binary.LittleEndian, e)
+}
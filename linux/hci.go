@@ -1,11 +1,14 @@
 package linux
 
 import (
+	"crypto/ecdh"
 	"fmt"
 	"io"
 	"log"
 	"sync"
+	"time"
 
+	"github.com/paypal/gatt/btaddr"
 	"github.com/paypal/gatt/linux/cmd"
 	"github.com/paypal/gatt/linux/evt"
 )
@@ -15,35 +18,119 @@ type HCI struct {
 	AcceptSlaveHandler   func(pd *PlatData)
 	AdvertisementHandler func(pd *PlatData)
 
-	d io.ReadWriteCloser
-	c *cmd.Cmd
-	e *evt.Evt
+	// ConnectFailedHandler is called when a connection initiated by Connect
+	// or AutoConnect fails to be established, or is canceled.
+	ConnectFailedHandler func(pd *PlatData, err error)
+
+	// LinkUpdatedHandler is called when the data length or the PHY of a
+	// connection changes, or fails to change.
+	LinkUpdatedHandler func(pd *PlatData, l LinkState, err error)
+
+	// ConnParamsRequestHandler is called when the remote device requests new
+	// parameters for a connection, and reports if they are accepted.
+	// Parameters out of the ranges of the spec are always rejected; the
+	// others are accepted if it is nil.
+	ConnParamsRequestHandler func(pd *PlatData, p ConnParams) bool
+
+	// PairingRequestHandler is called when the remote device requests a
+	// pairing, and reports if it is accepted. The pairings are accepted if
+	// it is nil.
+	PairingRequestHandler func(pd *PlatData) bool
+
+	// PairedHandler is called when a pairing completes, or fails.
+	PairedHandler func(pd *PlatData, b *Bond, err error)
+
+	// EncryptedHandler is called when the link of a connection is
+	// encrypted, or encrypted again, with the security s.
+	EncryptedHandler func(pd *PlatData, s Security)
+
+	// PasskeyDisplayHandler is called to display the passkey of a Passkey
+	// Entry pairing, which the user inputs on the remote device.
+	PasskeyDisplayHandler func(pd *PlatData, passkey uint32)
+
+	// PasskeyRequestHandler is called to input the passkey of a Passkey
+	// Entry pairing, which is displayed by the remote device.
+	PasskeyRequestHandler func(pd *PlatData) (uint32, error)
+
+	// ConfirmPasskeyHandler is called to confirm that the passkey of a
+	// Numeric Comparison pairing matches the one displayed by the remote
+	// device.
+	ConfirmPasskeyHandler func(pd *PlatData, passkey uint32) bool
+
+	// OOBDataHandler returns the OOB data of the remote device for LE
+	// legacy pairing, the TK, received out of band, or nil if there is none.
+	OOBDataHandler func(pd *PlatData) []byte
+
+	// SCOOBDataHandler returns the OOB data of the remote device for LE
+	// Secure Connections pairing, its random value and confirm value,
+	// received out of band, or nil if there is none.
+	SCOOBDataHandler func(pd *PlatData) (r, c []byte)
+
+	// BondHandler returns the bond with the remote device of a connection,
+	// or nil if there is none. Its LTKs are used to encrypt the link without
+	// pairing again.
+	BondHandler func(pd *PlatData) *Bond
+
+	d    io.ReadWriteCloser
+	c    *cmd.Cmd
+	e    *evt.Evt
+	evtc chan []byte // events to be dispatched in order by eventLoop
 
 	plist   map[bdaddr]*PlatData
 	plistmu *sync.Mutex
 
-	bufCnt  chan struct{}
-	bufSize int
+	pool    *credits // ACL data packet credits of the controller
+	bufSize int      // maximum length of ACL data packets of the controller
 
 	maxConn int
 	connsmu *sync.Mutex
 	conns   map[uint16]*conn
 
-	adv   bool
-	advmu *sync.Mutex
+	listenersmu *sync.Mutex
+	listeners   map[uint16]*Listener // connection-oriented channel listeners, by PSM
+
+	addr bdaddr // public address of the controller
+
+	addrmu     *sync.Mutex // protects the following fields
+	privacy    Privacy
+	ownType    uint8                   // type of the address used in advertising, scanning and connections
+	random     bdaddr                  // random address of the controller
+	initiating bool                    // an LE Create Connection is pending
+	connecting *PlatData               // device of the pending LE Create Connection
+	connq      []*connReq              // connections queued, while another is pending
+	masters    int                     // connections of which the local device is the master
+	maxMasters int                     // limit of masters, if non-zero
+	renewt     *time.Timer             // renews the private address
+	scanParams cmd.LESetScanParameters // scanning parameters, with the own address type
+	smpTimeout time.Duration           // time a pairing waits for each command of the remote device
+
+	pairingmu *sync.Mutex // protects the following fields
+	pairing   PairingParams
+	localIRK  []byte
+	sign      bool             // a CSRK is generated and distributed in each pairing
+	oobKey    *ecdh.PrivateKey // key pair of the local OOB data, until used by a pairing
+	oobR      []byte           // random value of the local OOB data
+	oobExp    time.Time        // expiry of the local OOB data
+
+	advmu   *sync.Mutex // protects the following fields
+	adv     bool
+	scan    bool
+	scanDup bool
 }
 
 type bdaddr [6]byte
 
 type PlatData struct {
-	Name        string
-	AddressType uint8
-	Address     [6]byte
-	Data        []byte
-	Connectable bool
-	RSSI        int8
-
-	Conn io.ReadWriteCloser
+	Name         string
+	Address      btaddr.BDAddr
+	Data         []byte // advertising data
+	ScanResponse []byte // scan response data, if scannable
+	Connectable  bool
+	RSSI         int8
+
+	Handle uint16 // connection handle, once connected
+	Conn   io.ReadWriteCloser
+	Reason uint8 // reason of the disconnection, once disconnected
 }
 
 func NewHCI(devID int, chk bool, maxConn int) (*HCI, error) {
@@ -51,42 +138,92 @@ func NewHCI(devID int, chk bool, maxConn int) (*HCI, error) {
 	if err != nil {
 		return nil, err
 	}
+	return newHCI(d, maxConn), nil
+}
+
+func newHCI(d io.ReadWriteCloser, maxConn int) *HCI {
 	c := cmd.NewCmd(d)
 	e := evt.NewEvt()
 
 	h := &HCI{
-		d: d,
-		c: c,
-		e: e,
+		d:    d,
+		c:    c,
+		e:    e,
+		evtc: make(chan []byte, 64),
 
 		plist:   make(map[bdaddr]*PlatData),
 		plistmu: &sync.Mutex{},
 
-		bufCnt:  make(chan struct{}, 15-1),
+		// Conservative defaults, until the controller reports its own.
+		pool:    newCredits(1),
 		bufSize: 27,
 
 		maxConn: maxConn,
 		connsmu: &sync.Mutex{},
 		conns:   map[uint16]*conn{},
 
-		advmu: &sync.Mutex{},
+		listenersmu: &sync.Mutex{},
+		listeners:   map[uint16]*Listener{},
+
+		smpTimeout: smpTimeout,
+		pairingmu:  &sync.Mutex{},
+		pairing:    DefaultPairingParams,
+
+		addrmu:     &sync.Mutex{},
+		scanParams: defaultScanParams,
+		advmu:      &sync.Mutex{},
 	}
 
 	e.HandleEvent(evt.LEMeta, evt.HandlerFunc(h.handleLEMeta))
 	e.HandleEvent(evt.DisconnectionComplete, evt.HandlerFunc(h.handleDisconnectionComplete))
+	e.HandleEvent(evt.EncryptionChange, evt.HandlerFunc(h.handleEncryptionChange))
+	e.HandleEvent(evt.EncryptionKeyRefreshComplete, evt.HandlerFunc(h.handleEncryptionKeyRefresh))
 	e.HandleEvent(evt.NumberOfCompletedPkts, evt.HandlerFunc(h.handleNumberOfCompletedPkts))
 	e.HandleEvent(evt.CommandComplete, evt.HandlerFunc(c.HandleComplete))
 	e.HandleEvent(evt.CommandStatus, evt.HandlerFunc(c.HandleStatus))
 
 	go h.mainLoop()
+	go h.eventLoop()
 	h.resetDevice()
-	return h, nil
+	h.readBufferSize()
+	h.readBDADDR()
+	return h
 }
 
+// Close disconnects all the connections, fails all the pending HCI
+// commands, and closes the HCI device.
 func (h *HCI) Close() error {
+	h.connsmu.Lock()
+	cs := make([]*conn, 0, len(h.conns))
 	for _, c := range h.conns {
+		cs = append(cs, c)
+	}
+	h.connsmu.Unlock()
+	for _, c := range cs {
 		c.Close()
 	}
+	h.addrmu.Lock()
+	if h.renewt != nil {
+		h.renewt.Stop()
+	}
+	// The pending and queued connections fail, so that they are all
+	// reported.
+	var pp []*PlatData
+	if h.connecting != nil {
+		pp = append(pp, h.connecting)
+	}
+	for _, r := range h.connq {
+		pp = append(pp, r.pd)
+	}
+	h.connecting, h.connq = nil, nil
+	h.addrmu.Unlock()
+	if h.ConnectFailedHandler != nil {
+		for _, pd := range pp {
+			go h.ConnectFailedHandler(pd, errHCIClosed)
+		}
+	}
+	h.c.Close()
+	h.pool.closeAll()
 	return h.d.Close()
 }
 
@@ -111,7 +248,7 @@ func (h *HCI) setAdvertiseEnable(en bool) error {
 
 func (h *HCI) SendCmdWithAdvOff(c cmd.CmdParam) error {
 	h.setAdvertiseEnable(false)
-	err := h.c.SendAndCheckResp(c, nil)
+	err := h.c.SendAndDecode(c, nil)
 	if h.adv {
 		h.setAdvertiseEnable(true)
 	}
@@ -119,6 +256,13 @@ func (h *HCI) SendCmdWithAdvOff(c cmd.CmdParam) error {
 }
 
 func (h *HCI) SetScanEnable(en bool, dup bool) error {
+	h.advmu.Lock()
+	h.scan, h.scanDup = en, dup
+	h.advmu.Unlock()
+	return h.setScanEnable(en, dup)
+}
+
+func (h *HCI) setScanEnable(en bool, dup bool) error {
 	return h.c.SendAndCheckResp(
 		cmd.LESetScanEnable{
 			LEScanEnable:     btoi(en),
@@ -126,29 +270,6 @@ func (h *HCI) SetScanEnable(en bool, dup bool) error {
 		}, []byte{0x00})
 }
 
-func (h *HCI) Connect(pd *PlatData) error {
-	h.c.Send(
-		cmd.LECreateConn{
-			LEScanInterval:        0x0004,         // N x 0.625ms
-			LEScanWindow:          0x0004,         // N x 0.625ms
-			InitiatorFilterPolicy: 0x00,           // white list not used
-			PeerAddressType:       pd.AddressType, // public or random
-			PeerAddress:           pd.Address,     //
-			OwnAddressType:        0x00,           // public
-			ConnIntervalMin:       0x0006,         // N x 0.125ms
-			ConnIntervalMax:       0x0006,         // N x 0.125ms
-			ConnLatency:           0x0000,         //
-			SupervisionTimeout:    0x000A,         // N x 10ms
-			MinimumCELength:       0x0000,         // N x 0.625ms
-			MaximumCELength:       0x0000,         // N x 0.625ms
-		})
-	return nil
-}
-
-func (h *HCI) CancelConnection(pd *PlatData) error {
-	return pd.Conn.Close()
-}
-
 func (h *HCI) SendRawCommand(c cmd.CmdParam) ([]byte, error) {
 	return h.c.Send(c)
 }
@@ -161,6 +282,7 @@ func btoi(b bool) uint8 {
 }
 
 func (h *HCI) mainLoop() {
+	defer close(h.evtc)
 	b := make([]byte, 4096)
 	for {
 		n, err := h.d.Read(b)
@@ -188,12 +310,15 @@ func (h *HCI) handlePacket(b []byte) {
 	case typSCODataPkt:
 		err = fmt.Errorf("SCO packet not supported")
 	case typEventPkt:
-		go func() {
-			err := h.e.Dispatch(b)
-			if err != nil {
-				log.Printf("hci: %s, [ % X]", err, b)
-			}
-		}()
+		// Command and flow control events are dispatched right away, so
+		// the other event handlers can wait for commands to complete.
+		// The rest are dispatched in the order they arrive.
+		switch b[0] {
+		case evt.CommandComplete, evt.CommandStatus, evt.NumberOfCompletedPkts:
+			err = h.e.Dispatch(b)
+		default:
+			h.evtc <- b
+		}
 	case typVendorPkt:
 		err = fmt.Errorf("Vendor packet not supported")
 	default:
@@ -204,11 +329,30 @@ func (h *HCI) handlePacket(b []byte) {
 	}
 }
 
+// eventLoop dispatches the events queued by mainLoop. Its handlers must not
+// wait for anything other than commands; slow work is done in goroutines.
+func (h *HCI) eventLoop() {
+	for b := range h.evtc {
+		if err := h.e.Dispatch(b); err != nil {
+			log.Printf("hci: %s, [ % X]", err, b)
+		}
+	}
+}
+
+// defaultScanParams are the scanning parameters set on reset.
+var defaultScanParams = cmd.LESetScanParameters{
+	LEScanType:           0x01,   // [0x00]: passive, 0x01: active
+	LEScanInterval:       0x0010, // [0x10]: 0.625ms * 16
+	LEScanWindow:         0x0010, // [0x10]: 0.625ms * 16
+	OwnAddressType:       0x00,   // [0x00]: public, 0x01: random
+	ScanningFilterPolicy: 0x00,   // [0x00]: accept all, 0x01: ignore non-white-listed.
+}
+
 func (h *HCI) resetDevice() error {
 	seq := []cmd.CmdParam{
 		cmd.Reset{},
 		cmd.SetEventMask{EventMask: 0x3dbff807fffbffff},
-		cmd.LESetEventMask{LEEventMask: 0x000000000000001F},
+		cmd.LESetEventMask{LEEventMask: 0x0000000000000A7F},
 		cmd.WriteSimplePairingMode{SimplePairingMode: 1},
 		cmd.WriteLEHostSupported{LESupportedHost: 1, SimultaneousLEHost: 0},
 		cmd.WriteInquiryMode{InquiryMode: 2},
@@ -222,13 +366,7 @@ func (h *HCI) resetDevice() error {
 			HostSynchronousDataPacketLength:    0xff,
 			HostTotalNumACLDataPackets:         0x0014,
 			HostTotalNumSynchronousDataPackets: 0x000a},
-		cmd.LESetScanParameters{
-			LEScanType:           0x01,   // [0x00]: passive, 0x01: active
-			LEScanInterval:       0x0010, // [0x10]: 0.625ms * 16
-			LEScanWindow:         0x0010, // [0x10]: 0.625ms * 16
-			OwnAddressType:       0x00,   // [0x00]: public, 0x01: random
-			ScanningFilterPolicy: 0x00,   // [0x00]: accept all, 0x01: ignore non-white-listed.
-		},
+		defaultScanParams,
 	}
 	for _, s := range seq {
 		if err := h.c.SendAndCheckResp(s, []byte{0x00}); err != nil {
@@ -238,6 +376,33 @@ func (h *HCI) resetDevice() error {
 	return nil
 }
 
+// readBufferSize reads the size and the number of the ACL data packets that
+// the controller can buffer. LE Read Buffer Size reports zero if the
+// controller shares its buffers between LE and BR/EDR, in which case
+// Read Buffer Size is used instead.
+func (h *HCI) readBufferSize() error {
+	var size, cnt int
+	le := &cmd.LEReadBufferSizeRP{}
+	if err := h.c.SendAndDecode(cmd.LEReadBufferSize{}, le); err != nil {
+		log.Printf("hci: failed to read LE buffer size, %s", err)
+	}
+	size, cnt = int(le.HCLEACLDataPacketLength), int(le.HCTotalNumLEACLDataPackets)
+	if size == 0 || cnt == 0 {
+		rp := &cmd.ReadBufferSizeRP{}
+		if err := h.c.SendAndDecode(cmd.ReadBufferSize{}, rp); err != nil {
+			log.Printf("hci: failed to read buffer size, %s", err)
+			return err
+		}
+		size, cnt = int(rp.HCACLDataPacketLength), int(rp.HCTotalNumACLDataPackets)
+	}
+	if size == 0 || cnt == 0 {
+		return fmt.Errorf("hci: invalid buffer size %d x %d", size, cnt)
+	}
+	h.bufSize = size
+	h.pool.setTotal(cnt)
+	return nil
+}
+
 func (h *HCI) handleAdvertisement(b []byte) {
 	// If no one is interested, don't bother.
 	if h.AdvertisementHandler == nil {
@@ -258,15 +423,14 @@ func (h *HCI) handleAdvertisement(b []byte) {
 			pd, ok := h.plist[addr]
 			h.plistmu.Unlock()
 			if ok {
-				pd.Data = append(pd.Data, ep.Data[i]...)
+				pd.ScanResponse = ep.Data[i]
 				h.AdvertisementHandler(pd)
 			}
 			continue
 		}
 
 		pd := &PlatData{
-			AddressType: ep.AddressType[i],
-			Address:     ep.Address[i],
+			Address:     btaddr.New(ep.Address[i], ep.AddressType[i]),
 			Data:        ep.Data[i],
 			Connectable: connectable,
 			RSSI:        ep.RSSI[i],
@@ -287,9 +451,7 @@ func (h *HCI) handleNumberOfCompletedPkts(b []byte) error {
 		return err
 	}
 	for _, r := range ep.Packets {
-		for i := 0; i < int(r.NumOfCompletedPkts); i++ {
-			<-h.bufCnt
-		}
+		h.pool.release(r.ConnectionHandle, int(r.NumOfCompletedPkts))
 	}
 	return nil
 }
@@ -299,32 +461,124 @@ func (h *HCI) handleConnection(b []byte) {
 	if err := ep.Unmarshal(b); err != nil {
 		return // FIXME
 	}
+	h.addConn(ep, bdaddr{}, bdaddr{})
+}
+
+// handleEnhancedConnection handles the connections of the controller
+// resolving the private addresses. The peer address of the event is the
+// identity address of a peer in the resolving list, while the private
+// addresses, if non-zero, are those used in the connection.
+func (h *HCI) handleEnhancedConnection(b []byte) {
+	ee := &evt.LEEnhancedConnectionCompleteEP{}
+	if err := ee.Unmarshal(b); err != nil {
+		return
+	}
+	ep := &evt.LEConnectionCompleteEP{
+		SubeventCode:        ee.SubeventCode,
+		Status:              ee.Status,
+		ConnectionHandle:    ee.ConnectionHandle,
+		Role:                ee.Role,
+		PeerAddressType:     ee.PeerAddressType & 0x01, // 0x02, 0x03: resolved identity address
+		PeerAddress:         ee.PeerAddress,
+		ConnInterval:        ee.ConnInterval,
+		ConnLatency:         ee.ConnLatency,
+		SupervisionTimeout:  ee.SupervisionTimeout,
+		MasterClockAccuracy: ee.MasterClockAccuracy,
+	}
+	h.addConn(ep, ee.LocalResolvablePrivateAddress, ee.PeerResolvablePrivateAddress)
+}
+
+// addConn sets up the connection of ep. The addresses used in the connection,
+// by the pairings, are the private addresses resolved by the controller, if
+// non-zero.
+func (h *HCI) addConn(ep *evt.LEConnectionCompleteEP, localRPA, peerRPA bdaddr) {
+	if ep.Status != 0x00 {
+		h.connectFailed(cmd.Error(ep.Status))
+		return
+	}
 	hh := ep.ConnectionHandle
 	c := newConn(h, hh)
+	c.master = ep.Role == 0x00
+	c.peerType, c.peer = ep.PeerAddressType, ep.PeerAddress
+	if peerRPA != (bdaddr{}) {
+		c.peerType, c.peer = 0x01, peerRPA
+	}
+	h.addrmu.Lock()
+	c.localType, c.local = h.ownAddr()
+	if localRPA != (bdaddr{}) {
+		c.localType, c.local = 0x01, localRPA
+	}
+	var pending *PlatData
+	if c.master {
+		pending, h.connecting = h.connecting, nil
+		h.initiating = false
+		h.masters++
+	}
+	h.addrmu.Unlock()
+	if c.master {
+		go h.nextConn()
+	}
+	c.link.Interval = ep.ConnInterval
+	c.link.Latency = ep.ConnLatency
+	c.link.Timeout = ep.SupervisionTimeout
 	h.connsmu.Lock()
 	h.conns[hh] = c
 	h.connsmu.Unlock()
-	h.setAdvertiseEnable(true)
+	h.pool.open(hh)
+	go c.smp.loop()
+	go h.acceptConnection(ep, c, pending)
+}
 
-	// FIXME: sloppiness. This call should be called by the package user once we
-	// flesh out the support of l2cap signaling packets (CID:0x0001,0x0005)
-	if ep.ConnLatency != 0 || ep.ConnInterval > 0x18 {
-		c.updateConnection()
+// connectFailed reports the failure of the pending connection, unless it is
+// the directed advertising which timed out.
+func (h *HCI) connectFailed(err cmd.Error) {
+	if err == cmd.ErrAdvertisingTimeout {
+		return
 	}
+	h.addrmu.Lock()
+	pd := h.connecting
+	h.connecting, h.initiating = nil, false
+	h.addrmu.Unlock()
+	if pd != nil && h.ConnectFailedHandler != nil {
+		go h.ConnectFailedHandler(pd, err)
+	}
+	go h.nextConn()
+}
+
+// acceptConnection reports the connection c. As the master, it is the
+// connection to the device of pending, initiated by Connect or AutoConnect,
+// if not nil.
+func (h *HCI) acceptConnection(ep *evt.LEConnectionCompleteEP, c *conn, pending *PlatData) {
+	h.setAdvertiseEnable(true)
 
 	// master connection
 	if ep.Role == 0x01 {
 		pd := &PlatData{
-			Address: ep.PeerAddress,
+			Address: btaddr.New(ep.PeerAddress, ep.PeerAddressType),
+			Handle:  c.attr,
 			Conn:    c,
 		}
+		c.setPlatData(pd)
 		h.AcceptMasterHandler(pd)
 		return
 	}
 	h.plistmu.Lock()
-	pd := h.plist[ep.PeerAddress]
-	h.plistmu.Unlock()
+	pd := pending
+	if pd == nil {
+		// Each connection has its own PlatData, apart from the scanned ones.
+		pd = &PlatData{}
+		if sd, ok := h.plist[ep.PeerAddress]; ok {
+			*pd = *sd
+		}
+	}
+	if pd.Address.IsZero() {
+		// Connected to a device of the filter accept list.
+		pd.Address = btaddr.New(ep.PeerAddress, ep.PeerAddressType)
+	}
+	pd.Handle = c.attr
 	pd.Conn = c
+	h.plistmu.Unlock()
+	c.setPlatData(pd)
 	h.AcceptSlaveHandler(pd)
 }
 
@@ -335,16 +589,33 @@ func (h *HCI) handleDisconnectionComplete(b []byte) error {
 	}
 	hh := ep.ConnectionHandle
 	h.connsmu.Lock()
-	defer h.connsmu.Unlock()
 	c, found := h.conns[hh]
 	if !found {
+		h.connsmu.Unlock()
 		// should not happen, just be cautious for now.
 		log.Printf("l2conn: disconnecting a disconnected 0x%04X connection", hh)
 		return nil
 	}
 	delete(h.conns, hh)
+	c.mu.Lock()
+	c.reason = ep.Reason
+	if c.pd != nil {
+		c.pd.Reason = ep.Reason
+	}
+	c.mu.Unlock()
 	close(c.aclc)
-	h.setAdvertiseEnable(true)
+	c.closeSignals()
+	c.closeChannels()
+	c.smp.close()
+	h.pool.close(hh)
+	h.connsmu.Unlock()
+	go h.setAdvertiseEnable(true)
+	if c.master {
+		h.addrmu.Lock()
+		h.masters--
+		h.addrmu.Unlock()
+		go h.nextConn()
+	}
 	return nil
 }
 
@@ -352,16 +623,24 @@ func (h *HCI) handleLEMeta(b []byte) error {
 	code := evt.LEEventCode(b[0])
 	switch code {
 	case evt.LEConnectionComplete:
-		go h.handleConnection(b)
+		h.handleConnection(b)
+	case evt.LEEnhancedConnectionComplete:
+		h.handleEnhancedConnection(b)
 	case evt.LEConnectionUpdateComplete:
-		// anything to do here?
+		return h.handleConnectionUpdateComplete(b)
+	case evt.LERemoteConnectionParameterRequest:
+		return h.handleRemoteConnectionParameterRequest(b)
 	case evt.LEAdvertisingReport:
 		go h.handleAdvertisement(b)
+	case evt.LEDataLengthChange:
+		return h.handleDataLengthChange(b)
+	case evt.LEPHYUpdateComplete:
+		return h.handlePHYUpdateComplete(b)
+	case evt.LELTKRequest:
+		return h.handleLTKRequest(b)
 	// case evt.LEReadRemoteUsedFeaturesComplete:
-	// case evt.LELTKRequest:
-	// case evt.LERemoteConnectionParameterRequest:
 	default:
-		return fmt.Errorf("Unhandled LE event: %s, [ % X ]", code, b)
+		return fmt.Errorf("Unhandled LE event: 0x%02X, [ % X ]", int(code), b)
 	}
 	return nil
 }
@@ -379,19 +658,102 @@ func (h *HCI) handleL2CAP(b []byte) error {
 		log.Printf("l2conn: got data for disconnected handle: 0x%04x", a.attr)
 		return nil
 	}
-	if len(a.b) < 4 {
-		log.Printf("l2conn: l2cap packet is too short/corrupt, length is %d", len(a.b))
+
+	// Reassemble the continued l2cap segments, if any.
+	if a.flags&0x1 != 0 {
+		if c.rx == nil {
+			log.Printf("l2conn: got continued segment without a start, length is %d", len(a.b))
+			return nil
+		}
+		c.rx = append(c.rx, a.b...)
+	} else {
+		if c.rx != nil {
+			log.Printf("l2conn: dropped incomplete l2cap packet, length is %d", len(c.rx))
+		}
+		c.rx = a.b
+	}
+	if len(c.rx) < 4 {
 		return nil
 	}
-	cid := uint16(a.b[2]) | (uint16(a.b[3]) << 8)
-	if cid == 5 {
-		c.handleSignal(a)
+	tlen := int(uint16(c.rx[0]) | uint16(c.rx[1])<<8)
+	if len(c.rx) < 4+tlen {
 		return nil
 	}
-	c.aclc <- a
+	b, c.rx = c.rx[:4+tlen], nil
+
+	switch cid := uint16(b[2]) | (uint16(b[3]) << 8); {
+	case cid == cidLESignal:
+		c.handleSignal(b)
+	case cid == cidSMP:
+		c.smp.receive(b[4:])
+	case cid >= cidDynamicMin:
+		c.handleKFrame(cid, b[4:])
+	default:
+		c.aclc <- b
+	}
+	return nil
+}
+
+// handleLTKRequest answers the request of the controller for the key to
+// encrypt a connection with, as slave.
+func (h *HCI) handleLTKRequest(b []byte) error {
+	ep := &evt.LELTKRequestEP{}
+	if err := ep.Unmarshal(b); err != nil {
+		return err
+	}
+	hh := ep.ConnectionHandle
+	c, err := h.conn(hh)
+	if err != nil {
+		return err
+	}
+	ltk := c.smp.ltk(ep.EncryptionDiversifier, ep.RandomNumber)
+	if ltk == nil {
+		return h.c.SendAndDecode(cmd.LELTKNegReply{ConnectionHandle: hh}, nil)
+	}
+	r := cmd.LELTKReply{ConnectionHandle: hh}
+	copy(r.LongTermKey[:], ltk)
+	return h.c.SendAndDecode(r, nil)
+}
+
+func (h *HCI) handleEncryptionChange(b []byte) error {
+	ep := &evt.EncryptionChangeEP{}
+	if err := ep.Unmarshal(b); err != nil {
+		return err
+	}
+	c, err := h.conn(ep.ConnectionHandle)
+	if err != nil {
+		return err
+	}
+	switch {
+	case ep.Status != 0:
+		c.smp.encrypted(cmd.Error(ep.Status))
+	case ep.EncryptionEnabled == 0:
+		c.smp.encrypted(errEncryptionDisabled)
+	default:
+		c.smp.encrypted(nil)
+	}
+	return nil
+}
+
+// handleEncryptionKeyRefresh handles the encryption of a link which is
+// already encrypted, with a new key.
+func (h *HCI) handleEncryptionKeyRefresh(b []byte) error {
+	ep := &evt.EncryptionKeyRefreshCompleteEP{}
+	if err := ep.Unmarshal(b); err != nil {
+		return err
+	}
+	c, err := h.conn(ep.ConnectionHandle)
+	if err != nil {
+		return err
+	}
+	if ep.Status != 0 {
+		c.smp.encrypted(cmd.Error(ep.Status))
+	} else {
+		c.smp.encrypted(nil)
+	}
 	return nil
 }
 
 func (h *HCI) trace(fmt string, v ...interface{}) {
-	log.Printf(fmt, v)
+	log.Printf(fmt, v...)
 }
@@ -0,0 +1,502 @@
+package linux
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/paypal/gatt/btaddr"
+	"github.com/paypal/gatt/linux/cmd"
+)
+
+// fakeController emulates an HCI controller, which completes every command
+// successfully, and records the ACL data packets sent by the host.
+type fakeController struct {
+	rc     chan []byte // packets to the host
+	aclc   chan []byte // ACL data packets from the host
+	closed chan struct{}
+	once   sync.Once
+
+	mu   sync.Mutex
+	rps  map[int][]byte // return parameters of commands, by opcode
+	cmdc chan []byte    // command packets from the host, if not nil
+}
+
+func newFakeController() *fakeController {
+	return &fakeController{
+		rc:     make(chan []byte, 64),
+		aclc:   make(chan []byte, 64),
+		closed: make(chan struct{}),
+		rps:    map[int][]byte{},
+	}
+}
+
+// statusOps are the commands completed by Command Status events.
+var statusOps = map[int]bool{
+	0x0406: true, // Disconnect
+	0x200D: true, // LE Create Connection
+	0x2013: true, // LE Connection Update
+	0x2016: true, // LE Read Remote Used Features
+	0x2019: true, // LE Start Encryption
+	0x2032: true, // LE Set PHY
+}
+
+func (f *fakeController) setRP(op int, rp ...byte) {
+	f.mu.Lock()
+	f.rps[op] = rp
+	f.mu.Unlock()
+}
+
+func (f *fakeController) event(code byte, params ...byte) {
+	f.rc <- append([]byte{0x04, code, byte(len(params))}, params...)
+}
+
+func (f *fakeController) Read(b []byte) (int, error) {
+	select {
+	case p := <-f.rc:
+		return copy(b, p), nil
+	case <-f.closed:
+		return 0, io.EOF
+	}
+}
+
+func (f *fakeController) Write(b []byte) (int, error) {
+	p := make([]byte, len(b))
+	copy(p, b)
+	switch p[0] {
+	case 0x01:
+		op := int(p[1]) | int(p[2])<<8
+		f.mu.Lock()
+		cmdc := f.cmdc
+		f.mu.Unlock()
+		if cmdc != nil {
+			cmdc <- p
+		}
+		if statusOps[op] {
+			f.event(0x0F, 0x00, 0x01, p[1], p[2])
+			break
+		}
+		f.mu.Lock()
+		rp, ok := f.rps[op]
+		f.mu.Unlock()
+		if !ok {
+			rp = []byte{0x00}
+		}
+		f.event(0x0E, append([]byte{0x01, p[1], p[2]}, rp...)...)
+	case 0x02:
+		f.aclc <- p
+	}
+	return len(b), nil
+}
+
+func (f *fakeController) Close() error {
+	f.once.Do(func() { close(f.closed) })
+	return nil
+}
+
+// connect emulates a central connecting to the host.
+func (f *fakeController) connect(hh uint16) { f.connectRole(hh, 0x01) }
+
+// connectRole emulates a connection, where the host has the role specified.
+func (f *fakeController) connectRole(hh uint16, role byte) {
+	f.connectPeer(hh, role, [6]byte{6, 5, 4, 3, 2, 1})
+}
+
+// connectPeer emulates a connection to the remote device of public address a,
+// most significant octet first.
+func (f *fakeController) connectPeer(hh uint16, role byte, a [6]byte) {
+	f.event(0x3E,
+		0x01,                  // LE Connection Complete
+		0x00,                  // Status
+		byte(hh), byte(hh>>8), // Connection Handle
+		role,                               // Role
+		0x00,                               // Peer Address Type
+		a[5], a[4], a[3], a[2], a[1], a[0], // Peer Address
+		0x18, 0x00, // Connection Interval
+		0x00, 0x00, // Connection Latency
+		0xC8, 0x00, // Supervision Timeout
+		0x00) // Master Clock Accuracy
+}
+
+// acl sends an unfragmented l2cap packet to the host.
+func (f *fakeController) acl(hh uint16, cid uint16, b ...byte) {
+	l := len(b)
+	p := []byte{0x02, byte(hh), byte(hh>>8) | 0x20, byte(l + 4), byte((l + 4) >> 8), byte(l), byte(l >> 8), byte(cid), byte(cid >> 8)}
+	f.rc <- append(p, b...)
+}
+
+func (f *fakeController) disconnect(hh uint16) {
+	f.event(0x05, 0x00, byte(hh), byte(hh>>8), 0x13)
+}
+
+func (f *fakeController) completed(hh uint16, n uint16) {
+	f.event(0x13, 0x01, byte(hh), byte(hh>>8), byte(n), byte(n>>8))
+}
+
+func newTestHCI(t *testing.T, f *fakeController) (*HCI, chan *PlatData) {
+	h := newHCI(f, 1)
+	pdc := make(chan *PlatData, 1)
+	h.AcceptMasterHandler = func(pd *PlatData) { pdc <- pd }
+	return h, pdc
+}
+
+func TestReadLEBufferSize(t *testing.T) {
+	f := newFakeController()
+	f.setRP(0x2002, 0x00, 0xFB, 0x00, 0x04) // 4 x 251 bytes
+	h, _ := newTestHCI(t, f)
+	defer h.Close()
+
+	if h.bufSize != 251 {
+		t.Errorf("bufSize: got %d want 251", h.bufSize)
+	}
+	if h.pool.avail != 4 {
+		t.Errorf("credits: got %d want 4", h.pool.avail)
+	}
+}
+
+func TestReadBufferSizeFallback(t *testing.T) {
+	f := newFakeController()
+	f.setRP(0x2002, 0x00, 0x00, 0x00, 0x00)                         // shared with BR/EDR
+	f.setRP(0x1005, 0x00, 0x53, 0x01, 0x40, 0x08, 0x00, 0x00, 0x00) // 8 x 339 bytes
+	h, _ := newTestHCI(t, f)
+	defer h.Close()
+
+	if h.bufSize != 339 {
+		t.Errorf("bufSize: got %d want 339", h.bufSize)
+	}
+	if h.pool.avail != 8 {
+		t.Errorf("credits: got %d want 8", h.pool.avail)
+	}
+}
+
+func TestCreditsReclaimedOnDisconnect(t *testing.T) {
+	f := newFakeController()
+	f.setRP(0x2002, 0x00, 0x1B, 0x00, 0x02) // 2 x 27 bytes
+	h, pdc := newTestHCI(t, f)
+	defer h.Close()
+
+	f.connect(0x40)
+	c := (<-pdc).Conn
+
+	// Take both credits, and leave them in flight.
+	for i := 0; i < 2; i++ {
+		if _, err := c.Write([]byte{0x01}); err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+		<-f.aclc
+	}
+
+	errc := make(chan error)
+	go func() {
+		_, err := c.Write([]byte{0x02})
+		errc <- err
+	}()
+	select {
+	case <-errc:
+		t.Fatalf("Write should block without credits")
+	case <-f.aclc:
+		t.Fatalf("Write sent a packet without credits")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	f.disconnect(0x40)
+	if err := <-errc; err == nil {
+		t.Errorf("blocked Write on a disconnected connection should fail")
+	}
+
+	// The credits of the disconnected connection are available to the next one.
+	f.connect(0x41)
+	c = (<-pdc).Conn
+	for i := 0; i < 2; i++ {
+		go c.Write([]byte{0x03})
+		select {
+		case <-f.aclc:
+		case <-time.After(time.Second):
+			t.Fatalf("credits were not reclaimed at disconnection")
+		}
+	}
+
+	// Completed packets return the credits.
+	f.completed(0x41, 1)
+	go c.Write([]byte{0x04})
+	select {
+	case <-f.aclc:
+	case <-time.After(time.Second):
+		t.Fatalf("credits were not returned by Number Of Completed Packets")
+	}
+}
+
+func TestLinkUpdates(t *testing.T) {
+	f := newFakeController()
+	f.setRP(0x2002, 0x00, 0xFB, 0x00, 0x08) // 8 x 251 bytes
+	f.setRP(0x2022, 0x00, 0x40, 0x00)
+	h, pdc := newTestHCI(t, f)
+	defer h.Close()
+
+	type update struct {
+		l   LinkState
+		err error
+	}
+	upc := make(chan update, 1)
+	h.LinkUpdatedHandler = func(pd *PlatData, l LinkState, err error) { upc <- update{l, err} }
+
+	f.connect(0x40)
+	pd := <-pdc
+	if pd.Handle != 0x40 {
+		t.Fatalf("Handle: got 0x%04X want 0x0040", pd.Handle)
+	}
+
+	// ACL data is fragmented to fit the LL data PDUs of 27 octets by default.
+	frags := func() (lens []int) {
+		if _, err := pd.Conn.Write(make([]byte, 100)); err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+		for n := 0; n < 104; {
+			p := <-f.aclc
+			lens = append(lens, len(p)-5)
+			n += len(p) - 5
+		}
+		f.completed(0x40, uint16(len(lens)))
+		return lens
+	}
+	if got := frags(); len(got) != 4 || got[0] != 27 {
+		t.Errorf("fragments before data length change: got %v", got)
+	}
+
+	if err := h.SetDataLength(0x40, 251); err != nil {
+		t.Fatalf("SetDataLength: %s", err)
+	}
+	f.event(0x3E, 0x07, 0x40, 0x00, 0xFB, 0x00, 0x48, 0x08, 0xFB, 0x00, 0x48, 0x08)
+	up := <-upc
+	if up.err != nil || up.l.MaxTxOctets != 251 || up.l.MaxRxTime != 2120 {
+		t.Errorf("data length change: got %+v", up)
+	}
+	if got := frags(); len(got) != 1 {
+		t.Errorf("fragments after data length change: got %v", got)
+	}
+
+	if err := h.SetPHY(0x40, 0x02, 0x02); err != nil {
+		t.Fatalf("SetPHY: %s", err)
+	}
+	f.event(0x3E, 0x0C, 0x00, 0x40, 0x00, 0x02, 0x02)
+	if up = <-upc; up.err != nil || up.l.TxPHY != PHY2M || up.l.RxPHY != PHY2M {
+		t.Errorf("PHY update: got %+v", up)
+	}
+	f.event(0x3E, 0x0C, 0x1A, 0x40, 0x00, 0x00, 0x00)
+	if up = <-upc; up.err == nil || up.l.TxPHY != PHY2M {
+		t.Errorf("failed PHY update: got %+v", up)
+	}
+
+	if err := h.SetPHY(0x41, 0x02, 0x02); err == nil {
+		t.Errorf("SetPHY on an unknown connection should fail")
+	}
+}
+
+func TestConnParamsCheck(t *testing.T) {
+	for _, tt := range []struct {
+		p  ConnParams
+		ok bool
+	}{
+		{ConnParams{0x0006, 0x0006, 0, 0x000A}, true},
+		{ConnParams{0x0018, 0x0028, 4, 0x01F4}, true},
+		{ConnParams{0x0005, 0x0006, 0, 0x000A}, false},   // interval too short
+		{ConnParams{0x0010, 0x0008, 0, 0x0C80}, false},   // min > max
+		{ConnParams{0x0006, 0x0006, 500, 0x0C80}, false}, // latency too large
+		{ConnParams{0x0050, 0x0050, 0, 0x0014}, false},   // timeout too short for the interval
+	} {
+		if err := tt.p.check(); (err == nil) != tt.ok {
+			t.Errorf("%+v: got %v", tt.p, err)
+		}
+	}
+}
+
+func TestUpdateParametersAsSlave(t *testing.T) {
+	f := newFakeController()
+	h, pdc := newTestHCI(t, f)
+	defer h.Close()
+	upc := make(chan LinkState, 1)
+	h.LinkUpdatedHandler = func(pd *PlatData, l LinkState, err error) { upc <- l }
+
+	f.connect(0x40)
+	<-pdc
+	if l, _ := h.Link(0x40); l.Interval != 0x18 || l.Timeout != 0xC8 {
+		t.Errorf("Link: got %+v", l)
+	}
+
+	// The slave requests the master with the L2CAP signaling channel.
+	p := ConnParams{IntervalMin: 0x0010, IntervalMax: 0x0020, Latency: 0, Timeout: 0x0100}
+	for _, result := range []byte{0x00, 0x01} {
+		errc := make(chan error)
+		go func() { errc <- h.UpdateParameters(0x40, p) }()
+		req := <-f.aclc
+		if req[7] != 0x05 || req[9] != 0x12 {
+			t.Fatalf("got [ % X ], want a Connection Parameter Update request", req)
+		}
+		if got := req[13:21]; got[0] != 0x10 || got[2] != 0x20 || got[6] != 0x00 || got[7] != 0x01 {
+			t.Errorf("request parameters: got [ % X ]", got)
+		}
+		f.completed(0x40, 1)
+		f.acl(0x40, 0x05, 0x13, req[10], 0x02, 0x00, result, 0x00)
+		if err := <-errc; (err == nil) != (result == 0x00) {
+			t.Errorf("result 0x%02X: got %v", result, err)
+		}
+	}
+
+	// The master reports the parameters in effect.
+	f.event(0x3E, 0x03, 0x00, 0x40, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x01)
+	if l := <-upc; l.Interval != 0x20 || l.Timeout != 0x100 {
+		t.Errorf("connection update complete: got %+v", l)
+	}
+
+	if err := h.UpdateParameters(0x40, ConnParams{}); err == nil {
+		t.Errorf("UpdateParameters with invalid parameters should fail")
+	}
+}
+
+func TestRemoteConnectionParameterRequest(t *testing.T) {
+	f := newFakeController()
+	f.setRP(0x2020, 0x00, 0x40, 0x00)
+	f.setRP(0x2021, 0x00, 0x40, 0x00)
+	h, pdc := newTestHCI(t, f)
+	defer h.Close()
+	f.connect(0x40)
+	<-pdc
+
+	f.mu.Lock()
+	f.cmdc = make(chan []byte, 1)
+	f.mu.Unlock()
+	for _, tt := range []struct {
+		params []byte
+		op     byte
+	}{
+		{[]byte{0x10, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x01}, 0x20}, // accepted
+		{[]byte{0x10, 0x00, 0x20, 0x00, 0x00, 0x00, 0x01, 0x00}, 0x21}, // timeout too short
+	} {
+		f.event(0x3E, append([]byte{0x06, 0x40, 0x00}, tt.params...)...)
+		if c := <-f.cmdc; c[1] != tt.op || c[2] != 0x20 {
+			t.Errorf("params [ % X ]: got command [ % X ]", tt.params, c)
+		}
+	}
+}
+
+func TestCancelConnection(t *testing.T) {
+	f := newFakeController()
+	h, _ := newTestHCI(t, f)
+	defer f.Close()
+	type failure struct {
+		pd  *PlatData
+		err error
+	}
+	fc := make(chan failure, 1)
+	h.ConnectFailedHandler = func(pd *PlatData, err error) { fc <- failure{pd, err} }
+	pdc := make(chan *PlatData, 1)
+	h.AcceptSlaveHandler = func(pd *PlatData) { pdc <- pd }
+	cmdc := make(chan []byte, 16)
+	f.mu.Lock()
+	f.cmdc = cmdc
+	f.mu.Unlock()
+
+	p := ConnParams{0x0018, 0x0028, 4, 0x01F4}
+	pd := &PlatData{Address: btaddr.BDAddr{Octets: [6]byte{1, 2, 3, 4, 5, 6}}}
+	if err := h.Connect(pd, p); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.CancelConnection(pd); err != nil {
+		t.Fatal(err)
+	}
+	nextCmd(t, cmdc, 0x200E)
+	f.event(0x3E, append([]byte{0x01, byte(cmd.ErrUnknownConnectionID)}, make([]byte, 17)...)...)
+	select {
+	case r := <-fc:
+		if r.pd != pd || r.err != cmd.ErrUnknownConnectionID {
+			t.Errorf("got failure %v of %p, want %v of %p", r.err, r.pd, cmd.ErrUnknownConnectionID, pd)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no failure reported")
+	}
+
+	// The connection established is reported with the PlatData connected.
+	if err := h.Connect(pd, p); err != nil {
+		t.Fatal(err)
+	}
+	f.connectPeer(0x40, 0x00, pd.Address.Octets)
+	select {
+	case got := <-pdc:
+		if got != pd || got.Conn == nil {
+			t.Errorf("got connection of %p, want %p", got, pd)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no connection")
+	}
+}
+
+// TestCancelConnectionRace cancels connections as they complete, or as h is
+// closed; each connection is reported once either way.
+func TestCancelConnectionRace(t *testing.T) {
+	f := newFakeController()
+	h, _ := newTestHCI(t, f)
+	fc := make(chan error, 2)
+	h.ConnectFailedHandler = func(pd *PlatData, err error) { fc <- err }
+	pdc := make(chan *PlatData, 2)
+	h.AcceptSlaveHandler = func(pd *PlatData) { pdc <- pd }
+	// The controller rejects the cancellation of a connection which
+	// completed already.
+	f.setRP(0x200E, byte(cmd.ErrCommandDisallowed))
+
+	p := ConnParams{0x0018, 0x0028, 4, 0x01F4}
+	pd := &PlatData{Address: btaddr.BDAddr{Octets: [6]byte{1, 2, 3, 4, 5, 6}}}
+	if err := h.Connect(pd, p); err != nil {
+		t.Fatal(err)
+	}
+	f.connectPeer(0x40, 0x00, pd.Address.Octets)
+	h.CancelConnection(pd)
+	select {
+	case got := <-pdc:
+		if got != pd {
+			t.Errorf("got connection of %p, want %p", got, pd)
+		}
+	case err := <-fc:
+		t.Fatalf("got failure %v, want the connection", err)
+	case <-time.After(time.Second):
+		t.Fatal("connection not reported")
+	}
+	if err := h.CancelConnection(pd); err != nil {
+		t.Errorf("CancelConnection of the connection: %s", err)
+	}
+
+	// The connection pending, and the one queued, fail once h is closed.
+	pd1 := &PlatData{Address: btaddr.BDAddr{Octets: [6]byte{1, 2, 3, 4, 5, 7}}}
+	pd2 := &PlatData{Address: btaddr.BDAddr{Octets: [6]byte{1, 2, 3, 4, 5, 8}}}
+	for _, pd := range []*PlatData{pd1, pd2} {
+		if err := h.Connect(pd, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h.Close()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-fc:
+			if err != errHCIClosed {
+				t.Errorf("got failure %v, want %v", err, errHCIClosed)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("connection not reported once closed")
+		}
+	}
+}
+
+func TestDisconnectReason(t *testing.T) {
+	f := newFakeController()
+	_, pdc := newTestHCI(t, f)
+	defer f.Close()
+	f.connect(0x40)
+	pd := <-pdc
+	f.disconnect(0x40)
+	if _, err := pd.Conn.Read(make([]byte, 32)); err != io.EOF {
+		t.Fatalf("got %v, want EOF", err)
+	}
+	if pd.Reason != 0x13 {
+		t.Errorf("got reason 0x%02X, want remote user terminated", pd.Reason)
+	}
+}
@@ -1,9 +1,10 @@
 package linux
 
 import (
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"sync"
 
 	"github.com/paypal/gatt/linux/cmd"
 )
@@ -30,35 +31,81 @@ func (a *aclData) unmarshal(b []byte) error {
 	return nil
 }
 
+var errConnClosed = errors.New("l2conn: connection closed")
+
 type conn struct {
 	hci  *HCI
 	attr uint16
-	aclc chan *aclData
+	aclc chan []byte // l2cap packets of the fixed channels, other than signaling
+	wmu  *sync.Mutex // serializes the fragments of the outgoing l2cap packets
+	rx   []byte      // l2cap packet being reassembled, only accessed by mainLoop
+
+	master    bool   // local device is the master of the connection
+	peerType  uint8  // address type of the remote device
+	peer      bdaddr // address of the remote device
+	localType uint8  // address type of the local device in the connection
+	local     bdaddr // address of the local device in the connection
+	smp       *smp
+
+	mu      *sync.Mutex // protects the following fields
+	pd      *PlatData
+	reason  uint8 // reason of the disconnection, once disconnected
+	link    LinkState
+	sigID   uint8                  // identifier of the last signaling request
+	pending map[uint8]chan *signal // outstanding signaling requests, by identifier
+	chans   map[uint16]*Channel    // connection-oriented channels, by local CID
 }
 
 func newConn(hci *HCI, hh uint16) *conn {
-	return &conn{
+	c := &conn{
 		hci:  hci,
 		attr: hh,
-		aclc: make(chan *aclData),
+		aclc: make(chan []byte),
+		wmu:  &sync.Mutex{},
+		mu:   &sync.Mutex{},
+		link: defaultLinkState,
+
+		pending: map[uint8]chan *signal{},
+		chans:   map[uint16]*Channel{},
 	}
+	c.smp = newSMP(c)
+	return c
+}
+
+func (c *conn) setPlatData(pd *PlatData) {
+	c.mu.Lock()
+	c.pd = pd
+	pd.Reason = c.reason
+	c.mu.Unlock()
+}
+
+func (c *conn) platData() *PlatData {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pd
+}
+
+func (c *conn) linkState() LinkState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.link
 }
 
-func (c *conn) updateConnection() (int, error) {
-	b := []byte{
-		0x12,       // Code (Connection Param Update)
-		0x02,       // ID
-		0x08, 0x00, // DataLength
-		0x08, 0x00, // IntervalMin
-		0x18, 0x00, // IntervalMax
-		0x00, 0x00, // SlaveLatency
-		0xC8, 0x00} // TimeoutMultiplier
-	return c.write(0x05, b)
+// fragSize returns the maximum length of the ACL data packets sent on the
+// connection, which fit in both the controller buffers and a single LL data
+// PDU, so the controller doesn't have to fragment them again.
+func (c *conn) fragSize() int {
+	n := int(c.linkState().MaxTxOctets)
+	if n > c.hci.bufSize {
+		n = c.hci.bufSize
+	}
+	return n
 }
 
 // write writes the l2cap payload to the controller.
 // It first prepend the l2cap header (4-bytes), and diassemble the payload
-// if it is larger than the HCI LE buffer size that the conntroller can support.
+// if it is larger than the HCI LE buffer size that the conntroller can support,
+// or than the LL data PDUs currently used by the connection.
 func (c *conn) write(cid int, b []byte) (int, error) {
 	flag := uint8(0) // ACL data continuation flag
 	tlen := len(b)   // Total length of the l2cap payload
@@ -73,11 +120,15 @@ func (c *conn) write(cid int, b []byte) (int, error) {
 			uint8(cid), uint8(cid >> 8), // l2cap header
 		}, b...)
 
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
 	n := 4 + tlen // l2cap header + l2cap payload
+	fs := c.fragSize()
 	for n > 0 {
 		dlen := n
-		if dlen > c.hci.bufSize {
-			dlen = c.hci.bufSize
+		if dlen > fs {
+			dlen = fs
 		}
 		w[0] = 0x02 // packetTypeACL
 		w[1] = uint8(c.attr)
@@ -86,7 +137,9 @@ func (c *conn) write(cid int, b []byte) (int, error) {
 		w[4] = uint8(dlen >> 8)
 
 		// make sure we don't send more buffers than the controller can handdle
-		c.hci.bufCnt <- struct{}{}
+		if !c.hci.pool.acquire(c.attr) {
+			return 0, errConnClosed
+		}
 
 		c.hci.d.Write(w[:5+dlen])
 		w = w[dlen:] // advance the pointer to the next segment, if any.
@@ -98,28 +151,16 @@ func (c *conn) write(cid int, b []byte) (int, error) {
 }
 
 func (c *conn) Read(b []byte) (int, error) {
-	a, ok := <-c.aclc
+	p, ok := <-c.aclc
 	if !ok {
 		return 0, io.EOF
 	}
-	tlen := int(uint16(a.b[0]) | uint16(a.b[1])<<8)
-	if tlen > len(b) {
+	d := p[4:] // skip l2cap header
+	if len(d) > len(b) {
 		return 0, io.ErrShortBuffer
 	}
-	d := a.b[4:] // skip l2cap header
-	copy(b, d)
-	n := len(d)
-
-	// Keep receiving and reassemble continued l2cap segments
-	for n != tlen {
-		if a, ok = <-c.aclc; !ok || (a.flags&0x1) == 0 {
-			return n, io.ErrUnexpectedEOF
-		}
-		copy(b[n:], a.b)
-		n += len(a.b)
-	}
-	// log.Printf("R: [ % X ]", b[:n])
-	return n, nil
+	// log.Printf("R: [ % X ]", d)
+	return copy(b, d), nil
 }
 
 func (c *conn) Write(b []byte) (int, error) {
@@ -131,44 +172,14 @@ func (c *conn) Close() error {
 	h := c.hci
 	hh := c.attr
 	h.connsmu.Lock()
-	defer h.connsmu.Unlock()
 	_, found := h.conns[hh]
+	h.connsmu.Unlock()
 	if !found {
 		// log.Printf("l2conn: 0x%04x already disconnected", hh)
 		return nil
 	}
-	if err, _ := h.c.Send(cmd.Disconnect{ConnectionHandle: hh, Reason: 0x13}); err != nil {
+	if _, err := h.c.Send(cmd.Disconnect{ConnectionHandle: hh, Reason: 0x13}); err != nil {
 		return fmt.Errorf("l2conn: failed to disconnect, %s", err)
 	}
 	return nil
 }
-
-// Signal Packets
-// 0x00 Reserved								Any
-// 0x01 Command reject							0x0001 and 0x0005
-// 0x02 Connection request						0x0001
-// 0x03 Connection response 					0x0001
-// 0x04 Configure request						0x0001
-// 0x05 Configure response						0x0001
-// 0x06 Disconnection request					0x0001 and 0x0005
-// 0x07 Disconnection response					0x0001 and 0x0005
-// 0x08 Echo request							0x0001
-// 0x09 Echo response							0x0001
-// 0x0A Information request						0x0001
-// 0x0B Information response					0x0001
-// 0x0C Create Channel request					0x0001
-// 0x0D Create Channel response					0x0001
-// 0x0E Move Channel request					0x0001
-// 0x0F Move Channel response					0x0001
-// 0x10 Move Channel Confirmation				0x0001
-// 0x11 Move Channel Confirmation response		0x0001
-// 0x12 Connection Parameter Update request		0x0005
-// 0x13 Connection Parameter Update response	0x0005
-// 0x14 LE Credit Based Connection request		0x0005
-// 0x15 LE Credit Based Connection response		0x0005
-// 0x16 LE Flow Control Credit					0x0005
-func (c *conn) handleSignal(a *aclData) error {
-	log.Printf("ignore l2cap signal:[ % X ]", a.b)
-	// FIXME: handle LE signaling channel (CID: 5)
-	return nil
-}
@@ -0,0 +1,285 @@
+package linux
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/paypal/gatt/linux/cmd"
+	"github.com/paypal/gatt/linux/evt"
+)
+
+// LE PHYs, as used by LE Read PHY and LE PHY Update Complete.
+const (
+	PHY1M    = 0x01
+	PHY2M    = 0x02
+	PHYCoded = 0x03
+)
+
+// LinkState is the link layer state of a connection.
+type LinkState struct {
+	MaxTxOctets uint16 // maximum payload octets of the LL data PDUs sent
+	MaxTxTime   uint16 // maximum time, in microseconds, to send a LL data PDU
+	MaxRxOctets uint16 // maximum payload octets of the LL data PDUs received
+	MaxRxTime   uint16 // maximum time, in microseconds, to receive a LL data PDU
+	TxPHY       uint8  // PHY used by the transmitter
+	RxPHY       uint8  // PHY used by the receiver
+	Interval    uint16 // connection interval, N x 1.25 ms
+	Latency     uint16 // connection events the slave can skip
+	Timeout     uint16 // supervision timeout, N x 10 ms
+}
+
+// ConnParams are the connection parameters requested for a connection.
+type ConnParams struct {
+	IntervalMin uint16 // N x 1.25 ms, 0x0006 - 0x0C80
+	IntervalMax uint16 // N x 1.25 ms, 0x0006 - 0x0C80
+	Latency     uint16 // connection events the slave can skip, 0x0000 - 0x01F3
+	Timeout     uint16 // supervision timeout, N x 10 ms, 0x000A - 0x0C80
+}
+
+var errInvalidConnParams = errors.New("l2conn: invalid connection parameters")
+
+// check reports if the parameters are in the ranges of the spec, and the
+// supervision timeout is larger than (1 + latency) * interval * 2.
+// See Core spec Vol 2, Part E, 7.8.12.
+func (p ConnParams) check() error {
+	switch {
+	case p.IntervalMin < 0x0006 || p.IntervalMax > 0x0C80 || p.IntervalMin > p.IntervalMax:
+		return errInvalidConnParams
+	case p.Latency > 0x01F3:
+		return errInvalidConnParams
+	case p.Timeout < 0x000A || p.Timeout > 0x0C80:
+		return errInvalidConnParams
+	case int(p.Timeout)*10*1000 <= (1+int(p.Latency))*int(p.IntervalMax)*1250*2:
+		return errInvalidConnParams
+	}
+	return nil
+}
+
+// defaultLinkState is the state of a new connection, until the controller
+// reports otherwise. See Core spec Vol 6, Part B, 4.5.10.
+var defaultLinkState = LinkState{
+	MaxTxOctets: 27,
+	MaxTxTime:   328,
+	MaxRxOctets: 27,
+	MaxRxTime:   328,
+	TxPHY:       PHY1M,
+	RxPHY:       PHY1M,
+}
+
+func (h *HCI) conn(hh uint16) (*conn, error) {
+	h.connsmu.Lock()
+	defer h.connsmu.Unlock()
+	c, ok := h.conns[hh]
+	if !ok {
+		return nil, fmt.Errorf("l2conn: unknown connection 0x%04X", hh)
+	}
+	return c, nil
+}
+
+// Link returns the link layer state of the connection hh.
+func (h *HCI) Link(hh uint16) (LinkState, error) {
+	c, err := h.conn(hh)
+	if err != nil {
+		return LinkState{}, err
+	}
+	return c.linkState(), nil
+}
+
+// UpdateParameters requests new parameters for the connection hh. As the
+// master, it updates them with the controller; as the slave, it requests
+// the master with the L2CAP Connection Parameter Update Request, and fails
+// if the master rejects them. The parameters in effect are reported by
+// LinkUpdatedHandler, once the master has updated them.
+func (h *HCI) UpdateParameters(hh uint16, p ConnParams) error {
+	if err := p.check(); err != nil {
+		return err
+	}
+	c, err := h.conn(hh)
+	if err != nil {
+		return err
+	}
+	if !c.master {
+		return c.requestConnParams(p)
+	}
+	return h.c.SendAndDecode(cmd.LEConnUpdate{
+		ConnectionHandle:   hh,
+		ConnIntervalMin:    p.IntervalMin,
+		ConnIntervalMax:    p.IntervalMax,
+		ConnLatency:        p.Latency,
+		SupervisionTimeout: p.Timeout,
+		MinimumCELength:    0x0000,
+		MaximumCELength:    0x0000,
+	}, &cmd.LEConnUpdateRP{})
+}
+
+// SetDataLength suggests the controller to send LL data PDUs of up to
+// txOctets payload octets on the connection hh. The lengths in effect
+// are reported by LinkUpdatedHandler, if they change.
+func (h *HCI) SetDataLength(hh uint16, txOctets uint16) error {
+	c, err := h.conn(hh)
+	if err != nil {
+		return err
+	}
+	return h.c.SendAndDecode(cmd.LESetDataLength{
+		ConnectionHandle: hh,
+		TxOctets:         txOctets,
+		TxTime:           txTime(txOctets, c.linkState().TxPHY),
+	}, &cmd.LESetDataLengthRP{})
+}
+
+// SetPHY requests the controller to use the PHYs, specified as bitmasks of
+// 0x01: LE 1M, 0x02: LE 2M, and 0x04: LE Coded, on the connection hh.
+// The PHYs in effect are reported by LinkUpdatedHandler.
+func (h *HCI) SetPHY(hh uint16, txPHYs, rxPHYs uint8) error {
+	if _, err := h.conn(hh); err != nil {
+		return err
+	}
+	return h.c.SendAndDecode(cmd.LESetPHY{
+		ConnectionHandle: hh,
+		AllPHYs:          0x00, // preferences are specified for both directions.
+		TxPHYs:           txPHYs,
+		RxPHYs:           rxPHYs,
+		PHYOptions:       0x0000, // no preferred coding on LE Coded PHY.
+	}, &cmd.LESetPHYRP{})
+}
+
+// txTime returns the time to send a LL data PDU of n payload octets.
+// On LE Coded PHY the maximum time is allowed, and the controller limits
+// it by the number of octets instead.
+func txTime(n uint16, phy uint8) uint16 {
+	if phy == PHYCoded {
+		return 0x4290
+	}
+	// preamble, access address, header, MIC and CRC, at 1 us per bit on LE 1M.
+	return (n + 14) * 8
+}
+
+func (h *HCI) handleDataLengthChange(b []byte) error {
+	ep := &evt.LEDataLengthChangeEP{}
+	if err := ep.Unmarshal(b); err != nil {
+		return err
+	}
+	c, err := h.conn(ep.ConnectionHandle)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.link.MaxTxOctets = ep.MaxTxOctets
+	c.link.MaxTxTime = ep.MaxTxTime
+	c.link.MaxRxOctets = ep.MaxRxOctets
+	c.link.MaxRxTime = ep.MaxRxTime
+	c.mu.Unlock()
+	h.linkUpdated(c, nil)
+	return nil
+}
+
+func (h *HCI) handlePHYUpdateComplete(b []byte) error {
+	ep := &evt.LEPHYUpdateCompleteEP{}
+	if err := ep.Unmarshal(b); err != nil {
+		return err
+	}
+	c, err := h.conn(ep.ConnectionHandle)
+	if err != nil {
+		return err
+	}
+	if ep.Status != 0x00 {
+		h.linkUpdated(c, cmd.Error(ep.Status))
+		return nil
+	}
+	c.mu.Lock()
+	c.link.TxPHY = ep.TxPHY
+	c.link.RxPHY = ep.RxPHY
+	c.mu.Unlock()
+	h.linkUpdated(c, nil)
+	return nil
+}
+
+func (h *HCI) handleConnectionUpdateComplete(b []byte) error {
+	ep := &evt.LEConnectionUpdateCompleteEP{}
+	if err := ep.Unmarshal(b); err != nil {
+		return err
+	}
+	c, err := h.conn(ep.ConnectionHandle)
+	if err != nil {
+		return err
+	}
+	if ep.Status != 0x00 {
+		h.linkUpdated(c, cmd.Error(ep.Status))
+		return nil
+	}
+	c.mu.Lock()
+	c.link.Interval = ep.ConnInterval
+	c.link.Latency = ep.ConnLatency
+	c.link.Timeout = ep.SupervisionTimeout
+	c.mu.Unlock()
+	h.linkUpdated(c, nil)
+	return nil
+}
+
+// acceptConnParams reports if the connection parameters requested by the
+// remote device are accepted.
+func (h *HCI) acceptConnParams(c *conn, p ConnParams) bool {
+	if p.check() != nil {
+		return false
+	}
+	pd := c.platData()
+	if h.ConnParamsRequestHandler == nil || pd == nil {
+		return true
+	}
+	return h.ConnParamsRequestHandler(pd, p)
+}
+
+func (h *HCI) handleRemoteConnectionParameterRequest(b []byte) error {
+	ep := &evt.LERemoteConnectionParameterRequestEP{}
+	if err := ep.Unmarshal(b); err != nil {
+		return err
+	}
+	c, err := h.conn(ep.ConnectionHandle)
+	if err != nil {
+		return err
+	}
+	p := ConnParams{
+		IntervalMin: ep.IntervalMin,
+		IntervalMax: ep.IntervalMax,
+		Latency:     ep.Latency,
+		Timeout:     ep.Timeout,
+	}
+	// The policy of the package user may block; answer without holding up the other events.
+	go func() {
+		if err := h.answerConnParams(c, p); err != nil {
+			log.Printf("l2conn: failed to answer connection parameters of 0x%04X connection, %s", c.attr, err)
+		}
+	}()
+	return nil
+}
+
+// answerConnParams answers the connection parameters requested by the
+// remote device with the link layer procedure.
+func (h *HCI) answerConnParams(c *conn, p ConnParams) error {
+	if !h.acceptConnParams(c, p) {
+		return h.c.SendAndDecode(cmd.LERemoteConnectionParameterNegReply{
+			ConnectionHandle: c.attr,
+			Reason:           uint8(cmd.ErrUnacceptableConnParameters),
+		}, &cmd.LERemoteConnectionParameterNegReplyRP{})
+	}
+	return h.c.SendAndDecode(cmd.LERemoteConnectionParameterReply{
+		ConnectionHandle: c.attr,
+		IntervalMin:      p.IntervalMin,
+		IntervalMax:      p.IntervalMax,
+		Latency:          p.Latency,
+		Timeout:          p.Timeout,
+		MinimumCELength:  0x0000,
+		MaximumCELength:  0x0000,
+	}, &cmd.LERemoteConnectionParameterReplyRP{})
+}
+
+// linkUpdated reports the link layer state of the connection to the
+// package user, if the connection has been accepted.
+func (h *HCI) linkUpdated(c *conn, err error) {
+	pd := c.platData()
+	if h.LinkUpdatedHandler == nil || pd == nil {
+		return
+	}
+	go h.LinkUpdatedHandler(pd, c.linkState(), err)
+}
@@ -0,0 +1,287 @@
+package linux
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/paypal/gatt/crypto"
+	"github.com/paypal/gatt/linux/cmd"
+)
+
+// DefaultRPATimeout is the period after which the private addresses of the
+// local device are renewed, by default.
+const DefaultRPATimeout = 15 * time.Minute
+
+// Privacy is the address privacy of the local device. See Core spec Vol 3,
+// Part C, 10.7 and Vol 6, Part B, 1.3.2.
+type Privacy struct {
+	// StaticAddr is the random static address of the local device, most
+	// significant octet first, which is its identity address in place of
+	// its public address, if non-zero. Its two most significant bits are
+	// set.
+	StaticAddr [6]byte
+
+	// IRK is the identity resolving key of the local device. If non-nil,
+	// the device advertises, scans and initiates connections with
+	// resolvable private addresses generated from it, and distributes it in
+	// the pairings, so that only its bonded peers recognize it.
+	IRK []byte
+
+	// NonResolvable makes the device use non-resolvable private addresses
+	// instead, if it has no IRK, so that no one recognizes it.
+	NonResolvable bool
+
+	// Timeout is the period after which the private addresses are renewed,
+	// DefaultRPATimeout if zero.
+	Timeout time.Duration
+}
+
+// private reports whether the device uses private addresses.
+func (p Privacy) private() bool { return p.IRK != nil || p.NonResolvable }
+
+// random reports whether the device uses a random address.
+func (p Privacy) random() bool { return p.private() || p.StaticAddr != bdaddr{} }
+
+// SetPrivacy sets the address privacy of the local device. The random
+// address of the device is set right away, and then renewed every
+// p.Timeout, if private; the advertising and the scanning are paused only
+// while the address changes. The advertising parameters sent afterwards
+// must have the own address type returned by OwnAddressType.
+func (h *HCI) SetPrivacy(p Privacy) error {
+	if p.StaticAddr != (bdaddr{}) && !static(p.StaticAddr) {
+		return errors.New("hci: invalid random static address")
+	}
+	if p.IRK != nil && len(p.IRK) != 16 {
+		return errors.New("smp: invalid key length")
+	}
+	if p.Timeout <= 0 {
+		p.Timeout = DefaultRPATimeout
+	}
+	h.pairingmu.Lock()
+	h.localIRK = p.IRK
+	h.pairingmu.Unlock()
+
+	h.addrmu.Lock()
+	h.privacy = p
+	h.ownType = 0x00
+	if p.random() {
+		h.ownType = 0x01
+	}
+	if h.renewt != nil {
+		h.renewt.Stop()
+		h.renewt = nil
+	}
+	h.addrmu.Unlock()
+	if err := h.renewAddr(); err != nil {
+		return err
+	}
+	h.addrmu.Lock()
+	h.scanParams.OwnAddressType = h.ownType
+	sp := h.scanParams
+	h.addrmu.Unlock()
+	return h.sendPaused(sp)
+}
+
+// OwnAddressType returns the type of the address the local device
+// advertises, scans and initiates connections with; 0x00: public, 0x01:
+// random.
+func (h *HCI) OwnAddressType() uint8 {
+	h.addrmu.Lock()
+	defer h.addrmu.Unlock()
+	return h.ownType
+}
+
+// identity returns the identity address of the local device, and its type.
+func (h *HCI) identity() (uint8, bdaddr) {
+	h.addrmu.Lock()
+	defer h.addrmu.Unlock()
+	if a := h.privacy.StaticAddr; a != (bdaddr{}) {
+		return 0x01, a
+	}
+	return 0x00, h.addr
+}
+
+// ownAddr returns the address the local device uses at the moment, and its
+// type. The caller holds addrmu.
+func (h *HCI) ownAddr() (uint8, bdaddr) {
+	if h.ownType == 0x01 {
+		return 0x01, h.random
+	}
+	return 0x00, h.addr
+}
+
+// renewAddr sets the random address of the controller to a new private
+// address, or to the static address, and schedules the next renewal.
+func (h *HCI) renewAddr() error {
+	h.addrmu.Lock()
+	defer h.addrmu.Unlock()
+	p := h.privacy
+	if !p.random() {
+		return nil
+	}
+	if h.initiating {
+		// The address can't change while a connection is initiated.
+		h.renewt = time.AfterFunc(time.Second, h.renew)
+		return nil
+	}
+	a := p.StaticAddr
+	if p.private() {
+		var err error
+		if a, err = h.privateAddr(p); err != nil {
+			return err
+		}
+	}
+	if err := h.sendPaused(cmd.LESetRandomAddress{RandomAddress: a}); err != nil {
+		return err
+	}
+	h.random = a
+	if p.private() {
+		h.renewt = time.AfterFunc(p.Timeout, h.renew)
+	}
+	return nil
+}
+
+func (h *HCI) renew() {
+	if err := h.renewAddr(); err != nil {
+		log.Printf("hci: failed to renew the private address, %s", err)
+	}
+}
+
+// privateAddr generates a resolvable private address from the IRK of p, or
+// else a non-resolvable private address. The random part of an address is
+// neither all zeros nor all ones.
+func (h *HCI) privateAddr(p Privacy) (bdaddr, error) {
+	for {
+		if p.IRK != nil {
+			r, err := random(3)
+			if err != nil {
+				return bdaddr{}, err
+			}
+			r[0] = r[0]&0x3F | 0x40 // prand, most significant octet first
+			if !uniform(r, 0x3F) {
+				hash := crypto.Ah(p.IRK, []byte{r[2], r[1], r[0]})
+				return bdaddr{r[0], r[1], r[2], hash[2], hash[1], hash[0]}, nil
+			}
+			continue
+		}
+		r, err := random(6)
+		if err != nil {
+			return bdaddr{}, err
+		}
+		r[0] &= 0x3F
+		if a := bdaddr(r); !uniform(r, 0x3F) && a != h.addr {
+			return a, nil
+		}
+	}
+}
+
+// static reports whether a is a valid random static address.
+func static(a bdaddr) bool {
+	return a[0]>>6 == 0x03 && !uniform(a[:], 0x3F)
+}
+
+// uniform reports whether the random bits of the address a, those masked by m
+// in its most significant octet and all the others, are all zeros or all
+// ones.
+func uniform(a []byte, m byte) bool {
+	zeros, ones := a[0]&m == 0, a[0]&m == m
+	for _, b := range a[1:] {
+		zeros, ones = zeros && b == 0x00, ones && b == 0xFF
+	}
+	return zeros || ones
+}
+
+// sendPaused sends the command, which the controller rejects while
+// advertising or scanning, with the advertising and the scanning paused.
+func (h *HCI) sendPaused(c cmd.CmdParam) error {
+	h.advmu.Lock()
+	scan, dup := h.scan, h.scanDup
+	h.advmu.Unlock()
+	if scan {
+		h.setScanEnable(false, dup)
+	}
+	err := h.SendCmdWithAdvOff(c)
+	if scan {
+		h.setScanEnable(true, dup)
+	}
+	return err
+}
+
+// AddToResolvingList adds the peer device of the identity address a, of type
+// typ, and its IRK, to the resolving list of the controller, along with the
+// IRK of the local device, if any. The controller resolves the private
+// addresses of the peers in the list, once the address resolution is
+// enabled, and reports their identity addresses instead.
+func (h *HCI) AddToResolvingList(typ uint8, a [6]byte, irk []byte) error {
+	if len(irk) != 16 {
+		return errors.New("smp: invalid key length")
+	}
+	c := cmd.LEAddDeviceToResolvingList{
+		PeerIdentityAddressType: typ,
+		PeerIdentityAddress:     a,
+	}
+	copy(c.PeerIRK[:], irk)
+	h.pairingmu.Lock()
+	copy(c.LocalIRK[:], h.localIRK)
+	h.pairingmu.Unlock()
+	return h.sendList(c)
+}
+
+// RemoveFromResolvingList removes the peer device of the identity address a,
+// of type typ, from the resolving list of the controller.
+func (h *HCI) RemoveFromResolvingList(typ uint8, a [6]byte) error {
+	return h.sendList(cmd.LERemoveDeviceFromResolvingList{
+		PeerIdentityAddressType: typ,
+		PeerIdentityAddress:     a,
+	})
+}
+
+// ClearResolvingList removes all the peer devices from the resolving list of
+// the controller.
+func (h *HCI) ClearResolvingList() error {
+	return h.sendList(cmd.LEClearResolvingList{})
+}
+
+// ResolvingListSize returns the number of peer devices the resolving list of
+// the controller holds at most.
+func (h *HCI) ResolvingListSize() (int, error) {
+	rp := &cmd.LEReadResolvingListSizeRP{}
+	if err := h.c.SendAndDecode(cmd.LEReadResolvingListSize{}, rp); err != nil {
+		return 0, err
+	}
+	return int(rp.ResolvingListSize), nil
+}
+
+// SetAddressResolution enables or disables the resolution of the private
+// addresses by the controller. If enabled, the controller renews the private
+// addresses it generates every timeout, DefaultRPATimeout if zero.
+func (h *HCI) SetAddressResolution(en bool, timeout time.Duration) error {
+	if en {
+		if timeout <= 0 {
+			timeout = DefaultRPATimeout
+		}
+		s := timeout / time.Second
+		if s < 1 {
+			s = 1
+		} else if s > 0xA1B8 {
+			s = 0xA1B8
+		}
+		if err := h.c.SendAndCheckResp(cmd.LESetRPATimeout{RPATimeout: uint16(s)}, []byte{0x00}); err != nil {
+			return err
+		}
+	}
+	return h.sendList(cmd.LESetAddressResolutionEnable{AddressResolutionEnable: btoi(en)})
+}
+
+// sendList sends the command on the resolving list or the filter accept list,
+// which the controller rejects while advertising, scanning or initiating a
+// connection.
+func (h *HCI) sendList(c cmd.CmdParam) error {
+	h.addrmu.Lock()
+	defer h.addrmu.Unlock()
+	if h.initiating {
+		return errors.New("hci: connection pending")
+	}
+	return h.sendPaused(c)
+}
@@ -0,0 +1,171 @@
+package linux
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/paypal/gatt/btaddr"
+	"github.com/paypal/gatt/crypto"
+	"github.com/paypal/gatt/linux/cmd"
+)
+
+// randomAddr waits for the next LE Set Random Address command sent to the
+// controller, and returns its address, most significant octet first.
+func randomAddr(t *testing.T, cmdc chan []byte) bdaddr {
+	for {
+		select {
+		case p := <-cmdc:
+			if op := int(p[1]) | int(p[2])<<8; op == 0x2005 {
+				return bdaddr{p[9], p[8], p[7], p[6], p[5], p[4]}
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no LE Set Random Address")
+		}
+	}
+}
+
+func TestPrivacy(t *testing.T) {
+	f := newFakeController()
+	h, _ := newTestHCI(t, f)
+	defer f.Close()
+	cmdc := make(chan []byte, 16)
+	f.mu.Lock()
+	f.cmdc = cmdc
+	f.mu.Unlock()
+
+	if err := h.SetPrivacy(Privacy{StaticAddr: [6]byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}}); err == nil {
+		t.Error("SetPrivacy accepted an invalid static address")
+	}
+
+	irk := []byte{0x9B, 0x7D, 0x39, 0x0A, 0xA6, 0x10, 0x10, 0x34, 0x05, 0xAD, 0xC8, 0x57, 0xA3, 0x34, 0x02, 0xEC}
+	static := [6]byte{0xC1, 0x02, 0x03, 0x04, 0x05, 0x06}
+	if err := h.SetPrivacy(Privacy{StaticAddr: static, IRK: irk, Timeout: 10 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if typ := h.OwnAddressType(); typ != 0x01 {
+		t.Errorf("got own address type 0x%02X, want random", typ)
+	}
+	if typ, a := h.identity(); typ != 0x01 || a != static {
+		t.Errorf("got identity address %X of type 0x%02X", a, typ)
+	}
+
+	// The resolvable private addresses resolve with the IRK, and are renewed.
+	a1, a2 := randomAddr(t, cmdc), randomAddr(t, cmdc)
+	for _, a := range []bdaddr{a1, a2} {
+		hash := crypto.Ah(irk, []byte{a[2], a[1], a[0]})
+		if a[0]>>6 != 0x01 || !bytes.Equal(hash, []byte{a[5], a[4], a[3]}) {
+			t.Errorf("%X is not resolvable with the IRK", a)
+		}
+	}
+	if a1 == a2 {
+		t.Errorf("the address %X was not renewed", a1)
+	}
+	// The connections are initiated with the private address, which isn't
+	// renewed until they complete.
+	if err := h.Connect(&PlatData{}, ConnParams{0x0018, 0x0028, 4, 0x01F4}); err != nil {
+		t.Fatal(err)
+	}
+	for p := range cmdc {
+		if op := int(p[1]) | int(p[2])<<8; op == 0x200D {
+			if p[4+12] != 0x01 {
+				t.Errorf("got own address type 0x%02X, want random", p[4+12])
+			}
+			break
+		}
+	}
+	h.addrmu.Lock()
+	initiating := h.initiating
+	h.addrmu.Unlock()
+	if !initiating {
+		t.Error("not initiating")
+	}
+
+	if got := h.irk(); !bytes.Equal(got, irk) {
+		t.Errorf("got IRK [ % X ], want it distributed", got)
+	}
+}
+
+func TestResolvingList(t *testing.T) {
+	f := newFakeController()
+	h, pdc := newTestHCI(t, f)
+	defer f.Close()
+	cmdc := make(chan []byte, 16)
+	f.mu.Lock()
+	f.cmdc = cmdc
+	f.mu.Unlock()
+
+	irk := []byte{0x9B, 0x7D, 0x39, 0x0A, 0xA6, 0x10, 0x10, 0x34, 0x05, 0xAD, 0xC8, 0x57, 0xA3, 0x34, 0x02, 0xEC}
+	id := [6]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06}
+	if err := h.AddToResolvingList(0x00, id, irk); err != nil {
+		t.Fatal(err)
+	}
+	for p := range cmdc {
+		if op := int(p[1]) | int(p[2])<<8; op != 0x2027 {
+			continue
+		}
+		want := append([]byte{0x00, 0x06, 0x05, 0x04, 0x03, 0x02, 0x01}, irk...)
+		want = append(want, make([]byte, 16)...)
+		if p[3] != 39 || !bytes.Equal(p[4:], want) {
+			t.Errorf("got LE Add Device To Resolving List [ % X ]", p[3:])
+		}
+		break
+	}
+	f.mu.Lock()
+	f.cmdc = nil
+	f.mu.Unlock()
+
+	// The controller reports the identity address of a central connecting
+	// with a private address, and the private addresses of the connection.
+	f.event(0x3E,
+		0x0A,       // LE Enhanced Connection Complete
+		0x00,       // Status
+		0x40, 0x00, // Connection Handle
+		0x01,                               // Role
+		0x02,                               // Peer Address Type: resolved public
+		0x06, 0x05, 0x04, 0x03, 0x02, 0x01, // Peer Address
+		0x16, 0x15, 0x14, 0x13, 0x12, 0x51, // Local Resolvable Private Address
+		0x26, 0x25, 0x24, 0x23, 0x22, 0x61, // Peer Resolvable Private Address
+		0x18, 0x00, // Connection Interval
+		0x00, 0x00, // Connection Latency
+		0xC8, 0x00, // Supervision Timeout
+		0x00) // Master Clock Accuracy
+	var pd *PlatData
+	select {
+	case pd = <-pdc:
+	case <-time.After(time.Second):
+		t.Fatal("no connection")
+	}
+	if pd.Address != (btaddr.BDAddr{Octets: id, Type: btaddr.Public}) {
+		t.Errorf("got peer %s of type %s, want its identity address", pd.Address, pd.Address.Type)
+	}
+	c := pd.Conn.(*conn)
+	if c.peerType != 0x01 || c.peer != (bdaddr{0x61, 0x22, 0x23, 0x24, 0x25, 0x26}) {
+		t.Errorf("got peer address %X of type 0x%02X in the connection", c.peer, c.peerType)
+	}
+	if c.localType != 0x01 || c.local != (bdaddr{0x51, 0x12, 0x13, 0x14, 0x15, 0x16}) {
+		t.Errorf("got local address %X of type 0x%02X in the connection", c.local, c.localType)
+	}
+}
+
+func TestResolvingListError(t *testing.T) {
+	f := newFakeController()
+	h, _ := newTestHCI(t, f)
+	defer f.Close()
+
+	// The list is full; the Memory Capacity Exceeded status is reported.
+	f.setRP(0x2027, 0x07)
+	irk := make([]byte, 16)
+	if err := h.AddToResolvingList(0x00, [6]byte{0x01}, irk); err != cmd.Error(0x07) {
+		t.Errorf("AddToResolvingList: got %v, want %v", err, cmd.Error(0x07))
+	}
+	f.setRP(0x2028, 0x12)
+	if err := h.RemoveFromResolvingList(0x00, [6]byte{0x01}); err != cmd.Error(0x12) {
+		t.Errorf("RemoveFromResolvingList: got %v, want %v", err, cmd.Error(0x12))
+	}
+	f.setRP(0x2005, 0x12)
+	static := [6]byte{0xC1, 0x02, 0x03, 0x04, 0x05, 0x06}
+	if err := h.SetPrivacy(Privacy{StaticAddr: static}); err != cmd.Error(0x12) {
+		t.Errorf("SetPrivacy: got %v, want %v", err, cmd.Error(0x12))
+	}
+}
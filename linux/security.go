@@ -0,0 +1,42 @@
+package linux
+
+import "errors"
+
+// Security is the security of the link of a connection.
+type Security struct {
+	Encrypted         bool
+	Authenticated     bool // the key is from a pairing with MITM protection
+	SecureConnections bool // the key is from a LE Secure Connections pairing
+	KeySize           int  // encryption key size
+	Bonded            bool // the key is the LTK of a bond, rather than of a new pairing
+}
+
+// Encrypt encrypts the link of the connection hh, as master, with the LTK of
+// the bond with the remote device, as returned by the BondHandler, and waits
+// for the encryption to complete.
+func (h *HCI) Encrypt(hh uint16) error {
+	c, err := h.conn(hh)
+	if err != nil {
+		return err
+	}
+	if !c.master {
+		return errors.New("smp: only the master encrypts the link")
+	}
+	s := c.smp
+	w := make(chan error, 1)
+	select {
+	case s.startc <- w:
+	case <-s.quitc:
+		return errConnClosed
+	}
+	return <-w
+}
+
+// Security returns the security of the link of the connection hh.
+func (h *HCI) Security(hh uint16) (Security, error) {
+	c, err := h.conn(hh)
+	if err != nil {
+		return Security{}, err
+	}
+	return c.smp.security(), nil
+}
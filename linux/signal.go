@@ -0,0 +1,271 @@
+package linux
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// cidLESignal is the channel of the LE signaling commands.
+// See Core spec Vol 3, Part A, 4.
+const cidLESignal = 0x0005
+
+// Signaling command codes, and the channels they are used on.
+const (
+	sigCommandReject           = 0x01 // 0x0001 and 0x0005
+	sigConnectionRequest       = 0x02 // 0x0001
+	sigConnectionResponse      = 0x03 // 0x0001
+	sigConfigureRequest        = 0x04 // 0x0001
+	sigConfigureResponse       = 0x05 // 0x0001
+	sigDisconnectRequest       = 0x06 // 0x0001 and 0x0005
+	sigDisconnectResponse      = 0x07 // 0x0001 and 0x0005
+	sigEchoRequest             = 0x08 // 0x0001
+	sigEchoResponse            = 0x09 // 0x0001
+	sigInformationRequest      = 0x0A // 0x0001
+	sigInformationResponse     = 0x0B // 0x0001
+	sigCreateChannelRequest    = 0x0C // 0x0001
+	sigCreateChannelResponse   = 0x0D // 0x0001
+	sigMoveChannelRequest      = 0x0E // 0x0001
+	sigMoveChannelResponse     = 0x0F // 0x0001
+	sigMoveChannelConfirmation = 0x10 // 0x0001
+	sigMoveChannelConfirmRsp   = 0x11 // 0x0001
+	sigConnParamUpdateRequest  = 0x12 // 0x0005
+	sigConnParamUpdateResponse = 0x13 // 0x0005
+	sigLECreditConnRequest     = 0x14 // 0x0005
+	sigLECreditConnResponse    = 0x15 // 0x0005
+	sigLEFlowControlCredit     = 0x16 // 0x0001 and 0x0005
+	sigCreditConnRequest       = 0x17 // 0x0001 and 0x0005
+	sigCreditConnResponse      = 0x18 // 0x0001 and 0x0005
+	sigCreditReconfRequest     = 0x19 // 0x0001 and 0x0005
+	sigCreditReconfResponse    = 0x1A // 0x0001 and 0x0005
+)
+
+// Command Reject reasons.
+const (
+	rejectNotUnderstood = 0x0000 // Command not understood
+	rejectMTUExceeded   = 0x0001 // Signaling MTU exceeded
+	rejectInvalidCID    = 0x0002 // Invalid CID in request
+)
+
+// sigMTU is the MTU of the LE signaling channel, the minimum of the spec.
+const sigMTU = 23
+
+// sigTimeout is the time to wait for the response of a signaling request,
+// the RTX timer of the spec. See Core spec Vol 3, Part A, 6.2.1.
+var sigTimeout = 30 * time.Second
+
+// A signal is a command of the LE signaling channel.
+type signal struct {
+	code uint8
+	id   uint8
+	data []byte
+}
+
+func (s *signal) marshal() []byte {
+	return append([]byte{s.code, s.id, uint8(len(s.data)), uint8(len(s.data) >> 8)}, s.data...)
+}
+
+func (s *signal) unmarshal(b []byte) error {
+	if len(b) < 4 {
+		return errors.New("l2conn: signaling command is too short")
+	}
+	n := int(b[2]) | int(b[3])<<8
+	if len(b) < 4+n {
+		return errors.New("l2conn: signaling command is truncated")
+	}
+	*s = signal{code: b[0], id: b[1], data: b[4 : 4+n]}
+	return nil
+}
+
+// isResponse reports if the command answers a request of the local device.
+func (s *signal) isResponse() bool {
+	switch s.code {
+	case sigCommandReject, sigDisconnectResponse, sigConnParamUpdateResponse, sigLECreditConnResponse,
+		sigCreditConnResponse, sigCreditReconfResponse:
+		return true
+	}
+	return false
+}
+
+// A sigHandler handles a signaling request from the remote device, and
+// returns the response, or nil if the request has no response.
+type sigHandler func(c *conn, s *signal) *signal
+
+// sigHandlers are the handlers of the signaling requests, by code.
+// Requests without a handler are answered with Command Reject.
+var sigHandlers = map[uint8]sigHandler{
+	sigDisconnectRequest:      (*conn).handleDisconnectRequest,
+	sigConnParamUpdateRequest: (*conn).handleConnParamUpdateRequest,
+	sigLECreditConnRequest:    (*conn).handleLECreditConnRequest,
+	sigLEFlowControlCredit:    (*conn).handleLEFlowControlCredit,
+	sigCreditConnRequest:      (*conn).handleCreditConnRequest,
+	sigCreditReconfRequest:    (*conn).handleCreditReconfRequest,
+}
+
+// reject returns a Command Reject for the request s.
+func reject(s *signal, reason uint16, data ...byte) *signal {
+	return &signal{
+		code: sigCommandReject,
+		id:   s.id,
+		data: append([]byte{uint8(reason), uint8(reason >> 8)}, data...),
+	}
+}
+
+// handleSignal handles the packets of the LE signaling channel. Responses
+// are delivered to the pending requests right away, while requests are
+// handled in their own goroutines, as their handlers may block.
+func (c *conn) handleSignal(p []byte) error {
+	b := p[4:] // skip l2cap header
+	s := &signal{}
+	if err := s.unmarshal(b); err != nil {
+		log.Printf("%s:[ % X ]", err, p)
+		return nil
+	}
+	if s.isResponse() {
+		c.mu.Lock()
+		rspc, ok := c.pending[s.id]
+		if ok {
+			delete(c.pending, s.id)
+			rspc <- s
+		}
+		c.mu.Unlock()
+		// Responses that match no pending request are silently discarded.
+		return nil
+	}
+	if s.id == 0x00 {
+		// 0x00 is an invalid identifier, and must be silently discarded.
+		return nil
+	}
+	if len(b) > sigMTU {
+		go c.sendSignal(reject(s, rejectMTUExceeded, uint8(sigMTU), uint8(sigMTU>>8)))
+		return nil
+	}
+	go c.handleRequest(s)
+	return nil
+}
+
+func (c *conn) handleRequest(s *signal) {
+	f, ok := sigHandlers[s.code]
+	if !ok {
+		c.sendSignal(reject(s, rejectNotUnderstood))
+		return
+	}
+	if rsp := f(c, s); rsp != nil {
+		c.sendSignal(rsp)
+	}
+}
+
+func (c *conn) sendSignal(s *signal) error {
+	_, err := c.write(cidLESignal, s.marshal())
+	return err
+}
+
+// nextSigID returns a new identifier for a signaling command.
+// The caller must hold c.mu.
+func (c *conn) nextSigID() uint8 {
+	c.sigID++
+	if c.sigID == 0 {
+		c.sigID++ // 0x00 is an invalid identifier.
+	}
+	return c.sigID
+}
+
+// request sends a signaling request, with a new identifier, and returns
+// its response, or Command Reject.
+func (c *conn) request(code uint8, data []byte) (*signal, error) {
+	rspc := make(chan *signal, 1)
+	c.mu.Lock()
+	id := c.nextSigID()
+	c.pending[id] = rspc
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.sendSignal(&signal{code: code, id: id, data: data}); err != nil {
+		return nil, err
+	}
+	select {
+	case rsp, ok := <-rspc:
+		if !ok {
+			return nil, errConnClosed
+		}
+		return rsp, nil
+	case <-time.After(sigTimeout):
+		return nil, fmt.Errorf("l2conn: signaling request 0x%02X timed out", code)
+	}
+}
+
+// closeSignals fails the outstanding signaling requests.
+func (c *conn) closeSignals() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, rspc := range c.pending {
+		close(rspc)
+		delete(c.pending, id)
+	}
+}
+
+// requestConnParams requests the master to update the connection parameters.
+func (c *conn) requestConnParams(p ConnParams) error {
+	rsp, err := c.request(sigConnParamUpdateRequest, marshalConnParams(p))
+	if err != nil {
+		return err
+	}
+	switch {
+	case rsp.code == sigCommandReject:
+		return errors.New("l2conn: connection parameter update request rejected by the remote device")
+	case len(rsp.data) < 2:
+		return errors.New("l2conn: malformed connection parameter update response")
+	case rsp.data[0] != 0x00:
+		return errors.New("l2conn: connection parameters rejected")
+	}
+	return nil
+}
+
+// handleConnParamUpdateRequest answers the connection parameters requested
+// by the slave, and updates them if they are accepted.
+func (c *conn) handleConnParamUpdateRequest(s *signal) *signal {
+	if !c.master || len(s.data) != 8 {
+		// Only the master can update the parameters.
+		return reject(s, rejectNotUnderstood)
+	}
+	p := unmarshalConnParams(s.data)
+	ok := c.hci.acceptConnParams(c, p)
+	result := uint8(0x00) // accepted
+	if !ok {
+		result = 0x01 // rejected
+	}
+	rsp := &signal{code: sigConnParamUpdateResponse, id: s.id, data: []byte{result, 0x00}}
+	if !ok {
+		return rsp
+	}
+	if err := c.sendSignal(rsp); err != nil {
+		return nil
+	}
+	if err := c.hci.UpdateParameters(c.attr, p); err != nil {
+		log.Printf("l2conn: failed to update connection parameters of 0x%04X connection, %s", c.attr, err)
+	}
+	return nil
+}
+
+func marshalConnParams(p ConnParams) []byte {
+	return []byte{
+		uint8(p.IntervalMin), uint8(p.IntervalMin >> 8), // IntervalMin
+		uint8(p.IntervalMax), uint8(p.IntervalMax >> 8), // IntervalMax
+		uint8(p.Latency), uint8(p.Latency >> 8), // SlaveLatency
+		uint8(p.Timeout), uint8(p.Timeout >> 8), // TimeoutMultiplier
+	}
+}
+
+func unmarshalConnParams(b []byte) ConnParams {
+	return ConnParams{
+		IntervalMin: uint16(b[0]) | uint16(b[1])<<8,
+		IntervalMax: uint16(b[2]) | uint16(b[3])<<8,
+		Latency:     uint16(b[4]) | uint16(b[5])<<8,
+		Timeout:     uint16(b[6]) | uint16(b[7])<<8,
+	}
+}
@@ -0,0 +1,100 @@
+package linux
+
+import (
+	"testing"
+	"time"
+)
+
+// signalRsp returns the next signaling command sent by the host, after
+// completing its packet.
+func signalRsp(t *testing.T, f *fakeController, hh uint16) []byte {
+	select {
+	case p := <-f.aclc:
+		f.completed(hh, 1)
+		if p[7] != cidLESignal {
+			t.Fatalf("got [ % X ], want a signaling command", p)
+		}
+		return p[9:]
+	case <-time.After(time.Second):
+		t.Fatalf("no signaling command sent")
+	}
+	return nil
+}
+
+func TestSignalCommandReject(t *testing.T) {
+	f := newFakeController()
+	h, pdc := newTestHCI(t, f)
+	defer h.Close()
+	f.connect(0x40)
+	<-pdc
+
+	// Unknown, or unsupported, requests are rejected.
+	f.acl(0x40, cidLESignal, sigEchoRequest, 0x07, 0x00, 0x00)
+	if got := signalRsp(t, f, 0x40); got[0] != sigCommandReject || got[1] != 0x07 || got[4] != rejectNotUnderstood {
+		t.Errorf("echo request: got [ % X ], want Command Reject", got)
+	}
+
+	// Only the master accepts Connection Parameter Update requests.
+	f.acl(0x40, cidLESignal, sigConnParamUpdateRequest, 0x08, 0x08, 0x00, 0x10, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x01)
+	if got := signalRsp(t, f, 0x40); got[0] != sigCommandReject || got[1] != 0x08 {
+		t.Errorf("update request to the slave: got [ % X ], want Command Reject", got)
+	}
+
+	// Requests with the invalid identifier are discarded.
+	f.acl(0x40, cidLESignal, sigEchoRequest, 0x00, 0x00, 0x00)
+	select {
+	case p := <-f.aclc:
+		t.Errorf("got [ % X ] for a request with identifier 0x00", p)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSignalConnParamUpdateRequest(t *testing.T) {
+	f := newFakeController()
+	h, _ := newTestHCI(t, f)
+	defer h.Close()
+
+	pdc := make(chan *PlatData, 1)
+	h.AcceptSlaveHandler = func(pd *PlatData) { pdc <- pd }
+	h.plist[bdaddr{6, 5, 4, 3, 2, 1}] = &PlatData{} // the peer address of connectRole
+	accept := make(chan bool, 1)
+	h.ConnParamsRequestHandler = func(pd *PlatData, p ConnParams) bool {
+		if p.IntervalMin != 0x10 || p.IntervalMax != 0x20 || p.Timeout != 0x100 {
+			t.Errorf("requested parameters: got %+v", p)
+		}
+		return <-accept
+	}
+	f.connectRole(0x40, 0x00)
+	<-pdc
+
+	f.mu.Lock()
+	f.cmdc = make(chan []byte, 1)
+	f.mu.Unlock()
+
+	req := []byte{sigConnParamUpdateRequest, 0x00, 0x08, 0x00, 0x10, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x01}
+	for i, ok := range []bool{false, true} {
+		req[1] = byte(i + 1)
+		accept <- ok
+		f.acl(0x40, cidLESignal, req...)
+		got := signalRsp(t, f, 0x40)
+		if got[0] != sigConnParamUpdateResponse || got[1] != req[1] || (got[4] == 0x00) != ok {
+			t.Errorf("accept %v: got [ % X ]", ok, got)
+		}
+	}
+	// The accepted parameters are updated by the master.
+	select {
+	case c := <-f.cmdc:
+		if c[1] != 0x13 || c[2] != 0x20 {
+			t.Errorf("got command [ % X ], want LE Connection Update", c)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("accepted parameters are not updated")
+	}
+
+	// Parameters out of range are rejected, without asking.
+	req[1], req[10], req[11] = 0x03, 0x01, 0x00
+	f.acl(0x40, cidLESignal, req...)
+	if got := signalRsp(t, f, 0x40); got[0] != sigConnParamUpdateResponse || got[4] != 0x01 {
+		t.Errorf("invalid parameters: got [ % X ]", got)
+	}
+}
@@ -0,0 +1,981 @@
+package linux
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/paypal/gatt/crypto"
+	"github.com/paypal/gatt/linux/cmd"
+)
+
+// cidSMP is the fixed channel of the Security Manager Protocol.
+const cidSMP = 0x0006
+
+// smpTimeout is the time a pairing waits for each command of the remote
+// device, after which no further pairing is possible on the connection.
+const smpTimeout = 30 * time.Second
+
+// SMP command codes. See Core spec Vol 3, Part H, 3.3.
+const (
+	smpPairingRequest        = 0x01
+	smpPairingResponse       = 0x02
+	smpPairingConfirm        = 0x03
+	smpPairingRandom         = 0x04
+	smpPairingFailed         = 0x05
+	smpEncryptionInformation = 0x06
+	smpCentralIdentification = 0x07
+	smpIdentityInformation   = 0x08
+	smpIdentityAddrInfo      = 0x09
+	smpSigningInformation    = 0x0A
+	smpSecurityRequest       = 0x0B
+	smpPairingPublicKey      = 0x0C
+	smpPairingDHKeyCheck     = 0x0D
+	smpKeypressNotification  = 0x0E
+)
+
+// smpLen are the lengths of the SMP commands, including their codes.
+var smpLen = map[byte]int{
+	smpPairingRequest:        7,
+	smpPairingResponse:       7,
+	smpPairingConfirm:        17,
+	smpPairingRandom:         17,
+	smpPairingFailed:         2,
+	smpEncryptionInformation: 17,
+	smpCentralIdentification: 11,
+	smpIdentityInformation:   17,
+	smpIdentityAddrInfo:      8,
+	smpSigningInformation:    17,
+	smpSecurityRequest:       2,
+	smpPairingPublicKey:      65,
+	smpPairingDHKeyCheck:     17,
+	smpKeypressNotification:  2,
+}
+
+// IO capabilities.
+const (
+	IODisplayOnly       = 0x00
+	IODisplayYesNo      = 0x01
+	IOKeyboardOnly      = 0x02
+	IONoInputNoOutput   = 0x03
+	IOKeyboardDisplay   = 0x04
+	ioCapabilityInvalid = 0x05
+)
+
+// Authentication requirements flags.
+const (
+	AuthBonding  = 0x01
+	AuthMITM     = 0x04
+	AuthSC       = 0x08
+	AuthKeypress = 0x10
+)
+
+// Key distribution flags.
+const (
+	KeyDistEnc  = 0x01 // LTK, EDIV and Rand
+	KeyDistID   = 0x02 // IRK and identity address
+	KeyDistSign = 0x04 // CSRK
+)
+
+// PairingError is the reason of a failed pairing, as sent or received in a
+// Pairing Failed command. See Core spec Vol 3, Part H, 3.5.5.
+type PairingError uint8
+
+const (
+	ErrPasskeyEntryFailed      PairingError = 0x01 // Passkey Entry Failed
+	ErrOOBNotAvailable         PairingError = 0x02 // OOB Not Available
+	ErrAuthRequirements        PairingError = 0x03 // Authentication Requirements
+	ErrConfirmValueFailed      PairingError = 0x04 // Confirm Value Failed
+	ErrPairingNotSupported     PairingError = 0x05 // Pairing Not Supported
+	ErrEncryptionKeySize       PairingError = 0x06 // Encryption Key Size
+	ErrCommandNotSupported     PairingError = 0x07 // Command Not Supported
+	ErrUnspecifiedReason       PairingError = 0x08 // Unspecified Reason
+	ErrRepeatedAttempts        PairingError = 0x09 // Repeated Attempts
+	ErrInvalidSMPParameters    PairingError = 0x0A // Invalid Parameters
+	ErrDHKeyCheckFailed        PairingError = 0x0B // DHKey Check Failed
+	ErrNumericComparisonFailed PairingError = 0x0C // Numeric Comparison Failed
+	ErrBREDRPairingInProgress  PairingError = 0x0D // BR/EDR pairing in progress
+	ErrCrossTransportKey       PairingError = 0x0E // Cross-transport Key Derivation/Generation not allowed
+	ErrKeyRejected             PairingError = 0x0F // Key Rejected
+)
+
+var pairingErrorName = map[PairingError]string{
+	ErrPasskeyEntryFailed:      "passkey entry failed",
+	ErrOOBNotAvailable:         "OOB not available",
+	ErrAuthRequirements:        "authentication requirements",
+	ErrConfirmValueFailed:      "confirm value failed",
+	ErrPairingNotSupported:     "pairing not supported",
+	ErrEncryptionKeySize:       "encryption key size",
+	ErrCommandNotSupported:     "command not supported",
+	ErrUnspecifiedReason:       "unspecified reason",
+	ErrRepeatedAttempts:        "repeated attempts",
+	ErrInvalidSMPParameters:    "invalid parameters",
+	ErrDHKeyCheckFailed:        "DHKey check failed",
+	ErrNumericComparisonFailed: "numeric comparison failed",
+	ErrBREDRPairingInProgress:  "BR/EDR pairing in progress",
+	ErrCrossTransportKey:       "cross-transport key derivation not allowed",
+	ErrKeyRejected:             "key rejected",
+}
+
+func (e PairingError) Error() string {
+	if s, ok := pairingErrorName[e]; ok {
+		return "smp: pairing failed, " + s
+	}
+	return fmt.Sprintf("smp: pairing failed, reason 0x%02X", uint8(e))
+}
+
+var (
+	errSMPTimeout         = errors.New("smp: timeout")
+	errNoBond             = errors.New("smp: no bond with the remote device")
+	errEncryptionDisabled = errors.New("hci: encryption disabled")
+)
+
+// PairingParams are the pairing features of the local device.
+type PairingParams struct {
+	IOCap       uint8 // IO capability
+	AuthReq     uint8 // authentication requirements flags
+	MaxKeySize  uint8 // maximum encryption key size, 7 - 16
+	InitKeyDist uint8 // keys distributed by the initiator
+	RespKeyDist uint8 // keys distributed by the responder
+}
+
+// DefaultPairingParams pairs with Just Works, bonding, and distributes all
+// the keys. LE Secure Connections is used, unless the remote device supports
+// LE legacy pairing only.
+var DefaultPairingParams = PairingParams{
+	IOCap:       IONoInputNoOutput,
+	AuthReq:     AuthBonding | AuthSC,
+	MaxKeySize:  16,
+	InitKeyDist: KeyDistEnc | KeyDistID | KeyDistSign,
+	RespKeyDist: KeyDistEnc | KeyDistID | KeyDistSign,
+}
+
+// Keys are the keys distributed by a device in a pairing. The keys not
+// distributed are nil. The keys are in the byte order they are sent in,
+// least significant octet first, while the identity address is most
+// significant octet first, as the addresses of PlatData.
+type Keys struct {
+	LTK        []byte
+	EDIV       uint16
+	Rand       uint64
+	IRK        []byte
+	IDAddrType uint8
+	IDAddr     [6]byte
+	CSRK       []byte
+}
+
+// Bond is the result of a pairing.
+type Bond struct {
+	Local   Keys // keys distributed by the local device
+	Remote  Keys // keys distributed by the remote device
+	KeySize int  // encryption key size
+
+	Authenticated     bool // MITM protection; other than Just Works
+	Bonding           bool // both devices requested bonding
+	SecureConnections bool // LE Secure Connections pairing; the LTK is generated by both devices
+}
+
+// security returns the security of a link encrypted with the LTK of the bond.
+func (b *Bond) security() Security {
+	return Security{
+		Encrypted:         true,
+		Authenticated:     b.Authenticated,
+		SecureConnections: b.SecureConnections,
+		KeySize:           b.KeySize,
+	}
+}
+
+// Pairing methods.
+const (
+	methodJustWorks   = iota
+	methodPasskeyInit // the initiator inputs the passkey displayed by the responder
+	methodPasskeyResp // the responder inputs the passkey displayed by the initiator
+	methodPasskeyBoth // both devices input the same passkey
+	methodOOB
+	methodNumericComparison
+)
+
+// legacyMethods are the pairing methods of LE legacy pairing, by the IO
+// capabilities of the initiator and the responder. See Core spec Vol 3,
+// Part H, 2.3.5.1.
+var legacyMethods = [5][5]int{
+	IODisplayOnly:     {methodJustWorks, methodJustWorks, methodPasskeyResp, methodJustWorks, methodPasskeyResp},
+	IODisplayYesNo:    {methodJustWorks, methodJustWorks, methodPasskeyResp, methodJustWorks, methodPasskeyResp},
+	IOKeyboardOnly:    {methodPasskeyInit, methodPasskeyInit, methodPasskeyBoth, methodJustWorks, methodPasskeyInit},
+	IONoInputNoOutput: {methodJustWorks, methodJustWorks, methodJustWorks, methodJustWorks, methodJustWorks},
+	IOKeyboardDisplay: {methodPasskeyInit, methodPasskeyInit, methodPasskeyResp, methodJustWorks, methodPasskeyResp},
+}
+
+// legacyMethod returns the method of LE legacy pairing, from the Pairing
+// Request and Response commands.
+func legacyMethod(preq, pres []byte) int {
+	if preq[2] != 0 && pres[2] != 0 {
+		return methodOOB
+	}
+	if (preq[3]|pres[3])&AuthMITM == 0 {
+		return methodJustWorks
+	}
+	return legacyMethods[preq[1]][pres[1]]
+}
+
+type pairResult struct {
+	b   *Bond
+	err error
+}
+
+// smp is the security manager of a connection. All the pairings are carried
+// out by its loop, one at a time.
+type smp struct {
+	c      *conn
+	rxc    chan []byte          // SMP commands received
+	encc   chan error           // results of the Encryption Change events
+	reqc   chan chan pairResult // pairings requested by the local device
+	startc chan chan error      // encryptions requested by the local device
+	quitc  chan struct{}        // closed on disconnection
+
+	waiters  []chan pairResult // only accessed by loop
+	timedOut bool              // only accessed by loop
+
+	mu     *sync.Mutex // protects the following fields
+	key    []byte      // key to answer the LTK request with, while pairing as slave
+	keySec Security    // security of key
+	next   Security    // security of the key the link is being encrypted with
+	sec    Security    // security of the link
+}
+
+func newSMP(c *conn) *smp {
+	return &smp{
+		c:      c,
+		rxc:    make(chan []byte, 8),
+		encc:   make(chan error, 1),
+		reqc:   make(chan chan pairResult),
+		startc: make(chan chan error),
+		quitc:  make(chan struct{}),
+		mu:     &sync.Mutex{},
+	}
+}
+
+// receive queues an SMP command received. It is called by mainLoop, with
+// connsmu held, so it doesn't wait.
+func (s *smp) receive(b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	select {
+	case s.rxc <- b:
+	default:
+		log.Printf("smp: dropped command [ % X ]", b)
+	}
+}
+
+// encrypted reports the result of an Encryption Change or Encryption Key
+// Refresh Complete event to the pairing waiting for it, if any, and updates
+// the security of the link. It is called by eventLoop.
+func (s *smp) encrypted(err error) {
+	s.mu.Lock()
+	switch err {
+	case nil:
+		s.sec = s.next
+	case errEncryptionDisabled:
+		s.sec = Security{}
+	}
+	sec := s.sec
+	s.mu.Unlock()
+	if f := s.c.hci.EncryptedHandler; f != nil && err == nil {
+		if pd := s.c.platData(); pd != nil {
+			go f(pd, sec)
+		}
+	}
+	select {
+	case s.encc <- err:
+	default:
+	}
+}
+
+// close ends the loop. It is called on disconnection, with connsmu held.
+func (s *smp) close() { close(s.quitc) }
+
+func (s *smp) loop() {
+	for {
+		select {
+		case b := <-s.rxc:
+			s.handle(b)
+		case w := <-s.reqc:
+			s.waiters = append(s.waiters, w)
+			if s.timedOut {
+				s.finish(nil, errSMPTimeout)
+			} else if s.c.master {
+				s.done(s.pair())
+			} else if err := s.send(smpSecurityRequest, s.params().AuthReq); err != nil {
+				s.finish(nil, err)
+			}
+		case w := <-s.startc:
+			w <- s.start()
+		case err := <-s.encc:
+			// The master encrypted the link with the key of the bond, instead
+			// of pairing.
+			if err == nil && !s.c.master {
+				s.finish(s.bond(), nil)
+			}
+		case <-s.quitc:
+			s.finish(nil, errConnClosed)
+			return
+		}
+	}
+}
+
+// handle handles an SMP command received out of a pairing.
+func (s *smp) handle(b []byte) {
+	if s.timedOut {
+		return
+	}
+	switch b[0] {
+	case smpPairingRequest:
+		if s.c.master {
+			s.fail(ErrCommandNotSupported)
+			return
+		}
+		if len(b) != smpLen[smpPairingRequest] {
+			s.fail(ErrInvalidSMPParameters)
+			return
+		}
+		if !s.accept() {
+			s.fail(ErrPairingNotSupported)
+			return
+		}
+		s.done(s.respond(b))
+	case smpSecurityRequest:
+		if !s.c.master {
+			return
+		}
+		// The link is encrypted with the key of the bond, if it meets the
+		// requirements of the slave, or the devices pair again.
+		mitm := len(b) == smpLen[smpSecurityRequest] && b[1]&AuthMITM != 0
+		if bd := s.bond(); bd != nil && bd.Remote.LTK != nil && (bd.Authenticated || !mitm) {
+			if s.start() == nil {
+				return
+			}
+		}
+		if !s.accept() {
+			s.fail(ErrPairingNotSupported)
+			return
+		}
+		s.done(s.pair())
+	case smpPairingFailed:
+		// The master rejected the Security Request.
+		if len(b) == smpLen[smpPairingFailed] {
+			s.finish(nil, PairingError(b[1]))
+		}
+	case smpKeypressNotification:
+	default:
+		s.fail(ErrUnspecifiedReason)
+	}
+}
+
+// accept reports if the pairing requested by the remote device is accepted.
+func (s *smp) accept() bool {
+	f := s.c.hci.PairingRequestHandler
+	return f == nil || f(s.c.platData())
+}
+
+// finish hands the result of a pairing to the local requests waiting for it.
+func (s *smp) finish(b *Bond, err error) {
+	for _, w := range s.waiters {
+		w <- pairResult{b, err}
+	}
+	s.waiters = nil
+}
+
+// done ends a pairing.
+func (s *smp) done(b *Bond, err error) {
+	if err == errSMPTimeout {
+		s.timedOut = true
+	}
+	s.setKey(nil, Security{})
+	s.finish(b, err)
+	if f := s.c.hci.PairedHandler; f != nil {
+		go f(s.c.platData(), b, err)
+	}
+}
+
+func (s *smp) params() PairingParams {
+	s.c.hci.pairingmu.Lock()
+	defer s.c.hci.pairingmu.Unlock()
+	return s.c.hci.pairing
+}
+
+func (s *smp) send(code byte, b ...byte) error {
+	_, err := s.c.write(cidSMP, append([]byte{code}, b...))
+	return err
+}
+
+// fail sends a Pairing Failed command, and returns its reason.
+func (s *smp) fail(reason PairingError) error {
+	s.send(smpPairingFailed, byte(reason))
+	return reason
+}
+
+// recv waits for the SMP command code of the pairing. Security Requests and
+// Keypress Notifications are ignored, and any other command fails the pairing.
+func (s *smp) recv(code byte) ([]byte, error) {
+	t := time.NewTimer(s.c.hci.smpTimeout)
+	defer t.Stop()
+	for {
+		select {
+		case b := <-s.rxc:
+			switch {
+			case b[0] == smpPairingFailed && len(b) == smpLen[smpPairingFailed]:
+				return nil, PairingError(b[1])
+			case b[0] == smpSecurityRequest || b[0] == smpKeypressNotification:
+				continue
+			case b[0] != code:
+				return nil, s.fail(ErrUnspecifiedReason)
+			case len(b) != smpLen[code]:
+				return nil, s.fail(ErrInvalidSMPParameters)
+			}
+			return b, nil
+		case <-t.C:
+			return nil, errSMPTimeout
+		case <-s.quitc:
+			return nil, errConnClosed
+		}
+	}
+}
+
+// features returns the Pairing Request or Response command of the local
+// device.
+func (s *smp) features(code byte) []byte {
+	p := s.params()
+	oob := byte(0)
+	if s.hasOOBData(p.AuthReq&AuthSC != 0) {
+		oob = 1
+	}
+	rkd := p.RespKeyDist
+	if !s.c.hci.signing() {
+		// Don't offer to distribute a CSRK we won't sign with.
+		if code == smpPairingRequest {
+			p.InitKeyDist &^= KeyDistSign
+		} else {
+			rkd &^= KeyDistSign
+		}
+	}
+	return []byte{code, p.IOCap, oob, p.AuthReq, p.MaxKeySize, p.InitKeyDist, rkd}
+}
+
+// pair pairs as the initiator.
+func (s *smp) pair() (*Bond, error) {
+	preq := s.features(smpPairingRequest)
+	if err := s.send(preq[0], preq[1:]...); err != nil {
+		return nil, err
+	}
+	pres, err := s.recv(smpPairingResponse)
+	if err != nil {
+		return nil, err
+	}
+	if pres[1] >= ioCapabilityInvalid || pres[4] < 7 || pres[4] > 16 {
+		return nil, s.fail(ErrInvalidSMPParameters)
+	}
+	// The responder distributes the keys requested, if it can.
+	pres[5] &= preq[5]
+	pres[6] &= preq[6]
+	return s.authenticate(preq, pres)
+}
+
+// respond pairs as the responder, to the Pairing Request preq.
+func (s *smp) respond(preq []byte) (*Bond, error) {
+	if preq[1] >= ioCapabilityInvalid || preq[4] < 7 || preq[4] > 16 {
+		return nil, s.fail(ErrInvalidSMPParameters)
+	}
+	pres := s.features(smpPairingResponse)
+	pres[5] &= preq[5]
+	pres[6] &= preq[6]
+	if err := s.send(pres[0], pres[1:]...); err != nil {
+		return nil, err
+	}
+	return s.authenticate(preq, pres)
+}
+
+// authenticate carries out the rest of a pairing, after the exchange of the
+// Pairing Request and Response commands; the generation of the key, the
+// encryption of the link with it, and the distribution of the keys.
+func (s *smp) authenticate(preq, pres []byte) (*Bond, error) {
+	size := preq[4]
+	if pres[4] < size {
+		size = pres[4]
+	}
+	if size < 7 {
+		return nil, s.fail(ErrEncryptionKeySize)
+	}
+	b := &Bond{
+		KeySize: int(size),
+		Bonding: preq[3]&pres[3]&AuthBonding != 0,
+	}
+	ikd, rkd := pres[5], pres[6]
+
+	var key []byte
+	var err error
+	if preq[3]&pres[3]&AuthSC != 0 {
+		b.SecureConnections = true
+		key, b.Authenticated, err = s.sc(preq, pres, size)
+		// The LTK is generated by both devices, instead of distributed.
+		ikd &^= KeyDistEnc
+		rkd &^= KeyDistEnc
+	} else {
+		key, b.Authenticated, err = s.legacy(preq, pres, size)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The slave has handed the key to the controller already.
+	if s.c.master {
+		err = s.encrypt(key, 0, 0, b.security())
+	} else {
+		err = s.waitEncryption()
+	}
+	if err != nil {
+		return nil, s.fail(ErrUnspecifiedReason)
+	}
+	if b.SecureConnections {
+		b.Local.LTK, b.Remote.LTK = key, key
+	}
+	if err := s.distribute(b, ikd, rkd); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// passkey returns the passkey of a Passkey Entry pairing, which is either
+// input by the user, or generated and displayed.
+func (s *smp) passkey(method int) (uint32, error) {
+	h, pd := s.c.hci, s.c.platData()
+	input := method == methodPasskeyBoth ||
+		method == methodPasskeyInit && s.c.master ||
+		method == methodPasskeyResp && !s.c.master
+	if input {
+		if h.PasskeyRequestHandler == nil {
+			return 0, s.fail(ErrPasskeyEntryFailed)
+		}
+		passkey, err := h.PasskeyRequestHandler(pd)
+		if err != nil || passkey > 999999 {
+			return 0, s.fail(ErrPasskeyEntryFailed)
+		}
+		return passkey, nil
+	}
+	if h.PasskeyDisplayHandler == nil {
+		return 0, s.fail(ErrPasskeyEntryFailed)
+	}
+	b, err := random(4)
+	if err != nil {
+		return 0, s.fail(ErrUnspecifiedReason)
+	}
+	passkey := binary.LittleEndian.Uint32(b) % 1000000
+	h.PasskeyDisplayHandler(pd, passkey)
+	return passkey, nil
+}
+
+// tk returns the temporary key of the LE legacy pairing method.
+func (s *smp) tk(method int) ([]byte, error) {
+	tk := make([]byte, 16)
+	switch method {
+	case methodJustWorks:
+	case methodOOB:
+		var d []byte
+		if f := s.c.hci.OOBDataHandler; f != nil {
+			d = f(s.c.platData())
+		}
+		if len(d) != 16 {
+			return nil, s.fail(ErrOOBNotAvailable)
+		}
+		copy(tk, d)
+	default:
+		passkey, err := s.passkey(method)
+		if err != nil {
+			return nil, err
+		}
+		binary.LittleEndian.PutUint32(tk, passkey)
+	}
+	return tk, nil
+}
+
+// legacy carries out the phase 2 of a LE legacy pairing, and returns the STK,
+// and whether it is authenticated. See Core spec Vol 3, Part H, 2.3.5.5.
+func (s *smp) legacy(preq, pres []byte, size uint8) ([]byte, bool, error) {
+	method := legacyMethod(preq, pres)
+	tk, err := s.tk(method)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// The master of the connection is always the initiator.
+	iat, ia, rat, ra := s.c.localType, swap(s.c.local[:]), s.c.peerType, swap(s.c.peer[:])
+	if !s.c.master {
+		iat, ia, rat, ra = rat, ra, iat, ia
+	}
+	r, err := random(16)
+	if err != nil {
+		return nil, false, s.fail(ErrUnspecifiedReason)
+	}
+	confirm := crypto.C1(tk, r, preq, pres, iat, ia, rat, ra)
+
+	// The initiator sends its confirm value first, and the responder sends
+	// its random value last.
+	var remote, rr []byte
+	if s.c.master {
+		if err := s.send(smpPairingConfirm, confirm...); err != nil {
+			return nil, false, err
+		}
+		if remote, err = s.recv(smpPairingConfirm); err != nil {
+			return nil, false, err
+		}
+		if err := s.send(smpPairingRandom, r...); err != nil {
+			return nil, false, err
+		}
+		if rr, err = s.recv(smpPairingRandom); err != nil {
+			return nil, false, err
+		}
+	} else {
+		if remote, err = s.recv(smpPairingConfirm); err != nil {
+			return nil, false, err
+		}
+		if err := s.send(smpPairingConfirm, confirm...); err != nil {
+			return nil, false, err
+		}
+		if rr, err = s.recv(smpPairingRandom); err != nil {
+			return nil, false, err
+		}
+	}
+	rr = rr[1:]
+	if !equal(crypto.C1(tk, rr, preq, pres, iat, ia, rat, ra), remote[1:]) {
+		return nil, false, s.fail(ErrConfirmValueFailed)
+	}
+
+	if s.c.master {
+		return mask(crypto.S1(tk, rr, r), size), method != methodJustWorks, nil
+	}
+	stk := mask(crypto.S1(tk, r, rr), size)
+	s.setKey(stk, Security{Encrypted: true, Authenticated: method != methodJustWorks, KeySize: int(size)})
+	if err := s.send(smpPairingRandom, r...); err != nil {
+		return nil, false, err
+	}
+	return stk, method != methodJustWorks, nil
+}
+
+// distribute exchanges the keys over the encrypted link; first the keys of
+// the responder, and then the keys of the initiator.
+func (s *smp) distribute(b *Bond, ikd, rkd uint8) error {
+	lkd, rmkd := rkd, ikd
+	if s.c.master {
+		lkd, rmkd = ikd, rkd
+	}
+	if s.c.master {
+		if err := s.recvKeys(&b.Remote, rmkd); err != nil {
+			return err
+		}
+		return s.sendKeys(&b.Local, lkd, b.KeySize)
+	}
+	if err := s.sendKeys(&b.Local, lkd, b.KeySize); err != nil {
+		return err
+	}
+	return s.recvKeys(&b.Remote, rmkd)
+}
+
+// sendKeys distributes the local keys kd.
+func (s *smp) sendKeys(k *Keys, kd uint8, size int) error {
+	h := s.c.hci
+	if kd&KeyDistEnc != 0 {
+		b, err := random(16 + 2 + 8)
+		if err != nil {
+			return s.fail(ErrUnspecifiedReason)
+		}
+		k.LTK = mask(b[:16], uint8(size))
+		k.EDIV = binary.LittleEndian.Uint16(b[16:])
+		k.Rand = binary.LittleEndian.Uint64(b[18:])
+		if err := s.send(smpEncryptionInformation, k.LTK...); err != nil {
+			return err
+		}
+		if err := s.send(smpCentralIdentification, b[16:]...); err != nil {
+			return err
+		}
+	}
+	if kd&KeyDistID != 0 {
+		// An all-zero IRK tells that the device has no IRK.
+		k.IRK = make([]byte, 16)
+		if irk := h.irk(); irk != nil {
+			copy(k.IRK, irk)
+		}
+		k.IDAddrType, k.IDAddr = h.identity()
+		if err := s.send(smpIdentityInformation, k.IRK...); err != nil {
+			return err
+		}
+		if err := s.send(smpIdentityAddrInfo, append([]byte{k.IDAddrType}, swap(k.IDAddr[:])...)...); err != nil {
+			return err
+		}
+	}
+	if kd&KeyDistSign != 0 {
+		// A new CSRK is generated for each pairing, and kept in its bond.
+		if !h.signing() {
+			return s.fail(ErrUnspecifiedReason)
+		}
+		csrk, err := random(16)
+		if err != nil {
+			return s.fail(ErrUnspecifiedReason)
+		}
+		k.CSRK = csrk
+		if err := s.send(smpSigningInformation, k.CSRK...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recvKeys receives the remote keys kd.
+func (s *smp) recvKeys(k *Keys, kd uint8) error {
+	if kd&KeyDistEnc != 0 {
+		b, err := s.recv(smpEncryptionInformation)
+		if err != nil {
+			return err
+		}
+		k.LTK = b[1:]
+		if b, err = s.recv(smpCentralIdentification); err != nil {
+			return err
+		}
+		k.EDIV = binary.LittleEndian.Uint16(b[1:])
+		k.Rand = binary.LittleEndian.Uint64(b[3:])
+	}
+	if kd&KeyDistID != 0 {
+		b, err := s.recv(smpIdentityInformation)
+		if err != nil {
+			return err
+		}
+		k.IRK = b[1:]
+		if b, err = s.recv(smpIdentityAddrInfo); err != nil {
+			return err
+		}
+		k.IDAddrType = b[1]
+		copy(k.IDAddr[:], swap(b[2:]))
+	}
+	if kd&KeyDistSign != 0 {
+		b, err := s.recv(smpSigningInformation)
+		if err != nil {
+			return err
+		}
+		k.CSRK = b[1:]
+	}
+	return nil
+}
+
+// encrypt starts the encryption of the link with the key ltk, of the
+// security sec, as master, and waits for it to complete.
+func (s *smp) encrypt(ltk []byte, ediv uint16, rnd uint64, sec Security) error {
+	// Discard the result of an earlier encryption, if any.
+	select {
+	case <-s.encc:
+	default:
+	}
+	s.mu.Lock()
+	s.next = sec
+	s.mu.Unlock()
+	c := cmd.LEStartEncryption{
+		ConnectionHandle:     s.c.attr,
+		RandomNumber:         rnd,
+		EncryptedDiversifier: ediv,
+	}
+	copy(c.LongTermKey[:], ltk)
+	if err := s.c.hci.c.SendAndDecode(c, nil); err != nil {
+		return err
+	}
+	return s.waitEncryption()
+}
+
+// waitEncryption waits for the link to be encrypted.
+func (s *smp) waitEncryption() error {
+	t := time.NewTimer(s.c.hci.smpTimeout)
+	defer t.Stop()
+	select {
+	case err := <-s.encc:
+		return err
+	case <-t.C:
+		return errSMPTimeout
+	case <-s.quitc:
+		return errConnClosed
+	}
+}
+
+// start encrypts the link with the LTK distributed by the slave in the
+// bond, as master.
+func (s *smp) start() error {
+	b := s.bond()
+	if b == nil || b.Remote.LTK == nil {
+		return errNoBond
+	}
+	sec := b.security()
+	sec.Bonded = true
+	return s.encrypt(b.Remote.LTK, b.Remote.EDIV, b.Remote.Rand, sec)
+}
+
+func (s *smp) setKey(key []byte, sec Security) {
+	s.mu.Lock()
+	s.key, s.keySec = key, sec
+	s.mu.Unlock()
+}
+
+// ltk returns the key to encrypt the link with, as slave, for the EDIV and
+// Rand requested by the master, or nil if there is none; the key of the
+// pairing, or the LTK distributed by the local device in the bond.
+func (s *smp) ltk(ediv uint16, rnd uint64) []byte {
+	s.mu.Lock()
+	key, sec := s.key, s.keySec
+	s.mu.Unlock()
+	// The keys generated in pairings are always requested with zero EDIV
+	// and Rand.
+	if key == nil || ediv != 0 || rnd != 0 {
+		key = nil
+		if b := s.bond(); b != nil && b.Local.EDIV == ediv && b.Local.Rand == rnd {
+			key, sec = b.Local.LTK, b.security()
+			sec.Bonded = true
+		}
+	}
+	if key != nil {
+		s.mu.Lock()
+		s.next = sec
+		s.mu.Unlock()
+	}
+	return key
+}
+
+// bond returns the bond with the remote device, or nil if there is none.
+func (s *smp) bond() *Bond {
+	if f := s.c.hci.BondHandler; f != nil {
+		return f(s.c.platData())
+	}
+	return nil
+}
+
+// security returns the security of the link.
+func (s *smp) security() Security {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sec
+}
+
+// random returns n random bytes.
+func random(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// mask masks the key k to the encryption key size.
+func mask(k []byte, size uint8) []byte {
+	for i := int(size); i < len(k); i++ {
+		k[i] = 0
+	}
+	return k
+}
+
+// swap returns the bytes of b in reverse order.
+func swap(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i, v := range b {
+		r[len(b)-1-i] = v
+	}
+	return r
+}
+
+func equal(a, b []byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Pair pairs with the remote device of the connection hh. As master, it
+// starts the pairing; as slave, it sends a Security Request, and waits for
+// the master to start it, or to encrypt the link with the key of the bond
+// with the local device, which is returned then. The pairing fails if the
+// remote device doesn't answer each command in time, however long the local
+// handlers wait for the user, or if the connection is closed.
+func (h *HCI) Pair(hh uint16) (*Bond, error) {
+	c, err := h.conn(hh)
+	if err != nil {
+		return nil, err
+	}
+	s := c.smp
+	w := make(chan pairResult, 1)
+	select {
+	case s.reqc <- w:
+	case <-s.quitc:
+		return nil, errConnClosed
+	}
+	select {
+	case r := <-w:
+		return r.b, r.err
+	case <-s.quitc:
+		// The loop may have handed the result just before.
+		select {
+		case r := <-w:
+			return r.b, r.err
+		default:
+			return nil, errConnClosed
+		}
+	}
+}
+
+// SetPairingParams sets the pairing features of the local device.
+func (h *HCI) SetPairingParams(p PairingParams) error {
+	if p.IOCap >= ioCapabilityInvalid || p.MaxKeySize < 7 || p.MaxKeySize > 16 {
+		return errors.New("smp: invalid pairing parameters")
+	}
+	h.pairingmu.Lock()
+	h.pairing = p
+	h.pairingmu.Unlock()
+	return nil
+}
+
+// SetLocalKeys sets the IRK distributed by the local device in the pairings,
+// which isn't distributed if nil, and if a CSRK is distributed. The CSRKs are
+// generated for each pairing, and returned in the bonds.
+func (h *HCI) SetLocalKeys(irk []byte, sign bool) error {
+	if irk != nil && len(irk) != 16 {
+		return errors.New("smp: invalid key length")
+	}
+	h.pairingmu.Lock()
+	h.localIRK, h.sign = irk, sign
+	h.pairingmu.Unlock()
+	return nil
+}
+
+func (h *HCI) irk() []byte {
+	h.pairingmu.Lock()
+	defer h.pairingmu.Unlock()
+	return h.localIRK
+}
+
+func (h *HCI) signing() bool {
+	h.pairingmu.Lock()
+	defer h.pairingmu.Unlock()
+	return h.sign
+}
+
+// readBDADDR reads the public address of the controller.
+func (h *HCI) readBDADDR() error {
+	rp := &cmd.ReadBDADDRRP{}
+	if err := h.c.SendAndDecode(cmd.ReadBDADDR{}, rp); err != nil {
+		log.Printf("hci: failed to read BD_ADDR, %s", err)
+		return err
+	}
+	copy(h.addr[:], swap(rp.BDADDR[:]))
+	return nil
+}
@@ -0,0 +1,320 @@
+package linux
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/binary"
+	"time"
+
+	"github.com/paypal/gatt/crypto"
+)
+
+// scMethods are the pairing methods of LE Secure Connections, by the IO
+// capabilities of the initiator and the responder. See Core spec Vol 3,
+// Part H, 2.3.5.1.
+var scMethods = [5][5]int{
+	IODisplayOnly:     {methodJustWorks, methodJustWorks, methodPasskeyResp, methodJustWorks, methodPasskeyResp},
+	IODisplayYesNo:    {methodJustWorks, methodNumericComparison, methodPasskeyResp, methodJustWorks, methodNumericComparison},
+	IOKeyboardOnly:    {methodPasskeyInit, methodPasskeyInit, methodPasskeyBoth, methodJustWorks, methodPasskeyInit},
+	IONoInputNoOutput: {methodJustWorks, methodJustWorks, methodJustWorks, methodJustWorks, methodJustWorks},
+	IOKeyboardDisplay: {methodPasskeyInit, methodNumericComparison, methodPasskeyResp, methodJustWorks, methodNumericComparison},
+}
+
+// scMethod returns the method of LE Secure Connections pairing, from the
+// Pairing Request and Response commands. Unlike LE legacy pairing, OOB is
+// used if either device has the OOB data of the other.
+func scMethod(preq, pres []byte) int {
+	if preq[2] != 0 || pres[2] != 0 {
+		return methodOOB
+	}
+	if (preq[3]|pres[3])&AuthMITM == 0 {
+		return methodJustWorks
+	}
+	return scMethods[preq[1]][pres[1]]
+}
+
+// publicKey returns the public key of k, as sent in Pairing Public Key
+// commands; the x and y coordinates, each least significant octet first.
+func publicKey(k *ecdh.PrivateKey) []byte {
+	b := k.PublicKey().Bytes() // 0x04 || x || y
+	return append(swap(b[1:33]), swap(b[33:65])...)
+}
+
+// parsePublicKey parses the public key b of a Pairing Public Key command,
+// which must be a point of the P-256 curve.
+func parsePublicKey(b []byte) (*ecdh.PublicKey, error) {
+	p := append([]byte{0x04}, swap(b[:32])...)
+	return ecdh.P256().NewPublicKey(append(p, swap(b[32:64])...))
+}
+
+// dhKey returns the DHKey of the private key k and the public key pk.
+func dhKey(k *ecdh.PrivateKey, pk *ecdh.PublicKey) ([]byte, error) {
+	b, err := k.ECDH(pk)
+	if err != nil {
+		return nil, err
+	}
+	return swap(b), nil
+}
+
+// hasOOBData reports whether the local device has the OOB data of the remote
+// device; for LE Secure Connections, if sc, as well as for LE legacy pairing.
+func (s *smp) hasOOBData(sc bool) bool {
+	h, pd := s.c.hci, s.c.platData()
+	if h.OOBDataHandler != nil && h.OOBDataHandler(pd) != nil {
+		return true
+	}
+	if sc && h.SCOOBDataHandler != nil {
+		if r, _ := h.SCOOBDataHandler(pd); r != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// commit exchanges the confirm values of the local and the remote devices,
+// of their x-coordinates lx and rx, their nonces and z, and then the nonces,
+// and returns them after checking the remote confirm value. In Just Works and
+// Numeric Comparison, only the responder sends a confirm value.
+func (s *smp) commit(lx, rx []byte, z uint8, both bool) (n, rn []byte, err error) {
+	if n, err = random(16); err != nil {
+		return nil, nil, s.fail(ErrUnspecifiedReason)
+	}
+	var rc []byte
+	check := func() error {
+		if rc != nil && !equal(rc[1:], crypto.F4(rx, lx, rn, z)) {
+			return s.fail(ErrConfirmValueFailed)
+		}
+		return nil
+	}
+	if s.c.master {
+		if both {
+			if err := s.send(smpPairingConfirm, crypto.F4(lx, rx, n, z)...); err != nil {
+				return nil, nil, err
+			}
+		}
+		if rc, err = s.recv(smpPairingConfirm); err != nil {
+			return nil, nil, err
+		}
+		if err := s.send(smpPairingRandom, n...); err != nil {
+			return nil, nil, err
+		}
+		if rn, err = s.recv(smpPairingRandom); err != nil {
+			return nil, nil, err
+		}
+		rn = rn[1:]
+		return n, rn, check()
+	}
+	if both {
+		if rc, err = s.recv(smpPairingConfirm); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := s.send(smpPairingConfirm, crypto.F4(lx, rx, n, z)...); err != nil {
+		return nil, nil, err
+	}
+	if rn, err = s.recv(smpPairingRandom); err != nil {
+		return nil, nil, err
+	}
+	rn = rn[1:]
+	// The nonce of the responder isn't revealed before the confirm value of
+	// the initiator is checked.
+	if err := check(); err != nil {
+		return nil, nil, err
+	}
+	return n, rn, s.send(smpPairingRandom, n...)
+}
+
+// sc carries out the phase 2 of a LE Secure Connections pairing, and returns
+// the LTK, and whether it is authenticated. See Core spec Vol 3, Part H, 2.3.5.6.
+func (s *smp) sc(preq, pres []byte, size uint8) ([]byte, bool, error) {
+	h, pd := s.c.hci, s.c.platData()
+	method := scMethod(preq, pres)
+	k, oobR, err := h.scKey()
+	if err != nil {
+		return nil, false, s.fail(ErrUnspecifiedReason)
+	}
+
+	// Public key exchange; the initiator sends its key first.
+	pk := publicKey(k)
+	if s.c.master {
+		if err := s.send(smpPairingPublicKey, pk...); err != nil {
+			return nil, false, err
+		}
+	}
+	rpk, err := s.recv(smpPairingPublicKey)
+	if err != nil {
+		return nil, false, err
+	}
+	rpk = rpk[1:]
+	if equal(rpk[:32], pk[:32]) {
+		// A reflected key; the DHKey would be known to a third party.
+		return nil, false, s.fail(ErrDHKeyCheckFailed)
+	}
+	pub, err := parsePublicKey(rpk)
+	if err != nil {
+		return nil, false, s.fail(ErrDHKeyCheckFailed)
+	}
+	dhkey, err := dhKey(k, pub)
+	if err != nil {
+		return nil, false, s.fail(ErrDHKeyCheckFailed)
+	}
+	if !s.c.master {
+		if err := s.send(smpPairingPublicKey, pk...); err != nil {
+			return nil, false, err
+		}
+	}
+	lx, rx := pk[:32], rpk[:32]
+
+	// Authentication stage 1. lr is the random value of the local device
+	// known by the remote device, and rr the one of the remote device known
+	// by the local device, as used in the DHKey checks.
+	var n, rn []byte
+	lr, rr := make([]byte, 16), make([]byte, 16)
+	switch method {
+	case methodJustWorks, methodNumericComparison:
+		if n, rn, err = s.commit(lx, rx, 0, false); err != nil {
+			return nil, false, err
+		}
+		if method == methodNumericComparison {
+			na, nb, pkax, pkbx := n, rn, lx, rx
+			if !s.c.master {
+				na, nb, pkax, pkbx = rn, n, rx, lx
+			}
+			v := crypto.G2(pkax, pkbx, na, nb) % 1000000
+			if h.ConfirmPasskeyHandler == nil || !h.ConfirmPasskeyHandler(pd, v) {
+				return nil, false, s.fail(ErrNumericComparisonFailed)
+			}
+		}
+	case methodOOB:
+		local, remote := preq[2], pres[2]
+		if !s.c.master {
+			local, remote = remote, local
+		}
+		if local != 0 {
+			var r, c []byte
+			if h.SCOOBDataHandler != nil {
+				r, c = h.SCOOBDataHandler(pd)
+			}
+			if len(r) != 16 || len(c) != 16 {
+				return nil, false, s.fail(ErrOOBNotAvailable)
+			}
+			if !equal(c, crypto.F4(rx, rx, r, 0)) {
+				return nil, false, s.fail(ErrConfirmValueFailed)
+			}
+			rr = r
+		}
+		if remote != 0 {
+			if oobR == nil {
+				return nil, false, s.fail(ErrOOBNotAvailable)
+			}
+			lr = oobR
+		}
+		if n, err = random(16); err != nil {
+			return nil, false, s.fail(ErrUnspecifiedReason)
+		}
+		if s.c.master {
+			if err := s.send(smpPairingRandom, n...); err != nil {
+				return nil, false, err
+			}
+		}
+		if rn, err = s.recv(smpPairingRandom); err != nil {
+			return nil, false, err
+		}
+		rn = rn[1:]
+		if !s.c.master {
+			if err := s.send(smpPairingRandom, n...); err != nil {
+				return nil, false, err
+			}
+		}
+	default:
+		passkey, err := s.passkey(method)
+		if err != nil {
+			return nil, false, err
+		}
+		binary.LittleEndian.PutUint32(lr, passkey)
+		copy(rr, lr)
+		// The passkey is committed to one bit at a time.
+		for i := uint(0); i < 20; i++ {
+			if n, rn, err = s.commit(lx, rx, 0x80|uint8(passkey>>i&1), true); err != nil {
+				return nil, false, err
+			}
+		}
+	}
+
+	// Authentication stage 2. The addresses are 56-bit; the address
+	// followed by its type.
+	la := append(swap(s.c.local[:]), s.c.localType)
+	ra := append(swap(s.c.peer[:]), s.c.peerType)
+	na, nb, a, b, rA, rB := n, rn, la, ra, lr, rr
+	if !s.c.master {
+		na, nb, a, b, rA, rB = rn, n, ra, la, rr, lr
+	}
+	mackey, ltk := crypto.F5(dhkey, na, nb, a, b)
+	ea := crypto.F6(mackey, na, nb, rB, preq[1:4], a, b)
+	eb := crypto.F6(mackey, nb, na, rA, pres[1:4], b, a)
+	ltk = mask(ltk, size)
+	authenticated := method != methodJustWorks
+
+	if s.c.master {
+		if err := s.send(smpPairingDHKeyCheck, ea...); err != nil {
+			return nil, false, err
+		}
+		e, err := s.recv(smpPairingDHKeyCheck)
+		if err != nil {
+			return nil, false, err
+		}
+		if !equal(e[1:], eb) {
+			return nil, false, s.fail(ErrDHKeyCheckFailed)
+		}
+		return ltk, authenticated, nil
+	}
+	e, err := s.recv(smpPairingDHKeyCheck)
+	if err != nil {
+		return nil, false, err
+	}
+	if !equal(e[1:], ea) {
+		return nil, false, s.fail(ErrDHKeyCheckFailed)
+	}
+	s.setKey(ltk, Security{Encrypted: true, Authenticated: authenticated, SecureConnections: true, KeySize: int(size)})
+	if err := s.send(smpPairingDHKeyCheck, eb...); err != nil {
+		return nil, false, err
+	}
+	return ltk, authenticated, nil
+}
+
+// oobLifetime is the time the local OOB data is valid for.
+const oobLifetime = 10 * time.Minute
+
+// LocalOOBData generates the OOB data of the local device for LE Secure
+// Connections pairings; the random value r and the confirm value c, to be
+// sent to the remote device out of band. The data is valid for the next
+// pairing, if within oobLifetime, and until the next call.
+func (h *HCI) LocalOOBData() (r, c []byte, err error) {
+	k, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	if r, err = random(16); err != nil {
+		return nil, nil, err
+	}
+	x := publicKey(k)[:32]
+	h.pairingmu.Lock()
+	h.oobKey, h.oobR, h.oobExp = k, r, time.Now().Add(oobLifetime)
+	h.pairingmu.Unlock()
+	return r, crypto.F4(x, x, r, 0), nil
+}
+
+// scKey returns the key pair of a LE Secure Connections pairing; the one of
+// the local OOB data, and its random value, if generated and not expired, or
+// else a new one. The key pair of the OOB data is used by one pairing only.
+func (h *HCI) scKey() (*ecdh.PrivateKey, []byte, error) {
+	h.pairingmu.Lock()
+	k, r, exp := h.oobKey, h.oobR, h.oobExp
+	h.oobKey, h.oobR = nil, nil
+	h.pairingmu.Unlock()
+	if k != nil && time.Now().Before(exp) {
+		return k, r, nil
+	}
+	k, err := ecdh.P256().GenerateKey(rand.Reader)
+	return k, nil, err
+}
@@ -0,0 +1,684 @@
+package linux
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"encoding/hex"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/paypal/gatt/btaddr"
+	"github.com/paypal/gatt/linux/cmd"
+)
+
+// be returns the little-endian bytes of the big-endian hex string s, as
+// the values are written in the spec.
+func be(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return swap(b)
+}
+
+func TestLegacyMethod(t *testing.T) {
+	for _, tt := range []struct {
+		iio, ioob, iauth uint8
+		rio, roob, rauth uint8
+		want             int
+	}{
+		{IOKeyboardOnly, 0, AuthMITM, IODisplayOnly, 0, 0, methodPasskeyInit},
+		{IODisplayOnly, 0, 0, IOKeyboardOnly, 0, AuthMITM, methodPasskeyResp},
+		{IOKeyboardOnly, 0, AuthMITM, IOKeyboardOnly, 0, 0, methodPasskeyBoth},
+		{IOKeyboardDisplay, 0, AuthMITM, IOKeyboardDisplay, 0, 0, methodPasskeyResp},
+		{IOKeyboardOnly, 0, 0, IODisplayOnly, 0, 0, methodJustWorks},
+		{IOKeyboardOnly, 0, AuthMITM, IONoInputNoOutput, 0, AuthMITM, methodJustWorks},
+		{IONoInputNoOutput, 1, 0, IONoInputNoOutput, 1, 0, methodOOB},
+		{IOKeyboardOnly, 1, AuthMITM, IODisplayOnly, 0, 0, methodPasskeyInit},
+	} {
+		preq := []byte{smpPairingRequest, tt.iio, tt.ioob, tt.iauth, 16, 0, 0}
+		pres := []byte{smpPairingResponse, tt.rio, tt.roob, tt.rauth, 16, 0, 0}
+		if got := legacyMethod(preq, pres); got != tt.want {
+			t.Errorf("legacyMethod([ % X ], [ % X ]): got %d want %d", preq, pres, got, tt.want)
+		}
+	}
+}
+
+// legacyParams are the default pairing parameters, without LE Secure
+// Connections.
+var legacyParams = PairingParams{
+	IOCap:       IONoInputNoOutput,
+	AuthReq:     AuthBonding,
+	MaxKeySize:  16,
+	InitKeyDist: KeyDistEnc | KeyDistID | KeyDistSign,
+	RespKeyDist: KeyDistEnc | KeyDistID | KeyDistSign,
+}
+
+var (
+	masterAddr = [6]byte{0xA1, 0xA2, 0xA3, 0xA4, 0xA5, 0xA6}
+	slaveAddr  = [6]byte{0xB1, 0xB2, 0xB3, 0xB4, 0xB5, 0xB6}
+)
+
+// newTestLink returns the hosts of a master and a slave, connected by the
+// connection hh. Their controllers carry the ACL data packets to each other,
+// and emulate the encryption of the link.
+func newTestLink(t *testing.T, hh uint16) (m, s *HCI, stop func()) {
+	fm, fs := newFakeController(), newFakeController()
+	fm.setRP(0x1009, append([]byte{0x00}, swap(masterAddr[:])...)...)
+	fs.setRP(0x1009, append([]byte{0x00}, swap(slaveAddr[:])...)...)
+	fs.setRP(0x201A, 0x00, byte(hh), byte(hh>>8))
+	fs.setRP(0x201B, 0x00, byte(hh), byte(hh>>8))
+	m, s = newHCI(fm, 1), newHCI(fs, 1)
+
+	connected := make(chan struct{}, 2)
+	m.plist[slaveAddr] = &PlatData{Address: btaddr.BDAddr{Octets: slaveAddr}}
+	m.AcceptSlaveHandler = func(pd *PlatData) { connected <- struct{}{} }
+	s.AcceptMasterHandler = func(pd *PlatData) { connected <- struct{}{} }
+
+	quitc := make(chan struct{})
+	air := func(from, to *fakeController) {
+		for {
+			select {
+			case p := <-from.aclc:
+				from.completed(hh, 1)
+				to.rc <- p
+			case <-quitc:
+				return
+			}
+		}
+	}
+	go air(fm, fs)
+	go air(fs, fm)
+
+	mcmdc, scmdc := make(chan []byte, 16), make(chan []byte, 16)
+	fm.mu.Lock()
+	fm.cmdc = mcmdc
+	fm.mu.Unlock()
+	fs.mu.Lock()
+	fs.cmdc = scmdc
+	fs.mu.Unlock()
+	go func() {
+		var ltk []byte
+		var enc bool
+		encrypted := func(status, enabled byte) {
+			for _, f := range []*fakeController{fm, fs} {
+				if enc && status == 0x00 {
+					f.event(0x30, status, byte(hh), byte(hh>>8)) // Encryption Key Refresh Complete
+				} else {
+					f.event(0x08, status, byte(hh), byte(hh>>8), enabled)
+				}
+			}
+			enc = enc || status == 0x00
+		}
+		for {
+			select {
+			case p := <-mcmdc:
+				if op := int(p[1]) | int(p[2])<<8; op == 0x2019 {
+					// LE Start Encryption: requests the LTK of the slave.
+					ltk = p[16:32]
+					fs.event(0x3E, append([]byte{0x05, byte(hh), byte(hh >> 8)}, p[6:16]...)...)
+				}
+			case p := <-scmdc:
+				switch op := int(p[1]) | int(p[2])<<8; op {
+				case 0x201A:
+					if bytes.Equal(p[6:22], ltk) {
+						encrypted(0x00, 0x01)
+					} else {
+						encrypted(0x3D, 0x00) // Connection Terminated due to MIC Failure
+					}
+				case 0x201B:
+					encrypted(0x06, 0x00) // PIN or Key Missing
+				}
+			case <-quitc:
+				return
+			}
+		}
+	}()
+
+	fm.connectPeer(hh, 0x00, slaveAddr)
+	fs.connectPeer(hh, 0x01, masterAddr)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-connected:
+		case <-time.After(time.Second):
+			t.Fatalf("not connected")
+		}
+	}
+	return m, s, func() {
+		close(quitc)
+		fm.Close()
+		fs.Close()
+	}
+}
+
+type pairing struct {
+	b   *Bond
+	err error
+}
+
+// paired returns the pairings reported by the PairedHandler of h.
+func paired(h *HCI) chan pairing {
+	pc := make(chan pairing, 1)
+	h.PairedHandler = func(pd *PlatData, b *Bond, err error) { pc <- pairing{b, err} }
+	return pc
+}
+
+func waitPairing(t *testing.T, pc chan pairing) pairing {
+	select {
+	case p := <-pc:
+		return p
+	case <-time.After(2 * time.Second):
+		t.Fatalf("pairing not completed")
+	}
+	return pairing{}
+}
+
+func TestLegacyJustWorks(t *testing.T) {
+	m, s, stop := newTestLink(t, 0x0040)
+	defer stop()
+	m.SetPairingParams(legacyParams)
+	s.SetPairingParams(legacyParams)
+	m.SetLocalKeys(nil, true)
+	mc, sc := paired(m), paired(s)
+
+	mb, err := m.Pair(0x0040)
+	if err != nil {
+		t.Fatalf("Pair: %s", err)
+	}
+	if p := waitPairing(t, mc); p.b != mb || p.err != nil {
+		t.Errorf("master PairedHandler: got %v, %v", p.b, p.err)
+	}
+	sp := waitPairing(t, sc)
+	if sp.err != nil {
+		t.Fatalf("slave pairing: %s", sp.err)
+	}
+	sb := sp.b
+
+	if mb.KeySize != 16 || mb.Authenticated || !mb.Bonding || mb.SecureConnections {
+		t.Errorf("got key size %d, authenticated %t, bonding %t", mb.KeySize, mb.Authenticated, mb.Bonding)
+	}
+	for _, kk := range [][2]Keys{{mb.Local, sb.Remote}, {sb.Local, mb.Remote}} {
+		sent, rcvd := kk[0], kk[1]
+		if len(sent.LTK) != 16 || !bytes.Equal(sent.LTK, rcvd.LTK) || sent.EDIV != rcvd.EDIV || sent.Rand != rcvd.Rand {
+			t.Errorf("LTK: sent %X/%d/%d, received %X/%d/%d", sent.LTK, sent.EDIV, sent.Rand, rcvd.LTK, rcvd.EDIV, rcvd.Rand)
+		}
+		if !bytes.Equal(sent.IRK, make([]byte, 16)) || !bytes.Equal(rcvd.IRK, sent.IRK) || rcvd.IDAddr != sent.IDAddr {
+			t.Errorf("identity: sent %X/%X, received %X/%X", sent.IRK, sent.IDAddr, rcvd.IRK, rcvd.IDAddr)
+		}
+	}
+	if mb.Local.IDAddr != masterAddr || sb.Local.IDAddr != slaveAddr {
+		t.Errorf("identity addresses: got %X and %X", mb.Local.IDAddr, sb.Local.IDAddr)
+	}
+	// Only the master distributes a CSRK.
+	if len(mb.Local.CSRK) != 16 || !bytes.Equal(sb.Remote.CSRK, mb.Local.CSRK) || mb.Remote.CSRK != nil {
+		t.Errorf("CSRK: got %X at the slave, %X and %X at the master", sb.Remote.CSRK, mb.Local.CSRK, mb.Remote.CSRK)
+	}
+
+	// Each pairing distributes a new CSRK.
+	mb2, err := m.Pair(0x0040)
+	if err != nil {
+		t.Fatalf("Pair again: %s", err)
+	}
+	sp = waitPairing(t, sc)
+	if bytes.Equal(mb2.Local.CSRK, mb.Local.CSRK) || sp.err != nil || !bytes.Equal(sp.b.Remote.CSRK, mb2.Local.CSRK) {
+		t.Errorf("CSRK paired again: got %X at the slave, %X at the master, was %X", sp.b.Remote.CSRK, mb2.Local.CSRK, mb.Local.CSRK)
+	}
+}
+
+func TestLegacyPasskeyEntry(t *testing.T) {
+	for _, wrong := range []bool{false, true} {
+		m, s, stop := newTestLink(t, 0x0040)
+		m.SetPairingParams(PairingParams{IOCap: IOKeyboardOnly, AuthReq: AuthBonding | AuthMITM, MaxKeySize: 16})
+		s.SetPairingParams(PairingParams{IOCap: IODisplayOnly, AuthReq: AuthBonding, MaxKeySize: 10})
+		passkeyc := make(chan uint32, 1)
+		s.PasskeyDisplayHandler = func(pd *PlatData, passkey uint32) { passkeyc <- passkey }
+		m.PasskeyRequestHandler = func(pd *PlatData) (uint32, error) {
+			passkey := <-passkeyc
+			if wrong {
+				passkey = (passkey + 1) % 1000000
+			}
+			return passkey, nil
+		}
+		sc := paired(s)
+
+		mb, err := m.Pair(0x0040)
+		sp := waitPairing(t, sc)
+		if wrong {
+			if err != ErrConfirmValueFailed || sp.err != ErrConfirmValueFailed {
+				t.Errorf("wrong passkey: got %v at the master, %v at the slave", err, sp.err)
+			}
+		} else {
+			if err != nil || sp.err != nil {
+				t.Fatalf("got %v at the master, %v at the slave", err, sp.err)
+			}
+			if !mb.Authenticated || !sp.b.Authenticated || mb.KeySize != 10 {
+				t.Errorf("got authenticated %t, key size %d", mb.Authenticated, mb.KeySize)
+			}
+			// Neither device distributes keys.
+			if mb.Remote.LTK != nil || sp.b.Remote.LTK != nil {
+				t.Errorf("got keys distributed")
+			}
+		}
+		stop()
+	}
+}
+
+func TestSlowPasskeyEntry(t *testing.T) {
+	m, s, stop := newTestLink(t, 0x0040)
+	defer stop()
+	m.smpTimeout = 50 * time.Millisecond
+	m.SetPairingParams(PairingParams{IOCap: IOKeyboardOnly, AuthReq: AuthBonding | AuthMITM, MaxKeySize: 16})
+	s.SetPairingParams(PairingParams{IOCap: IODisplayOnly, AuthReq: AuthBonding, MaxKeySize: 16})
+	passkeyc := make(chan uint32, 1)
+	s.PasskeyDisplayHandler = func(pd *PlatData, passkey uint32) { passkeyc <- passkey }
+	m.PasskeyRequestHandler = func(pd *PlatData) (uint32, error) {
+		// The user takes longer than the remote device may take to answer.
+		time.Sleep(4 * m.smpTimeout)
+		return <-passkeyc, nil
+	}
+	mc := paired(m)
+
+	b, err := m.Pair(0x0040)
+	if err != nil {
+		t.Fatalf("Pair: %s", err)
+	}
+	if p := waitPairing(t, mc); p.b != b || p.err != nil {
+		t.Errorf("PairedHandler: got %v, %v, want %v", p.b, p.err, b)
+	}
+	if !b.Authenticated {
+		t.Errorf("passkey pairing not authenticated")
+	}
+}
+
+func TestLegacyOOB(t *testing.T) {
+	m, s, stop := newTestLink(t, 0x0040)
+	defer stop()
+	m.SetPairingParams(legacyParams)
+	tk := be("0F0E0D0C0B0A09080706050403020100")
+	m.OOBDataHandler = func(pd *PlatData) []byte { return tk }
+	s.OOBDataHandler = func(pd *PlatData) []byte { return tk }
+	b, err := m.Pair(0x0040)
+	if err != nil {
+		t.Fatalf("Pair: %s", err)
+	}
+	if !b.Authenticated {
+		t.Errorf("OOB pairing not authenticated")
+	}
+}
+
+func TestSecurityRequest(t *testing.T) {
+	m, s, stop := newTestLink(t, 0x0040)
+	defer stop()
+	mc := paired(m)
+	if _, err := s.Pair(0x0040); err != nil {
+		t.Fatalf("Pair as slave: %s", err)
+	}
+	if p := waitPairing(t, mc); p.err != nil {
+		t.Errorf("master pairing: %s", p.err)
+	}
+}
+
+func TestPairingRejected(t *testing.T) {
+	m, s, stop := newTestLink(t, 0x0040)
+	defer stop()
+	reject := func(pd *PlatData) bool { return false }
+	s.PairingRequestHandler = reject
+	if _, err := m.Pair(0x0040); err != ErrPairingNotSupported {
+		t.Errorf("Pair rejected by the slave: got %v", err)
+	}
+	m.PairingRequestHandler = reject
+	if _, err := s.Pair(0x0040); err != ErrPairingNotSupported {
+		t.Errorf("Pair rejected by the master: got %v", err)
+	}
+}
+
+// waitSecurity waits for the link of the connection 0x0040 of h to have the
+// security want; the Encryption Change events of the devices are handled
+// concurrently.
+func waitSecurity(t *testing.T, h *HCI, want Security) {
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		sec, err := h.Security(0x0040)
+		if sec == want && err == nil {
+			return
+		}
+		if time.Since(start) > time.Second {
+			t.Errorf("Security: got %+v, %v, want %+v", sec, err, want)
+			return
+		}
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	m, s, stop := newTestLink(t, 0x0040)
+	defer stop()
+	if err := m.Encrypt(0x0040); err != errNoBond {
+		t.Errorf("Encrypt without a bond: got %v", err)
+	}
+	m.SetPairingParams(legacyParams)
+	s.SetPairingParams(legacyParams)
+	sc := paired(s)
+	encc := make(chan Security, 4)
+	s.EncryptedHandler = func(pd *PlatData, sec Security) { encc <- sec }
+	mb, err := m.Pair(0x0040)
+	if err != nil {
+		t.Fatalf("Pair: %s", err)
+	}
+	sb := waitPairing(t, sc).b
+	want := Security{Encrypted: true, KeySize: 16}
+	waitSecurity(t, m, want)
+	waitSecurity(t, s, want)
+	if sec := <-encc; sec != want {
+		t.Errorf("EncryptedHandler of the pairing: got %+v, want %+v", sec, want)
+	}
+
+	// The slave has lost the bond.
+	m.BondHandler = func(pd *PlatData) *Bond { return mb }
+	if err := m.Encrypt(0x0040); err != cmd.Error(0x06) {
+		t.Errorf("Encrypt with a key missing: got %v", err)
+	}
+
+	// The link is encrypted again with the LTK distributed by the slave.
+	s.BondHandler = func(pd *PlatData) *Bond { return sb }
+	sb.Authenticated = true
+	mb.Authenticated = true
+	if err := m.Encrypt(0x0040); err != nil {
+		t.Fatalf("Encrypt: %s", err)
+	}
+	want.Authenticated = true
+	want.Bonded = true
+	waitSecurity(t, m, want)
+	waitSecurity(t, s, want)
+	select {
+	case sec := <-encc:
+		if sec != want {
+			t.Errorf("EncryptedHandler: got %+v, want %+v", sec, want)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("EncryptedHandler not called")
+	}
+
+	// The master answers a Security Request with the key of the bond.
+	if b, err := s.Pair(0x0040); b != sb || err != nil {
+		t.Errorf("Pair as slave: got %v, %v, want the bond", b, err)
+	}
+	if err := s.Encrypt(0x0040); err == nil {
+		t.Errorf("Encrypt as slave should fail")
+	}
+}
+
+// smpRsp returns the next SMP command sent by the host, after reassembling
+// its fragments.
+func smpRsp(t *testing.T, f *fakeController, hh uint16) []byte {
+	var b []byte
+	for len(b) < 4 || len(b) < 4+(int(b[0])|int(b[1])<<8) {
+		select {
+		case p := <-f.aclc:
+			f.completed(hh, 1)
+			b = append(b, p[5:]...)
+		case <-time.After(time.Second):
+			t.Fatalf("no SMP command sent")
+		}
+	}
+	if cid := uint16(b[2]) | uint16(b[3])<<8; cid != cidSMP {
+		t.Fatalf("got [ % X ], want an SMP command", b)
+	}
+	return b[4:]
+}
+
+func TestPairingInvalidResponse(t *testing.T) {
+	f := newFakeController()
+	h, pdc := newTestHCI(t, f)
+	defer f.Close()
+	h.plist[bdaddr{6, 5, 4, 3, 2, 1}] = &PlatData{}
+	h.AcceptSlaveHandler = h.AcceptMasterHandler
+	f.connectRole(0x0040, 0x00)
+	<-pdc
+
+	ec := make(chan error, 1)
+	go func() {
+		_, err := h.Pair(0x0040)
+		ec <- err
+	}()
+	if b := smpRsp(t, f, 0x0040); b[0] != smpPairingRequest || len(b) != 7 {
+		t.Fatalf("got [ % X ], want Pairing Request", b)
+	}
+	// The encryption key size can't be smaller than 7 octets.
+	f.acl(0x0040, cidSMP, smpPairingResponse, IONoInputNoOutput, 0x00, AuthBonding, 6, 0x00, 0x00)
+	if b := smpRsp(t, f, 0x0040); !bytes.Equal(b, []byte{smpPairingFailed, byte(ErrInvalidSMPParameters)}) {
+		t.Errorf("got [ % X ], want Pairing Failed", b)
+	}
+	if err := <-ec; err != ErrInvalidSMPParameters {
+		t.Errorf("Pair: got %v", err)
+	}
+
+	// The master rejects Pairing Requests.
+	f.acl(0x0040, cidSMP, smpPairingRequest, IONoInputNoOutput, 0x00, AuthBonding, 16, 0x00, 0x00)
+	if b := smpRsp(t, f, 0x0040); !bytes.Equal(b, []byte{smpPairingFailed, byte(ErrCommandNotSupported)}) {
+		t.Errorf("got [ % X ], want Pairing Failed", b)
+	}
+}
+
+func TestP256(t *testing.T) {
+	// Core spec Vol 2, Part G, 7.1.2.1, data set 1.
+	ka, _ := ecdh.P256().NewPrivateKey(swap(be("3f49f6d4a3c55f3874c9b3e3d2103f504aff607beb40b7995899b8a6cd3c1abd")))
+	kb, _ := ecdh.P256().NewPrivateKey(swap(be("55188b3d32f6bb9a900afcfbeed4e72a59cb9ac2f19d7cfb6b4fdd49f47fc5fd")))
+	pka := append(be("20b003d2f297be2c5e2c83a7e9f9a5b9eff49111acf4fddbcc0301480e359de6"),
+		be("dc809c49652aeb6d63329abf5a52155c766345c28fed3024741c8ed01589d28b")...)
+	pkb := append(be("1ea1f0f01faf1d9609592284f19e4c0047b58afd8615a69f559077b22faaa190"),
+		be("4c55f33e429dad377356703a9ab85160472d1130e28e36765f89aff915b1214a")...)
+	if got := publicKey(ka); !bytes.Equal(got, pka) {
+		t.Errorf("public key: got [ % X ] want [ % X ]", got, pka)
+	}
+	pub, err := parsePublicKey(pkb)
+	if err != nil {
+		t.Fatalf("parsePublicKey: %s", err)
+	}
+	dhkey, err := dhKey(ka, pub)
+	if want := be("ec0234a357c8ad05341010a60a397d9b99796b13b4f866f1868d34f373bfa698"); err != nil || !bytes.Equal(dhkey, want) {
+		t.Errorf("DHKey: got [ % X ], %v, want [ % X ]", dhkey, err, want)
+	}
+	if pub, err = parsePublicKey(publicKey(kb)); err != nil {
+		t.Fatalf("parsePublicKey: %s", err)
+	}
+	if got, _ := dhKey(ka, pub); !bytes.Equal(got, dhkey) {
+		t.Errorf("DHKey of B: got [ % X ]", got)
+	}
+
+	// Points off the curve are invalid.
+	pkb[40] ^= 0x01
+	if _, err := parsePublicKey(pkb); err == nil {
+		t.Errorf("parsePublicKey of a point off the curve should fail")
+	}
+}
+
+func scParams(io, auth uint8) PairingParams {
+	return PairingParams{
+		IOCap:       io,
+		AuthReq:     AuthBonding | AuthSC | auth,
+		MaxKeySize:  16,
+		InitKeyDist: KeyDistEnc | KeyDistID,
+		RespKeyDist: KeyDistEnc | KeyDistID,
+	}
+}
+
+// pairBoth pairs the master m with the slave s, and returns both results.
+func pairBoth(t *testing.T, m, s *HCI) (mp, sp pairing) {
+	sc := paired(s)
+	mp.b, mp.err = m.Pair(0x0040)
+	return mp, waitPairing(t, sc)
+}
+
+func TestSCJustWorks(t *testing.T) {
+	m, s, stop := newTestLink(t, 0x0040)
+	defer stop()
+	m.SetPairingParams(scParams(IONoInputNoOutput, 0))
+	s.SetPairingParams(scParams(IOKeyboardDisplay, AuthMITM))
+	mp, sp := pairBoth(t, m, s)
+	if mp.err != nil || sp.err != nil {
+		t.Fatalf("got %v at the master, %v at the slave", mp.err, sp.err)
+	}
+	mb, sb := mp.b, sp.b
+	if !mb.SecureConnections || mb.Authenticated || !sb.SecureConnections {
+		t.Errorf("got secure connections %t, authenticated %t", mb.SecureConnections, mb.Authenticated)
+	}
+	if len(mb.Local.LTK) != 16 || !bytes.Equal(mb.Local.LTK, sb.Local.LTK) || !bytes.Equal(mb.Remote.LTK, mb.Local.LTK) {
+		t.Errorf("LTK: got [ % X ] at the master, [ % X ] at the slave", mb.Local.LTK, sb.Local.LTK)
+	}
+	if mb.Remote.EDIV != 0 || mb.Remote.Rand != 0 {
+		t.Errorf("EDIV and Rand distributed")
+	}
+	if mb.Remote.IDAddr != slaveAddr || sb.Remote.IDAddr != masterAddr {
+		t.Errorf("identity addresses: got %X and %X", mb.Remote.IDAddr, sb.Remote.IDAddr)
+	}
+}
+
+func TestSCNumericComparison(t *testing.T) {
+	for _, reject := range []bool{false, true} {
+		m, s, stop := newTestLink(t, 0x0040)
+		m.SetPairingParams(scParams(IODisplayYesNo, AuthMITM))
+		s.SetPairingParams(scParams(IOKeyboardDisplay, 0))
+		values := make(chan uint32, 2)
+		m.ConfirmPasskeyHandler = func(pd *PlatData, v uint32) bool { values <- v; return true }
+		s.ConfirmPasskeyHandler = func(pd *PlatData, v uint32) bool { values <- v; return !reject }
+
+		mp, sp := pairBoth(t, m, s)
+		if reject {
+			if mp.err != ErrNumericComparisonFailed || sp.err != ErrNumericComparisonFailed {
+				t.Errorf("rejected: got %v at the master, %v at the slave", mp.err, sp.err)
+			}
+		} else {
+			if mp.err != nil || sp.err != nil {
+				t.Fatalf("got %v at the master, %v at the slave", mp.err, sp.err)
+			}
+			if v1, v2 := <-values, <-values; v1 != v2 || v1 > 999999 {
+				t.Errorf("compared %d and %d", v1, v2)
+			}
+			if !mp.b.Authenticated || !bytes.Equal(mp.b.Local.LTK, sp.b.Local.LTK) {
+				t.Errorf("got authenticated %t", mp.b.Authenticated)
+			}
+		}
+		stop()
+	}
+}
+
+func TestSCPasskeyEntry(t *testing.T) {
+	for _, wrong := range []bool{false, true} {
+		m, s, stop := newTestLink(t, 0x0040)
+		m.SetPairingParams(scParams(IODisplayOnly, AuthMITM))
+		s.SetPairingParams(scParams(IOKeyboardOnly, 0))
+		passkeyc := make(chan uint32, 1)
+		m.PasskeyDisplayHandler = func(pd *PlatData, passkey uint32) { passkeyc <- passkey }
+		s.PasskeyRequestHandler = func(pd *PlatData) (uint32, error) {
+			passkey := <-passkeyc
+			if wrong {
+				passkey ^= 0x40
+			}
+			return passkey, nil
+		}
+
+		mp, sp := pairBoth(t, m, s)
+		if wrong {
+			if mp.err != ErrConfirmValueFailed || sp.err != ErrConfirmValueFailed {
+				t.Errorf("wrong passkey: got %v at the master, %v at the slave", mp.err, sp.err)
+			}
+		} else {
+			if mp.err != nil || sp.err != nil {
+				t.Fatalf("got %v at the master, %v at the slave", mp.err, sp.err)
+			}
+			if !mp.b.Authenticated || !bytes.Equal(mp.b.Local.LTK, sp.b.Local.LTK) {
+				t.Errorf("got authenticated %t", mp.b.Authenticated)
+			}
+		}
+		stop()
+	}
+}
+
+func TestSCOOB(t *testing.T) {
+	for _, wrong := range []bool{false, true} {
+		m, s, stop := newTestLink(t, 0x0040)
+		m.SetPairingParams(scParams(IONoInputNoOutput, 0))
+		s.SetPairingParams(scParams(IONoInputNoOutput, 0))
+		r, c, err := s.LocalOOBData()
+		if err != nil {
+			t.Fatalf("LocalOOBData: %s", err)
+		}
+		if wrong {
+			c[0] ^= 0x01
+		}
+		m.SCOOBDataHandler = func(pd *PlatData) ([]byte, []byte) { return r, c }
+
+		mp, sp := pairBoth(t, m, s)
+		if wrong {
+			if mp.err != ErrConfirmValueFailed || sp.err != ErrConfirmValueFailed {
+				t.Errorf("wrong confirm value: got %v at the master, %v at the slave", mp.err, sp.err)
+			}
+		} else {
+			if mp.err != nil || sp.err != nil {
+				t.Fatalf("got %v at the master, %v at the slave", mp.err, sp.err)
+			}
+			if !mp.b.Authenticated || !bytes.Equal(mp.b.Local.LTK, sp.b.Local.LTK) {
+				t.Errorf("got authenticated %t", mp.b.Authenticated)
+			}
+		}
+		stop()
+	}
+}
+
+func TestSCInvalidPublicKey(t *testing.T) {
+	for _, reflect := range []bool{false, true} {
+		f := newFakeController()
+		h, pdc := newTestHCI(t, f)
+		h.plist[bdaddr{6, 5, 4, 3, 2, 1}] = &PlatData{}
+		h.AcceptSlaveHandler = h.AcceptMasterHandler
+		h.SetPairingParams(scParams(IONoInputNoOutput, 0))
+		f.connectRole(0x0040, 0x00)
+		<-pdc
+
+		ec := make(chan error, 1)
+		go func() {
+			_, err := h.Pair(0x0040)
+			ec <- err
+		}()
+		smpRsp(t, f, 0x0040)
+		f.acl(0x0040, cidSMP, smpPairingResponse, IONoInputNoOutput, 0x00, AuthBonding|AuthSC, 16, 0x00, 0x00)
+		pk := smpRsp(t, f, 0x0040)
+		if pk[0] != smpPairingPublicKey || len(pk) != 65 {
+			t.Fatalf("got [ % X ], want Pairing Public Key", pk)
+		}
+		if !reflect {
+			pk[64] ^= 0x01 // off the curve
+		}
+		f.acl(0x0040, cidSMP, pk...)
+		if b := smpRsp(t, f, 0x0040); !bytes.Equal(b, []byte{smpPairingFailed, byte(ErrDHKeyCheckFailed)}) {
+			t.Errorf("got [ % X ], want Pairing Failed", b)
+		}
+		if err := <-ec; err != ErrDHKeyCheckFailed {
+			t.Errorf("Pair: got %v", err)
+		}
+		f.Close()
+	}
+}
+
+func TestSCKey(t *testing.T) {
+	h := &HCI{pairingmu: &sync.Mutex{}}
+	if _, _, err := h.LocalOOBData(); err != nil {
+		t.Fatalf("LocalOOBData: %s", err)
+	}
+	k1, r, err := h.scKey()
+	if err != nil || r == nil {
+		t.Fatalf("got %v, want the key pair of the OOB data", err)
+	}
+	// The key pair of the OOB data is used once.
+	k2, r, err := h.scKey()
+	if err != nil || r != nil || k2.Equal(k1) {
+		t.Errorf("got the key pair of the OOB data again")
+	}
+
+	// The OOB data expires.
+	h.LocalOOBData()
+	h.oobExp = time.Now().Add(-time.Second)
+	if _, r, _ := h.scKey(); r != nil {
+		t.Errorf("got the key pair of the expired OOB data")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"io"
 
+	"github.com/paypal/gatt/linux"
 	"github.com/paypal/gatt/linux/cmd"
 )
 
@@ -31,6 +32,21 @@ func LnxMaxConnections(n int) Option {
 	}
 }
 
+// LnxMaxPeripheralConnections limits the connections to remote peripherals to
+// n at a time, if n is non-zero; the connections requested beyond are queued
+// until others disconnect. The controller limits them otherwise.
+// This option can be used with NewDevice or Option on Linux implementation.
+func LnxMaxPeripheralConnections(n int) Option {
+	return func(d Device) error {
+		dd := d.(*device)
+		dd.maxPeriph = n
+		if dd.hci != nil {
+			dd.hci.SetMaxMasterConnections(n)
+		}
+		return nil
+	}
+}
+
 // LnxSetAdvertisingEnable sets the advertising data to the HCI device.
 // This option can be used with Option on Linux implementation.
 func LnxSetAdvertisingEnable(en bool) Option {
@@ -74,14 +90,206 @@ func LnxSetAdvertisingParameters(c *cmd.LESetAdvertisingParameters) Option {
 }
 
 // LnxSendHCIRawCommand sends a raw command to the HCI device
+// rsp can be nil, an io.Writer, which receives the raw return parameters,
+// or a pointer to the return parameter struct of the command, such as
+// *cmd.LEReadBufferSizeRP, which receives the decoded return parameters.
+// If the command fails with a status other than success, the status is
+// returned as a cmd.Error.
 // This option can be used with NewDevice or Option on Linux implementation.
-func LnxSendHCIRawCommand(c cmd.CmdParam, rsp io.Writer) Option {
+func LnxSendHCIRawCommand(c cmd.CmdParam, rsp interface{}) Option {
 	return func(d Device) error {
 		b, err := d.(*device).SendHCIRawCommand(c)
-		if rsp == nil {
+		if err != nil {
+			return err
+		}
+		if w, ok := rsp.(io.Writer); ok {
+			w.Write(b)
+			rsp = nil
+		}
+		if len(b) == 0 {
+			return nil // Vendor commands may have no return parameters at all.
+		}
+		return cmd.UnmarshalRP(b, rsp)
+	}
+}
+
+// LnxSetBondStore sets the store of the bonds with the remote devices, which
+// are kept in memory by default.
+// This option can only be used with NewDevice on Linux implementation.
+func LnxSetBondStore(s BondStore) Option {
+	return func(d Device) error {
+		d.(*device).bonds = s
+		return nil
+	}
+}
+
+// LnxSetPrivacy sets the address privacy of the device; its random static
+// identity address, and its IRK, from which it generates the resolvable
+// private addresses it advertises, scans and initiates connections with,
+// and renews them periodically.
+// This option can only be used with NewDevice on Linux implementation.
+func LnxSetPrivacy(p linux.Privacy) Option {
+	return func(d Device) error {
+		d.(*device).privacy = &p
+		return nil
+	}
+}
+
+// LnxSetAddressResolution enables or disables the resolution of the private
+// addresses of the bonded devices by the controller, which accepts their
+// connections and reports their advertisements under their identity
+// addresses, with no resolution by the host. The resolving list of the
+// controller is filled with the bonds of the device, which distributed their
+// IRKs, and the new bonds are added to it; the bonds deleted with RemoveBond
+// are removed from it. Enabling it again refreshes the list, after bonds are
+// deleted from the BondStore directly.
+// This option can be used with NewDevice or Option on Linux implementation.
+func LnxSetAddressResolution(en bool) Option {
+	return func(d Device) error {
+		dd := d.(*device)
+		dd.resolving = en
+		if dd.hci == nil {
+			return nil
+		}
+		if !en {
+			return dd.hci.SetAddressResolution(false, 0)
+		}
+		return dd.syncResolvingList()
+	}
+}
+
+// LnxAddToAcceptList adds the device of the address, e.g. as parsed by
+// ParseBDAddr, to the filter accept list of the controller, to which the scanning, the advertising and the connections
+// may be restricted; see LnxSetScanFilter, LnxSetAdvertisingFilter and
+// LnxAutoConnect.
+// This option can be used with Option on Linux implementation.
+func LnxAddToAcceptList(a BDAddr) Option {
+	return func(d Device) error {
+		dd := d.(*device)
+		if dd.hci == nil {
+			return errors.New("device is not initialized")
+		}
+		return dd.hci.AddToAcceptList(a.HCIType(), a.Octets)
+	}
+}
+
+// LnxRemoveFromAcceptList removes the device of the address from the filter accept list of the controller.
+// This option can be used with Option on Linux implementation.
+func LnxRemoveFromAcceptList(a BDAddr) Option {
+	return func(d Device) error {
+		dd := d.(*device)
+		if dd.hci == nil {
+			return errors.New("device is not initialized")
+		}
+		return dd.hci.RemoveFromAcceptList(a.HCIType(), a.Octets)
+	}
+}
+
+// LnxClearAcceptList removes all the devices from the filter accept list of
+// the controller.
+// This option can be used with Option on Linux implementation.
+func LnxClearAcceptList() Option {
+	return func(d Device) error {
+		dd := d.(*device)
+		if dd.hci == nil {
+			return errors.New("device is not initialized")
+		}
+		return dd.hci.ClearAcceptList()
+	}
+}
+
+// LnxSetScanFilter restricts the scanning to the advertisements of the
+// devices of the filter accept list, if en is true.
+// This option can be used with NewDevice or Option on Linux implementation.
+func LnxSetScanFilter(en bool) Option {
+	return func(d Device) error {
+		dd := d.(*device)
+		dd.scanFilter = en
+		if dd.hci == nil {
+			return nil
+		}
+		return dd.hci.SetScanFilter(en)
+	}
+}
+
+// LnxSetAdvertisingFilter restricts the scan requests, if scan is true, and
+// the connection requests, if connect is true, which the advertising accepts
+// to those of the devices of the filter accept list.
+// This option can be used with NewDevice or Option on Linux implementation.
+func LnxSetAdvertisingFilter(scan, connect bool) Option {
+	return func(d Device) error {
+		dd := d.(*device)
+		if dd.advParam == nil {
+			p := dd.advSent
+			dd.advParam = &p
+		}
+		dd.advParam.AdvertisingFilterPolicy = 0x00
+		if scan {
+			dd.advParam.AdvertisingFilterPolicy |= 0x01
+		}
+		if connect {
+			dd.advParam.AdvertisingFilterPolicy |= 0x02
+		}
+		if dd.hci == nil {
+			return nil
+		}
+		return dd.update()
+	}
+}
+
+// LnxAutoConnect initiates a connection to the first device of the filter
+// accept list which advertises connectably, with the connection parameters
+// p. The connection, or the failure to initiate it, is reported by the
+// PeripheralConnected handler.
+// This option can be used with Option on Linux implementation.
+func LnxAutoConnect(p ConnParams) Option {
+	return func(d Device) error {
+		dd := d.(*device)
+		if dd.hci == nil {
+			return errors.New("device is not initialized")
+		}
+		return dd.hci.AutoConnect(p.lnx())
+	}
+}
+
+// LnxSetDefaultDataLength suggests the link layer to send data PDUs of up to n
+// payload octets on the new connections, from MinDataLength to MaxDataLength.
+// This option can be used with NewDevice or Option on Linux implementation.
+func LnxSetDefaultDataLength(n int) Option {
+	return func(d Device) error {
+		if err := checkDataLength(n); err != nil {
 			return err
 		}
-		rsp.Write(b)
-		return err
+		dd := d.(*device)
+		dd.defDataLen = &cmd.LEWriteSuggestedDefaultDataLength{
+			SuggestedMaxTxOctets: uint16(n),
+			SuggestedMaxTxTime:   uint16(n+14) * 8,
+		}
+		if dd.hci == nil {
+			return nil
+		}
+		return dd.updateDefaults()
+	}
+}
+
+// LnxSetDefaultPHY sets the PHYs the link layer prefers to transmit and to
+// receive on the new connections.
+// This option can be used with NewDevice or Option on Linux implementation.
+func LnxSetDefaultPHY(tx, rx PHY) Option {
+	return func(d Device) error {
+		txm, err := tx.mask()
+		if err != nil {
+			return err
+		}
+		rxm, err := rx.mask()
+		if err != nil {
+			return err
+		}
+		dd := d.(*device)
+		dd.defPHY = &cmd.LESetDefaultPHY{TxPHYs: txm, RxPHYs: rxm}
+		if dd.hci == nil {
+			return nil
+		}
+		return dd.updateDefaults()
 	}
 }
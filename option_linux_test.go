@@ -19,7 +19,7 @@ func ExampleLnxSetAdvertisingEnable() {
 	d.Option(LnxSetAdvertisingEnable(true)) // Can only be used with Option.
 }
 
-func ExampleSetAdvertisingData() {
+func ExampleLnxSetAdvertisingData() {
 	// Manually crafting an advertising packet with a type field, and a service uuid - 0xFE01.
 	o := LnxSetAdvertisingData(&cmd.LESetAdvertisingData{
 		AdvertisingDataLength: 6,
@@ -62,11 +62,21 @@ func ExampleLnxSendHCIRawCommand_predefinedCommand() {
 	}
 	rsp := bytes.NewBuffer(nil)
 	d, _ := NewDevice()
-	d.Option(LnxSendHCIRawCommand(c, rsp)) // Can only be used with Option
-	// Check the return status
-	if rsp.Bytes()[0] != 0x00 {
+	if err := d.Option(LnxSendHCIRawCommand(c, rsp)); err != nil { // Can only be used with Option
+		// Handle errors, e.g. cmd.ErrCommandDisallowed
+	}
+	// The raw return parameters, starting with the status.
+	_ = rsp.Bytes()
+}
+
+func ExampleLnxSendHCIRawCommand_decodedReturnParameters() {
+	// Send a predefined command of cmd package, and decode its return parameters.
+	rp := &cmd.LEReadBufferSizeRP{}
+	d, _ := NewDevice()
+	if err := d.Option(LnxSendHCIRawCommand(cmd.LEReadBufferSize{}, rp)); err != nil {
 		// Handle errors
 	}
+	_ = rp.HCLEACLDataPacketLength
 }
 
 // customCmd implements cmd.CmdParam as a fake vendor command.
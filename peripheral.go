@@ -11,8 +11,17 @@ type Peripheral interface {
 	Device() Device
 
 	// ID is the platform specific unique ID of the remote peripheral, e.g. MAC for Linux, Peripheral UUID for MacOS.
+	// On Linux, it is the identity address of a peripheral bonded when it was discovered or connected, which doesn't
+	// change along with its resolvable private address.
 	ID() string
 
+	// Address returns the current address of the remote peripheral, and its type.
+	Address() BDAddr
+
+	// IdentityAddress returns the identity address of the remote peripheral, and its type, if it has bonded.
+	// Its resolvable private addresses are resolved with the IRK it distributed.
+	IdentityAddress() (a BDAddr, ok bool)
+
 	// Name returns the name of the remote peripheral.
 	// This can be the advertised name, if exists, or the GAP device name, which takes priority
 	Name() string
@@ -49,6 +58,11 @@ type Peripheral interface {
 	// WriteCharacteristic writes the value of a characteristic.
 	WriteCharacteristic(c *Characteristic, b []byte, noRsp bool) error
 
+	// WriteCharacteristicSigned writes the value of a characteristic, which supports signed writes,
+	// without response. The write is signed with the key distributed to the bonded peripheral,
+	// unless the link is encrypted.
+	WriteCharacteristicSigned(c *Characteristic, b []byte) error
+
 	// WriteDescriptor writes the value of a characteristic descriptor.
 	WriteDescriptor(d *Descriptor, b []byte) error
 
@@ -63,6 +77,45 @@ type Peripheral interface {
 
 	// SetMTU sets the mtu for the remote peripheral.
 	SetMTU(mtu uint16) error
+
+	// SetDataLength suggests the link layer to send data PDUs of up to n payload octets, from MinDataLength to MaxDataLength.
+	// The data length in effect is reported by the PeripheralLinkUpdated handler.
+	SetDataLength(n int) error
+
+	// SetPHY requests the link layer to use the specified PHYs to transmit and to receive.
+	// The PHYs in effect are reported by the PeripheralLinkUpdated handler.
+	SetPHY(tx, rx PHY) error
+
+	// UpdateParameters requests new connection parameters.
+	// As the central of the connection, the link layer updates them; as the peripheral, it requests the remote central,
+	// and fails if the remote central rejects them. The parameters in effect are reported by the PeripheralLinkUpdated handler.
+	UpdateParameters(p ConnParams) error
+
+	// Link returns the link layer state of the connection.
+	Link() Link
+
+	// ConnInfo returns the connection handle, the role and the peer address type of the connection, and its current
+	// parameters. Only the role is reported on OS X.
+	ConnInfo() ConnInfo
+
+	// OpenChannel opens a L2CAP channel to the LE_PSM of the remote device.
+	OpenChannel(psm int) (L2CAPChannel, error)
+
+	// EnableEATT opens n, up to 5, Enhanced ATT bearers to the remote peripheral, which may accept fewer of them.
+	// The requests to the peripheral are then spread across the ATT bearer and the Enhanced ATT bearers, each of
+	// which serves one request at a time.
+	// The link must be encrypted, and the peripheral must declare the support of Enhanced ATT bearers in its Server
+	// Supported Features characteristic. The features of the client are declared in its Client Supported Features
+	// characteristic, if any.
+	EnableEATT(n int) error
+
+	// Pair pairs with the remote peripheral, and waits for the pairing to complete.
+	// The pairings, requested by either device, are also reported by the PeripheralPaired handler.
+	Pair() error
+
+	// Encrypt encrypts the link with the key of the bond with the remote peripheral, from an earlier pairing,
+	// and waits for the encryption to complete.
+	Encrypt() error
 }
 
 type subscriber struct {
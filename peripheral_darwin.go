@@ -209,6 +209,24 @@ func (p *peripheral) SetMTU(mtu uint16) error {
 	return errors.New("Not implemented")
 }
 
+func (p *peripheral) SetDataLength(n int) error             { return notImplemented }
+func (p *peripheral) SetPHY(tx, rx PHY) error               { return notImplemented }
+func (p *peripheral) UpdateParameters(ConnParams) error     { return notImplemented }
+func (p *peripheral) OpenChannel(int) (L2CAPChannel, error) { return nil, notImplemented }
+func (p *peripheral) EnableEATT(int) error                  { return notImplemented }
+func (p *peripheral) Pair() error                           { return notImplemented }
+func (p *peripheral) Encrypt() error                        { return notImplemented }
+func (p *peripheral) Link() Link                            { return Link{} }
+
+func (p *peripheral) Address() BDAddr                 { return BDAddr{} }
+func (p *peripheral) IdentityAddress() (BDAddr, bool) { return BDAddr{}, false }
+
+func (p *peripheral) ConnInfo() ConnInfo { return ConnInfo{Role: RoleCentral} }
+
+func (p *peripheral) WriteCharacteristicSigned(c *Characteristic, b []byte) error {
+	return notImplemented
+}
+
 func uuidSlice(uu []UUID) [][]byte {
 	us := [][]byte{}
 	for _, u := range uu {
@@ -5,10 +5,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net"
-	"strings"
 
 	"github.com/paypal/gatt/linux"
 )
@@ -26,17 +23,16 @@ type peripheral struct {
 
 	sub *subscriber
 
-	mtu uint16
-	l2c io.ReadWriteCloser
-
-	reqc  chan message
+	att   *bearer       // the ATT bearer; Enhanced ATT bearers are only known to their loops
+	reqc  chan *message // requests to send on any bearer
 	quitc chan struct{}
 
 	pd *linux.PlatData // platform specific data
+	id BDAddr          // identity address, if bonded when discovered or connected, or else the address
 }
 
 func (p *peripheral) Device() Device       { return p.d }
-func (p *peripheral) ID() string           { return strings.ToUpper(net.HardwareAddr(p.pd.Address[:]).String()) }
+func (p *peripheral) ID() string           { return p.id.String() }
 func (p *peripheral) Name() string         { return p.pd.Name }
 func (p *peripheral) Services() []*Service { return p.svcs }
 
@@ -220,12 +216,14 @@ func (p *peripheral) ReadLongCharacteristic(c *Characteristic) ([]byte, error) {
 	// The spec says that a read blob request should fail if the characteristic
 	// is smaller than mtu - 1.  To simplify the API, the first read is done
 	// with a regular read request.  If the buffer received is equal to mtu -1,
-	// then we read the rest of the data using read blob.
-	firstRead, err := p.ReadCharacteristic(c)
-	if err != nil {
-		return nil, err
-	}
-	if len(firstRead) < int(p.mtu)-1 {
+	// then we read the rest of the data using read blob, on the same bearer,
+	// whose MTU it depends on.
+	b := make([]byte, 3)
+	b[0] = attOpReadReq
+	binary.LittleEndian.PutUint16(b[1:3], c.vh)
+	b, br := p.request(nil, attOpReadReq, b)
+	firstRead := b[1:]
+	if len(firstRead) < int(br.mtu)-1 {
 		return firstRead, nil
 	}
 
@@ -239,14 +237,14 @@ func (p *peripheral) ReadLongCharacteristic(c *Characteristic) ([]byte, error) {
 		binary.LittleEndian.PutUint16(b[1:3], c.vh)
 		binary.LittleEndian.PutUint16(b[3:5], off)
 
-		b = p.sendReq(op, b)
+		b, _ = p.request(br, op, b)
 		b = b[1:]
 		if len(b) == 0 {
 			break
 		}
 		buf.Write(b)
 		off += uint16(len(b))
-		if len(b) < int(p.mtu)-1 {
+		if len(b) < int(br.mtu)-1 {
 			break
 		}
 	}
@@ -349,84 +347,124 @@ func searchService(ss []*Service, start, end uint16) *Service {
 
 // TODO: unifiy the message with OS X pots and refactor
 type message struct {
-	op   byte
-	b    []byte
-	rspc chan []byte
+	op     byte
+	b      []byte
+	rspc   chan []byte
+	bearer *bearer // the bearer the request was sent on
 }
 
 func (p *peripheral) sendCmd(op byte, b []byte) {
-	p.reqc <- message{op: op, b: b}
+	p.reqc <- &message{op: op, b: b}
 }
 
 func (p *peripheral) sendReq(op byte, b []byte) []byte {
-	m := message{op: op, b: b, rspc: make(chan []byte)}
-	p.reqc <- m
-	return <-m.rspc
+	rsp, _ := p.request(nil, op, b)
+	return rsp
+}
+
+// request sends the request on the bearer br, or on the first bearer available
+// if br is nil, and returns the response, and the bearer it was sent on.
+func (p *peripheral) request(br *bearer, op byte, b []byte) ([]byte, *bearer) {
+	m := &message{op: op, b: b, rspc: make(chan []byte)}
+	if br == nil {
+		p.reqc <- m
+		return <-m.rspc, m.bearer
+	}
+	select {
+	case br.reqc <- m:
+	case <-br.quitc:
+		return attErrorRsp(op, 0x0000, attEcodeUnlikely), br
+	}
+	return <-m.rspc, br
 }
 
 func (p *peripheral) loop() {
-	// Serialize the request.
-	rspc := make(chan []byte)
+	p.serve(p.att)
+	close(p.quitc)
+}
 
+// serve sends the requests on the bearer, and handles the responses and the
+// notifications received on it, until the bearer is closed. Each bearer
+// serializes its own requests, which it takes from the queue of the
+// peripheral whenever it is idle.
+func (p *peripheral) serve(br *bearer) {
 	// Dequeue request loop
 	go func() {
 		for {
+			var req *message
 			select {
-			case req := <-p.reqc:
-				p.l2c.Write(req.b)
-				if req.rspc == nil {
-					break
-				}
-				r := <-rspc
-				switch reqOp, rspOp := req.b[0], r[0]; {
-				case rspOp == attRspFor[reqOp]:
-				case rspOp == attOpError && r[1] == reqOp:
-				default:
-					log.Printf("Request 0x%02x got a mismatched response: 0x%02x", reqOp, rspOp)
-					// FIXME: terminate the connection?
-				}
-				req.rspc <- r
-			case <-p.quitc:
+			case req = <-p.reqc:
+			case req = <-br.reqc:
+			case <-br.quitc:
 				return
 			}
+			br.l2c.Write(req.b)
+			if req.rspc == nil {
+				continue
+			}
+			var r []byte
+			select {
+			case r = <-br.rspc:
+			case <-br.quitc:
+				r = attErrorRsp(req.b[0], 0x0000, attEcodeUnlikely)
+			}
+			switch reqOp, rspOp := req.b[0], r[0]; {
+			case rspOp == attRspFor[reqOp]:
+			case rspOp == attOpError && r[1] == reqOp:
+			default:
+				log.Printf("Request 0x%02x got a mismatched response: 0x%02x", reqOp, rspOp)
+				// FIXME: terminate the connection?
+			}
+			req.bearer = br
+			req.rspc <- r
 		}
 	}()
 
-	// L2CAP implementations shall support a minimum MTU size of 48 bytes.
-	// The default value is 672 bytes
-	buf := make([]byte, 672)
+	buf := br.rxBuf()
 
 	// Handling response or notification/indication
 	for {
-		n, err := p.l2c.Read(buf)
+		n, err := br.l2c.Read(buf)
 		if n == 0 || err != nil {
-			close(p.quitc)
+			close(br.quitc)
 			return
 		}
 
 		b := make([]byte, n)
 		copy(b, buf)
 
-		if (b[0] != attOpHandleNotify) && (b[0] != attOpHandleInd) {
-			rspc <- b
-			continue
-		}
-
-		h := binary.LittleEndian.Uint16(b[1:3])
-		f := p.sub.fn(h)
-		if f == nil {
-			log.Printf("notified by unsubscribed handle")
-			// FIXME: terminate the connection?
-		} else {
-			go f(b[3:], nil)
-		}
-
-		if b[0] == attOpHandleInd {
+		switch b[0] {
+		case attOpHandleNotify:
+			p.notified(binary.LittleEndian.Uint16(b[1:3]), b[3:])
+		case attOpHandleInd:
+			p.notified(binary.LittleEndian.Uint16(b[1:3]), b[3:])
 			// write aknowledgement for indication
-			p.l2c.Write([]byte{attOpHandleCnf})
+			br.l2c.Write([]byte{attOpHandleCnf})
+		case attOpMultiHandleNotify:
+			// Handle Length Value tuples
+			for b = b[1:]; len(b) != 0; {
+				if len(b) < 4 || len(b) < 4+int(binary.LittleEndian.Uint16(b[2:4])) {
+					log.Printf("malformed multiple handle value notification")
+					break
+				}
+				l := 4 + int(binary.LittleEndian.Uint16(b[2:4]))
+				p.notified(binary.LittleEndian.Uint16(b[:2]), b[4:l])
+				b = b[l:]
+			}
+		default:
+			br.rspc <- b
 		}
+	}
+}
 
+func (p *peripheral) notified(h uint16, b []byte) {
+	f := p.sub.fn(h)
+	if f == nil {
+		log.Printf("notified by unsubscribed handle")
+		// FIXME: terminate the connection?
+		return
 	}
+	go f(b, nil)
 }
 
 func (p *peripheral) SetMTU(mtu uint16) error {
@@ -435,11 +473,25 @@ func (p *peripheral) SetMTU(mtu uint16) error {
 	b[0] = op
 	binary.LittleEndian.PutUint16(b[1:3], uint16(mtu))
 
-	b = p.sendReq(op, b)
+	// The MTU is only exchanged on the ATT bearer.
+	b, _ = p.request(p.att, op, b)
 	serverMTU := binary.LittleEndian.Uint16(b[1:3])
 	if serverMTU < mtu {
 		mtu = serverMTU
 	}
-	p.mtu = mtu
+	p.att.mtu = mtu
 	return nil
 }
+
+func (p *peripheral) SetDataLength(n int) error { return setDataLength(p.d.hci, p.pd, n) }
+func (p *peripheral) SetPHY(tx, rx PHY) error   { return setPHY(p.d.hci, p.pd, tx, rx) }
+func (p *peripheral) UpdateParameters(cp ConnParams) error {
+	return updateParameters(p.d.hci, p.pd, cp)
+}
+func (p *peripheral) OpenChannel(psm int) (L2CAPChannel, error) {
+	return openChannel(p.d.hci, p.pd, psm)
+}
+func (p *peripheral) Link() Link { return link(p.d.hci, p.pd) }
+func (p *peripheral) ConnInfo() ConnInfo {
+	return connInfo(p.d.hci, p.pd, RoleCentral)
+}
@@ -0,0 +1,152 @@
+package gatt
+
+import (
+	"bytes"
+	"log"
+
+	"github.com/paypal/gatt/crypto"
+	"github.com/paypal/gatt/linux"
+)
+
+// resolvable reports whether the address of the remote device of pd is a
+// resolvable private address.
+func resolvable(pd *linux.PlatData) bool {
+	return pd.Address.IsResolvable()
+}
+
+// resolves reports whether the IRK k resolves the resolvable private address
+// a, in display order; prand || hash.
+func resolves(k []byte, a [6]byte) bool {
+	if len(k) != 16 {
+		return false
+	}
+	h := crypto.Ah(k, []byte{a[2], a[1], a[0]})
+	return bytes.Equal(h, []byte{a[5], a[4], a[3]})
+}
+
+// loadBond returns the bond with the remote device of pd, or nil if there is
+// none. The bond is looked up by the address of the device, or, if it is a
+// resolvable private address, by the IRK distributed by the device.
+func loadBond(s BondStore, pd *linux.PlatData) (*Bond, error) {
+	b, err := s.Load(pd.Address)
+	if b != nil || err != nil || !resolvable(pd) {
+		return b, err
+	}
+	bb, err := s.Bonds()
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range bb {
+		if resolves(b.Remote.IRK, pd.Address.Octets) {
+			return b, nil
+		}
+	}
+	return nil, nil
+}
+
+// identity returns the identity address of the remote device of pd, if it has
+// bonded; or else its current address.
+func identity(s BondStore, pd *linux.PlatData) (BDAddr, bool) {
+	if s == nil {
+		return pd.Address, false
+	}
+	b, err := loadBond(s, pd)
+	if b == nil || err != nil {
+		return pd.Address, false
+	}
+	return b.Addr, true
+}
+
+func (p *peripheral) Address() BDAddr { return p.pd.Address }
+
+func (p *peripheral) IdentityAddress() (BDAddr, bool) {
+	a, ok := identity(p.d.bonds, p.pd)
+	if !ok {
+		return BDAddr{}, false
+	}
+	return a, true
+}
+
+func (c *central) Address() BDAddr {
+	if c.pd == nil {
+		return BDAddr{}
+	}
+	return c.pd.Address
+}
+
+func (c *central) IdentityAddress() (BDAddr, bool) {
+	if c.pd == nil {
+		return BDAddr{}, false
+	}
+	a, ok := identity(c.bonds, c.pd)
+	if !ok {
+		return BDAddr{}, false
+	}
+	return a, true
+}
+
+// syncResolvingList replaces the resolving list of the controller with the
+// bonded devices which distributed their IRKs, as many as it holds, and
+// enables the address resolution.
+func (d *device) syncResolvingList() error {
+	h := d.hci
+	bb, err := d.bonds.Bonds()
+	if err != nil {
+		return err
+	}
+	if err := h.SetAddressResolution(false, 0); err != nil {
+		return err
+	}
+	if err := h.ClearResolvingList(); err != nil {
+		return err
+	}
+	n, err := h.ResolvingListSize()
+	if err != nil {
+		return err
+	}
+	for _, b := range bb {
+		if b.Remote.IRK == nil {
+			continue
+		}
+		if n == 0 {
+			log.Printf("resolving list full, %s not added", b.Addr)
+			continue
+		}
+		if err := h.AddToResolvingList(b.Addr.HCIType(), b.Addr.Octets, b.Remote.IRK); err != nil {
+			return err
+		}
+		n--
+	}
+	timeout := linux.DefaultRPATimeout
+	if d.privacy != nil && d.privacy.Timeout > 0 {
+		timeout = d.privacy.Timeout
+	}
+	return h.SetAddressResolution(true, timeout)
+}
+
+// addToResolvingList adds the new bond, if its remote device distributed its
+// IRK, to the resolving list of the controller, in place of its former entry.
+func (d *device) addToResolvingList(b *Bond) {
+	if b.Remote.IRK == nil {
+		return
+	}
+	typ, a := b.Addr.HCIType(), b.Addr.Octets
+	d.hci.RemoveFromResolvingList(typ, a)
+	if err := d.hci.AddToResolvingList(typ, a, b.Remote.IRK); err != nil {
+		log.Printf("failed to add %s to the resolving list: %s", b.Addr, err)
+	}
+}
+
+func (d *device) RemoveBond(a BDAddr) error {
+	b, err := d.bonds.Load(a)
+	if b == nil || err != nil {
+		return err
+	}
+	if err := d.bonds.Delete(b.Addr); err != nil {
+		return err
+	}
+	if d.resolving && b.Remote.IRK != nil {
+		return d.hci.RemoveFromResolvingList(b.Addr.HCIType(), b.Addr.Octets)
+	}
+	return nil
+}
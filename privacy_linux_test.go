@@ -0,0 +1,57 @@
+package gatt
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/paypal/gatt/btaddr"
+	"github.com/paypal/gatt/linux"
+)
+
+func TestLoadBond(t *testing.T) {
+	// Core spec Vol 3, Part H, D.7; the IRK least significant octet first.
+	irk, _ := hex.DecodeString("9b7d390aa610103405adc857a33402ec")
+	s := newBondMap()
+	s.Save(&Bond{Addr: btaddr.New([6]byte{0xC0, 0x01, 0x02, 0x03, 0x04, 0x05}, 0x01), Remote: BondKeys{IRK: irk}})
+	s.Save(&Bond{Addr: btaddr.New([6]byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}, 0x00)})
+
+	for _, tt := range []struct {
+		pd   *linux.PlatData
+		want string
+	}{
+		{&linux.PlatData{Address: btaddr.New([6]byte{0x70, 0x81, 0x94, 0x0D, 0xFB, 0xAA}, 0x01)}, "C0:01:02:03:04:05"},
+		{&linux.PlatData{Address: btaddr.New([6]byte{0x70, 0x81, 0x94, 0x0D, 0xFB, 0xAB}, 0x01)}, ""},
+		{&linux.PlatData{Address: btaddr.New([6]byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}, 0x00)}, "00:11:22:33:44:55"},
+	} {
+		b, err := loadBond(s, tt.pd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if b != nil {
+			got = b.Addr.String()
+		}
+		if got != tt.want {
+			t.Errorf("%s: got bond %q, want %q", tt.pd.Address, got, tt.want)
+		}
+		if a, ok := identity(s, tt.pd); ok != (tt.want != "") || ok && a.String() != tt.want {
+			t.Errorf("%s: got identity %s, %t", tt.pd.Address, a, ok)
+		}
+	}
+}
+
+func TestRemoveBond(t *testing.T) {
+	d := &device{bonds: newBondMap()}
+	a := btaddr.New([6]byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}, 0x00)
+	d.bonds.Save(&Bond{Addr: a})
+	if err := d.RemoveBond(a); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := d.bonds.Load(a); b != nil {
+		t.Errorf("got bond %+v, want it deleted", b)
+	}
+	// Removing a bond which doesn't exist is not an error.
+	if err := d.RemoveBond(a); err != nil {
+		t.Errorf("RemoveBond without a bond: %s", err)
+	}
+}
@@ -0,0 +1,57 @@
+package gatt
+
+// IOCapability is the input and output capabilities of a device, which
+// determine how the user takes part in its pairings.
+type IOCapability int
+
+const (
+	IODisplayOnly     IOCapability = 0x00 // displays a passkey
+	IODisplayYesNo    IOCapability = 0x01 // displays a passkey, and the user confirms it
+	IOKeyboardOnly    IOCapability = 0x02 // the user enters a passkey
+	IONoInputNoOutput IOCapability = 0x03 // no user interaction; Just Works pairings only
+	IOKeyboardDisplay IOCapability = 0x04 // displays a passkey, or the user enters one
+)
+
+// AuthReq is the authentication requirements of the pairings of a device.
+type AuthReq int
+
+const (
+	AuthBonding AuthReq = 0x01 // the keys are kept, to encrypt the later connections
+	AuthMITM    AuthReq = 0x04 // protection against man-in-the-middle attacks
+	AuthSC      AuthReq = 0x08 // LE Secure Connections, if the remote device supports it
+)
+
+// An Agent takes part in the pairings of the device, on behalf of the user.
+// The remote devices are identified by the ID of the remote central or
+// peripheral. The Agent is called from the goroutines of the pairings, which
+// wait for it.
+type Agent interface {
+	// IOCapability returns the input and output capabilities of the device.
+	IOCapability() IOCapability
+
+	// AuthReq returns the authentication requirements of the pairings.
+	AuthReq() AuthReq
+
+	// AuthorizePairing reports whether the pairing requested by the remote
+	// device is accepted.
+	AuthorizePairing(id string) bool
+
+	// DisplayPasskey displays the passkey which the user enters on the
+	// remote device.
+	DisplayPasskey(id string, passkey uint32)
+
+	// RequestPasskey returns the passkey displayed by the remote device,
+	// entered by the user.
+	RequestPasskey(id string) (uint32, error)
+
+	// ConfirmPasskey reports whether the user confirms that the passkey
+	// matches the one displayed by the remote device.
+	ConfirmPasskey(id string, passkey uint32) bool
+}
+
+// PairingAgent returns a Handler, which sets the agent taking part in the pairings, and declares the IO capabilities and
+// the authentication requirements of the device. Without an agent, the pairings are Just Works, with bonding.
+// The agent is ignored on OS X, where the system takes part in the pairings.
+func PairingAgent(a Agent) Handler {
+	return func(d Device) { d.(*device).setAgent(a) }
+}
@@ -0,0 +1,321 @@
+package gatt
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/paypal/gatt/btaddr"
+	"github.com/paypal/gatt/linux"
+)
+
+func (c *central) Pair() error {
+	_, err := c.hci.Pair(c.pd.Handle)
+	return err
+}
+
+func (p *peripheral) Pair() error {
+	_, err := p.d.hci.Pair(p.pd.Handle)
+	return err
+}
+
+func (p *peripheral) Encrypt() error {
+	return p.d.hci.Encrypt(p.pd.Handle)
+}
+
+// security returns the security of the link with the central.
+func (c *central) security() linux.Security {
+	if c.hci == nil {
+		return linux.Security{}
+	}
+	s, err := c.hci.Security(c.pd.Handle)
+	if err != nil {
+		return linux.Security{}
+	}
+	return s
+}
+
+// access returns the ATT error of the request of the central to read or to
+// write the attribute, if the security s of the request or the Authorizer of
+// the attribute doesn't satisfy the permission of the attribute; it returns
+// attEcodeSuccess if they do. The errors of the link security lead the
+// central to pair, or to encrypt the link with the keys of its bond.
+func (c *central) access(a *attr, write bool, s linux.Security) attEcode {
+	p := a.rperm
+	if write {
+		p = a.wperm
+	}
+	if p.encrypted() {
+		switch {
+		case !s.Encrypted:
+			if b := c.bond(); b != nil && (b.Local.LTK != nil || b.Remote.LTK != nil) {
+				return attEcodeInsuffEnc
+			}
+			return attEcodeAuthentication
+		case p.Authentication && !s.Authenticated, p.SecureConnections && !s.SecureConnections:
+			return attEcodeAuthentication
+		case s.KeySize < p.MinKeySize:
+			return attEcodeInsuffEncrKeySize
+		}
+	}
+	if p.Authorization && (a.authz == nil || !a.authz.Authorize(Request{Central: c}, write)) {
+		return attEcodeAuthorization
+	}
+	return attEcodeSuccess
+}
+
+// setAgent sets the pairing agent, and the pairing parameters it declares.
+func (d *device) setAgent(a Agent) {
+	d.agent = a
+	p := linux.DefaultPairingParams
+	if a != nil {
+		p.IOCap = uint8(a.IOCapability())
+		p.AuthReq = uint8(a.AuthReq() & (AuthBonding | AuthMITM | AuthSC))
+	}
+	if err := d.hci.SetPairingParams(p); err != nil {
+		log.Printf("invalid pairing agent: %s", err)
+	}
+}
+
+// peerID returns the ID of the remote central or peripheral of the
+// connection.
+func (d *device) peerID(pd *linux.PlatData) string {
+	switch p := d.peer(pd).(type) {
+	case *central:
+		return p.ID()
+	case *peripheral:
+		return p.ID()
+	}
+	return pd.Address.String()
+}
+
+func (d *device) handlePairingRequest(pd *linux.PlatData) bool {
+	return d.agent == nil || d.agent.AuthorizePairing(d.peerID(pd))
+}
+
+func (d *device) handlePasskeyDisplay(pd *linux.PlatData, passkey uint32) {
+	if d.agent != nil {
+		d.agent.DisplayPasskey(d.peerID(pd), passkey)
+	}
+}
+
+func (d *device) handlePasskeyRequest(pd *linux.PlatData) (uint32, error) {
+	if d.agent == nil {
+		return 0, errors.New("no pairing agent")
+	}
+	return d.agent.RequestPasskey(d.peerID(pd))
+}
+
+func (d *device) handleConfirmPasskey(pd *linux.PlatData, passkey uint32) bool {
+	return d.agent != nil && d.agent.ConfirmPasskey(d.peerID(pd), passkey)
+}
+
+// bond returns the bond with the central, or nil if there is none.
+func (c *central) bond() *Bond {
+	if c.bonds == nil || c.pd == nil {
+		return nil
+	}
+	b, err := loadBond(c.bonds, c.pd)
+	if err != nil {
+		log.Printf("failed to load the bond with %s: %s", c.ID(), err)
+		return nil
+	}
+	return b
+}
+
+func (c *central) saveBond(b *Bond) {
+	if err := c.bonds.Save(b); err != nil {
+		log.Printf("failed to save the bond with %s: %s", c.ID(), err)
+	}
+}
+
+// resume restores the Client Supported Features and the notifications the
+// bonded central subscribed to in its earlier connections, once the link is
+// encrypted with the LTK of its bond. If the services have changed since,
+// only the subscription to Service Changed is restored, and the change is
+// indicated to the central.
+func (c *central) resume() {
+	c.mu.Lock()
+	resumed := c.resumed
+	c.resumed = true
+	c.mu.Unlock()
+	b := c.bond()
+	if resumed || b == nil || c.attrs == nil {
+		return
+	}
+	h := c.attrs.hash()
+	if b.DatabaseHash != nil && !bytes.Equal(b.DatabaseHash, h) {
+		b.ServiceChanged = true
+		for h := range b.CCC {
+			if c.serviceChanged(h) == nil {
+				delete(b.CCC, h)
+			}
+		}
+	}
+	b.DatabaseHash = h
+	c.saveBond(b)
+	c.mu.Lock()
+	c.features = b.ClientFeatures
+	c.mu.Unlock()
+	sec := c.security()
+	for h, v := range b.CCC {
+		a, ok := c.attrs.At(h)
+		if !ok || !a.typ.Equal(attrClientCharacteristicConfigUUID) || v&(gattCCCNotifyFlag|gattCCCIndicateFlag) == 0 {
+			continue
+		}
+		if c.access(&a, false, sec) == attEcodeSuccess {
+			c.startNotify(&a, int(c.att.mtu-3))
+		}
+		if char := c.serviceChanged(h); char != nil && b.ServiceChanged && v&gattCCCIndicateFlag != 0 {
+			go c.indicateServiceChanged(char)
+		}
+	}
+}
+
+// serviceChanged returns the Service Changed characteristic, if h is the
+// handle of its Client Characteristic Configuration, or else nil.
+func (c *central) serviceChanged(h uint16) *Characteristic {
+	a, ok := c.attrs.At(h)
+	if !ok || !a.typ.Equal(attrClientCharacteristicConfigUUID) {
+		return nil
+	}
+	if char := a.pvt.(*Descriptor).char; char.uuid.Equal(attrServiceChangedUUID) {
+		return char
+	}
+	return nil
+}
+
+// indicateServiceChanged indicates to the central that all the services may
+// have changed, and clears the change pending in its bond once the central
+// confirms the indication.
+func (c *central) indicateServiceChanged(char *Characteristic) {
+	// Discard the confirmation of an earlier indication, if any.
+	select {
+	case <-c.cnfc:
+	default:
+	}
+	if _, err := c.att.l2c.Write([]byte{attOpHandleInd, byte(char.vh), byte(char.vh >> 8), 0x01, 0x00, 0xFF, 0xFF}); err != nil {
+		return
+	}
+	t := time.NewTimer(attTimeout)
+	defer t.Stop()
+	select {
+	case <-c.cnfc:
+	case <-t.C:
+		log.Printf("Service Changed not confirmed by %s", c.ID())
+		return
+	}
+	if b := c.bond(); b != nil && b.ServiceChanged {
+		b.ServiceChanged = false
+		c.saveBond(b)
+	}
+}
+
+// setCCC keeps the Client Characteristic Configuration written by the
+// central in its bond, if it has bonded. The change of the services pending
+// is indicated once the central subscribes to Service Changed.
+func (c *central) setCCC(h, v uint16) {
+	b := c.bond()
+	if b == nil {
+		return
+	}
+	if b.CCC == nil {
+		b.CCC = map[uint16]uint16{}
+	}
+	if v == 0 {
+		delete(b.CCC, h)
+	} else {
+		b.CCC[h] = v
+	}
+	c.saveBond(b)
+	c.mu.Lock()
+	resumed := c.resumed
+	c.mu.Unlock()
+	if char := c.serviceChanged(h); char != nil && resumed && b.ServiceChanged && v&gattCCCIndicateFlag != 0 {
+		go c.indicateServiceChanged(char)
+	}
+}
+
+// newBond returns the bond of the pairing b with the remote device of the
+// connection pd.
+func newBond(pd *linux.PlatData, b *linux.Bond) *Bond {
+	keys := func(k linux.Keys) BondKeys {
+		return BondKeys{LTK: k.LTK, EDIV: k.EDIV, Rand: k.Rand, IRK: k.IRK, CSRK: k.CSRK}
+	}
+	bd := &Bond{
+		Addr:              pd.Address,
+		Local:             keys(b.Local),
+		Remote:            keys(b.Remote),
+		KeySize:           b.KeySize,
+		Authenticated:     b.Authenticated,
+		SecureConnections: b.SecureConnections,
+	}
+	if b.Remote.IRK != nil {
+		bd.Addr = btaddr.New(b.Remote.IDAddr, b.Remote.IDAddrType)
+	}
+	return bd
+}
+
+// lnx returns the bond in the form of the linux package.
+func (b *Bond) lnx() *linux.Bond {
+	keys := func(k BondKeys) linux.Keys {
+		return linux.Keys{LTK: k.LTK, EDIV: k.EDIV, Rand: k.Rand, IRK: k.IRK, CSRK: k.CSRK}
+	}
+	lb := &linux.Bond{
+		Local:             keys(b.Local),
+		Remote:            keys(b.Remote),
+		KeySize:           b.KeySize,
+		Authenticated:     b.Authenticated,
+		Bonding:           true,
+		SecureConnections: b.SecureConnections,
+	}
+	if b.Remote.IRK != nil {
+		lb.Remote.IDAddr, lb.Remote.IDAddrType = b.Addr.Octets, b.Addr.HCIType()
+	}
+	return lb
+}
+
+// handleBond returns the bond with the remote device of the connection, if
+// it has bonded with the local device.
+func (d *device) handleBond(pd *linux.PlatData) *linux.Bond {
+	b, err := loadBond(d.bonds, pd)
+	if err != nil {
+		log.Printf("failed to load the bond with %s: %s", pd.Address, err)
+	}
+	if b == nil {
+		return nil
+	}
+	return b.lnx()
+}
+
+// handleEncrypted resumes the bond with the central, once the link is
+// encrypted with its LTK.
+func (d *device) handleEncrypted(pd *linux.PlatData, s linux.Security) {
+	if c, ok := d.peer(pd).(*central); ok && s.Bonded {
+		c.resume()
+	}
+}
+
+// handlePaired saves the bonds, and reports the pairings of the connections
+// to the Paired handlers of their roles.
+func (d *device) handlePaired(pd *linux.PlatData, b *linux.Bond, err error) {
+	if err == nil && b.Bonding {
+		bd := newBond(pd, b)
+		if err := d.bonds.Save(bd); err != nil {
+			log.Printf("failed to save the bond with %s: %s", pd.Address, err)
+		} else if d.resolving {
+			d.addToResolvingList(bd)
+		}
+	}
+	switch p := d.peer(pd).(type) {
+	case *central:
+		if d.centralPaired != nil {
+			d.centralPaired(p, err)
+		}
+	case *peripheral:
+		if d.peripheralPaired != nil {
+			d.peripheralPaired(p, err)
+		}
+	}
+}
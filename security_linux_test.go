@@ -0,0 +1,185 @@
+package gatt
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/paypal/gatt/linux"
+)
+
+// testAddr is the address of the remote devices of the tests.
+var testAddr = BDAddr{Octets: [6]byte{0xA1, 0xA2, 0xA3, 0xA4, 0xA5, 0xA6}}
+
+func TestResume(t *testing.T) {
+	gs := NewService(attrGATTUUID)
+	gs.AddCharacteristic(attrServiceChangedUUID).HandleNotifyFunc(func(r Request, n Notifier) {})
+	s := NewService(UUID16(0x180F))
+	bc := s.AddCharacteristic(UUID16(0x2A19))
+	bc.HandleNotifyFunc(func(r Request, n Notifier) {})
+	ec := s.AddCharacteristic(UUID16(0x2A1A))
+	ec.HandleNotifyFunc(func(r Request, n Notifier) {})
+	ec.SetReadPermission(Permission{Encryption: true})
+	attrs := generateAttributes([]*Service{gs, s}, 1)
+	pd := &linux.PlatData{Address: testAddr}
+	bonds := newBondMap()
+	connect := func(l2c *testHandler) *central {
+		c := newCentral(attrs, BDAddr{}, l2c)
+		c.pd = pd
+		c.bonds = bonds
+		return c
+	}
+
+	// Only the bonded centrals are resumed.
+	c := connect(nil)
+	c.resume()
+	c.setCCC(0x0004, gattCCCIndicateFlag)
+	if bb, _ := bonds.Bonds(); len(bb) != 0 {
+		t.Errorf("got bonds %+v", bb)
+	}
+
+	ccc := map[uint16]uint16{0x0004: gattCCCIndicateFlag, 0x0008: gattCCCNotifyFlag, 0x000B: gattCCCNotifyFlag}
+	bonds.Save(&Bond{Addr: testAddr, CCC: ccc})
+	c = connect(nil)
+	c.resume()
+	b, _ := bonds.Load(testAddr)
+	if !reflect.DeepEqual(b.CCC, ccc) || b.ServiceChanged || !reflect.DeepEqual(b.DatabaseHash, attrs.hash()) {
+		t.Errorf("got %+v", b)
+	}
+	// The notifications the link isn't secure enough for aren't restored.
+	if !c.subscribed(bc) || c.subscribed(ec) {
+		t.Errorf("got subscribed %t and %t, want true and false", c.subscribed(bc), c.subscribed(ec))
+	}
+
+	// The services changed since the central last connected; only the
+	// subscription to Service Changed is kept, and the change is indicated.
+	b.DatabaseHash = make([]byte, 16)
+	bonds.Save(b)
+	l2c := newTestHandler()
+	c = connect(l2c)
+	c.resume()
+	if got, want := recv(t, l2c), "1d03000100ffff"; got != want {
+		t.Errorf("got indication %s, want %s", got, want)
+	}
+	b, _ = bonds.Load(b.Addr)
+	if want := map[uint16]uint16{0x0004: gattCCCIndicateFlag}; !reflect.DeepEqual(b.CCC, want) || !b.ServiceChanged {
+		t.Errorf("got %+v, want the Service Changed pending", b)
+	}
+	if rsp := c.handleReq(c.att, []byte{attOpHandleCnf}); rsp != nil {
+		t.Errorf("got response % X to the confirmation", rsp)
+	}
+	for start := time.Now(); b.ServiceChanged; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("Service Changed still pending once confirmed")
+		}
+		b, _ = bonds.Load(b.Addr)
+	}
+}
+
+func TestAccess(t *testing.T) {
+	authorized := false
+	var wrote []byte
+	s := NewService(UUID16(0x180F))
+	bc := s.AddCharacteristic(UUID16(0x2A19))
+	bc.HandleReadFunc(func(rsp ResponseWriter, req *ReadRequest) { rsp.Write([]byte{0x64}) })
+	bc.HandleNotifyFunc(func(r Request, n Notifier) {})
+	bc.SetReadPermission(Permission{Encryption: true})
+	dc := s.AddCharacteristic(UUID16(0x2A00))
+	dc.SetValue([]byte("gopher"))
+	d := dc.AddDescriptor(UUID16(0x2901))
+	d.HandleWriteFunc(func(r Request, data []byte) byte {
+		wrote = data
+		return StatusSuccess
+	})
+	d.SetWritePermission(Permission{Authorization: true})
+	d.SetAuthorizerFunc(func(r Request, write bool) bool { return authorized && write })
+
+	c := newCentral(generateAttributes([]*Service{s}, 1), BDAddr{}, nil)
+	c.pd = &linux.PlatData{Address: testAddr}
+	c.bonds = newBondMap()
+	br := newBearer(nil, 23, false)
+
+	tests := []struct {
+		desc string
+		req  []byte
+		rsp  []byte
+	}{
+		{"read without pairing", []byte{attOpReadReq, 0x03, 0x00}, []byte{attOpError, attOpReadReq, 0x03, 0x00, 0x05}},
+		{"read by type without pairing", []byte{attOpReadByTypeReq, 0x01, 0x00, 0xFF, 0xFF, 0x19, 0x2A}, []byte{attOpError, attOpReadByTypeReq, 0x03, 0x00, 0x05}},
+		{"subscribe without pairing", []byte{attOpWriteReq, 0x04, 0x00, 0x01, 0x00}, []byte{attOpError, attOpWriteReq, 0x04, 0x00, 0x05}},
+		{"read unprotected", []byte{attOpReadReq, 0x06, 0x00}, append([]byte{attOpReadRsp}, "gopher"...)},
+		{"write unauthorized", []byte{attOpWriteReq, 0x07, 0x00, 0x01}, []byte{attOpError, attOpWriteReq, 0x07, 0x00, 0x08}},
+	}
+	for _, tt := range tests {
+		if rsp := c.handleReq(br, tt.req); !reflect.DeepEqual(rsp, tt.rsp) {
+			t.Errorf("%s: got % X, want % X", tt.desc, rsp, tt.rsp)
+		}
+	}
+	if wrote != nil {
+		t.Errorf("unauthorized write of % X", wrote)
+	}
+
+	// The bonded central is asked to encrypt the link, rather than to pair.
+	c.bonds.Save(&Bond{Addr: testAddr, Local: BondKeys{LTK: make([]byte, 16)}})
+	want := []byte{attOpError, attOpReadReq, 0x03, 0x00, 0x0F}
+	if rsp := c.handleReq(br, []byte{attOpReadReq, 0x03, 0x00}); !reflect.DeepEqual(rsp, want) {
+		t.Errorf("read bonded: got % X, want % X", rsp, want)
+	}
+
+	authorized = true
+	want = []byte{attOpWriteRsp}
+	if rsp := c.handleReq(br, []byte{attOpWriteReq, 0x07, 0x00, 0x01}); !reflect.DeepEqual(rsp, want) || !reflect.DeepEqual(wrote, []byte{0x01}) {
+		t.Errorf("write authorized: got % X, wrote % X", rsp, wrote)
+	}
+}
+
+func TestSignedWrite(t *testing.T) {
+	csrk := []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0A, 0x0B, 0x0C, 0x0D, 0x0E, 0x0F, 0x10}
+	addr := [6]byte{0xA1, 0xA2, 0xA3, 0xA4, 0xA5, 0xA6}
+
+	// The peripheral signs with the CSRK it distributed.
+	p := &peripheral{d: &device{bonds: newBondMap()}, pd: &linux.PlatData{Address: BDAddr{Octets: addr}}}
+	p.d.bonds.Save(&Bond{Addr: testAddr, Local: BondKeys{CSRK: csrk}})
+	m := []byte{attOpSignedWriteCmd, 0x03, 0x00, 0x01}
+	var cmds [][]byte
+	for i := 0; i < 2; i++ {
+		sig, err := p.sign(m)
+		if err != nil {
+			t.Fatalf("sign: %s", err)
+		}
+		cmds = append(cmds, append(m[1:len(m):len(m)], sig...))
+	}
+	if b, _ := p.d.bonds.Load(testAddr); b.Local.SignCounter != 2 {
+		t.Errorf("got sign counter %d, want 2", b.Local.SignCounter)
+	}
+
+	// The central verifies with the CSRK distributed by the peripheral.
+	var wrote [][]byte
+	s := NewService(UUID16(0xFFF0))
+	ch := s.AddCharacteristic(UUID16(0xFFF1))
+	ch.HandleSignedWriteFunc(func(r Request, data []byte) byte {
+		wrote = append(wrote, data)
+		return StatusSuccess
+	})
+	ch.SetWritePermission(Permission{Authentication: true})
+	c := newCentral(generateAttributes([]*Service{s}, 1), BDAddr{}, nil)
+	c.pd = &linux.PlatData{Address: BDAddr{Octets: addr}}
+	c.bonds = newBondMap()
+	c.bonds.Save(&Bond{Addr: testAddr, Remote: BondKeys{CSRK: csrk}, Authenticated: true})
+	br := newBearer(nil, 23, false)
+
+	forged := append([]byte{}, cmds[1]...)
+	forged[len(forged)-1] ^= 0x01
+	for _, b := range [][]byte{cmds[1], cmds[0], cmds[1], forged} {
+		if rsp := c.handleReq(br, append([]byte{attOpSignedWriteCmd}, b...)); rsp != nil {
+			t.Errorf("got response % X", rsp)
+		}
+	}
+	// The first command is a replay, once the second is verified.
+	if !reflect.DeepEqual(wrote, [][]byte{{0x01}}) {
+		t.Errorf("got writes % X, want [01]", wrote)
+	}
+	if b, _ := c.bonds.Load(testAddr); b.Remote.SignCounter != 2 {
+		t.Errorf("got sign counter %d, want 2", b.Remote.SignCounter)
+	}
+}
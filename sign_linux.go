@@ -0,0 +1,125 @@
+package gatt
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sync"
+
+	"github.com/paypal/gatt/crypto"
+	"github.com/paypal/gatt/linux"
+)
+
+// signmu serializes the updates of the sign counters in the bonds.
+var signmu sync.Mutex
+
+// REQ: SignedWriteCmd(0xD2), Handle, Value, Signature(SignCounter, MAC)
+// The command has no response; the writes failing the signature
+// verification or the checks of the attribute are dropped.
+func (c *central) handleSignedWrite(br *bearer, b []byte) []byte {
+	if len(b) < 2+12 {
+		return nil
+	}
+	h := binary.LittleEndian.Uint16(b[:2])
+	value := b[2 : len(b)-12]
+
+	a, ok := c.attrs.At(h)
+	if !ok || a.props&CharSignedWrite == 0 {
+		return nil
+	}
+	s, ok := c.verify(append([]byte{attOpSignedWriteCmd}, b...))
+	if !ok {
+		return nil
+	}
+	if ecode := c.access(&a, true, s); ecode != attEcodeSuccess {
+		return nil
+	}
+	r := Request{Central: c}
+	if c, ok := a.pvt.(*Characteristic); ok && c.whandler != nil {
+		c.whandler.ServeWrite(r, value)
+	}
+	return nil
+}
+
+// verify verifies the signature of the Signed Write Command pdu with the CSRK
+// distributed by the central, and moves the sign counter of its bond past the
+// signature, so that the command can't be replayed. It returns the security
+// of the write; that of the link if it is encrypted, or else that of the
+// pairing which distributed the key.
+func (c *central) verify(pdu []byte) (linux.Security, bool) {
+	signmu.Lock()
+	defer signmu.Unlock()
+	b := c.bond()
+	if b == nil || len(b.Remote.CSRK) != 16 {
+		return linux.Security{}, false
+	}
+	m, sig := pdu[:len(pdu)-12], pdu[len(pdu)-12:]
+	n := binary.LittleEndian.Uint32(sig)
+	if n < b.Remote.SignCounter || n == math.MaxUint32 {
+		return linux.Security{}, false
+	}
+	if subtle.ConstantTimeCompare(crypto.Sign(b.Remote.CSRK, m, n), sig) != 1 {
+		return linux.Security{}, false
+	}
+	b.Remote.SignCounter = n + 1
+	c.saveBond(b)
+
+	if s := c.security(); s.Encrypted {
+		return s, true
+	}
+	return linux.Security{
+		Encrypted:         true,
+		Authenticated:     b.Authenticated,
+		SecureConnections: b.SecureConnections,
+		KeySize:           b.KeySize,
+	}, true
+}
+
+func (p *peripheral) WriteCharacteristicSigned(c *Characteristic, value []byte) error {
+	if c.props&CharSignedWrite == 0 {
+		return errors.New("characteristic does not support signed writes")
+	}
+	// The data is already authenticated on an encrypted link.
+	if s, err := p.d.hci.Security(p.pd.Handle); err == nil && s.Encrypted {
+		return p.WriteCharacteristic(c, value, true)
+	}
+	if 3+len(value)+12 > int(p.att.mtu) {
+		return errors.New("value exceeds the mtu")
+	}
+	b := make([]byte, 3+len(value))
+	b[0] = attOpSignedWriteCmd
+	binary.LittleEndian.PutUint16(b[1:3], c.vh)
+	copy(b[3:], value)
+
+	sig, err := p.sign(b)
+	if err != nil {
+		return err
+	}
+	p.sendCmd(attOpSignedWriteCmd, append(b, sig...))
+	return nil
+}
+
+// sign returns the signature of m with the CSRK distributed to the
+// peripheral, and moves the sign counter of its bond past it. The counter is
+// saved before the signature is sent, so that it is never reused.
+func (p *peripheral) sign(m []byte) ([]byte, error) {
+	signmu.Lock()
+	defer signmu.Unlock()
+	b, err := loadBond(p.d.bonds, p.pd)
+	if err != nil {
+		return nil, err
+	}
+	if b == nil || len(b.Local.CSRK) != 16 {
+		return nil, errors.New("no signing key distributed to the peripheral")
+	}
+	if b.Local.SignCounter == math.MaxUint32 {
+		return nil, errors.New("sign counter exhausted")
+	}
+	sig := crypto.Sign(b.Local.CSRK, m, b.Local.SignCounter)
+	b.Local.SignCounter++
+	if err := p.d.bonds.Save(b); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}